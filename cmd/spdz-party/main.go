@@ -0,0 +1,273 @@
+// Command spdz-party runs the preprocessing phase of SPDZ for one party and stores the resulting
+// authenticated triples and MAC key share on disk.
+//
+// Usage:
+//
+//	spdz-party -config party0.json
+//	spdz-party -gen-identity party-0 -out party0
+//
+// The configuration is described in package config. The second form writes a static Ed25519 identity
+// to party0.crt and party0.key. When the configuration has a tls section, the parties connect over
+// mutually authenticated TLS and sign every message with their identity key.
+//
+// When a party misbehaves, the run aborts naming it and the round. Over TLS, the signed message of the
+// misbehaving party is saved as evidence to abort-party-<id>.evidence in the output directory. When the
+// party sent different messages to different parties, its other signed message is saved next to it to
+// abort-party-<id>.conflict.
+//
+// Every batch is checked by sacrificing half of its triples, followed by the MAC check on the openings of
+// the sacrifice, and only the checked triples are stored. A run cannot be resumed: the triples of a store
+// are authenticated under the MAC key of the run that generated them, which a new run does not share, so
+// a party refuses an output directory that already holds a MAC key share. After a failed run, the parties
+// may have stored different batches, and their stores must not be used. Remove the output directories of
+// all parties, or move them aside, and start a new run with a fresh session.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"spdz-go/config"
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+	"spdz-go/network"
+	"spdz-go/protocol"
+	"spdz-go/store"
+)
+
+func main() {
+	configFile := flag.String("config", "", "party configuration file")
+	timeout := flag.Duration("timeout", 30*time.Second, "time to wait for the other parties to connect")
+	genIdentity := flag.String("gen-identity", "", "generate an identity with this name instead of running a party")
+	out := flag.String("out", "", "path prefix of the generated identity files")
+	flag.Parse()
+
+	if *genIdentity != "" {
+		if err := writeIdentity(*genIdentity, *out); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetPrefix(fmt.Sprintf("party %d: ", cfg.ID))
+	if err = run(cfg, nil, *timeout); err != nil {
+		var abort *protocol.AbortError
+		if errors.As(err, &abort) {
+			log.Printf("party %d misbehaved in round %s", abort.Party, abort.Round)
+			if file, werr := writeEvidence(cfg.Output, abort); werr != nil {
+				log.Printf("cannot save evidence: %v", werr)
+			} else if file != "" {
+				log.Printf("evidence saved to %s", file)
+			}
+		}
+		log.Fatal(err)
+	}
+}
+
+// writeEvidence writes the signed message of the party accused by abort to dir, and returns the file it
+// was written to, or "" if abort carries no evidence. The conflicting signed digest of an equivocation is
+// written next to it with the extension .conflict.
+func writeEvidence(dir string, abort *protocol.AbortError) (string, error) {
+	if abort.Evidence == nil {
+		return "", nil
+	}
+	data, err := abort.Evidence.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, fmt.Sprintf("abort-party-%d.evidence", abort.Party))
+	if err = os.WriteFile(file, data, 0o644); err != nil {
+		return "", err
+	}
+	if abort.Conflict != nil {
+		if data, err = abort.Conflict.MarshalBinary(); err != nil {
+			return "", err
+		}
+		if err = os.WriteFile(strings.TrimSuffix(file, ".evidence")+".conflict", data, 0o644); err != nil {
+			return "", err
+		}
+	}
+	return file, nil
+}
+
+// writeIdentity generates an identity and writes it to prefix.crt and prefix.key.
+func writeIdentity(name, prefix string) error {
+	if prefix == "" {
+		prefix = name
+	}
+	certPEM, keyPEM, err := network.GenerateIdentity(name, 10*365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(prefix+".crt", certPEM, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(prefix+".key", keyPEM, 0o600)
+}
+
+// connect establishes the transport of the party, over TLS with signed messages if configured.
+// If ln is not nil, it is used instead of listening on the configured address.
+// The frames of the peers are bounded by the largest message of the protocol, or by the configured size.
+func connect(cfg *config.Config, ln net.Listener, timeout time.Duration) (network.Transport, error) {
+	addrs := cfg.Addrs()
+	limits := protocol.TransportLimits(cfg.Params(), len(cfg.Parties))
+	if cfg.MaxFrameSize > 0 {
+		limits.MaxFrameSize = cfg.MaxFrameSize
+	}
+	if cfg.TLS == nil {
+		if ln != nil {
+			return network.NewTCPTransportWithListener(cfg.ID, ln, addrs, timeout, limits)
+		}
+		return network.NewTCPTransport(cfg.ID, addrs, timeout, limits)
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+	certs := make([]*x509.Certificate, len(cfg.Parties))
+	for i := 0; i < len(certs) && err == nil; i++ {
+		certs[i], err = network.LoadCertificateFile(cfg.Parties[i].Cert)
+	}
+	if err != nil {
+		if ln != nil {
+			ln.Close()
+		}
+		return nil, err
+	}
+	tlsCfg := network.PartyConfig{ID: cfg.ID, Addrs: addrs, Certificate: keyPair, Certificates: certs, Limits: limits}
+	key, pubs, err := network.IdentityKeys(tlsCfg)
+	if err != nil {
+		if ln != nil {
+			ln.Close()
+		}
+		return nil, err
+	}
+	var tr *network.TCPTransport
+	if ln != nil {
+		tr, err = network.NewTLSTransportWithListener(tlsCfg, ln, timeout)
+	} else {
+		tr, err = network.NewTLSTransport(tlsCfg, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signed, err := network.NewSignedTransport(tr, key, pubs)
+	if err != nil {
+		tr.Close()
+		return nil, err
+	}
+	return signed, nil
+}
+
+// openStore opens the triple store in dir, which must not hold triples yet:
+// the triples of a store are authenticated under a single MAC key, and a failed run cannot be resumed.
+func openStore(dir string, params hpbfv.Parameters) (*store.TripleStore, error) {
+	ts, err := store.OpenTripleStore(dir, params)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = store.LoadMacKeyShare(dir, params); err == nil {
+		err = fmt.Errorf("%s already holds the triples of a run, which cannot be resumed: remove it or use another output directory", dir)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return ts, nil
+	}
+	ts.Close()
+	return nil, err
+}
+
+// run runs the preprocessing of the party and appends its triples to the store, batch by batch, once they
+// passed the sacrifice and the MAC check.
+func run(cfg *config.Config, ln net.Listener, timeout time.Duration) error {
+	params := cfg.Params()
+
+	ts, err := openStore(cfg.Output, params)
+	if err != nil {
+		if ln != nil {
+			ln.Close()
+		}
+		return err
+	}
+	defer ts.Close()
+
+	tr, err := connect(cfg, ln, timeout)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	log.Printf("connected to %d parties", len(cfg.Parties)-1)
+
+	var alpha field.Element
+	var src protocol.TripleSource
+	var runBatch func(b int) error
+	switch cfg.Protocol {
+	case config.Soho:
+		party := protocol.NewSohoParty(cfg.ID, params, cfg.CRSBytes())
+		if err = protocol.SetupSoho(party, tr, cfg.Session); err != nil {
+			return err
+		}
+		alpha, src = party.MacKeyShare(), party
+		runBatch = func(b int) error {
+			return protocol.RunSohoBatch(party, tr, cfg.Session, b, cfg.StatisticalSecurity)
+		}
+	case config.Hemi:
+		party := protocol.NewHemiParty(cfg.ID, params, len(cfg.Parties))
+		if err = protocol.SetupHemi(party, tr, cfg.Session); err != nil {
+			return err
+		}
+		alpha, src = party.MacKeyShare(), party
+		runBatch = func(b int) error {
+			return protocol.RunHemiBatch(party, tr, cfg.Session, b, cfg.StatisticalSecurity)
+		}
+	}
+	if err = store.SaveMacKeyShare(cfg.Output, params, alpha); err != nil {
+		return err
+	}
+	log.Printf("key setup done")
+
+	// every batch is checked by sacrificing half of its triples before it is stored
+	vt := protocol.NewVerifiedTriples(cfg.ID, params, len(cfg.Parties), alpha, src)
+
+	for b := 0; b < cfg.Batches; b++ {
+		start := time.Now()
+		if err = runBatch(b); err != nil {
+			return fmt.Errorf("batch %d: %w", b, err)
+		}
+		if err = protocol.RunSacrifice(vt, tr, cfg.Session, b, params.Slots()/2); err != nil {
+			return fmt.Errorf("batch %d: %w", b, err)
+		}
+
+		var triples []*protocol.AuthTriple
+		for {
+			triple, err := vt.NextAuthTriple()
+			if errors.Is(err, protocol.ErrNoTriples) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			triples = append(triples, triple)
+		}
+		if err = ts.Append(cfg.Session, b, triples); err != nil {
+			return err
+		}
+		log.Printf("batch %d: stored %d triples in %s", b, len(triples), time.Since(start).Round(time.Millisecond))
+	}
+
+	log.Printf("%d triples available in %s", ts.Len(), cfg.Output)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"spdz-go/config"
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+	"spdz-go/protocol"
+	"spdz-go/store"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// writeConfigs writes the identities and configuration files of numParties parties in dir and
+// returns the configuration files together with pre-bound listeners.
+func writeConfigs(t *testing.T, dir string, numParties int, proto string) ([]string, []net.Listener) {
+	listeners := make([]net.Listener, numParties)
+	parties := make([]config.Party, numParties)
+	for i := range parties {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i] = ln
+		name := fmt.Sprintf("party%d", i)
+		require.NoError(t, writeIdentity(name, filepath.Join(dir, name)))
+		parties[i] = config.Party{Addr: ln.Addr().String(), Cert: name + ".crt"}
+	}
+
+	files := make([]string, numParties)
+	for i := range files {
+		cfg := config.Config{
+			ID:         i,
+			Session:    "test",
+			Protocol:   proto,
+			Parameters: config.ParametersConfig{Preset: "HEMI_V2"},
+			Batches:    2,
+			Output:     fmt.Sprintf("out%d", i),
+			TLS:        &config.TLS{Cert: fmt.Sprintf("party%d.crt", i), Key: fmt.Sprintf("party%d.key", i)},
+			Parties:    parties,
+		}
+		data, err := yaml.Marshal(&cfg)
+		require.NoError(t, err)
+		files[i] = filepath.Join(dir, fmt.Sprintf("party%d.yaml", i))
+		require.NoError(t, os.WriteFile(files[i], data, 0o600))
+	}
+	return files, listeners
+}
+
+func TestSpdzParty(t *testing.T) {
+	numParties := 3
+	dir := t.TempDir()
+	files, listeners := writeConfigs(t, dir, numParties, "hemi")
+
+	cfgs := make([]*config.Config, numParties)
+	for i, file := range files {
+		var err error
+		cfgs[i], err = config.Load(file)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, fmt.Sprintf("out%d", i)), cfgs[i].Output)
+	}
+	require.NoError(t, config.CheckConsistent(cfgs...))
+
+	errs := make([]error, numParties)
+	var wg sync.WaitGroup
+	for i := range cfgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = run(cfgs[i], listeners[i], 10*time.Second)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		require.NoError(t, err, "party %d", i)
+	}
+
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := params.Field()
+	alpha := f.NewElement()
+	triples := make([][]*protocol.AuthTriple, numParties)
+	for i, cfg := range cfgs {
+		alphaI, err := store.LoadMacKeyShare(cfg.Output, params)
+		require.NoError(t, err)
+		f.Add(alpha, alpha, alphaI)
+
+		ts, err := store.OpenTripleStore(cfg.Output, params)
+		require.NoError(t, err)
+		require.Equal(t, 2*(params.Slots()/2), ts.Len())
+		triples[i], err = ts.Take(ts.Len())
+		require.NoError(t, err)
+		require.NoError(t, ts.Close())
+	}
+
+	prod := f.NewElement()
+	for k := range triples[0] {
+		sums := f.NewVector(6)
+		for i := range triples {
+			tr := triples[i][k]
+			for j, v := range []field.Element{tr.A.Value, tr.B.Value, tr.C.Value, tr.A.Mac, tr.B.Mac, tr.C.Mac} {
+				f.Add(sums[j], sums[j], v)
+			}
+		}
+		require.True(t, f.Equal(f.Mul(prod, sums[0], sums[1]), sums[2]), "triple %d", k)
+		for j := 0; j < 3; j++ {
+			require.True(t, f.Equal(f.Mul(prod, alpha, sums[j]), sums[j+3]), "MAC of triple %d", k)
+		}
+	}
+
+	t.Run("Rerun", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		require.ErrorContains(t, run(cfgs[0], ln, time.Second), "cannot be resumed")
+	})
+}
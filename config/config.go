@@ -0,0 +1,365 @@
+// Package config loads and validates the declarative configuration of a party in a session.
+//
+// A configuration is a YAML (or JSON) document such as
+//
+//	id: 0
+//	session: run-1
+//	protocol: soho
+//	parameters:
+//	  preset: SOHO_V2
+//	strict_security: true
+//	statistical_security: 40
+//	batches: 4
+//	output: out0
+//	tls:
+//	  cert: party0.crt
+//	  key: party0.key
+//	parties:
+//	  - addr: 127.0.0.1:7000
+//	    cert: party0.crt
+//	  - addr: 127.0.0.1:7001
+//	    cert: party1.crt
+//
+// Relative paths are relative to the directory of the configuration file.
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"spdz-go/hpbfv"
+
+	"golang.org/x/crypto/blake2b"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Soho and Hemi are the supported preprocessing protocols.
+	Soho = "soho"
+	Hemi = "hemi"
+
+	// DefaultStatisticalSecurity is the statistical security parameter of the noise flooding
+	// if none is configured.
+	DefaultStatisticalSecurity = 40
+
+	// maxProvenCiphertexts is the number of ciphertexts of the largest proof of plaintext knowledge of the
+	// Soho preprocessing, that of protocol.SohoParty.AuthTriplesRoundOne.
+	maxProvenCiphertexts = 6
+)
+
+// Config is the configuration of a party in a session.
+type Config struct {
+	ID       int    `yaml:"id"`
+	Session  string `yaml:"session"` // unique ID of the session, shared by all parties
+	Protocol string `yaml:"protocol"`
+
+	Parameters ParametersConfig `yaml:"parameters"`
+	// StrictSecurity refuses parameters whose estimated security is below hpbfv.MinSecurityLevel.
+	StrictSecurity bool `yaml:"strict_security,omitempty"`
+
+	// StatisticalSecurity is the statistical security parameter of the noise flooding of decryption shares
+	// (Soho) or of the answers of the pairwise OLE (Hemi), whose size follows from the estimated noise of
+	// the flooded ciphertexts.
+	StatisticalSecurity int `yaml:"statistical_security,omitempty"`
+
+	// MaxFrameSize is the size in bytes of the largest frame accepted from a peer. If zero, it is the size
+	// of the largest message of the protocol with the parameters, see protocol.TransportLimits.
+	MaxFrameSize int `yaml:"max_frame_size,omitempty"`
+
+	Batches int    `yaml:"batches"`
+	CRS     string `yaml:"crs,omitempty"` // hex encoded common reference string, derived from the session if empty
+	Output  string `yaml:"output"`        // directory of the triple store
+	TLS     *TLS   `yaml:"tls,omitempty"`
+
+	Parties []Party `yaml:"parties"`
+
+	params hpbfv.Parameters
+	crs    []byte
+}
+
+// ParametersConfig selects the parameters, either by preset name or by literal.
+type ParametersConfig struct {
+	Preset  string             `yaml:"preset,omitempty"`
+	Literal *ParametersLiteral `yaml:"literal,omitempty"`
+}
+
+// ParametersLiteral is the configuration form of hpbfv.ParametersLiteral, with B and G in decimal.
+type ParametersLiteral struct {
+	LogN    int      `yaml:"logn"`
+	Q       []uint64 `yaml:"q,omitempty"`
+	QMul    []uint64 `yaml:"qmul,omitempty"`
+	P       []uint64 `yaml:"p,omitempty"`
+	LogQ    []int    `yaml:"logq,omitempty"`
+	LogQMul []int    `yaml:"logqmul,omitempty"`
+	LogP    []int    `yaml:"logp,omitempty"`
+	H       int      `yaml:"h,omitempty"`
+	Sigma   float64  `yaml:"sigma,omitempty"`
+	B       string   `yaml:"b"`
+	D       uint64   `yaml:"d"`
+	G       string   `yaml:"g"`
+}
+
+// TLS holds the local certificate and key files.
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// Party describes a party of the session.
+type Party struct {
+	Addr string `yaml:"addr"`
+	Cert string `yaml:"cert,omitempty"`
+	// Fingerprint is the hex encoded parameter fingerprint announced by the party. If set, it must
+	// match the fingerprint of the configured parameters.
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+}
+
+// Load reads, resolves and validates the configuration in file.
+func Load(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data, filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes and validates a YAML or JSON configuration. Relative paths are resolved against dir.
+// Unknown fields are rejected.
+func Parse(data []byte, dir string) (*Config, error) {
+	cfg := new(Config)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty configuration")
+		}
+		return nil, err
+	}
+
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	resolve(&cfg.Output)
+	if cfg.TLS != nil {
+		resolve(&cfg.TLS.Cert)
+		resolve(&cfg.TLS.Key)
+	}
+	for i := range cfg.Parties {
+		resolve(&cfg.Parties[i].Cert)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration, fills in the defaults and instantiates the parameters.
+func (c *Config) Validate() (err error) {
+	switch {
+	case len(c.Parties) < 2:
+		return errors.New("at least two parties are required")
+	case c.ID < 0 || c.ID >= len(c.Parties):
+		return fmt.Errorf("invalid party ID %d", c.ID)
+	case c.Session == "":
+		return errors.New("missing session")
+	case c.Protocol != Soho && c.Protocol != Hemi:
+		return fmt.Errorf("unknown protocol %q", c.Protocol)
+	case c.Batches < 1:
+		return errors.New("batches must be positive")
+	case c.Output == "":
+		return errors.New("missing output directory")
+	case c.MaxFrameSize < 0:
+		return errors.New("max_frame_size must not be negative")
+	}
+
+	if c.StatisticalSecurity == 0 {
+		c.StatisticalSecurity = DefaultStatisticalSecurity
+	}
+	if c.StatisticalSecurity < 0 || c.StatisticalSecurity > 256 {
+		return errors.New("invalid noise flooding configuration")
+	}
+
+	if c.params, err = c.Parameters.instantiate(); err != nil {
+		return err
+	}
+	if c.StrictSecurity {
+		if err = c.params.CheckSecurity(hpbfv.MinSecurityLevel); err != nil {
+			return err
+		}
+	}
+
+	if c.Protocol == Soho {
+		// The preprocessing decrypts products of sums of the proven encryptions of all parties, whose
+		// flooded shares must still decrypt correctly and be covered by the proofs of decryption.
+		numParties := len(c.Parties)
+		sum := c.params.ProvenNoise(maxProvenCiphertexts, numParties) + 0.5*math.Log2(float64(numParties))
+		var noiseBits int
+		if noiseBits, err = c.params.FloodingNoiseBits(c.params.MulNoise(sum, sum, numParties), numParties, c.StatisticalSecurity); err != nil {
+			return fmt.Errorf("statistical security %d: %w", c.StatisticalSecurity, err)
+		}
+		if maxBits := c.params.MaxDecryptionProofNoiseBits(c.params.MaxLevel()); noiseBits > maxBits {
+			return fmt.Errorf("statistical security %d: %d bits of flooding noise exceed the %d bits covered by the proofs of decryption", c.StatisticalSecurity, noiseBits, maxBits)
+		}
+	}
+
+	if c.Protocol == Hemi {
+		// The pairwise OLE multiplies the proven encryptions of a pair of messages by a secret plaintext,
+		// whose flooded answer must still decrypt correctly.
+		noise := c.params.AddNoise(c.params.PlaintextMulNoise(c.params.ProvenNoise(2, 1), 1), c.params.FreshNoise(1))
+		if _, err = c.params.CiphertextFloodingNoiseBits(noise, c.StatisticalSecurity); err != nil {
+			return fmt.Errorf("statistical security %d: %w", c.StatisticalSecurity, err)
+		}
+	}
+
+	if c.CRS != "" {
+		if c.crs, err = hex.DecodeString(c.CRS); err != nil || len(c.crs) == 0 {
+			return errors.New("invalid crs")
+		}
+	} else {
+		crs := blake2b.Sum256([]byte("spdz-go/crs/" + c.Session))
+		c.crs = crs[:]
+	}
+
+	if c.TLS != nil && (c.TLS.Cert == "" || c.TLS.Key == "") {
+		return errors.New("tls requires a cert and a key")
+	}
+	fp := c.Fingerprint()
+	for i, p := range c.Parties {
+		if p.Addr == "" {
+			return fmt.Errorf("missing address of party %d", i)
+		}
+		if (p.Cert != "") != (c.TLS != nil) {
+			return fmt.Errorf("tls requires the local key pair and the certificate of every party, including party %d", i)
+		}
+		if p.Fingerprint != "" && p.Fingerprint != hex.EncodeToString(fp[:]) {
+			return fmt.Errorf("party %d announced parameter fingerprint %s, expected %x", i, p.Fingerprint, fp)
+		}
+	}
+	return nil
+}
+
+// instantiate returns the parameters of the preset or literal.
+func (pc ParametersConfig) instantiate() (params hpbfv.Parameters, err error) {
+	var lit hpbfv.ParametersLiteral
+	switch {
+	case pc.Preset != "" && pc.Literal != nil:
+		return params, errors.New("parameters: preset and literal are exclusive")
+	case pc.Preset != "":
+		var ok bool
+		if lit, ok = hpbfv.Presets[pc.Preset]; !ok {
+			return params, fmt.Errorf("parameters: unknown preset %q", pc.Preset)
+		}
+	case pc.Literal != nil:
+		if lit, err = pc.Literal.toHPBFV(); err != nil {
+			return params, err
+		}
+	default:
+		return params, errors.New("parameters: missing preset or literal")
+	}
+
+	if params, err = hpbfv.NewParametersFromLiteral(lit); err != nil {
+		return params, fmt.Errorf("parameters: %w", err)
+	}
+	return params, nil
+}
+
+func (pl *ParametersLiteral) toHPBFV() (hpbfv.ParametersLiteral, error) {
+	b, okB := new(big.Int).SetString(pl.B, 10)
+	g, okG := new(big.Int).SetString(pl.G, 10)
+	if !okB || !okG || b.Sign() <= 0 || g.Sign() <= 0 {
+		return hpbfv.ParametersLiteral{}, errors.New("parameters: b and g must be positive decimal integers")
+	}
+	if pl.D == 0 || pl.LogN <= 0 || pl.LogN > 20 || pl.D > 1<<pl.LogN || (1<<pl.LogN)%pl.D != 0 {
+		return hpbfv.ParametersLiteral{}, errors.New("parameters: d must divide 2^logn")
+	}
+	return hpbfv.ParametersLiteral{
+		LogN:    pl.LogN,
+		Q:       pl.Q,
+		QMul:    pl.QMul,
+		P:       pl.P,
+		LogQ:    pl.LogQ,
+		LogQMul: pl.LogQMul,
+		LogP:    pl.LogP,
+		H:       pl.H,
+		Sigma:   pl.Sigma,
+		B:       b,
+		D:       pl.D,
+		G:       g,
+	}, nil
+}
+
+// Params returns the parameters of the session.
+func (c *Config) Params() hpbfv.Parameters {
+	return c.params
+}
+
+// Fingerprint returns the fingerprint of the parameters of the session.
+func (c *Config) Fingerprint() [hpbfv.FingerprintSize]byte {
+	return c.params.Fingerprint()
+}
+
+// CRSBytes returns the common reference string of the session.
+func (c *Config) CRSBytes() []byte {
+	return append([]byte{}, c.crs...)
+}
+
+// Addrs returns the addresses of the parties.
+func (c *Config) Addrs() []string {
+	addrs := make([]string, len(c.Parties))
+	for i, p := range c.Parties {
+		addrs[i] = p.Addr
+	}
+	return addrs
+}
+
+// CheckConsistent checks that the configurations of several parties describe the same session:
+// same session ID, protocol, parameter fingerprint, noise flooding, CRS and party addresses, with distinct party IDs.
+func CheckConsistent(cfgs ...*Config) error {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	ref := cfgs[0]
+	fp := ref.Fingerprint()
+	ids := make(map[int]bool)
+	for _, c := range cfgs {
+		if ids[c.ID] {
+			return fmt.Errorf("party %d configured twice", c.ID)
+		}
+		ids[c.ID] = true
+
+		switch {
+		case c.Session != ref.Session:
+			return fmt.Errorf("party %d: session %q differs from %q", c.ID, c.Session, ref.Session)
+		case c.Protocol != ref.Protocol:
+			return fmt.Errorf("party %d: protocol %q differs from %q", c.ID, c.Protocol, ref.Protocol)
+		case c.Fingerprint() != fp:
+			return fmt.Errorf("party %d: parameter fingerprint differs from party %d", c.ID, ref.ID)
+		case c.StatisticalSecurity != ref.StatisticalSecurity || c.Batches != ref.Batches:
+			return fmt.Errorf("party %d: noise flooding or batches differ from party %d", c.ID, ref.ID)
+		case !bytes.Equal(c.crs, ref.crs):
+			return fmt.Errorf("party %d: crs differs from party %d", c.ID, ref.ID)
+		case len(c.Parties) != len(ref.Parties):
+			return fmt.Errorf("party %d: %d parties instead of %d", c.ID, len(c.Parties), len(ref.Parties))
+		}
+		for i := range c.Parties {
+			if c.Parties[i].Addr != ref.Parties[i].Addr {
+				return fmt.Errorf("party %d: address of party %d differs from party %d", c.ID, i, ref.ID)
+			}
+		}
+	}
+	return nil
+}
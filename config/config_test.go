@@ -0,0 +1,164 @@
+package config
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"spdz-go/hpbfv"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testYAML = `
+id: 1
+session: run-1
+protocol: soho
+parameters:
+  preset: SOHO_V2
+batches: 2
+output: out1
+tls:
+  cert: party1.crt
+  key: party1.key
+parties:
+  - addr: 127.0.0.1:7000
+    cert: party0.crt
+  - addr: 127.0.0.1:7001
+    cert: /etc/party1.crt
+`
+
+const testLiteralYAML = `
+id: 0
+session: run-1
+protocol: soho
+parameters:
+  literal:
+    logn: 14
+    q: [0x1fffffffffe10001, 0x1fffffffffe00001, 0x1fffffffffdd0001, 0x1fffffffffd08001]
+    qmul: [0x1fffffffffab0001, 0x1fffffffffa10001, 0x1fffffffff998001, 0x1fffffffff978001]
+    sigma: 3.2
+    b: "10792"
+    d: 512
+    g: "328256967394537077627"
+batches: 2
+output: /tmp/out0
+parties:
+  - addr: 127.0.0.1:7000
+  - addr: 127.0.0.1:7001
+`
+
+const testJSON = `{
+  "id": 0,
+  "session": "run-1",
+  "protocol": "soho",
+  "parameters": {"preset": "SOHO_V2"},
+  "statistical_security": 40,
+  "batches": 2,
+  "output": "out0",
+  "tls": {"cert": "party0.crt", "key": "party0.key"},
+  "parties": [
+    {"addr": "127.0.0.1:7000", "cert": "party0.crt"},
+    {"addr": "127.0.0.1:7001", "cert": "party1.crt"}
+  ]
+}`
+
+func TestConfig(t *testing.T) {
+	soho, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := soho.Fingerprint()
+
+	t.Run("YAML", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "party1.yaml")
+		require.NoError(t, os.WriteFile(file, []byte(testYAML), 0o600))
+
+		cfg, err := Load(file)
+		require.NoError(t, err)
+		require.Equal(t, 1, cfg.ID)
+		require.Equal(t, filepath.Join(dir, "out1"), cfg.Output)
+		require.Equal(t, filepath.Join(dir, "party1.key"), cfg.TLS.Key)
+		require.Equal(t, filepath.Join(dir, "party0.crt"), cfg.Parties[0].Cert)
+		require.Equal(t, "/etc/party1.crt", cfg.Parties[1].Cert)
+		require.Equal(t, []string{"127.0.0.1:7000", "127.0.0.1:7001"}, cfg.Addrs())
+		require.Equal(t, fp, cfg.Fingerprint())
+		require.Equal(t, DefaultStatisticalSecurity, cfg.StatisticalSecurity)
+		require.Len(t, cfg.CRSBytes(), 32)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		cfg0, err := Parse([]byte(testJSON), "/cfg")
+		require.NoError(t, err)
+		require.Equal(t, "/cfg/out0", cfg0.Output)
+
+		cfg1, err := Parse([]byte(testYAML), "/cfg")
+		require.NoError(t, err)
+		require.NoError(t, CheckConsistent(cfg0, cfg1))
+		require.Error(t, CheckConsistent(cfg0, cfg0))
+
+		cfg1.Session = "run-2"
+		require.Error(t, CheckConsistent(cfg0, cfg1))
+	})
+
+	t.Run("Literal", func(t *testing.T) {
+		cfg, err := Parse([]byte(testLiteralYAML), "/cfg")
+		require.NoError(t, err)
+		require.Equal(t, fp, cfg.Fingerprint())
+
+		cfgHemi, err := Parse([]byte(testYAML), "/cfg")
+		require.NoError(t, err)
+		cfgHemi.Parameters = ParametersConfig{Preset: "HEMI"}
+		cfgHemi.Protocol = Hemi
+		require.ErrorIs(t, cfgHemi.Validate(), hpbfv.ErrDecryptionFailure)
+		cfgHemi.Parameters = ParametersConfig{Preset: "HEMI_V2"}
+		require.NoError(t, cfgHemi.Validate())
+		require.Error(t, CheckConsistent(cfg, cfgHemi))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, mutate := range map[string]func(*Config){
+			"ID":        func(c *Config) { c.ID = 2 },
+			"Session":   func(c *Config) { c.Session = "" },
+			"Protocol":  func(c *Config) { c.Protocol = "spdz" },
+			"Preset":    func(c *Config) { c.Parameters.Preset = "none" },
+			"Exclusive": func(c *Config) { c.Parameters.Literal = &ParametersLiteral{} },
+			"Literal": func(c *Config) {
+				c.Parameters = ParametersConfig{Literal: &ParametersLiteral{LogN: 14, B: "10792", D: 512, G: "1"}}
+			},
+			"Batches":     func(c *Config) { c.Batches = 0 },
+			"Output":      func(c *Config) { c.Output = "" },
+			"FrameSize":   func(c *Config) { c.MaxFrameSize = -1 },
+			"Key":         func(c *Config) { c.TLS = &TLS{Cert: "c"} },
+			"PeerCert":    func(c *Config) { c.Parties[0].Cert = "" },
+			"CRS":         func(c *Config) { c.CRS = "zz" },
+			"Flooding":    func(c *Config) { c.StatisticalSecurity = 100 },
+			"Proof":       func(c *Config) { c.StatisticalSecurity = 160 },
+			"Strict":      func(c *Config) { c.StrictSecurity, c.Parameters.Preset = true, "HPN13D10T128" },
+			"Fingerprint": func(c *Config) { c.Parties[0].Fingerprint = hex.EncodeToString(make([]byte, hpbfv.FingerprintSize)) },
+		} {
+			cfg, err := Parse([]byte(testYAML), "/cfg")
+			require.NoError(t, err)
+			mutate(cfg)
+			require.Error(t, cfg.Validate(), name)
+		}
+
+		// the Soho preprocessing cannot flood its decryptions within the modulus of SOHO
+		cfg, err := Parse([]byte(testYAML), "/cfg")
+		require.NoError(t, err)
+		cfg.Parameters.Preset = "SOHO"
+		require.ErrorIs(t, cfg.Validate(), hpbfv.ErrDecryptionFailure)
+
+		cfg, err = Parse([]byte(testYAML), "/cfg")
+		require.NoError(t, err)
+		cfg.Parties[0].Fingerprint = hex.EncodeToString(fp[:])
+		require.NoError(t, cfg.Validate())
+
+		_, err = Parse([]byte(testYAML+"unknown: 1\n"), "/cfg")
+		require.Error(t, err)
+		_, err = Parse(nil, "/cfg")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,432 @@
+// Package field implements the arithmetic of the prime field Z_T for a modulus T chosen at runtime,
+// on elements of a fixed number of 64-bit limbs.
+//
+// Unlike *big.Int, the elements of a Field are always reduced and have the same size, so a vector of
+// elements is a single allocation and the arithmetic never allocates. This is the representation of
+// the plaintext slots, the shares and the MACs of the protocol.
+package field
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math/big"
+	"math/bits"
+
+	"spdz-go/utils"
+)
+
+// stackLimbs is the largest number of limbs whose scratch space is kept on the stack, and smallLimbs the
+// largest one of the smaller scratch space of the Montgomery products.
+const (
+	stackLimbs = 64
+	smallLimbs = 6
+)
+
+// Field is the field Z_T for a prime T. A Field is immutable and can be used concurrently.
+type Field struct {
+	modulus *big.Int
+	limbs   int
+	size    int      // size in bytes of the encoding of an element
+	t       []uint64 // limbs of T
+	tInv    uint64   // -T^-1 mod 2^64
+	rr      []uint64 // R^2 mod T, for R = 2^(64*limbs)
+	one     []uint64
+	topMask byte     // mask of the bits of T in the first byte of an encoding
+	tMinus2 *big.Int // exponent of the inversion
+}
+
+// Element is an element of a Field, stored as its representative in [0, T) on little-endian
+// 64-bit limbs. It is only valid for the Field that created it, and its length is the number
+// of limbs of that Field.
+type Element []uint64
+
+// NewField returns the field Z_T. The modulus must be an odd prime, which is not checked beyond its parity.
+func NewField(t *big.Int) (*Field, error) {
+	if t.Cmp(big.NewInt(3)) < 0 || t.Bit(0) == 0 {
+		return nil, errors.New("the modulus must be an odd prime")
+	}
+
+	f := new(Field)
+	f.modulus = new(big.Int).Set(t)
+	f.limbs = (t.BitLen() + 63) / 64
+	f.size = (t.BitLen() + 7) / 8
+	f.topMask = byte(1<<uint((t.BitLen()-1)%8+1) - 1)
+	f.tMinus2 = new(big.Int).Sub(t, big.NewInt(2))
+
+	f.t = f.NewElement()
+	setWords(f.t, t.Bits())
+
+	// Newton iteration for T^-1 mod 2^64, which doubles the number of correct bits at each step
+	inv := f.t[0]
+	for i := 0; i < 5; i++ {
+		inv *= 2 - f.t[0]*inv
+	}
+	f.tInv = -inv
+
+	rr := new(big.Int).Lsh(big.NewInt(1), uint(128*f.limbs))
+	f.rr = f.NewElement()
+	setWords(f.rr, rr.Mod(rr, t).Bits())
+
+	f.one = f.NewElement()
+	f.one[0] = 1
+
+	return f, nil
+}
+
+// Modulus returns a copy of the modulus T.
+func (f *Field) Modulus() *big.Int {
+	return new(big.Int).Set(f.modulus)
+}
+
+// Limbs returns the number of 64-bit limbs of the elements.
+func (f *Field) Limbs() int {
+	return f.limbs
+}
+
+// ElementSize returns the size in bytes of the encoding of an element, see Encode.
+func (f *Field) ElementSize() int {
+	return f.size
+}
+
+// NewElement returns a new element set to zero.
+func (f *Field) NewElement() Element {
+	return make(Element, f.limbs)
+}
+
+// NewVector returns n new elements set to zero, stored in a single allocation.
+func (f *Field) NewVector(n int) []Element {
+	buf := make([]uint64, n*f.limbs)
+	v := make([]Element, n)
+	for i := range v {
+		v[i] = buf[i*f.limbs : (i+1)*f.limbs : (i+1)*f.limbs]
+	}
+	return v
+}
+
+// Set sets z to x and returns z.
+func (f *Field) Set(z, x Element) Element {
+	copy(z, x)
+	return z
+}
+
+// SetUint64 sets z to x mod T and returns z.
+func (f *Field) SetUint64(z Element, x uint64) Element {
+	for i := range z {
+		z[i] = 0
+	}
+	if f.limbs == 1 {
+		x %= f.t[0]
+	}
+	z[0] = x
+	return z
+}
+
+// SetBig sets z to x mod T, for any x, and returns z.
+func (f *Field) SetBig(z Element, x *big.Int) Element {
+	if x.Sign() < 0 || x.Cmp(f.modulus) >= 0 {
+		x = new(big.Int).Mod(x, f.modulus)
+	}
+	setWords(z, x.Bits())
+	return z
+}
+
+// NewElementFromBig returns a new element set to x mod T.
+func (f *Field) NewElementFromBig(x *big.Int) Element {
+	return f.SetBig(f.NewElement(), x)
+}
+
+// Big returns x as a new *big.Int.
+func (f *Field) Big(x Element) *big.Int {
+	return f.ToBig(new(big.Int).SetBits(make([]big.Word, 0, f.limbs*64/bits.UintSize)), x)
+}
+
+// ToBig sets z to x, reusing the memory of z, and returns z.
+func (f *Field) ToBig(z *big.Int, x Element) *big.Int {
+	words := z.Bits()[:0]
+	for _, l := range x {
+		if bits.UintSize == 64 {
+			words = append(words, big.Word(l))
+		} else {
+			words = append(words, big.Word(uint32(l)), big.Word(l>>32))
+		}
+	}
+	return z.SetBits(words)
+}
+
+// IsZero reports in constant time whether x is zero.
+func (f *Field) IsZero(x Element) bool {
+	var acc uint64
+	for _, l := range x {
+		acc |= l
+	}
+	return isZeroWord(acc)
+}
+
+// Equal reports in constant time whether x and y are equal.
+func (f *Field) Equal(x, y Element) bool {
+	var acc uint64
+	for i := range x {
+		acc |= x[i] ^ y[i]
+	}
+	return isZeroWord(acc)
+}
+
+// Add sets z to x+y and returns z. z may alias x or y.
+func (f *Field) Add(z, x, y Element) Element {
+	var c uint64
+	for i := range z {
+		z[i], c = bits.Add64(x[i], y[i], c)
+	}
+	if c != 0 || !lessLimbs(z, f.t) {
+		subLimbs(z, z, f.t)
+	}
+	return z
+}
+
+// Sub sets z to x-y and returns z. z may alias x or y.
+func (f *Field) Sub(z, x, y Element) Element {
+	if subLimbs(z, x, y) != 0 {
+		addLimbs(z, z, f.t)
+	}
+	return z
+}
+
+// Neg sets z to -x and returns z. z may alias x.
+func (f *Field) Neg(z, x Element) Element {
+	if f.IsZero(x) {
+		return f.Set(z, x)
+	}
+	subLimbs(z, f.t, x)
+	return z
+}
+
+// Mul sets z to x*y and returns z. z may alias x or y.
+func (f *Field) Mul(z, x, y Element) Element {
+	var stack [3*stackLimbs + 2]uint64
+	buf := f.scratch(stack[:])
+	u, t := buf[:f.limbs], buf[2*f.limbs:]
+	// the Montgomery product divides by R, which the second one by R^2 compensates
+	f.montMul(u, x, y, t)
+	f.montMul(z, u, f.rr, t)
+	return z
+}
+
+// Exp sets z to x^e, for e >= 0, and returns z. z may alias x.
+func (f *Field) Exp(z, x Element, e *big.Int) Element {
+	if e.Sign() < 0 {
+		panic("cannot Exp: negative exponent")
+	}
+	var stack [3*stackLimbs + 2]uint64
+	buf := f.scratch(stack[:])
+	acc, base, t := buf[:f.limbs], buf[f.limbs:2*f.limbs], buf[2*f.limbs:]
+
+	// square-and-multiply in the Montgomery representation
+	f.montMul(base, x, f.rr, t)
+	f.montMul(acc, f.one, f.rr, t)
+	for i := e.BitLen() - 1; i >= 0; i-- {
+		f.montMul(acc, acc, acc, t)
+		if e.Bit(i) == 1 {
+			f.montMul(acc, acc, base, t)
+		}
+	}
+	f.montMul(z, acc, f.one, t)
+	return z
+}
+
+// Inv sets z to x^-1 and returns z, or sets z to zero if x is zero. z may alias x.
+func (f *Field) Inv(z, x Element) Element {
+	return f.Exp(z, x, f.tMinus2)
+}
+
+// The Montgomery representation of x is x*R mod T, for R = 2^(64*Limbs). Add, Sub and Neg apply to it
+// unchanged, and MontMul multiplies in it with a single reduction instead of the two of Mul, which suits
+// long chains of products such as number-theoretic transforms.
+
+// ToMont sets z to the Montgomery representation of x and returns z. z may alias x.
+func (f *Field) ToMont(z, x Element) Element {
+	f.montMulScratch(z, x, f.rr)
+	return z
+}
+
+// FromMont sets z to the element of Montgomery representation x and returns z. z may alias x.
+func (f *Field) FromMont(z, x Element) Element {
+	f.montMulScratch(z, x, f.one)
+	return z
+}
+
+// MontMul sets z to x*y/R and returns z, which is the Montgomery representation of the product of the
+// elements of Montgomery representations x and y. z may alias x or y.
+// The operand y may also be a short operand of fewer limbs, see SetWords, in which case z is
+// x*y/2^(64*len(y)) mod T and the product only costs len(y) passes over the limbs of x.
+func (f *Field) MontMul(z, x, y Element) Element {
+	f.montMulScratch(z, x, y)
+	return z
+}
+
+// SetWords sets the limbs of z to 0 <= x < 2^(64*len(z)), such as a short operand of MontMul.
+func SetWords(z []uint64, x *big.Int) {
+	setWords(z, x.Bits())
+}
+
+// Sample sets the elements zs to independent uniform elements read from prng, by rejection sampling
+// of ElementSize bytes at a time.
+func (f *Field) Sample(prng utils.PRNG, zs ...Element) {
+	buf := make([]byte, f.size)
+	for _, z := range zs {
+		for {
+			if _, err := prng.Read(buf); err != nil {
+				panic("cannot Sample: PRNG read error")
+			}
+			buf[0] &= f.topMask
+			setBytes(z, buf)
+			if lessLimbs(z, f.t) {
+				break
+			}
+		}
+	}
+}
+
+// Encode writes x in the ElementSize bytes of b, in big-endian order.
+func (f *Field) Encode(b []byte, x Element) {
+	b = b[:f.size]
+	for i := range b {
+		j := len(b) - 1 - i
+		b[j] = byte(x[i/8] >> (8 * uint(i%8)))
+	}
+}
+
+// Decode sets z to the element encoded in the ElementSize bytes of b, and returns an error if the
+// encoded integer is not reduced modulo T.
+func (f *Field) Decode(z Element, b []byte) error {
+	if len(b) != f.size {
+		return errors.New("invalid element encoding size")
+	}
+	setBytes(z, b)
+	if !lessLimbs(z, f.t) {
+		return errors.New("element is not reduced modulo T")
+	}
+	return nil
+}
+
+// scratch returns 3*limbs+2 words of scratch space, in stack if it is large enough.
+func (f *Field) scratch(stack []uint64) []uint64 {
+	if n := 3*f.limbs + 2; n <= len(stack) {
+		return stack[:n]
+	}
+	return make([]uint64, 3*f.limbs+2)
+}
+
+// montMulScratch calls montMul with scratch space on the stack, sized so that the products of the
+// elements of a few limbs do not pay for clearing the space of the largest ones.
+func (f *Field) montMulScratch(z, x, y []uint64) {
+	switch n := f.limbs + 2; {
+	case n <= smallLimbs+2:
+		var stack [smallLimbs + 2]uint64
+		f.montMul(z, x, y, stack[:])
+	case n <= stackLimbs+2:
+		var stack [stackLimbs + 2]uint64
+		f.montMul(z, x, y, stack[:])
+	default:
+		f.montMul(z, x, y, make([]uint64, n))
+	}
+}
+
+// montMul sets z to x*y/R mod T, which is the Montgomery product of x and y, using the limbs+2 words
+// of t. z may alias x or y.
+func (f *Field) montMul(z, x, y, t []uint64) {
+	n := f.limbs
+	t = t[:n+2]
+	for i := range t {
+		t[i] = 0
+	}
+
+	// coarsely integrated operand scanning
+	for _, yi := range y {
+		var c uint64
+		for j := 0; j < n; j++ {
+			c, t[j] = madd(x[j], yi, t[j], c)
+		}
+		var cc uint64
+		t[n], cc = bits.Add64(t[n], c, 0)
+		t[n+1] = cc
+
+		q := t[0] * f.tInv
+		c, _ = madd(q, f.t[0], t[0], 0)
+		for j := 1; j < n; j++ {
+			c, t[j-1] = madd(q, f.t[j], t[j], c)
+		}
+		t[n-1], cc = bits.Add64(t[n], c, 0)
+		t[n] = t[n+1] + cc
+	}
+
+	if t[n] != 0 || !lessLimbs(t[:n], f.t) {
+		subLimbs(t[:n], t[:n], f.t)
+	}
+	copy(z, t[:n])
+}
+
+// setWords writes the little-endian words of an integer smaller than 2^(64*len(z)) in z.
+func setWords(z []uint64, words []big.Word) {
+	for i := range z {
+		z[i] = 0
+	}
+	for i, w := range words {
+		if bits.UintSize == 64 {
+			z[i] = uint64(w)
+		} else {
+			z[i/2] |= uint64(w) << (32 * (i % 2))
+		}
+	}
+}
+
+// setBytes writes the big-endian bytes of an integer smaller than 2^(64*len(z)) in z.
+func setBytes(z []uint64, b []byte) {
+	for i := range z {
+		z[i] = 0
+	}
+	for i := range b {
+		z[i/8] |= uint64(b[len(b)-1-i]) << (8 * uint(i%8))
+	}
+}
+
+// isZeroWord returns x == 0 without branching on x.
+func isZeroWord(x uint64) bool {
+	return subtle.ConstantTimeEq(int32((x|-x)>>63), 0) == 1
+}
+
+// madd returns the high and low words of a*b + c + d.
+func madd(a, b, c, d uint64) (hi, lo uint64) {
+	hi, lo = bits.Mul64(a, b)
+	var cc uint64
+	lo, cc = bits.Add64(lo, c, 0)
+	hi += cc
+	lo, cc = bits.Add64(lo, d, 0)
+	hi += cc
+	return
+}
+
+// addLimbs sets z to x+y and returns the carry.
+func addLimbs(z, x, y []uint64) (c uint64) {
+	for i := range z {
+		z[i], c = bits.Add64(x[i], y[i], c)
+	}
+	return
+}
+
+// subLimbs sets z to x-y and returns the borrow.
+func subLimbs(z, x, y []uint64) (b uint64) {
+	for i := range z {
+		z[i], b = bits.Sub64(x[i], y[i], b)
+	}
+	return
+}
+
+// lessLimbs returns x < y.
+func lessLimbs(x, y []uint64) bool {
+	for i := len(x) - 1; i >= 0; i-- {
+		if x[i] != y[i] {
+			return x[i] < y[i]
+		}
+	}
+	return false
+}
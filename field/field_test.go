@@ -0,0 +1,109 @@
+package field
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"spdz-go/utils"
+)
+
+// testModuli are primes of one limb, of a limb and a bit, of several limbs, and with a partial top limb.
+var testModuli = []*big.Int{
+	big.NewInt(65537),
+	nextPrime(64),
+	nextPrime(192),
+	new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 521), big.NewInt(1)),
+}
+
+// nextPrime returns the smallest prime larger than 2^logP.
+func nextPrime(logP uint) *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), logP)
+	for p.Add(p, big.NewInt(1)); !p.ProbablyPrime(20); p.Add(p, big.NewInt(2)) {
+	}
+	return p
+}
+
+func TestField(t *testing.T) {
+	prng, err := utils.NewKeyedPRNG([]byte("field"))
+	require.NoError(t, err)
+
+	_, err = NewField(big.NewInt(1 << 20))
+	require.Error(t, err)
+
+	for _, m := range testModuli {
+		f, err := NewField(m)
+		require.NoError(t, err)
+
+		t.Run(fmt.Sprintf("T=%d-bits", m.BitLen()), func(t *testing.T) {
+			v := f.NewVector(3)
+			f.Sample(prng, v...)
+			x, y := f.Big(v[0]), f.Big(v[1])
+			require.True(t, x.Cmp(m) < 0 && y.Cmp(m) < 0)
+
+			want := func(z *big.Int) *big.Int { return z.Mod(z, m) }
+
+			z := f.NewElement()
+			require.Equal(t, want(new(big.Int).Add(x, y)), f.Big(f.Add(z, v[0], v[1])))
+			require.Equal(t, want(new(big.Int).Sub(x, y)), f.Big(f.Sub(z, v[0], v[1])))
+			require.Equal(t, want(new(big.Int).Neg(x)), f.Big(f.Neg(z, v[0])))
+			require.Equal(t, want(new(big.Int).Mul(x, y)), f.Big(f.Mul(z, v[0], v[1])))
+
+			e := big.NewInt(65539)
+			require.Equal(t, new(big.Int).Exp(x, e, m), f.Big(f.Exp(z, v[0], e)))
+			require.Equal(t, new(big.Int).ModInverse(x, m), f.Big(f.Inv(z, v[0])))
+			require.True(t, f.Equal(f.SetUint64(v[2], 1), f.Mul(z, z, v[0])))
+			require.True(t, f.IsZero(f.Inv(z, f.SetUint64(z, 0))))
+
+			// aliasing
+			f.Set(v[2], v[0])
+			f.Mul(v[2], v[2], v[2])
+			require.Equal(t, want(new(big.Int).Mul(x, x)), f.Big(v[2]))
+			require.Equal(t, x, f.Big(v[0]), "NewVector elements must not overlap")
+
+			// reduction of the inputs
+			require.Equal(t, x, f.Big(f.NewElementFromBig(new(big.Int).Sub(x, new(big.Int).Mul(m, big.NewInt(3))))))
+			require.True(t, f.IsZero(f.NewElementFromBig(m)))
+			require.True(t, f.Equal(f.SetUint64(z, 7), f.NewElementFromBig(big.NewInt(7))))
+			require.False(t, f.Equal(v[0], v[1]))
+			require.True(t, f.IsZero(f.Neg(z, f.SetUint64(z, 0))))
+
+			// Montgomery representation
+			xm, ym := f.ToMont(f.NewElement(), v[0]), f.ToMont(f.NewElement(), v[1])
+			require.Equal(t, want(new(big.Int).Mul(x, y)), f.Big(f.FromMont(z, f.MontMul(z, xm, ym))))
+			require.Equal(t, want(new(big.Int).Add(x, y)), f.Big(f.FromMont(z, f.Add(z, xm, ym))))
+			short := make([]uint64, 1)
+			SetWords(short, big.NewInt(12345))
+			got := f.Big(f.FromMont(z, f.MontMul(z, xm, short)))
+			require.Equal(t, want(new(big.Int).Mul(x, big.NewInt(12345))), want(got.Lsh(got, 64)))
+			require.Equal(t, x, f.ToBig(big.NewInt(-1), v[0]))
+
+			// encoding
+			buf := make([]byte, f.ElementSize())
+			f.Encode(buf, v[0])
+			require.Equal(t, x.FillBytes(make([]byte, (m.BitLen()+7)/8)), buf)
+			require.NoError(t, f.Decode(z, buf))
+			require.True(t, f.Equal(v[0], z))
+			require.Error(t, f.Decode(z, m.FillBytes(make([]byte, f.ElementSize()))))
+			require.Error(t, f.Decode(z, buf[1:]))
+		})
+	}
+
+	t.Run("Allocations", func(t *testing.T) {
+		f, err := NewField(testModuli[3])
+		require.NoError(t, err)
+		v := f.NewVector(2)
+		f.Sample(prng, v...)
+		z := f.NewElement()
+		allocs := testing.AllocsPerRun(10, func() {
+			f.Add(z, v[0], v[1])
+			f.Sub(z, z, v[1])
+			f.Mul(z, z, v[1])
+			f.Inv(z, z)
+			f.FromMont(z, f.MontMul(z, f.ToMont(z, z), v[1]))
+		})
+		require.Zero(t, allocs)
+	})
+}
@@ -0,0 +1,41 @@
+package hpbfv
+
+import "math/big"
+
+// barrett divides nonnegative integers by a fixed divisor d with multiplications and shifts only,
+// which, unlike big.Int.QuoRem, does not allocate when d is small. The quotient is estimated from
+// mu = floor(2^s/d), and is exact up to two corrections for dividends smaller than 2^s.
+type barrett struct {
+	d, mu *big.Int
+	k, s  uint // bit-sizes of d and of the dividends
+	buf   *big.Int
+}
+
+// newBarrett returns a barrett for the divisor d > 0 and dividends of at most maxBits bits.
+func newBarrett(d *big.Int, maxBits int) *barrett {
+	br := &barrett{d: new(big.Int).Set(d), k: uint(d.BitLen()), buf: new(big.Int)}
+	if maxBits < d.BitLen() {
+		maxBits = d.BitLen()
+	}
+	br.s = uint(maxBits)
+	br.mu = new(big.Int).Lsh(big.NewInt(1), br.s)
+	br.mu.Quo(br.mu, d)
+	return br
+}
+
+// quoRem sets q and r to the quotient and remainder of a >= 0 by d. The three must be distinct.
+func (br *barrett) quoRem(q, r, a *big.Int) {
+	// floor((a / 2^(k-1)) * mu / 2^(s-k+1)) underestimates the quotient by at most 2
+	br.buf.Rsh(a, br.k-1)
+	q.Mul(br.buf, br.mu)
+	q.Rsh(q, br.s-br.k+1)
+
+	r.Mul(q, br.d)
+	r.Sub(a, r)
+	for r.Cmp(br.d) >= 0 {
+		r.Sub(r, br.d)
+		q.Add(q, bigOne)
+	}
+}
+
+var bigOne = big.NewInt(1)
@@ -1,19 +1,50 @@
 package hpbfv
 
-import "spdz-go/rlwe"
+import (
+	"math"
+
+	"spdz-go/rlwe"
+)
 
 type Ciphertext struct {
 	*rlwe.Ciphertext
+
+	noise      float64 // log2 of the estimated standard deviation of the noise, NaN if unknown
+	numParties int     // number of parties whose secrets sum to the decryption key
 }
 
 // NewCiphertext creates a new ciphertext parameterized by degree, level and scale.
+// The new ciphertext is an encryption of zero without noise.
 func NewCiphertext(params Parameters, degree int) (ciphertext *Ciphertext) {
-	return &Ciphertext{rlwe.NewCiphertext(params.Parameters, degree, params.MaxLevel())}
+	return &Ciphertext{Ciphertext: rlwe.NewCiphertext(params.Parameters, degree, params.MaxLevel()), noise: math.Inf(-1)}
 }
 
 // CopyNew creates a deep copy of the receiver ciphertext and returns it.
 func (ct *Ciphertext) CopyNew() *Ciphertext {
-	return &Ciphertext{ct.Ciphertext.CopyNew()}
+	return &Ciphertext{Ciphertext: ct.Ciphertext.CopyNew(), noise: ct.noise, numParties: ct.numParties}
+}
+
+// Noise returns the log2 of the estimated standard deviation of the noise of the ciphertext, see
+// Parameters.FreshNoise, and the number of parties of the key it is encrypted under. The noise is NaN
+// if it is unknown, as for ciphertexts decoded from bytes.
+func (ct *Ciphertext) Noise() (noise float64, numParties int) {
+	return ct.noise, ct.numParties
+}
+
+// SetNoise sets the estimated noise of the ciphertext and the number of parties of its key, see Noise.
+func (ct *Ciphertext) SetNoise(noise float64, numParties int) {
+	ct.noise, ct.numParties = noise, numParties
+}
+
+// setNoiseOf sets the noise estimate of ct to noise, under the key of the largest number of parties of ops.
+func (ct *Ciphertext) setNoiseOf(noise float64, ops ...*Ciphertext) {
+	numParties := 0
+	for _, op := range ops {
+		if op.numParties > numParties {
+			numParties = op.numParties
+		}
+	}
+	ct.SetNoise(noise, numParties)
 }
 
 // MarshalBinary encodes a Ciphertext in a byte slice.
@@ -24,5 +55,6 @@ func (ct *Ciphertext) MarshalBinary() (data []byte, err error) {
 // UnmarshalBinary decodes a previously marshaled Ciphertext in the target Ciphertext.
 func (ct *Ciphertext) UnmarshalBinary(data []byte) (err error) {
 	ct.Ciphertext = new(rlwe.Ciphertext)
+	ct.noise, ct.numParties = math.NaN(), 0
 	return ct.Ciphertext.UnmarshalBinary(data)
 }
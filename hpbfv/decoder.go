@@ -2,93 +2,110 @@ package hpbfv
 
 import (
 	"math/big"
+	"math/bits"
 
+	"spdz-go/field"
 	"spdz-go/ring"
 )
 
+// Decoder decodes plaintexts of R_Q scaled by Q/T into messages of Z_T^D.
+//
+// Like the Encoder, it works on the elements of params.Field() in the Montgomery representation with
+// precomputed twiddles, and the reduction by X^D - B only uses preallocated buffers.
+// A Decoder must not be used concurrently.
 type Decoder struct {
 	params Parameters
-
-	polyPool *ring.Poly
-
-	nttRoots   *Message
-	msgPool    *Message
-	coeffPool1 []*big.Int
-	coeffPool2 []*big.Int
+	field  *field.Field
+
+	twiddles []field.Element // twiddles of the NTT, see nttTwiddles
+	buff     []field.Element // D elements mod T
+
+	// Horner's rule modulo T with the short operands B and y, see Decode
+	b, y   []uint64        // limbs of B and of y + B + 1
+	horner []field.Element // 2^(64*(limbs + len(b)*i + len(y))) mod T for i < K
+	offset field.Element   // (B + 1) * (1 + B + ... + B^(K-1)) mod T
+	term   field.Element
+
+	// buffers of the scaling by 1/Q
+	coeffs        []*big.Int // coefficients of the plaintext in [0, Q)
+	qDivQi        []*big.Int // Q/qi
+	qDivQiInv     []uint64   // (Q/qi)^-1 mod qi
+	modQ, divQ    *barrett   // reduction modulo Q of the CRT sum, and division by Q of the scaled coefficients
+	bBig, yOffset *big.Int   // B and (B + 1) * Q + Q/2
+	num, prod, w  *big.Int
+	quo, rem      *big.Int
 }
 
 func NewDecoder(params Parameters) (dcd *Decoder) {
 	dcd = new(Decoder)
 	dcd.params = params
-	dcd.polyPool = params.RingQ().NewPoly()
-	dcd.nttRoots = NewMessage(params)
-	dcd.msgPool = NewMessage(params)
-	dcd.coeffPool1 = make([]*big.Int, params.N())
-	dcd.coeffPool2 = make([]*big.Int, params.N())
-	for i := 0; i < params.N(); i++ {
-		dcd.coeffPool1[i] = big.NewInt(0)
-		dcd.coeffPool2[i] = big.NewInt(0)
-	}
 
 	slots := params.Slots()
-	root := params.Root()
-	roots := dcd.nttRoots
-	k := params.N() / params.Slots()
+	k := params.N() / slots
+	ringQ := params.RingQ()
+	t, b := params.T(), params.b
 
-	//compute ntt roots
-	for i := 0; i < slots; i++ {
-		//roots.Value[i].Exp(root, big.NewInt(int64(2*k*i+1)), params.T)
+	f := params.Field()
+	dcd.field = f
+	dcd.buff = f.NewVector(slots)
+
+	//compute ntt roots root^(5^(ik/2) mod 2N)
+	root := f.NewElementFromBig(params.Root())
+	pows := newPowTable(f, f.ToMont(root, root), 2*params.N())
+	roots := f.NewVector(slots / 2)
+	for i := range roots {
 		e := ring.ModExp(5, uint64((k/2)*i), uint64(params.N()*2))
-		roots.Value[i].Exp(root, big.NewInt(int64(e)), params.T())
+		pows.exp(roots[i], e)
 	}
-
-	return
-}
-
-func (dcd *Decoder) ntt(msgIn, msgOut *Message) {
-	slots := dcd.params.Slots()
-	roots := dcd.nttRoots
-
-	if msgIn != msgOut {
-		for i := 0; i < slots; i++ {
-			msgOut.Value[i].Set(msgIn.Value[i])
-		}
+	dcd.twiddles = nttTwiddles(f, roots, slots)
+
+	// the scaled coefficients are in [0, B + 2]
+	dcd.b = make([]uint64, (b.BitLen()+63)/64)
+	field.SetWords(dcd.b, b)
+	dcd.y = make([]uint64, (new(big.Int).Add(b, big.NewInt(3)).BitLen()+63)/64)
+
+	dcd.horner = f.NewVector(k)
+	pow := new(big.Int).Lsh(bigOne, uint(64*(f.Limbs()+len(dcd.y))))
+	step := new(big.Int).Lsh(bigOne, uint(64*len(dcd.b)))
+	for i := 0; i < k; i++ {
+		f.SetBig(dcd.horner[i], pow.Mod(pow, t))
+		pow.Mul(pow, step)
 	}
 
-	//apply bit reversal
-	j := 0
-	for i := 1; i < slots; i++ {
-		bit := (slots >> 1)
-		for j >= bit {
-			j -= bit
-			bit >>= 1
-		}
-		j += bit
-		if i < j {
-			msgOut.Value[i], msgOut.Value[j] = msgOut.Value[j], msgOut.Value[i]
-		}
+	sum, bi := new(big.Int), big.NewInt(1)
+	for i := 0; i < k; i++ {
+		sum.Add(sum, bi)
+		bi.Mul(bi, b)
 	}
+	dcd.offset = f.NewElementFromBig(sum.Mul(sum, new(big.Int).Add(b, bigOne)))
+	f.ToMont(dcd.offset, dcd.offset)
+	dcd.term = f.NewElement()
 
-	//apply inplace NTT
-	for i := 2; i <= slots; i <<= 1 {
-		step := slots / i
-		for j := 0; j < slots; j += i {
-			for k := 0; k < i/2; k++ {
-				u := new(big.Int).Set(msgOut.Value[j+k])
-				v := new(big.Int).Exp(roots.Value[k], big.NewInt(int64(step)), dcd.params.T())
-				v.Mul(msgOut.Value[j+k+i/2], v)
-				msgOut.Value[j+k].Add(u, v)
-				msgOut.Value[j+k+i/2].Sub(u, v)
-
-				msgOut.Value[j+k].Mod(msgOut.Value[j+k], dcd.params.T())
-				msgOut.Value[j+k+i/2].Mod(msgOut.Value[j+k+i/2], dcd.params.T())
-			}
-		}
+	dcd.coeffs = make([]*big.Int, params.N())
+	for i := range dcd.coeffs {
+		dcd.coeffs[i] = new(big.Int)
 	}
 
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Mod(msgOut.Value[i], dcd.params.T())
+	qBig := params.QBigInt()
+	dcd.qDivQi = make([]*big.Int, len(ringQ.Modulus))
+	dcd.qDivQiInv = make([]uint64, len(ringQ.Modulus))
+	for i, qi := range ringQ.Modulus {
+		qiBig := new(big.Int).SetUint64(qi)
+		dcd.qDivQi[i] = new(big.Int).Quo(qBig, qiBig)
+		dcd.qDivQiInv[i] = new(big.Int).ModInverse(dcd.qDivQi[i], qiBig).Uint64()
 	}
+
+	dcd.bBig = new(big.Int).Set(b)
+	dcd.yOffset = new(big.Int).Mul(new(big.Int).Add(b, bigOne), qBig)
+	dcd.yOffset.Add(dcd.yOffset, new(big.Int).Rsh(qBig, 1))
+
+	dcd.modQ = newBarrett(qBig, qBig.BitLen()+bits.Len(uint(len(ringQ.Modulus))))
+	dcd.divQ = newBarrett(qBig, new(big.Int).Add(dcd.yOffset, qBig).BitLen())
+
+	dcd.num, dcd.prod, dcd.w = new(big.Int), new(big.Int), new(big.Int)
+	dcd.quo, dcd.rem = new(big.Int), new(big.Int)
+
+	return
 }
 
 func (dcd *Decoder) DecodeNew(ptxtIn *Plaintext) (msgOut *Message) {
@@ -99,47 +116,100 @@ func (dcd *Decoder) DecodeNew(ptxtIn *Plaintext) (msgOut *Message) {
 
 func (dcd *Decoder) Decode(ptxtIn *Plaintext, msgOut *Message) {
 	params := dcd.params
-	ringQ := params.RingQ()
+	f := dcd.field
 	slots := params.Slots()
-	d := int(params.D())
+	k := params.N() / slots
 
-	for i := 0; i < params.N(); i++ {
-		dcd.coeffPool1[i].SetInt64(0)
-		dcd.coeffPool2[i].SetInt64(0)
+	for i := range dcd.coeffs {
+		dcd.reconstruct(ptxtIn.Value, i)
 	}
 
-	// mult (X^d-b)/q to ptxt
-
-	ringQ.PolyToBigint(ptxtIn.Value, 1, dcd.coeffPool1)
+	// mult (X^d-b)/q to ptxt, and reduce modulo X^d-b: the slot j is the sum of the scaled
+	// coefficients y_(j+i*d) times b^i, computed by Horner's rule. Since b and y are short,
+	// the products are only partially reduced, by 2^(64*len(b)) and 2^(64*len(y)), which the
+	// constants of horner compensate so that the sum ends up in the Montgomery representation.
+	for j := 0; j < slots; j++ {
+		acc := dcd.buff[j]
+		for i := k - 1; i >= 0; i-- {
+			field.SetWords(dcd.y, dcd.scaleDown(j+i*slots))
+			f.MontMul(dcd.term, dcd.horner[i], dcd.y)
+			if i == k-1 {
+				f.Set(acc, dcd.term)
+				continue
+			}
+			f.MontMul(acc, acc, dcd.b)
+			f.Add(acc, acc, dcd.term)
+		}
+		// the scaled coefficients are offset by b + 1
+		f.Sub(acc, acc, dcd.offset)
+	}
 
-	for i := 0; i < params.N(); i++ {
-		tmp := new(big.Int).Mul(dcd.coeffPool1[i], new(big.Int).Neg(params.b))
-		dcd.coeffPool2[i].Add(dcd.coeffPool2[i], tmp)
+	//apply NTT
+	nttInPlace(f, dcd.buff, dcd.twiddles, dcd.term)
 
-		if i+d < params.N() {
-			dcd.coeffPool2[i+d].Add(dcd.coeffPool2[i+d], dcd.coeffPool1[i])
-		} else {
-			dcd.coeffPool2[i+d-params.N()].Sub(dcd.coeffPool2[i+d-params.N()], dcd.coeffPool1[i])
-		}
+	for i := 0; i < slots; i++ {
+		f.FromMont(msgOut.Value[i], dcd.buff[i])
 	}
+}
+
+// reconstruct sets the i-th coefficient of dcd.coeffs to the i-th coefficient of pol in [0, Q).
+func (dcd *Decoder) reconstruct(pol *ring.Poly, i int) {
+	ringQ := dcd.params.RingQ()
 
-	qHalf := new(big.Int).Div(params.QBigInt(), big.NewInt(2))
-	for i := 0; i < params.N(); i++ {
-		dcd.coeffPool2[i].Add(dcd.coeffPool2[i], qHalf)
-		dcd.coeffPool2[i].Div(dcd.coeffPool2[i], params.QBigInt())
+	dcd.num.SetUint64(0)
+	for l := 0; l < pol.Level()+1; l++ {
+		dcd.w.SetUint64(ring.BRed(pol.Coeffs[l][i], dcd.qDivQiInv[l], ringQ.Modulus[l], ringQ.BredParams[l]))
+		dcd.num.Add(dcd.num, dcd.prod.Mul(dcd.w, dcd.qDivQi[l]))
 	}
+	dcd.modQ.quoRem(dcd.quo, dcd.coeffs[i], dcd.num)
+}
 
-	for i := params.N() - 1; i >= slots; i-- {
-		dcd.coeffPool2[i].Mul(dcd.coeffPool2[i], params.b)
-		dcd.coeffPool2[i-slots].Add(dcd.coeffPool2[i-slots], dcd.coeffPool2[i])
-		dcd.coeffPool2[i-slots].Mod(dcd.coeffPool2[i-slots], dcd.params.T())
+// scaleDown returns y + b + 1 in [0, b + 2], for y = round(c/Q) and c the coefficient idx of the
+// product of the plaintext by X^d-b. The result is only valid until the next call.
+func (dcd *Decoder) scaleDown(idx int) *big.Int {
+	n, d := dcd.params.N(), dcd.params.Slots()
+
+	y := dcd.num.Mul(dcd.coeffs[idx], dcd.bBig)
+	y.Sub(dcd.yOffset, y)
+	if idx >= d {
+		y.Add(y, dcd.coeffs[idx-d])
+	} else {
+		y.Sub(y, dcd.coeffs[idx-d+n])
 	}
 
-	//apply NTT
+	dcd.divQ.quoRem(dcd.quo, dcd.rem, y)
+	return dcd.quo
+}
 
-	for i := 0; i < slots; i++ {
-		dcd.msgPool.Value[i].Set(dcd.coeffPool2[i])
+// powTable computes the powers x^e for e < 2^(2w) with a single product, from the
+// tables of x^lo and x^(hi*2^w) for lo, hi < 2^w, in the Montgomery representation.
+type powTable struct {
+	field  *field.Field
+	w      int
+	lo, hi []field.Element
+}
+
+// newPowTable returns the powTable of x, in the Montgomery representation, for exponents smaller than bound.
+func newPowTable(f *field.Field, x field.Element, bound int) *powTable {
+	w := (bits.Len(uint(bound)) + 1) / 2
+	size := 1 << w
+
+	pt := &powTable{field: f, w: w, lo: f.NewVector(size), hi: f.NewVector(size)}
+	f.ToMont(pt.lo[0], f.SetUint64(pt.lo[0], 1))
+	f.Set(pt.hi[0], pt.lo[0])
+	for i := 1; i < size; i++ {
+		f.MontMul(pt.lo[i], pt.lo[i-1], x)
 	}
 
-	dcd.ntt(dcd.msgPool, msgOut)
+	step := f.MontMul(f.NewElement(), pt.lo[size-1], x)
+	for i := 1; i < size; i++ {
+		f.MontMul(pt.hi[i], pt.hi[i-1], step)
+	}
+	return pt
+}
+
+// exp sets z to x^e.
+func (pt *powTable) exp(z field.Element, e uint64) {
+	mask := uint64(1)<<uint(pt.w) - 1
+	pt.field.MontMul(z, pt.lo[e&mask], pt.hi[e>>uint(pt.w)])
 }
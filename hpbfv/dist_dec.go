@@ -1,18 +1,22 @@
 package hpbfv
 
 import (
+	"fmt"
+
 	"spdz-go/rlwe"
 
 	"spdz-go/ring"
 	"spdz-go/utils"
 
+	"math"
 	"math/big"
 )
 
 type DistributedDecryptor struct {
 	Decryptor
-	buff *ring.Poly
-	sk   *rlwe.SecretKey
+	buff      *ring.Poly
+	buffProof *ring.Poly
+	sk        *rlwe.SecretKey
 
 	prng utils.PRNG
 }
@@ -25,6 +29,7 @@ func NewDistributedDecryptor(params Parameters, sk *rlwe.SecretKey) (dec *Distri
 	dec = new(DistributedDecryptor)
 	dec.Decryptor = *NewDecryptor(params, sk)
 	dec.buff = params.RingQ().NewPoly()
+	dec.buffProof = params.RingQ().NewPoly()
 	dec.sk = sk
 
 	prng, err := utils.NewPRNG()
@@ -36,6 +41,12 @@ func NewDistributedDecryptor(params Parameters, sk *rlwe.SecretKey) (dec *Distri
 }
 
 func (dec *DistributedDecryptor) PartialDecrypt(ct *Ciphertext, noiseBits int) *DistDecShare {
+	return dec.partialDecrypt(ct, dec.sk.Value.Q, noiseBits, dec.prng)
+}
+
+// partialDecrypt returns the decryption share of ct under the secret skQ, in the coefficient domain,
+// flooded with a centered noise of noiseBits bits read from prng, see addNoise.
+func (dec *DistributedDecryptor) partialDecrypt(ct *Ciphertext, skQ *ring.Poly, noiseBits int, prng utils.PRNG) *DistDecShare {
 	level := ct.Level()
 
 	ringQ := dec.params.RingQ()
@@ -47,14 +58,28 @@ func (dec *DistributedDecryptor) PartialDecrypt(ct *Ciphertext, noiseBits int) *
 
 	ringQ.NTTLazyLvl(level, ct.Value[1], share)
 
-	ringQ.MulCoeffsMontgomeryLvl(level, share, dec.sk.Value.Q, share)
+	ringQ.MulCoeffsMontgomeryLvl(level, share, skQ, share)
 
 	ringQ.InvNTTLvl(level, share, share)
-	dec.addNoise(share, noiseBits)
+	addNoise(ringQ, share, noiseBits, prng)
 
 	return &DistDecShare{share}
 }
 
+// PartialDecryptWithSecurity behaves as PartialDecrypt, with the flooding noise chosen from the estimated
+// noise of ct, see Ciphertext.Noise and Parameters.FloodingNoiseBits, so that the joint decryption of the
+// shares of all parties statistically hides the noise of ct with security parameter statSec.
+// It returns an error wrapping ErrDecryptionFailure if the flooded ciphertext would not decrypt correctly,
+// and an error if the noise of ct is unknown.
+func (dec *DistributedDecryptor) PartialDecryptWithSecurity(ct *Ciphertext, statSec int) (*DistDecShare, error) {
+	noise, numParties := ct.Noise()
+	noiseBits, err := dec.params.FloodingNoiseBits(noise, numParties, statSec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot PartialDecryptWithSecurity: %w", err)
+	}
+	return dec.PartialDecrypt(ct, noiseBits), nil
+}
+
 func (dec *DistributedDecryptor) PartialDecryptNew(ct *Ciphertext, noiseBits int) *DistDecShare {
 	return dec.PartialDecrypt(ct, noiseBits)
 }
@@ -102,23 +127,35 @@ func (dec *DistributedDecryptor) JointDecryptToMsgNew(ct *Ciphertext, shares []*
 	return
 }
 
-// The logic is for RNS representation
-func (dec *DistributedDecryptor) addNoise(pol *ring.Poly, noiseBits int) {
-	ringQ := dec.params.RingQ()
+// FloodNoise adds to ct, in the coefficient domain, a centered noise of noiseBits bits read from prng, see
+// Parameters.CiphertextFloodingNoiseBits, and updates its noise estimate.
+func FloodNoise(params Parameters, ct *Ciphertext, noiseBits int, prng utils.PRNG) {
+	addNoise(params.RingQ(), ct.Value[0], noiseBits, prng)
+	// the flooding noise is uniform, of standard deviation 2^(noiseBits-1)/sqrt(3)
+	noise, numParties := ct.Noise()
+	ct.SetNoise(params.AddNoise(noise, float64(noiseBits-1)-0.5*math.Log2(3)), numParties)
+}
+
+// addNoise adds to pol, in the coefficient domain, a flooding noise with coefficients uniform in
+// (-2^(noiseBits-1), 2^(noiseBits-1)] read from prng. The noise is centered so that it does not bias the
+// joint decryption. The logic is for RNS representation.
+func addNoise(ringQ *ring.Ring, pol *ring.Poly, noiseBits int, prng utils.PRNG) {
 	buf := make([]byte, (noiseBits+7)/8)
 
+	// Mask the noise to the desired bit-length and center it
+	mask := new(big.Int).Lsh(big.NewInt(1), uint(noiseBits))
+	mask.Sub(mask, big.NewInt(1))
+	offset := new(big.Int).Rsh(mask, 1)
+
 	for j := 0; j < ringQ.N; j++ {
-		_, err := dec.prng.Read(buf)
+		_, err := prng.Read(buf)
 		if err != nil {
 			panic(err)
 		}
 
 		noiseBig := new(big.Int).SetBytes(buf)
-
-		// Mask the noise to the desired bit-length
-		mask := new(big.Int).Lsh(big.NewInt(1), uint(noiseBits))
-		mask.Sub(mask, big.NewInt(1))
 		noiseBig.And(noiseBig, mask)
+		noiseBig.Sub(noiseBig, offset)
 
 		// Add the noise to each modulus
 		for i, qi := range ringQ.Modulus {
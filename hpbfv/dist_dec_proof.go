@@ -0,0 +1,356 @@
+package hpbfv
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"spdz-go/ring"
+	"spdz-go/rlwe"
+	"spdz-go/utils"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The proofs of decryption show that a decryption share of a ciphertext (c0, c1) is c1*s + e modulo Q for
+// the secret s of the partial public key b = e_pk - a*s of the party and a flooding noise e of at most
+// noiseBits bits. Without them, a party can add any polynomial to its share and shift the joint decryption
+// by it without being noticed.
+//
+// The relation (b, share) = (e_pk - a*s, c1*s + e) is linear in the witness (s, e_pk, e), so the proof is
+// the Fiat-Shamir Sigma protocol of the proofs of key generation, with the same challenges, masks, rejection
+// sampling and soundness slack. The party recovers e_pk from its secret key and its partial public key. The
+// mask of e is 2^PlaintextProofSlack times larger than the flooding noise, so the proof requires noiseBits to
+// be about PlaintextProofSlack+2 bits smaller than the modulus of the ciphertext.
+
+// ErrInvalidDecryptionShare is returned when a decryption share does not match its proof of decryption.
+var ErrInvalidDecryptionShare = errors.New("invalid decryption share")
+
+// DecryptionProof is a non-interactive proof that a decryption share was computed with the secret key of
+// a partial public key, see PartialDecryptWithProof.
+type DecryptionProof struct {
+	Digest []byte         // hash of the statement and the commitments, from which the challenges are derived
+	Z      [][]*ring.Poly // masked witness (s, e_pk, e) of every repetition, in the coefficient domain
+}
+
+// decryptionProofRepetitions returns the number of repetitions of a proof of decryption, which have the
+// challenge space of the masks of a proof of plaintext knowledge.
+func (p Parameters) decryptionProofRepetitions() int {
+	return p.plaintextProofMasks()
+}
+
+// decryptionProofMasks returns the bounds on the coefficients of the masks of s and e_pk, the bit-size of
+// the mask of the flooding noise, and false if twice these bounds cannot be checked modulo the first prime
+// of Q, or modulo the modulus of Q at level for the flooding noise.
+func (p Parameters) decryptionProofMasks(level, noiseBits int) (maskS, maskE uint64, maskBits int, ok bool) {
+	maskS = 1 << PlaintextProofSlack
+	maskE = maskS * uint64(6*p.Sigma())
+	maskBits = noiseBits + PlaintextProofSlack
+	ok = noiseBits >= 0 && 2*maskE < p.RingQ().Modulus[0]>>1
+	ok = ok && new(big.Int).Lsh(big.NewInt(1), uint(maskBits+2)).Cmp(p.RingQ().ModulusAtLevel[level]) < 0
+	return
+}
+
+// decryptionProofBounds returns the bounds on the coefficients of the masked witness z = y + X^c * w of a
+// proof of decryption with flooding noise of noiseBits bits: the bounds on the masks minus the bounds on the
+// witness, that is, 1 for s, 6*sigma for e_pk and 2^(noiseBits-1) for e. The prover rejects the masked
+// witnesses out of these bounds, so that the published ones are uniform in them whatever the witness.
+func (p Parameters) decryptionProofBounds(level, noiseBits int) (boundS, boundE uint64, boundNoise *big.Int) {
+	maskS, maskE, maskBits, _ := p.decryptionProofMasks(level, noiseBits)
+	boundNoise = new(big.Int).Lsh(big.NewInt(1), uint(maskBits))
+	boundNoise.Sub(boundNoise, big.NewInt(1))
+	boundNoise.Sub(boundNoise, new(big.Int).Rsh(new(big.Int).Lsh(big.NewInt(1), uint(noiseBits)), 1))
+	return maskS - 1, maskE - uint64(6*p.Sigma()), boundNoise
+}
+
+// MaxDecryptionProofNoiseBits returns the bit-size of the largest flooding noise that a proof of decryption of
+// a ciphertext at level can cover, or -1 if the parameters do not support proofs of decryption.
+func (p Parameters) MaxDecryptionProofNoiseBits(level int) int {
+	noiseBits := p.RingQ().ModulusAtLevel[level].BitLen() - 3 - PlaintextProofSlack
+	if _, _, _, ok := p.decryptionProofMasks(level, noiseBits); !ok {
+		return -1
+	}
+	return noiseBits
+}
+
+// PartialDecryptWithProof behaves as PartialDecrypt and additionally returns a proof that the share was
+// computed with the secret key of the partial public key pk of the party, with a flooding noise of
+// noiseBits bits. It returns an error if noiseBits is too large for the modulus of ct.
+//
+// The masked witness of the proof is rejection sampled: the proof is computed again with fresh masks, which
+// happens with probability about N*V*2^-PlaintextProofSlack, until every coefficient of the masked witness
+// is within decryptionProofBounds. The published coefficients are then uniform and leak nothing on the
+// secret key, however many proofs are published.
+func (dec *DistributedDecryptor) PartialDecryptWithProof(ct *Ciphertext, pk *rlwe.PublicKey, noiseBits int) (*DistDecShare, *DecryptionProof, error) {
+	params := dec.params
+	ringQ := params.RingQ()
+	levelQ, level := params.MaxLevel(), ct.Level()
+
+	if ct.Degree() != 1 {
+		panic("cannot PartialDecryptWithProof: ct.Degree() != 1")
+	}
+	maskS, maskE, maskBits, ok := params.decryptionProofMasks(level, noiseBits)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot PartialDecryptWithProof: %d bits of flooding noise are too large for the proof", noiseBits)
+	}
+
+	// witness (s, e_pk, e) in the coefficient domain, where e_pk = b + a*s
+	witness := []*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPoly()}
+	ringQ.InvMFormLvl(levelQ, dec.sk.Value.Q, witness[0])
+	ringQ.InvNTTLvl(levelQ, witness[0], witness[0])
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, pk.Value[1].Q, dec.sk.Value.Q, witness[1])
+	ringQ.AddLvl(levelQ, witness[1], pk.Value[0].Q, witness[1])
+	ringQ.InvMFormLvl(levelQ, witness[1], witness[1])
+	ringQ.InvNTTLvl(levelQ, witness[1], witness[1])
+	addNoise(ringQ, witness[2], noiseBits, dec.prng)
+	witness[2].Resize(level)
+
+	key, share := ringQ.NewPoly(), ringQ.NewPolyLvl(level)
+	dec.decryptionRelation(pk.Value[1].Q, ct.Value[1], witness, key, share)
+	shareImg := ringQ.NewPolyLvl(level)
+	boundS, boundE, boundNoise := params.decryptionProofBounds(level, noiseBits)
+
+	numReps := params.decryptionProofRepetitions()
+	buf := make([]byte, 8*params.N())
+	xw := ringQ.NewPoly()
+	for {
+		// commitments decryptionRelation(y) for masks y
+		ys := make([][]*ring.Poly, numReps)
+		h := dec.decryptionProofHash(ct, pk, share, noiseBits)
+		for k := range ys {
+			ys[k] = []*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPolyLvl(level)}
+			sampleBounded(dec.prng, ringQ, maskS, ys[k][0])
+			sampleBounded(dec.prng, ringQ, maskE, ys[k][1])
+			dec.sampleFlooding(maskBits, ys[k][2])
+			dec.decryptionRelation(pk.Value[1].Q, ct.Value[1], ys[k], key, shareImg)
+			hashPoly(h, ringQ, key, buf)
+			hashPoly(h, ringQ, shareImg, buf)
+		}
+
+		// z = y + X^c * w
+		proof := &DecryptionProof{Digest: h.Sum(nil), Z: ys}
+		accept := true
+		for k, c := range decryptionProofChallenges(params, proof.Digest) {
+			if c >= 0 {
+				for l := range witness {
+					lvl := witness[l].Level()
+					mulByMonomialLvl(ringQ, lvl, witness[l], c, xw)
+					ringQ.AddLvl(lvl, proof.Z[k][l], xw, proof.Z[k][l])
+				}
+			}
+			z := proof.Z[k]
+			if !isSmall(ringQ, z[0], boundS) || !isSmall(ringQ, z[1], boundE) || !isBoundedBig(ringQ, z[2], boundNoise) {
+				accept = false
+				break
+			}
+		}
+		if accept {
+			return &DistDecShare{share}, proof, nil
+		}
+	}
+}
+
+// VerifyShare checks the proof that share is a decryption share of ct computed with the secret key of the
+// partial public key pk and a flooding noise of noiseBits bits. It returns an error wrapping
+// ErrInvalidDecryptionShare if it is not.
+func (dec *DistributedDecryptor) VerifyShare(ct *Ciphertext, share *DistDecShare, proof *DecryptionProof, pk *rlwe.PublicKey, noiseBits int) error {
+	params := dec.params
+	ringQ := params.RingQ()
+	levelQ, level := params.MaxLevel(), ct.Level()
+
+	if ct.Degree() != 1 {
+		return fmt.Errorf("%w: ct.Degree() != 1", ErrInvalidDecryptionShare)
+	}
+	if _, _, _, ok := params.decryptionProofMasks(level, noiseBits); !ok {
+		return fmt.Errorf("%w: %d bits of flooding noise are too large for the proof", ErrInvalidDecryptionShare, noiseBits)
+	}
+	boundS, boundE, boundNoise := params.decryptionProofBounds(level, noiseBits)
+	if pk == nil || !dec.checkPoly(pk.Value[0].Q, levelQ) || !dec.checkPoly(pk.Value[1].Q, levelQ) {
+		return fmt.Errorf("%w: invalid shape of the public key", ErrInvalidDecryptionShare)
+	}
+	if share == nil || !dec.checkPoly(share.Poly, level) {
+		return fmt.Errorf("%w: invalid shape of the share", ErrInvalidDecryptionShare)
+	}
+	numReps := params.decryptionProofRepetitions()
+	if proof == nil || len(proof.Digest) != blake2b.Size256 || len(proof.Z) != numReps {
+		return fmt.Errorf("%w: expected %d repetitions", ErrInvalidDecryptionShare, numReps)
+	}
+	for k, z := range proof.Z {
+		if len(z) != 3 || !dec.checkPoly(z[0], levelQ) || !dec.checkPoly(z[1], levelQ) || !dec.checkPoly(z[2], level) {
+			return fmt.Errorf("%w: invalid shape of repetition %d", ErrInvalidDecryptionShare, k)
+		}
+	}
+
+	// the statement in the coefficient domain
+	key := ringQ.NewPoly()
+	ringQ.InvNTTLvl(levelQ, pk.Value[0].Q, key)
+
+	h := dec.decryptionProofHash(ct, pk, share.Poly, noiseBits)
+	buf := make([]byte, 8*params.N())
+	keyImg, shareImg, xk := ringQ.NewPoly(), ringQ.NewPolyLvl(level), ringQ.NewPoly()
+	for k, c := range decryptionProofChallenges(params, proof.Digest) {
+		z := proof.Z[k]
+		if !isSmall(ringQ, z[0], boundS) || !isSmall(ringQ, z[1], boundE) || !isBoundedBig(ringQ, z[2], boundNoise) {
+			return fmt.Errorf("%w: repetition %d out of bounds", ErrInvalidDecryptionShare, k)
+		}
+		dec.decryptionRelation(pk.Value[1].Q, ct.Value[1], z, keyImg, shareImg)
+		if c >= 0 {
+			mulByMonomialLvl(ringQ, levelQ, key, c, xk)
+			ringQ.SubLvl(levelQ, keyImg, xk, keyImg)
+			mulByMonomialLvl(ringQ, level, share.Poly, c, xk)
+			ringQ.SubLvl(level, shareImg, xk, shareImg)
+		}
+		hashPoly(h, ringQ, keyImg, buf)
+		hashPoly(h, ringQ, shareImg, buf)
+	}
+	if subtle.ConstantTimeCompare(h.Sum(nil), proof.Digest) != 1 {
+		return fmt.Errorf("%w: challenges do not match", ErrInvalidDecryptionShare)
+	}
+	return nil
+}
+
+// JointDecryptVerified verifies the share of every party against its proof and its partial public key, and
+// decrypts ct as JointDecrypt. It returns an error naming the first party whose share is invalid, in which
+// case ptOut is left unchanged.
+func (dec *DistributedDecryptor) JointDecryptVerified(ct *Ciphertext, shares []*DistDecShare, proofs []*DecryptionProof, pks []*rlwe.PublicKey, noiseBits int, ptOut *Plaintext) error {
+	if len(shares) == 0 || len(proofs) != len(shares) || len(pks) != len(shares) {
+		return fmt.Errorf("cannot JointDecryptVerified: got %d shares, %d proofs and %d public keys", len(shares), len(proofs), len(pks))
+	}
+	for i := range shares {
+		if err := dec.VerifyShare(ct, shares[i], proofs[i], pks[i], noiseBits); err != nil {
+			return fmt.Errorf("party %d: %w", i, err)
+		}
+	}
+	dec.JointDecrypt(ct, shares, ptOut)
+	return nil
+}
+
+// JointDecryptToMsgVerified behaves as JointDecryptVerified and decodes the plaintext into msgOut.
+func (dec *DistributedDecryptor) JointDecryptToMsgVerified(ct *Ciphertext, shares []*DistDecShare, proofs []*DecryptionProof, pks []*rlwe.PublicKey, noiseBits int, msgOut *Message) error {
+	pt := NewPlaintext(dec.params)
+	if err := dec.JointDecryptVerified(ct, shares, proofs, pks, noiseBits, pt); err != nil {
+		return err
+	}
+	dec.dcd.Decode(pt, msgOut)
+	return nil
+}
+
+// decryptionRelation sets key and share to the image of the witness w = (s, e_pk, e) in the coefficient
+// domain: key = e_pk - a*s modulo Q, in the Montgomery form of the public keys, and share = c1*s + e at the
+// level of e.
+func (dec *DistributedDecryptor) decryptionRelation(a, c1 *ring.Poly, w []*ring.Poly, key, share *ring.Poly) {
+	ringQ := dec.params.RingQ()
+	levelQ, level := dec.params.MaxLevel(), w[2].Level()
+
+	s := dec.buffProof
+	ringQ.NTTLvl(levelQ, w[0], s)
+	ringQ.MFormLvl(levelQ, s, s)
+
+	ringQ.NTTLvl(levelQ, w[1], key)
+	ringQ.MFormLvl(levelQ, key, key)
+	ringQ.MulCoeffsMontgomeryAndSubLvl(levelQ, a, s, key)
+	ringQ.InvNTTLvl(levelQ, key, key)
+
+	ringQ.NTTLvl(level, c1, share)
+	ringQ.MulCoeffsMontgomeryLvl(level, share, s, share)
+	ringQ.InvNTTLvl(level, share, share)
+	ringQ.AddLvl(level, share, w[2], share)
+}
+
+// decryptionProofHash returns a hash of the statement of a proof of decryption.
+func (dec *DistributedDecryptor) decryptionProofHash(ct *Ciphertext, pk *rlwe.PublicKey, share *ring.Poly, noiseBits int) hash.Hash {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	fp := dec.params.Fingerprint()
+	h.Write([]byte("hpbfv/decryption-proof"))
+	h.Write(fp[:])
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(noiseBits))
+	h.Write(b[:])
+
+	ringQ := dec.params.RingQ()
+	buf := make([]byte, 8*dec.params.N())
+	for _, pol := range []*ring.Poly{pk.Value[0].Q, pk.Value[1].Q, ct.Value[0], ct.Value[1], share} {
+		hashPoly(h, ringQ, pol, buf)
+	}
+	return h
+}
+
+// decryptionProofChallenges derives the challenges of a proof of decryption from its digest, as the degree
+// of the monomial or -1 for zero.
+func decryptionProofChallenges(params Parameters, digest []byte) []int {
+	xof, err := blake2b.NewXOF(blake2b.OutputLengthUnknown, nil)
+	if err != nil {
+		panic(err)
+	}
+	xof.Write([]byte("hpbfv/decryption-proof/challenges"))
+	xof.Write(digest)
+	cs := make([]int, params.decryptionProofRepetitions())
+	for k := range cs {
+		cs[k] = readMonomialChallenge(xof, params.N())
+	}
+	return cs
+}
+
+// checkPoly reports whether pol is allocated at level.
+func (dec *DistributedDecryptor) checkPoly(pol *ring.Poly, level int) bool {
+	return pol != nil && pol.N() == dec.params.N() && pol.Level() == level
+}
+
+// sampleFlooding sets the coefficients of pol to integers uniform in [-2^bits, 2^bits).
+func (dec *DistributedDecryptor) sampleFlooding(bits int, pol *ring.Poly) {
+	sampleUniformBig(dec.prng, dec.params.RingQ(), bits, pol)
+}
+
+// sampleUniformBig sets the coefficients of pol to integers uniform in [-2^bits, 2^bits) read from prng.
+func sampleUniformBig(prng utils.PRNG, ringQ *ring.Ring, bits int, pol *ring.Poly) {
+	buf := make([]byte, (bits+8)/8)
+	coeffs := make([]*big.Int, ringQ.N)
+	offset := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	mask := new(big.Int).Sub(new(big.Int).Lsh(offset, 1), big.NewInt(1))
+	for j := range coeffs {
+		if _, err := prng.Read(buf); err != nil {
+			panic(err)
+		}
+		coeffs[j] = new(big.Int).SetBytes(buf)
+		coeffs[j].And(coeffs[j], mask).Sub(coeffs[j], offset)
+	}
+	ringQ.SetCoefficientsBigintLvl(pol.Level(), coeffs, pol)
+}
+
+// isBoundedBig reports whether the coefficients of pol, centered modulo the modulus of Q at its level, are
+// in [-bound, bound].
+func isBoundedBig(ringQ *ring.Ring, pol *ring.Poly, bound *big.Int) bool {
+	coeffs := make([]*big.Int, ringQ.N)
+	for j := range coeffs {
+		coeffs[j] = new(big.Int)
+	}
+	ringQ.PolyToBigintCenteredLvl(pol.Level(), pol, 1, coeffs)
+	for _, c := range coeffs {
+		if c.CmpAbs(bound) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mulByMonomialLvl sets out to X^deg * pol modulo the first level+1 primes of r, for 0 <= deg < 2N.
+// pol and out must not alias.
+func mulByMonomialLvl(r *ring.Ring, level int, pol *ring.Poly, deg int, out *ring.Poly) {
+	n := r.N
+	for i := 0; i < level+1; i++ {
+		qi := r.Modulus[i]
+		for j, c := range pol.Coeffs[i] {
+			k := j + deg
+			if (k/n)&1 == 1 && c != 0 {
+				c = qi - c
+			}
+			out.Coeffs[i][k%n] = c
+		}
+	}
+}
@@ -2,122 +2,100 @@ package hpbfv
 
 import (
 	"math/big"
+	"math/bits"
 
+	"spdz-go/field"
 	"spdz-go/ring"
 )
 
+// Encoder encodes messages of Z_T^D into plaintexts of R_Q scaled by Q/T.
+//
+// The slot transform works on the elements of params.Field() in the Montgomery representation with
+// precomputed twiddles, and the lift to R_Q only uses preallocated buffers.
+// An Encoder must not be used concurrently.
 type Encoder struct {
 	params Parameters
+	field  *field.Field
 
-	polyPool *ring.Poly
-
-	nttRoots   *Message
-	rootPows   *Message
-	msgPool    *Message
-	coeffPool1 []*big.Int
-	coeffPool2 []*big.Int
-
-	dInvModT *big.Int
-
+	twiddles []field.Element // twiddles of the inverse NTT, see nttTwiddles
+	scale    []field.Element // X^-i * D^-1 mod T, applied to the i-th slot after the inverse NTT
 	indexMap []int
+	buff     []field.Element // D elements mod T
+	tmp      field.Element
+
+	// buffers of the lift to R_Q
+	qBig, tBig, tHalf, bBig *big.Int
+	modT, divT              *barrett // divisions by T of r*B and of Q*r + T/2, for r < T
+	r, num, quo, rem        *big.Int
 }
 
 func NewEncoder(params Parameters) (ecd *Encoder) {
 	ecd = new(Encoder)
 	ecd.params = params
-	ecd.polyPool = params.RingQ().NewPoly()
-	ecd.nttRoots = NewMessage(params)
-	ecd.rootPows = NewMessage(params)
-	ecd.msgPool = NewMessage(params)
-	ecd.indexMap = make([]int, params.Slots())
-	ecd.dInvModT = new(big.Int).ModInverse(big.NewInt(int64(params.Slots())), params.T())
-	ecd.coeffPool1 = make([]*big.Int, params.N())
-	ecd.coeffPool2 = make([]*big.Int, params.N())
-	for i := 0; i < params.N(); i++ {
-		ecd.coeffPool1[i] = big.NewInt(0)
-		ecd.coeffPool2[i] = big.NewInt(0)
-	}
 
 	slots := params.Slots()
-	root := params.Root()
-	roots := ecd.nttRoots
-	rootPows := ecd.rootPows
-	k := params.N() / params.Slots()
+	k := params.N() / slots
+	t := params.T()
+
+	f := params.Field()
+	ecd.field = f
+	ecd.buff = f.NewVector(slots)
+	ecd.tmp = f.NewElement()
+
+	// inverse of the 2N-th root of unity
+	rootInv := f.NewElementFromBig(params.Root())
+	f.Exp(rootInv, rootInv, big.NewInt(int64(2*params.N()-1)))
+
+	// i-th root root^-2Ki, and X^-i * D^-1
+	w := f.Exp(f.NewElement(), rootInv, big.NewInt(int64(2*k)))
+	f.ToMont(w, w)
+	f.ToMont(rootInv, rootInv)
+	roots := f.NewVector(slots / 2)
+	for i := range roots {
+		if i == 0 {
+			f.ToMont(roots[i], f.SetUint64(roots[i], 1))
+		} else {
+			f.MontMul(roots[i], roots[i-1], w)
+		}
+	}
+	ecd.twiddles = nttTwiddles(f, roots, slots)
 
-	//compute i-th root and minus i-th power of root
-	for i := 0; i < slots; i++ {
-		roots.Value[i].Exp(root, big.NewInt(int64(2*params.N()-2*k*i)), params.T())
-		rootPows.Value[i].Exp(root, big.NewInt(int64(2*params.N()-i)), params.T())
+	ecd.scale = f.NewVector(slots)
+	f.ToMont(ecd.scale[0], f.SetBig(ecd.scale[0], new(big.Int).ModInverse(big.NewInt(int64(slots)), t)))
+	for i := 1; i < slots; i++ {
+		f.MontMul(ecd.scale[i], ecd.scale[i-1], rootInv)
 	}
 
 	//compute indexMap[5^(ik/2)/2k)] = i
+	ecd.indexMap = make([]int, slots)
 	for i := 0; i < slots; i++ {
 		idx := ring.ModExp(5, uint64(i*k/2), uint64(params.N()*2)) / uint64(2*k)
 		ecd.indexMap[idx] = i
 	}
 
+	ecd.qBig = params.QBigInt()
+	ecd.tBig = t
+	ecd.tHalf = new(big.Int).Rsh(t, 1)
+	ecd.bBig = new(big.Int).Set(params.b)
+	ecd.modT = newBarrett(t, t.BitLen()+params.b.BitLen())
+	ecd.divT = newBarrett(t, t.BitLen()+ecd.qBig.BitLen())
+	ecd.r, ecd.num, ecd.quo, ecd.rem = new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+
 	return
 }
 
-func (ecd *Encoder) invNtt(msgIn, msgOut *Message) {
-
-	ecd.permute(msgIn, ecd.msgPool)
-
-	slots := ecd.params.Slots()
-	roots := ecd.nttRoots
-
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Set(ecd.msgPool.Value[i])
-	}
-
-	//apply bit reversal
-	j := 0
-	for i := 1; i < slots; i++ {
-		bit := (slots >> 1)
-		for j >= bit {
-			j -= bit
-			bit >>= 1
-		}
-		j += bit
-		if i < j {
-			msgOut.Value[i], msgOut.Value[j] = msgOut.Value[j], msgOut.Value[i]
-		}
-	}
-
-	//apply inplace NTT
-	for i := 2; i <= slots; i <<= 1 {
-		step := slots / i
-		for j := 0; j < slots; j += i {
-			for k := 0; k < i/2; k++ {
-				u := new(big.Int).Set(msgOut.Value[j+k])
-				v := new(big.Int).Exp(roots.Value[k], big.NewInt(int64(step)), ecd.params.T())
-				v.Mul(msgOut.Value[j+k+i/2], v)
-				msgOut.Value[j+k].Add(u, v)
-				msgOut.Value[j+k+i/2].Sub(u, v)
-
-				msgOut.Value[j+k].Mod(msgOut.Value[j+k], ecd.params.T())
-				msgOut.Value[j+k+i/2].Mod(msgOut.Value[j+k+i/2], ecd.params.T())
-			}
-		}
-	}
+// invNtt maps the slots of msgIn to the coefficients mod T of the polynomial of degree D it encodes.
+func (ecd *Encoder) invNtt(msgIn *Message, out []field.Element) {
+	f := ecd.field
 
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Mul(msgOut.Value[i], ecd.rootPows.Value[i])
-		msgOut.Value[i].Mul(msgOut.Value[i], ecd.dInvModT)
-		msgOut.Value[i].Mod(msgOut.Value[i], ecd.params.T())
+	for i := range out {
+		f.ToMont(out[i], msgIn.Value[ecd.indexMap[i]])
 	}
 
-}
+	nttInPlace(f, out, ecd.twiddles, ecd.tmp)
 
-func (ecd *Encoder) permute(msgIn, msgOut *Message) {
-	if msgIn == msgOut {
-		panic("Cannot permute: input and output message should be different!!")
-	}
-
-	slots := ecd.params.Slots()
-
-	for i := 0; i < slots; i++ {
-		msgOut.Value[i].Set(msgIn.Value[ecd.indexMap[i]])
+	for i := range out {
+		f.MontMul(out[i], out[i], ecd.scale[i])
 	}
 }
 
@@ -128,38 +106,125 @@ func (ecd *Encoder) EncodeNew(msgIn *Message) (ptxtOut *Plaintext) {
 }
 
 func (ecd *Encoder) Encode(msgIn *Message, ptxtOut *Plaintext) {
-	params := ecd.params
+	ecd.invNtt(msgIn, ecd.buff)
+	ecd.lift(ecd.buff, ptxtOut)
+}
+
+// coeffs writes to coeffsOut the D coefficients mod T of the polynomial mod X^D - B that msgIn encodes.
+func (ecd *Encoder) coeffs(msgIn *Message, coeffsOut []field.Element) {
+	f := ecd.field
+	ecd.invNtt(msgIn, ecd.buff)
+	for i := range coeffsOut {
+		f.FromMont(coeffsOut[i], ecd.buff[i])
+	}
+}
 
-	ecd.invNtt(msgIn, ecd.msgPool)
+// encodeCoeffs encodes the polynomial mod X^D - B of coefficients coeffsIn, see coeffs.
+func (ecd *Encoder) encodeCoeffs(coeffsIn []field.Element, ptxtOut *Plaintext) {
+	f := ecd.field
+	for i := range coeffsIn {
+		f.ToMont(ecd.buff[i], coeffsIn[i])
+	}
+	ecd.lift(ecd.buff, ptxtOut)
+}
+
+// lift writes to ptxtOut the encoding in R_Q of the polynomial of coefficients coeffs, in the Montgomery representation.
+func (ecd *Encoder) lift(coeffs []field.Element, ptxtOut *Plaintext) {
+	params := ecd.params
+	f := ecd.field
 
-	// mult (X^(N-D) + bX^(N-2D) + ...)
+	// mult -(X^(N-D) + bX^(N-2D) + ... + b^(K-1)) and scale by Q/T: since the multiples of T vanish
+	// modulo Q, the coefficient j + i*D is round(Q*r/T) for r = -m_j * b^(K-1-i) mod T
 	d := params.Slots()
 	k := params.N() / d
-
-	for i := 0; i < k; i++ {
-		ecd.coeffPool1[i*d].Exp(params.b, big.NewInt(int64(k-i-1)), nil)
+	r := ecd.r
+	for j := 0; j < d; j++ {
+		f.ToBig(r, f.FromMont(ecd.tmp, coeffs[j]))
+		if r.Sign() != 0 {
+			r.Sub(ecd.tBig, r)
+		}
+		for i := k - 1; i >= 0; i-- {
+			if i < k-1 {
+				ecd.num.Mul(r, ecd.bBig)
+				ecd.modT.quoRem(ecd.quo, r, ecd.num)
+			}
+			ecd.scaleUp(r, ptxtOut.Value, j+i*d)
+		}
 	}
+}
 
-	for i := 0; i < params.N(); i++ {
-		ecd.coeffPool2[i].SetInt64(0)
+// scaleUp sets the coefficient idx of pol to round(Q*r/T) mod Q, for 0 <= r < T.
+func (ecd *Encoder) scaleUp(r *big.Int, pol *ring.Poly, idx int) {
+	ecd.num.Mul(r, ecd.qBig)
+	ecd.num.Add(ecd.num, ecd.tHalf)
+	ecd.divT.quoRem(ecd.quo, ecd.rem, ecd.num)
+
+	words := ecd.quo.Bits()
+	for i, qi := range ecd.params.RingQ().Modulus[:pol.Level()+1] {
+		pol.Coeffs[i][idx] = modWords(words, qi)
 	}
+}
 
-	for i := 0; i < k; i++ {
-		for j := 0; j < d; j++ {
-			e := j + i*d
-			tmp := new(big.Int).Mul(ecd.msgPool.Value[j], ecd.coeffPool1[i*d])
-			ecd.coeffPool2[e].Sub(ecd.coeffPool2[e], tmp)
+// modWords returns the integer of little-endian words words modulo q.
+func modWords(words []big.Word, q uint64) (r uint64) {
+	for i := len(words) - 1; i >= 0; i-- {
+		if bits.UintSize == 64 {
+			_, r = bits.Div64(r, uint64(words[i]), q)
+		} else {
+			_, r = bits.Div64(r>>32, r<<32|uint64(words[i]), q)
 		}
 	}
+	return
+}
 
-	// scale by Q/T
+// nttTwiddles returns the twiddles of the NTT of size slots with the given slots/2 roots: the stage
+// of the butterflies of half-size h uses roots[k]^(slots/2h) for k < h, which are stored at h-1+k.
+func nttTwiddles(f *field.Field, roots []field.Element, slots int) []field.Element {
+	if slots < 2 {
+		return nil
+	}
+	tw := f.NewVector(slots - 1)
+	for k, root := range roots[:slots/2] {
+		f.Set(tw[slots/2-1+k], root)
+	}
+	for h := slots / 4; h >= 1; h >>= 1 {
+		for k := 0; k < h; k++ {
+			f.MontMul(tw[h-1+k], tw[2*h-1+k], tw[2*h-1+k])
+		}
+	}
+	return tw
+}
 
-	tHalf := new(big.Int).Div(params.T(), big.NewInt(2))
-	for i := 0; i < params.N(); i++ {
-		ecd.coeffPool2[i].Mul(ecd.coeffPool2[i], params.QBigInt())
-		ecd.coeffPool2[i].Add(ecd.coeffPool2[i], tHalf)
-		ecd.coeffPool2[i].Div(ecd.coeffPool2[i], params.T())
+// nttInPlace applies to x, in the Montgomery representation, the NTT of size len(x) with the twiddles of
+// nttTwiddles: a bit-reversal permutation followed by the butterflies (u, v) -> (u + w*v, u - w*v).
+// The element v is used as scratch space.
+func nttInPlace(f *field.Field, x, twiddles []field.Element, v field.Element) {
+	slots := len(x)
+	j := 0
+	for i := 1; i < slots; i++ {
+		bit := slots >> 1
+		for j >= bit {
+			j -= bit
+			bit >>= 1
+		}
+		j += bit
+		if i < j {
+			xi, xj := x[i], x[j]
+			for l := range xi {
+				xi[l], xj[l] = xj[l], xi[l]
+			}
+		}
 	}
 
-	params.RingQ().SetCoefficientsBigint(ecd.coeffPool2, ptxtOut.Value)
+	for h := 1; h < slots; h <<= 1 {
+		tw := twiddles[h-1:]
+		for j := 0; j < slots; j += 2 * h {
+			for k := 0; k < h; k++ {
+				a, b := x[j+k], x[j+k+h]
+				f.MontMul(v, b, tw[k])
+				f.Sub(b, a, v)
+				f.Add(a, a, v)
+			}
+		}
+	}
 }
@@ -5,15 +5,23 @@ import (
 )
 
 type Encryptor struct {
-	params   Parameters
-	enc      rlwe.Encryptor
-	ecd      *Encoder
-	ptxtPool *Plaintext
+	params     Parameters
+	enc        rlwe.Encryptor
+	ecd        *Encoder
+	ptxtPool   *Plaintext
+	numParties int
 }
 
 func NewEncryptor(params Parameters, pk *rlwe.PublicKey) (enc *Encryptor) {
+	return NewJointEncryptor(params, pk, 1)
+}
+
+// NewJointEncryptor creates an Encryptor under the joint public key of numParties parties,
+// which only differs from NewEncryptor in the noise estimate of the ciphertexts.
+func NewJointEncryptor(params Parameters, pk *rlwe.PublicKey, numParties int) (enc *Encryptor) {
 	enc = new(Encryptor)
 	enc.params = params
+	enc.numParties = numParties
 	enc.ptxtPool = NewPlaintext(params)
 	enc.enc = rlwe.NewEncryptor(params.Parameters, pk)
 	enc.ecd = NewEncoder(params)
@@ -22,11 +30,12 @@ func NewEncryptor(params Parameters, pk *rlwe.PublicKey) (enc *Encryptor) {
 
 func (enc *Encryptor) Encrypt(ptxtIn *Plaintext, ctxtOut *Ciphertext) {
 	enc.enc.Encrypt(ptxtIn.Plaintext, ctxtOut.Ciphertext)
+	ctxtOut.SetNoise(enc.params.FreshNoise(enc.numParties), enc.numParties)
 }
 
 func (enc *Encryptor) EncryptMsg(msgIn *Message, ctxtOut *Ciphertext) {
 	enc.ecd.Encode(msgIn, enc.ptxtPool)
-	enc.enc.Encrypt(enc.ptxtPool.Plaintext, ctxtOut.Ciphertext)
+	enc.Encrypt(enc.ptxtPool, ctxtOut)
 }
 
 func (enc *Encryptor) EncryptNew(ptxtIn *Plaintext) (ctxtOut *Ciphertext) {
@@ -2,6 +2,7 @@ package hpbfv
 
 import (
 	"fmt"
+	"math"
 
 	"spdz-go/ring"
 	"spdz-go/rlwe"
@@ -232,6 +233,7 @@ func (eval *Evaluator) Add(op0, op1, ctOut *Ciphertext) {
 	el0, el1, elOut := eval.getElemAndCheckBinary(op0.Ciphertext, op1.Ciphertext,
 		ctOut.Ciphertext, utils.MaxInt(op0.Degree(), op1.Degree()))
 	eval.evaluateInPlaceBinary(el0, el1, elOut, eval.params.RingQ().Add)
+	ctOut.setNoiseOf(eval.params.AddNoise(op0.noise, op1.noise), op0, op1)
 }
 
 // AddNew adds op0 to op1 and creates a new element ctOut to store the result.
@@ -252,6 +254,7 @@ func (eval *Evaluator) Sub(op0, op1, ctOut *Ciphertext) {
 			eval.params.RingQ().Neg(ctOut.Value[i], ctOut.Value[i])
 		}
 	}
+	ctOut.setNoiseOf(eval.params.AddNoise(op0.noise, op1.noise), op0, op1)
 }
 
 // SubNew subtracts op1 from op0 and creates a new element ctOut to store the result.
@@ -266,6 +269,7 @@ func (eval *Evaluator) Neg(ctIn, ctOut *Ciphertext) {
 	for i := 0; i <= ctIn.Degree(); i++ {
 		eval.params.RingQ().Neg(ctIn.Value[i], ctOut.Value[i])
 	}
+	ctOut.setNoiseOf(ctIn.noise, ctIn)
 }
 
 // NegNew negates op and creates a new element to store the result.
@@ -290,6 +294,7 @@ func (eval *Evaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet,
 	if k == 0 {
 
 		ctOut.Copy(ct0.El())
+		ctOut.setNoiseOf(ct0.noise, ct0)
 
 	} else {
 		galElL := eval.params.GaloisElementForColumnRotationBy(uint64(k))
@@ -297,6 +302,7 @@ func (eval *Evaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet,
 		if swk, inSet := rtks.GetRotationKey(galElL); inSet {
 
 			eval.permute(ct0, galElL, swk, ctOut)
+			ctOut.setNoiseOf(eval.params.AddNoise(ct0.noise, eval.params.KeySwitchNoise(ct0.numParties)), ct0)
 
 		} else {
 			panic(fmt.Errorf("evaluator has no rotation key for rotation by %d", k))
@@ -315,6 +321,8 @@ func (eval *Evaluator) RotateColumnsNew(ct0 *Ciphertext, rtks *rlwe.RotationKeyS
 func (eval *Evaluator) MulAndRelin(op0, op1 *Ciphertext, rlk *rlwe.RelinearizationKey, ctOut *Ciphertext) {
 	eval.tensorAndRescale(op0.Ciphertext, op1.Ciphertext, eval.poolCtMul.Ciphertext)
 	eval.relinearize(eval.poolCtMul, rlk, ctOut)
+	numParties := utils.MaxInt(op0.numParties, op1.numParties)
+	ctOut.SetNoise(eval.params.MulNoise(op0.noise, op1.noise, numParties), numParties)
 }
 
 // Mul multiplies op0 by op1 and returns the result in ctOut.
@@ -329,6 +337,8 @@ func (eval *Evaluator) MulAndRelinNew(op0, op1 *Ciphertext, rlk *rlwe.Relineariz
 func (eval *Evaluator) MulAndRelinHoisted(op0 []ringqp.Poly, op1 *Ciphertext, rlk *rlwe.RelinearizationKey, ctOut *Ciphertext) {
 	eval.tensorAndRescaleHoisted(op0, op1.Ciphertext, eval.poolCtMul.Ciphertext)
 	eval.relinearize(eval.poolCtMul, rlk, ctOut)
+	// the noise of the hoisted operand is not known
+	ctOut.setNoiseOf(math.NaN(), op1)
 }
 
 func (eval *Evaluator) tensorAndRescalePt(ct *rlwe.Ciphertext, pt *rlwe.Plaintext, ctOut *rlwe.Ciphertext) {
@@ -387,6 +397,7 @@ func (eval *Evaluator) tensorAndRescalePt(ct *rlwe.Ciphertext, pt *rlwe.Plaintex
 
 func (eval *Evaluator) PlaintextMul(pt *Plaintext, ct *Ciphertext, ctOut *Ciphertext) {
 	eval.tensorAndRescalePt(ct.Ciphertext, pt.Plaintext, ctOut.Ciphertext)
+	ctOut.setNoiseOf(eval.params.PlaintextMulNoise(ct.noise, ct.numParties), ct)
 }
 
 func (eval *Evaluator) PlaintextMulNew(pt *Plaintext, ct *Ciphertext) (ctOut *Ciphertext) {
@@ -0,0 +1,67 @@
+package hpbfv
+
+import (
+	"testing"
+
+	"spdz-go/ring"
+	"spdz-go/utils"
+)
+
+func BenchmarkHPBFV(b *testing.B) {
+	names := []string{"SOHO", "HPN13D10T128", "HPN13D5T4096", "PN15T128"}
+	if testing.Short() {
+		names = names[:2]
+	}
+
+	for _, name := range names {
+		params, err := NewParametersFromLiteral(Presets[name])
+		if err != nil {
+			b.Fatal(err)
+		}
+		prng, err := utils.NewPRNG()
+		if err != nil {
+			b.Fatal(err)
+		}
+		tc := &testContext{params: params, ringQ: params.RingQ(), uSampler: ring.NewUniformSampler(prng, params.RingQ())}
+
+		benchEncoder(tc, name, b)
+	}
+}
+
+// benchEncoder compares the Encoder and Decoder with their math/big reference implementation.
+func benchEncoder(tc *testContext, name string, b *testing.B) {
+	params := tc.params
+	msg := genTestVectors(tc)
+	pt := NewPlaintext(params)
+
+	encoder, decoder := NewEncoder(params), NewDecoder(params)
+	refEncoder, refDecoder := newReferenceEncoder(params), newReferenceDecoder(params)
+
+	b.Run(testString("Encoder/Encode/"+name, params), func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encoder.Encode(msg, pt)
+		}
+	})
+
+	b.Run(testString("Encoder/Decode/"+name, params), func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			decoder.Decode(pt, msg)
+		}
+	})
+
+	b.Run(testString("Encoder/Reference/Encode/"+name, params), func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			refEncoder.Encode(msg, pt)
+		}
+	})
+
+	b.Run(testString("Encoder/Reference/Decode/"+name, params), func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			refDecoder.Decode(pt, msg)
+		}
+	})
+}
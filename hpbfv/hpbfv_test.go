@@ -9,7 +9,11 @@ import (
 			"github.com/stretchr/testify/require"
 	*/
 
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"testing"
 
 	"spdz-go/ring"
@@ -75,18 +79,22 @@ func genTestParams(params Parameters) (testctx *testContext, err error) {
 func genTestVectors(testctx *testContext) (msg *Message) {
 	params := testctx.params
 	coeffs := testctx.uSampler.ReadNew()
-	msg = NewMessage(params)
-	testctx.ringQ.PolyToBigint(coeffs, params.N()/params.Slots(), msg.Value)
+	values := make([]*big.Int, params.Slots())
+	testctx.ringQ.PolyToBigint(coeffs, params.N()/params.Slots(), values)
 
+	msg = NewMessage(params)
 	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i].Mod(msg.Value[i], params.T())
+		params.Field().SetBig(msg.Value[i], values[i])
 	}
 
 	return
 }
 
 func TestHPBFV(t *testing.T) {
-	params := NewParametersFromLiteral(HPN13D10T128)
+	params, err := NewParametersFromLiteral(HPN13D10T128)
+	if err != nil {
+		t.Fatal(err)
+	}
 	testctx, err := genTestParams(params)
 	if err != nil {
 		panic(err)
@@ -95,6 +103,202 @@ func TestHPBFV(t *testing.T) {
 	// testParameters(testctx, t)
 	testEncrypt(testctx, t)
 	testEvaluator(testctx, t)
+	testNoise(testctx, t)
+}
+
+func TestParameters(t *testing.T) {
+	soho, err := NewParametersFromLiteral(SOHO)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"SOHO", "SOHO_V2", "HEMI", "HEMI_V2", "HPN13D10T128"} {
+		params, err := NewParametersFromLiteral(Presets[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(testString("Parameters/Literal/"+name, params), func(t *testing.T) {
+			paramsOut, err := NewParametersFromLiteral(params.ParametersLiteral())
+			assert.NoError(t, err)
+			assert.True(t, params.Equals(paramsOut))
+			assert.Equal(t, params.Fingerprint(), paramsOut.Fingerprint())
+			assert.Equal(t, name == "SOHO", params.Equals(soho))
+		})
+
+		t.Run(testString("Parameters/MarshalBinary/"+name, params), func(t *testing.T) {
+			data, err := params.MarshalBinary()
+			assert.NoError(t, err)
+			assert.Len(t, data, params.MarshalBinarySize())
+
+			var paramsOut Parameters
+			assert.NoError(t, paramsOut.UnmarshalBinary(data))
+			assert.True(t, params.Equals(paramsOut))
+			assert.Equal(t, params.T(), paramsOut.T())
+
+			for i := 1; i < len(data); i++ {
+				assert.Error(t, paramsOut.UnmarshalBinary(data[:i]), "truncated to %d bytes", i)
+			}
+			assert.Error(t, paramsOut.UnmarshalBinary(append(data, 0)))
+		})
+
+		t.Run(testString("Parameters/MarshalJSON/"+name, params), func(t *testing.T) {
+			data, err := json.Marshal(params)
+			assert.NoError(t, err)
+
+			var paramsOut Parameters
+			assert.NoError(t, json.Unmarshal(data, &paramsOut))
+			assert.True(t, params.Equals(paramsOut))
+
+			var pl ParametersLiteral
+			assert.NoError(t, json.Unmarshal(data, &pl))
+			paramsOut, err = NewParametersFromLiteral(pl)
+			assert.NoError(t, err)
+			assert.True(t, params.Equals(paramsOut))
+		})
+	}
+
+	t.Run("Parameters/Fingerprint", func(t *testing.T) {
+		// the fingerprint is part of the wire format and must not change
+		fp := soho.Fingerprint()
+		assert.Equal(t, "84db8170a5ba330ab7964c385c39cba87a29642d71f7d3b29d8065808964b70a", hex.EncodeToString(fp[:]))
+		sohoV2, err := NewParametersFromLiteral(SOHO_V2)
+		assert.NoError(t, err)
+		fpV2 := sohoV2.Fingerprint()
+		assert.Equal(t, "119b9002f3aed6dee1791d53d17dee14458ae5df5e6eb225e4c2f3374be81b55", hex.EncodeToString(fpV2[:]))
+		hemi, err := NewParametersFromLiteral(HEMI)
+		assert.NoError(t, err)
+		assert.NotEqual(t, fp, hemi.Fingerprint())
+	})
+
+	t.Run("Parameters/Invalid", func(t *testing.T) {
+		pl := SOHO
+		pl.B = MustBigFromDecimal("10793")
+		data, err := json.Marshal(pl)
+		assert.NoError(t, err)
+		var params Parameters
+		assert.Error(t, json.Unmarshal(data, &params))
+
+		pl = SOHO
+		pl.G = big.NewInt(3)
+		_, err = NewParametersFromLiteral(pl)
+		assert.Error(t, err)
+	})
+
+	t.Run("Parameters/Strict", func(t *testing.T) {
+		_, err := NewParametersFromLiteralStrict(HPN14D13T128)
+		assert.NoError(t, err)
+		_, err = NewParametersFromLiteralStrict(HPN13D10T128)
+		assert.ErrorIs(t, err, ErrInsecureParameters)
+		assert.Equal(t, 256.0, soho.SecurityLevel())
+	})
+}
+
+func TestGenerateParameters(t *testing.T) {
+	// the generator finds the hand-picked bases of the presets
+	for _, name := range []string{"SOHO", "HPN14D13T128", "HPN13D10T128", "HPN14D12T256", "PN15T128"} {
+		pl := Presets[name]
+		params, err := NewParametersFromLiteral(pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, g, err := FindPlaintextBasis(pl.LogN, pl.D, params.T().BitLen())
+		assert.NoError(t, err, name)
+		assert.Equal(t, 0, b.Cmp(pl.B), "%s: B = %s", name, b)
+
+		pl.G = g
+		_, err = NewParametersFromLiteral(pl)
+		assert.NoError(t, err, name)
+	}
+
+	t.Run("Literal", func(t *testing.T) {
+		pl, err := GenerateParametersLiteral(ParametersLiteral{LogN: 12, D: 1 << 9, LogQ: []int{50, 50}, LogP: []int{51}}, 200)
+		assert.NoError(t, err)
+		params, err := NewParametersFromLiteral(pl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, params.T().BitLen())
+		assert.Equal(t, 2, len(pl.QMul))
+		assert.Equal(t, 1, len(pl.P))
+
+		moduli := map[uint64]bool{}
+		for _, qi := range append(append(append([]uint64{}, pl.Q...), pl.QMul...), pl.P...) {
+			assert.False(t, moduli[qi], "duplicate prime %d", qi)
+			moduli[qi] = true
+		}
+
+		testctx, err := genTestParams(params)
+		assert.NoError(t, err)
+		msg := genTestVectors(testctx)
+		msgOut := testctx.decryptor.DecryptToMsgNew(testctx.encryptor.EncryptMsgNew(msg))
+		for i := range msg.Value {
+			assert.Equal(t, msg.Value[i], msgOut.Value[i])
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := GenerateParametersLiteral(ParametersLiteral{LogN: 12, D: 1 << 9}, 200)
+		assert.Error(t, err)
+		_, _, err = FindPlaintextBasis(12, 3, 200)
+		assert.Error(t, err)
+		_, _, err = FindPlaintextBasis(12, 1, 4096)
+		assert.Error(t, err)
+	})
+}
+
+func TestEncoder(t *testing.T) {
+	names := []string{"SOHO", "HPN13D10T128", "HPN13D5T4096", "PN15T128"}
+	if testing.Short() {
+		names = names[:2]
+	}
+
+	for _, name := range names {
+		params, err := NewParametersFromLiteral(Presets[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		prng, err := utils.NewPRNG()
+		if err != nil {
+			panic(err)
+		}
+		testctx := &testContext{params: params, ringQ: params.RingQ(), uSampler: ring.NewUniformSampler(prng, params.RingQ())}
+
+		encoder, decoder := NewEncoder(params), NewDecoder(params)
+		refEncoder, refDecoder := newReferenceEncoder(params), newReferenceDecoder(params)
+
+		// the encoder and decoder match the math/big implementation
+		t.Run(testString("Encoder/Reference/"+name, params), func(t *testing.T) {
+			msg := genTestVectors(testctx)
+			pt := encoder.EncodeNew(msg)
+			assert.True(t, testctx.ringQ.Equal(refEncoder.EncodeNew(msg).Value, pt.Value))
+
+			// with K = 1, the slot permutation of indexMap is not a bijection and messages do not
+			// round-trip, with either implementation
+			if params.N() > params.Slots() {
+				msgOut := decoder.DecodeNew(pt)
+				for i := range msg.Value {
+					assert.Equal(t, msg.Value[i], msgOut.Value[i])
+				}
+			}
+
+			testctx.uSampler.Read(pt.Value)
+			msgOut, refOut := decoder.DecodeNew(pt), refDecoder.DecodeNew(pt)
+			for i := range msgOut.Value {
+				assert.Equal(t, refOut.Value[i], msgOut.Value[i])
+			}
+		})
+
+		// encoding again into the same plaintext reuses its memory
+		t.Run(testString("Encoder/Allocations/"+name, params), func(t *testing.T) {
+			msg := genTestVectors(testctx)
+			pt := NewPlaintext(params)
+			encoder.Encode(msg, pt)
+			decoder.Decode(pt, msg)
+			assert.Zero(t, testing.AllocsPerRun(2, func() { encoder.Encode(msg, pt) }))
+			assert.Zero(t, testing.AllocsPerRun(2, func() { decoder.Decode(pt, msg) }))
+		})
+	}
 }
 
 // func testParameters(testctx *testContext, t *testing.T) {
@@ -123,7 +327,7 @@ func testEncrypt(testctx *testContext, t *testing.T) {
 		msgOut := testctx.decoder.DecodeNew(pt)
 
 		for i := 0; i < slots; i++ {
-			assert.Equal(t, msgOut.Value[i].Text(10), msg.Value[i].Text(10))
+			assert.Equal(t, msgOut.Value[i], msg.Value[i])
 		}
 	})
 
@@ -134,7 +338,7 @@ func testEncrypt(testctx *testContext, t *testing.T) {
 		msgOut := dec.DecryptToMsgNew(ct)
 
 		for i := 0; i < slots; i++ {
-			assert.Equal(t, msgOut.Value[i].Text(10), msg.Value[i].Text(10))
+			assert.Equal(t, msgOut.Value[i], msg.Value[i])
 		}
 	})
 
@@ -154,8 +358,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg3 := NewMessage(params)
 
 		for i := 0; i < params.Slots(); i++ {
-			msg3.Value[i].Add(msg1.Value[i], msg2.Value[i])
-			msg3.Value[i].Mod(msg3.Value[i], params.T())
+			params.Field().Add(msg3.Value[i], msg1.Value[i], msg2.Value[i])
 		}
 
 		ct1 := enc.EncryptMsgNew(msg1)
@@ -164,7 +367,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msgOut := dec.DecryptToMsgNew(ct3)
 
 		for i := 0; i < slots; i++ {
-			assert.Equal(t, msgOut.Value[i].Text(10), msg3.Value[i].Text(10))
+			assert.Equal(t, msgOut.Value[i], msg3.Value[i])
 		}
 
 	})
@@ -175,8 +378,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg3 := NewMessage(params)
 
 		for i := 0; i < params.Slots(); i++ {
-			msg3.Value[i].Sub(msg1.Value[i], msg2.Value[i])
-			msg3.Value[i].Mod(msg3.Value[i], params.T())
+			params.Field().Sub(msg3.Value[i], msg1.Value[i], msg2.Value[i])
 		}
 
 		ct1 := enc.EncryptMsgNew(msg1)
@@ -185,7 +387,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msgOut := dec.DecryptToMsgNew(ct3)
 
 		for i := 0; i < slots; i++ {
-			assert.Equal(t, msgOut.Value[i].Text(10), msg3.Value[i].Text(10))
+			assert.Equal(t, msgOut.Value[i], msg3.Value[i])
 		}
 
 	})
@@ -196,8 +398,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg3 := NewMessage(params)
 
 		for i := 0; i < params.Slots(); i++ {
-			msg3.Value[i].Mul(msg1.Value[i], msg2.Value[i])
-			msg3.Value[i].Mod(msg3.Value[i], params.T())
+			params.Field().Mul(msg3.Value[i], msg1.Value[i], msg2.Value[i])
 		}
 
 		ct1 := enc.EncryptMsgNew(msg1)
@@ -206,7 +407,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msgOut := dec.DecryptToMsgNew(ct3)
 
 		for i := 0; i < slots; i++ {
-			assert.Equal(t, msgOut.Value[i].Text(10), msg3.Value[i].Text(10))
+			assert.Equal(t, msgOut.Value[i], msg3.Value[i])
 		}
 
 	})
@@ -219,9 +420,9 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 
 			for i := 0; i < slots; i++ {
 				if i-rotidx >= 0 {
-					msg2.Value[i-rotidx].Set(msg1.Value[i])
+					params.Field().Set(msg2.Value[i-rotidx], msg1.Value[i])
 				} else {
-					msg2.Value[i-rotidx+slots].Set(msg1.Value[i])
+					params.Field().Set(msg2.Value[i-rotidx+slots], msg1.Value[i])
 				}
 			}
 
@@ -230,7 +431,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 			msgOut := dec.DecryptToMsgNew(ct2)
 
 			for i := 0; i < slots; i++ {
-				assert.Equal(t, msgOut.Value[i].Text(10), msg2.Value[i].Text(10))
+				assert.Equal(t, msgOut.Value[i], msg2.Value[i])
 			}
 
 		}
@@ -242,8 +443,7 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msg2 := NewMessage(params)
 
 		for i := 0; i < slots; i++ {
-			msg2.Value[i].Neg(msg1.Value[i])
-			msg2.Value[i].Mod(msg2.Value[i], params.T())
+			params.Field().Neg(msg2.Value[i], msg1.Value[i])
 		}
 
 		ct1 := enc.EncryptMsgNew(msg1)
@@ -251,9 +451,374 @@ func testEvaluator(testctx *testContext, t *testing.T) {
 		msgOut := dec.DecryptToMsgNew(ct2)
 
 		for i := 0; i < slots; i++ {
-			assert.Equal(t, msgOut.Value[i].Text(10), msg2.Value[i].Text(10))
+			assert.Equal(t, msgOut.Value[i], msg2.Value[i])
 		}
 
 	})
 
 }
+
+func testNoise(testctx *testContext, t *testing.T) {
+	params := testctx.params
+
+	msg0 := genTestVectors(testctx)
+	msg1 := genTestVectors(testctx)
+	ct0 := testctx.encryptor.EncryptMsgNew(msg0)
+	ct1 := testctx.encryptor.EncryptMsgNew(msg1)
+
+	t.Run(testString("Noise/Fresh", params), func(t *testing.T) {
+		std, min, max := Noise(params, ct0, msg0, testctx.decryptor)
+		noise, _ := ct0.Noise()
+		assert.InDelta(t, noise, std, 1)
+		assert.LessOrEqual(t, min, std)
+		assert.LessOrEqual(t, std, max)
+		assert.InDelta(t, params.DecryptionMargin()-max, NoiseBudget(params, ct0, msg0, testctx.decryptor), 1e-9)
+		assert.Negative(t, NoiseBudget(params, ct0, msg1, testctx.decryptor))
+
+		_, _, max = Noise(params, NewCiphertext(params, 1), NewMessage(params), testctx.decryptor)
+		assert.True(t, math.IsInf(max, -1))
+	})
+
+	t.Run(testString("Noise/MulDepth", params), func(t *testing.T) {
+		testNoiseMulDepth(t, params, testctx.decryptor, func(ct0, ct1 *Ciphertext) *Ciphertext {
+			return testctx.eval.MulAndRelinNew(ct0, ct1, testctx.rlk)
+		}, ct0, msg0, ct1, msg1)
+	})
+}
+
+// testNoiseMulDepth multiplies ct0 by ct1 with mul for as long as the estimated noise allows it, and
+// checks at each depth the decryption, the measured noise against its estimate and the noise budget.
+func testNoiseMulDepth(t *testing.T, params Parameters, dec *Decryptor, mul func(ct0, ct1 *Ciphertext) *Ciphertext, ct0 *Ciphertext, msg0 *Message, ct1 *Ciphertext, msg1 *Message) {
+	const statSec = 40
+
+	budget := NoiseBudget(params, ct0, msg0, dec)
+	msg := NewMessage(params)
+	for i := range msg.Value {
+		params.Field().Set(msg.Value[i], msg0.Value[i])
+	}
+
+	for depth := 1; ; depth++ {
+		ct := mul(ct0, ct1)
+		noise, _ := ct.Noise()
+		if params.NoiseBound(noise, statSec) >= params.DecryptionMargin() {
+			assert.Greater(t, depth, 1, "not a single multiplication fits in the decryption margin")
+			return
+		}
+
+		for i := range msg.Value {
+			params.Field().Mul(msg.Value[i], msg.Value[i], msg1.Value[i])
+		}
+		msgOut := dec.DecryptToMsgNew(ct)
+		for i := range msg.Value {
+			if !params.Field().Equal(msgOut.Value[i], msg.Value[i]) {
+				t.Fatalf("depth %d: decryption failed at index %d", depth, i)
+			}
+		}
+
+		std, _, max := Noise(params, ct, msg, dec)
+		t.Logf("depth %d: estimated noise %.1f bits, measured %.1f bits (max %.1f), budget %.1f bits", depth, noise, std, max, params.DecryptionMargin()-max)
+		assert.GreaterOrEqual(t, params.NoiseBound(noise, statSec), max, "depth %d", depth)
+
+		newBudget := NoiseBudget(params, ct, msg, dec)
+		assert.Less(t, newBudget, budget, "depth %d", depth)
+		budget, ct0 = newBudget, ct
+	}
+}
+
+// referenceEncoder and referenceDecoder are the straightforward math/big implementations of the
+// Encoder and Decoder, against which the latter are tested and benchmarked.
+type referenceEncoder struct {
+	params Parameters
+
+	polyPool *ring.Poly
+
+	nttRoots   []*big.Int
+	rootPows   []*big.Int
+	msgPool    []*big.Int
+	coeffPool1 []*big.Int
+	coeffPool2 []*big.Int
+
+	dInvModT *big.Int
+
+	indexMap []int
+}
+
+func newReferenceEncoder(params Parameters) (ecd *referenceEncoder) {
+	ecd = new(referenceEncoder)
+	ecd.params = params
+	ecd.polyPool = params.RingQ().NewPoly()
+	ecd.nttRoots = newBigVector(params.Slots())
+	ecd.rootPows = newBigVector(params.Slots())
+	ecd.msgPool = newBigVector(params.Slots())
+	ecd.indexMap = make([]int, params.Slots())
+	ecd.dInvModT = new(big.Int).ModInverse(big.NewInt(int64(params.Slots())), params.T())
+	ecd.coeffPool1 = newBigVector(params.N())
+	ecd.coeffPool2 = newBigVector(params.N())
+
+	slots := params.Slots()
+	root := params.Root()
+	roots := ecd.nttRoots
+	rootPows := ecd.rootPows
+	k := params.N() / params.Slots()
+
+	//compute i-th root and minus i-th power of root
+	for i := 0; i < slots; i++ {
+		roots[i].Exp(root, big.NewInt(int64(2*params.N()-2*k*i)), params.T())
+		rootPows[i].Exp(root, big.NewInt(int64(2*params.N()-i)), params.T())
+	}
+
+	//compute indexMap[5^(ik/2)/2k)] = i
+	for i := 0; i < slots; i++ {
+		idx := ring.ModExp(5, uint64(i*k/2), uint64(params.N()*2)) / uint64(2*k)
+		ecd.indexMap[idx] = i
+	}
+
+	return
+}
+
+func (ecd *referenceEncoder) invNtt(msgIn *Message, msgOut []*big.Int) {
+
+	ecd.permute(msgIn, ecd.msgPool)
+
+	slots := ecd.params.Slots()
+	roots := ecd.nttRoots
+
+	for i := 0; i < slots; i++ {
+		msgOut[i].Set(ecd.msgPool[i])
+	}
+
+	//apply bit reversal
+	j := 0
+	for i := 1; i < slots; i++ {
+		bit := (slots >> 1)
+		for j >= bit {
+			j -= bit
+			bit >>= 1
+		}
+		j += bit
+		if i < j {
+			msgOut[i], msgOut[j] = msgOut[j], msgOut[i]
+		}
+	}
+
+	//apply inplace NTT
+	for i := 2; i <= slots; i <<= 1 {
+		step := slots / i
+		for j := 0; j < slots; j += i {
+			for k := 0; k < i/2; k++ {
+				u := new(big.Int).Set(msgOut[j+k])
+				v := new(big.Int).Exp(roots[k], big.NewInt(int64(step)), ecd.params.T())
+				v.Mul(msgOut[j+k+i/2], v)
+				msgOut[j+k].Add(u, v)
+				msgOut[j+k+i/2].Sub(u, v)
+
+				msgOut[j+k].Mod(msgOut[j+k], ecd.params.T())
+				msgOut[j+k+i/2].Mod(msgOut[j+k+i/2], ecd.params.T())
+			}
+		}
+	}
+
+	for i := 0; i < slots; i++ {
+		msgOut[i].Mul(msgOut[i], ecd.rootPows[i])
+		msgOut[i].Mul(msgOut[i], ecd.dInvModT)
+		msgOut[i].Mod(msgOut[i], ecd.params.T())
+	}
+
+}
+
+func (ecd *referenceEncoder) permute(msgIn *Message, msgOut []*big.Int) {
+
+	slots := ecd.params.Slots()
+
+	for i := 0; i < slots; i++ {
+		msgOut[i].Set(ecd.params.Field().Big(msgIn.Value[ecd.indexMap[i]]))
+	}
+}
+
+func (ecd *referenceEncoder) EncodeNew(msgIn *Message) (ptxtOut *Plaintext) {
+	ptxtOut = NewPlaintext(ecd.params)
+	ecd.Encode(msgIn, ptxtOut)
+	return
+}
+
+func (ecd *referenceEncoder) Encode(msgIn *Message, ptxtOut *Plaintext) {
+	params := ecd.params
+
+	ecd.invNtt(msgIn, ecd.msgPool)
+
+	// mult (X^(N-D) + bX^(N-2D) + ...)
+	d := params.Slots()
+	k := params.N() / d
+
+	for i := 0; i < k; i++ {
+		ecd.coeffPool1[i*d].Exp(params.b, big.NewInt(int64(k-i-1)), nil)
+	}
+
+	for i := 0; i < params.N(); i++ {
+		ecd.coeffPool2[i].SetInt64(0)
+	}
+
+	for i := 0; i < k; i++ {
+		for j := 0; j < d; j++ {
+			e := j + i*d
+			tmp := new(big.Int).Mul(ecd.msgPool[j], ecd.coeffPool1[i*d])
+			ecd.coeffPool2[e].Sub(ecd.coeffPool2[e], tmp)
+		}
+	}
+
+	// scale by Q/T
+
+	tHalf := new(big.Int).Div(params.T(), big.NewInt(2))
+	for i := 0; i < params.N(); i++ {
+		ecd.coeffPool2[i].Mul(ecd.coeffPool2[i], params.QBigInt())
+		ecd.coeffPool2[i].Add(ecd.coeffPool2[i], tHalf)
+		ecd.coeffPool2[i].Div(ecd.coeffPool2[i], params.T())
+	}
+
+	params.RingQ().SetCoefficientsBigint(ecd.coeffPool2, ptxtOut.Value)
+}
+
+type referenceDecoder struct {
+	params Parameters
+
+	polyPool *ring.Poly
+
+	nttRoots   []*big.Int
+	msgPool    []*big.Int
+	coeffPool1 []*big.Int
+	coeffPool2 []*big.Int
+}
+
+func newReferenceDecoder(params Parameters) (dcd *referenceDecoder) {
+	dcd = new(referenceDecoder)
+	dcd.params = params
+	dcd.polyPool = params.RingQ().NewPoly()
+	dcd.nttRoots = newBigVector(params.Slots())
+	dcd.msgPool = newBigVector(params.Slots())
+	dcd.coeffPool1 = newBigVector(params.N())
+	dcd.coeffPool2 = newBigVector(params.N())
+
+	slots := params.Slots()
+	root := params.Root()
+	roots := dcd.nttRoots
+	k := params.N() / params.Slots()
+
+	//compute ntt roots
+	for i := 0; i < slots; i++ {
+		//roots[i].Exp(root, big.NewInt(int64(2*k*i+1)), params.T)
+		e := ring.ModExp(5, uint64((k/2)*i), uint64(params.N()*2))
+		roots[i].Exp(root, big.NewInt(int64(e)), params.T())
+	}
+
+	return
+}
+
+func (dcd *referenceDecoder) ntt(msgIn, msgOut []*big.Int) {
+	slots := dcd.params.Slots()
+	roots := dcd.nttRoots
+
+	for i := 0; i < slots; i++ {
+		msgOut[i].Set(msgIn[i])
+	}
+
+	//apply bit reversal
+	j := 0
+	for i := 1; i < slots; i++ {
+		bit := (slots >> 1)
+		for j >= bit {
+			j -= bit
+			bit >>= 1
+		}
+		j += bit
+		if i < j {
+			msgOut[i], msgOut[j] = msgOut[j], msgOut[i]
+		}
+	}
+
+	//apply inplace NTT
+	for i := 2; i <= slots; i <<= 1 {
+		step := slots / i
+		for j := 0; j < slots; j += i {
+			for k := 0; k < i/2; k++ {
+				u := new(big.Int).Set(msgOut[j+k])
+				v := new(big.Int).Exp(roots[k], big.NewInt(int64(step)), dcd.params.T())
+				v.Mul(msgOut[j+k+i/2], v)
+				msgOut[j+k].Add(u, v)
+				msgOut[j+k+i/2].Sub(u, v)
+
+				msgOut[j+k].Mod(msgOut[j+k], dcd.params.T())
+				msgOut[j+k+i/2].Mod(msgOut[j+k+i/2], dcd.params.T())
+			}
+		}
+	}
+
+	for i := 0; i < slots; i++ {
+		msgOut[i].Mod(msgOut[i], dcd.params.T())
+	}
+}
+
+func (dcd *referenceDecoder) DecodeNew(ptxtIn *Plaintext) (msgOut *Message) {
+	msgOut = NewMessage(dcd.params)
+	dcd.Decode(ptxtIn, msgOut)
+	return
+}
+
+func (dcd *referenceDecoder) Decode(ptxtIn *Plaintext, msgOut *Message) {
+	params := dcd.params
+	ringQ := params.RingQ()
+	slots := params.Slots()
+	d := int(params.D())
+
+	for i := 0; i < params.N(); i++ {
+		dcd.coeffPool1[i].SetInt64(0)
+		dcd.coeffPool2[i].SetInt64(0)
+	}
+
+	// mult (X^d-b)/q to ptxt
+
+	ringQ.PolyToBigint(ptxtIn.Value, 1, dcd.coeffPool1)
+
+	for i := 0; i < params.N(); i++ {
+		tmp := new(big.Int).Mul(dcd.coeffPool1[i], new(big.Int).Neg(params.b))
+		dcd.coeffPool2[i].Add(dcd.coeffPool2[i], tmp)
+
+		if i+d < params.N() {
+			dcd.coeffPool2[i+d].Add(dcd.coeffPool2[i+d], dcd.coeffPool1[i])
+		} else {
+			dcd.coeffPool2[i+d-params.N()].Sub(dcd.coeffPool2[i+d-params.N()], dcd.coeffPool1[i])
+		}
+	}
+
+	qHalf := new(big.Int).Div(params.QBigInt(), big.NewInt(2))
+	for i := 0; i < params.N(); i++ {
+		dcd.coeffPool2[i].Add(dcd.coeffPool2[i], qHalf)
+		dcd.coeffPool2[i].Div(dcd.coeffPool2[i], params.QBigInt())
+	}
+
+	for i := params.N() - 1; i >= slots; i-- {
+		dcd.coeffPool2[i].Mul(dcd.coeffPool2[i], params.b)
+		dcd.coeffPool2[i-slots].Add(dcd.coeffPool2[i-slots], dcd.coeffPool2[i])
+		dcd.coeffPool2[i-slots].Mod(dcd.coeffPool2[i-slots], dcd.params.T())
+	}
+
+	//apply NTT
+
+	for i := 0; i < slots; i++ {
+		dcd.msgPool[i].Set(dcd.coeffPool2[i])
+	}
+
+	dcd.ntt(dcd.msgPool, dcd.msgPool)
+
+	for i := 0; i < slots; i++ {
+		params.Field().SetBig(msgOut.Value[i], dcd.msgPool[i])
+	}
+}
+
+// newBigVector returns a vector of n zero big integers.
+func newBigVector(n int) []*big.Int {
+	v := make([]*big.Int, n)
+	for i := range v {
+		v[i] = new(big.Int)
+	}
+	return v
+}
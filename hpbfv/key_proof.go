@@ -0,0 +1,375 @@
+package hpbfv
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"spdz-go/rlwe"
+	"spdz-go/rlwe/ringqp"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The proofs of key generation show that the partial public key and relinearization key of a party are
+// the image under keyRelation of a short witness: a ternary secret s, a ternary r and errors bounded by
+// 6*sigma. Without them, a party can choose its partial public key as a function of the others' and control
+// the joint secret key, or publish a relinearization key that breaks multiplications.
+//
+// The relation is linear, so the proof is a Fiat-Shamir Sigma protocol with the same monomial challenges
+// and noise flooding as the proofs of plaintext knowledge: for each of V repetitions, the prover masks the
+// witness w with y, whose coefficients are 2^PlaintextProofSlack times larger, and reveals
+// z = y + X^c * w for a challenge c in {0, 1, X, ..., X^(2N-1)}. The prover starts again with fresh masks
+// whenever a coefficient of z is out of keyProofBounds, so that the published z are uniform in these bounds
+// whatever the witness. The commitments keyRelation(y) are not sent: the verifier recomputes them as
+// keyRelation(z) - X^c * keys from the challenges and checks that they hash to the digest the challenges
+// were derived from. The soundness slack is the same as for the proofs of
+// plaintext knowledge: the extracted witness is short, but up to 2^PlaintextProofSlack times larger.
+
+// ErrInvalidKeyProof is returned when a proof of key generation does not verify.
+var ErrInvalidKeyProof = errors.New("invalid proof of key generation")
+
+// KeyProof is a non-interactive proof that a partial public key and relinearization key were generated
+// from short secrets and errors, see GenPartialKeysWithProof.
+type KeyProof struct {
+	Digest []byte          // hash of the keys and the commitments, from which the challenges are derived
+	Z      [][]ringqp.Poly // masked witness (s, r, e...) of every repetition, in the coefficient domain
+}
+
+// keyWitnessSize returns the number of polynomials of the witness of keyRelation: the secret s, the
+// randomness r and the errors of b, d and v.
+func (p Parameters) keyWitnessSize() int {
+	levelQ, levelP := p.QCount()-1, p.PCount()-1
+	return 2 + 3*p.DecompRNS(levelQ, levelP)*p.DecompPw2(levelQ, levelP)
+}
+
+// keyProofRepetitions returns the number of repetitions of a proof of key generation, which have the
+// challenge space of the masks of a proof of plaintext knowledge.
+func (p Parameters) keyProofRepetitions() int {
+	return p.plaintextProofMasks()
+}
+
+// keyProofMasks returns the bounds on the coefficients of the masks of the secrets and of the errors,
+// and false if twice these bounds cannot be checked modulo the first primes of Q and P.
+func (p Parameters) keyProofMasks() (maskS, maskE uint64, ok bool) {
+	maskS = 1 << PlaintextProofSlack
+	maskE = maskS * uint64(6*p.Sigma())
+	ok = 2*maskE < p.RingQ().Modulus[0]>>1
+	if p.PCount() > 0 {
+		ok = ok && 2*maskE < p.RingP().Modulus[0]>>1
+	}
+	return
+}
+
+// keyProofBounds returns the bounds on the coefficients of the masked witness of the secrets and of the
+// errors: the bounds on the masks minus the bounds on the witness, 1 for the secrets and 6*sigma for the
+// errors. An honest prover only publishes masked witnesses within these bounds.
+func (p Parameters) keyProofBounds() (boundS, boundE uint64) {
+	maskS, maskE, _ := p.keyProofMasks()
+	return maskS - 1, maskE - uint64(6*p.Sigma())
+}
+
+// GenKeysWithProof behaves as GenKeys and additionally returns a proof that the keys are well formed,
+// bound to the index id of the party.
+func (keygen *PartialKeyGenerator) GenKeysWithProof(id int) (sk *rlwe.SecretKey, pk *rlwe.PublicKey, rlk *RelinearizationKey, proof *KeyProof) {
+	sk = keygen.GenSecretKey()
+	pk, rlk, proof = keygen.GenPartialKeysWithProof(sk, id)
+	return
+}
+
+// GenPartialKeysWithProof behaves as GenPartialKeys and additionally returns a proof that the keys are
+// well formed, bound to the index id of the party. It assumes that sk was generated by GenSecretKey. The
+// proof is computed again with fresh masks, with probability about N*V*2^-PlaintextProofSlack per witness
+// polynomial, until its masked witness is within keyProofBounds.
+func (keygen *PartialKeyGenerator) GenPartialKeysWithProof(sk *rlwe.SecretKey, id int) (pk *rlwe.PublicKey, rlk *RelinearizationKey, proof *KeyProof) {
+	params := keygen.params
+	ringQP := params.RingQP()
+	levelQ, levelP := params.QCount()-1, params.PCount()-1
+
+	maskS, maskE, ok := params.keyProofMasks()
+	if !ok {
+		panic("cannot GenPartialKeysWithProof: moduli too small for the proof")
+	}
+
+	pk, rlk, witness := keygen.genPartialKeys(sk)
+	boundS, boundE := params.keyProofBounds()
+
+	numReps := params.keyProofRepetitions()
+	image := NewRelinearizationKey(params.Parameters, levelQ, levelP)
+	xw := ringQP.NewPoly()
+	for {
+		// commitments keyRelation(y) for masks y
+		ys := make([][]ringqp.Poly, numReps)
+		h := keygen.keyProofHash(rlk, id)
+		for k := range ys {
+			ys[k] = make([]ringqp.Poly, len(witness))
+			for l := range ys[k] {
+				ys[k][l] = ringQP.NewPoly()
+				if l < 2 {
+					keygen.sampleBoundedQP(maskS, ys[k][l])
+				} else {
+					keygen.sampleBoundedQP(maskE, ys[k][l])
+				}
+			}
+			keygen.keyRelation(ys[k], image)
+			keygen.hashCommitment(h, image, nil, -1)
+		}
+
+		// z = y + X^c * w
+		proof = &KeyProof{Digest: h.Sum(nil), Z: ys}
+		accept := true
+		for k, c := range keyProofChallenges(params, proof.Digest) {
+			for l := range witness {
+				if c >= 0 {
+					mulByMonomialQP(ringQP, witness[l], c, xw)
+					ringQP.AddLvl(levelQ, levelP, proof.Z[k][l], xw, proof.Z[k][l])
+				}
+				bound := boundE
+				if l < 2 {
+					bound = boundS
+				}
+				accept = accept && isSmallQP(ringQP, proof.Z[k][l], bound)
+			}
+		}
+		if accept {
+			return pk, rlk, proof
+		}
+	}
+}
+
+// VerifyPartialKeys checks the proof that the partial keys pk and rlk of the party of index id are well
+// formed, and that they use the common reference string of keygen. It returns an error wrapping
+// ErrInvalidKeyProof if they are not.
+func (keygen *PartialKeyGenerator) VerifyPartialKeys(pk *rlwe.PublicKey, rlk *RelinearizationKey, proof *KeyProof, id int) error {
+	params := keygen.params
+	ringQP := params.RingQP()
+	levelQ, levelP := params.QCount()-1, params.PCount()-1
+
+	if _, _, ok := params.keyProofMasks(); !ok {
+		return fmt.Errorf("%w: moduli too small for the proof", ErrInvalidKeyProof)
+	}
+	boundS, boundE := params.keyProofBounds()
+	if err := keygen.checkPartialKeys(pk, rlk); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidKeyProof, err)
+	}
+	numReps, size := params.keyProofRepetitions(), params.keyWitnessSize()
+	if proof == nil || len(proof.Digest) != blake2b.Size256 || len(proof.Z) != numReps {
+		return fmt.Errorf("%w: expected %d repetitions", ErrInvalidKeyProof, numReps)
+	}
+	for k := range proof.Z {
+		if len(proof.Z[k]) != size {
+			return fmt.Errorf("%w: invalid shape of repetition %d", ErrInvalidKeyProof, k)
+		}
+		for l := range proof.Z[k] {
+			if !keygen.checkPoly(proof.Z[k][l]) {
+				return fmt.Errorf("%w: invalid shape of repetition %d", ErrInvalidKeyProof, k)
+			}
+		}
+	}
+
+	// the keys in the coefficient domain
+	keys := keyImage(rlk)
+	for i := range keys {
+		pol := ringQP.NewPoly()
+		ringQP.InvNTTLvl(levelQ, levelP, keys[i], pol)
+		keys[i] = pol
+	}
+
+	h := keygen.keyProofHash(rlk, id)
+	image := NewRelinearizationKey(params.Parameters, levelQ, levelP)
+	for k, c := range keyProofChallenges(params, proof.Digest) {
+		for l, z := range proof.Z[k] {
+			bound := boundE
+			if l < 2 {
+				bound = boundS
+			}
+			if !isSmallQP(ringQP, z, bound) {
+				return fmt.Errorf("%w: repetition %d out of bounds", ErrInvalidKeyProof, k)
+			}
+		}
+		keygen.keyRelation(proof.Z[k], image)
+		keygen.hashCommitment(h, image, keys, c)
+	}
+	if subtle.ConstantTimeCompare(h.Sum(nil), proof.Digest) != 1 {
+		return fmt.Errorf("%w: challenges do not match", ErrInvalidKeyProof)
+	}
+	return nil
+}
+
+// AggregateKeysVerified verifies the partial keys of every party against its proof, and aggregates them as
+// AggregateKeys. The proof of the party of index i must be bound to i. It returns an error naming the first
+// party whose keys are invalid.
+func (keygen *PartialKeyGenerator) AggregateKeysVerified(pks []*rlwe.PublicKey, rlks []*RelinearizationKey, proofs []*KeyProof) (jpk *rlwe.PublicKey, jrlk *RelinearizationKey, err error) {
+	if len(pks) == 0 || len(rlks) != len(pks) || len(proofs) != len(pks) {
+		return nil, nil, fmt.Errorf("cannot AggregateKeysVerified: got %d public keys, %d relinearization keys and %d proofs", len(pks), len(rlks), len(proofs))
+	}
+	for i := range pks {
+		if err = keygen.VerifyPartialKeys(pks[i], rlks[i], proofs[i], i); err != nil {
+			return nil, nil, fmt.Errorf("party %d: %w", i, err)
+		}
+	}
+	return keygen.AggregateKeys(pks, rlks)
+}
+
+// checkPartialKeys checks the shape of pk and rlk, and that they use the common reference string.
+func (keygen *PartialKeyGenerator) checkPartialKeys(pk *rlwe.PublicKey, rlk *RelinearizationKey) error {
+	if pk == nil || !keygen.checkPoly(pk.Value[0]) || !keygen.checkPoly(pk.Value[1]) {
+		return errors.New("invalid shape of the public key")
+	}
+	if !pk.Value[1].Equals(keygen.A[0][0]) {
+		return errors.New("mismatch in common reference string")
+	}
+	if rlk == nil {
+		return errors.New("nil relinearization key")
+	}
+	for _, ct := range []rlwe.GadgetCiphertext{rlk.BD, rlk.V} {
+		if len(ct.Value) != len(keygen.A) {
+			return errors.New("invalid shape of the relinearization key")
+		}
+		for i := range ct.Value {
+			if len(ct.Value[i]) != len(keygen.A[i]) {
+				return errors.New("invalid shape of the relinearization key")
+			}
+			for j := range ct.Value[i] {
+				if !keygen.checkPoly(ct.Value[i][j].Value[0]) || !keygen.checkPoly(ct.Value[i][j].Value[1]) {
+					return errors.New("invalid shape of the relinearization key")
+				}
+			}
+		}
+	}
+	for i := range rlk.V.Value {
+		for j := range rlk.V.Value[i] {
+			if !rlk.V.Value[i][j].Value[1].Equals(keygen.U[i][j]) {
+				return errors.New("mismatch in common reference string")
+			}
+		}
+	}
+	if !pk.Value[0].Equals(rlk.BD.Value[0][0].Value[0]) {
+		return errors.New("public key does not match the relinearization key")
+	}
+	return nil
+}
+
+// checkPoly reports whether pol is allocated at the maximum levels of Q and P.
+func (keygen *PartialKeyGenerator) checkPoly(pol ringqp.Poly) bool {
+	params := keygen.params
+	if pol.Q == nil || pol.Q.N() != params.N() || pol.Q.Level() != params.QCount()-1 {
+		return false
+	}
+	if params.PCount() == 0 {
+		return pol.P == nil
+	}
+	return pol.P != nil && pol.P.N() == params.N() && pol.P.Level() == params.PCount()-1
+}
+
+// keyProofHash returns a hash of the statement of a proof of key generation of the party of index id.
+func (keygen *PartialKeyGenerator) keyProofHash(rlk *RelinearizationKey, id int) hash.Hash {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	fp := keygen.params.Fingerprint()
+	h.Write([]byte("hpbfv/key-proof"))
+	h.Write(fp[:])
+	var idBytes [8]byte
+	binary.BigEndian.PutUint64(idBytes[:], uint64(id))
+	h.Write(idBytes[:])
+
+	buf := make([]byte, 8*keygen.params.N())
+	for _, pol := range keyImage(rlk) {
+		keygen.hashPolyQP(h, pol, buf)
+	}
+	return h
+}
+
+// hashCommitment writes to h the commitment keyRelation(y) = image - X^c * keys in the coefficient domain,
+// where image is in the NTT domain and keys in the coefficient domain. Zero challenges (c < 0) and nil
+// keys leave image unchanged.
+func (keygen *PartialKeyGenerator) hashCommitment(h hash.Hash, image *RelinearizationKey, keys []ringqp.Poly, c int) {
+	ringQP := keygen.params.RingQP()
+	levelQ, levelP := keygen.params.QCount()-1, keygen.params.PCount()-1
+	buf := make([]byte, 8*keygen.params.N())
+	pol, xk := keygen.buffQP[0], keygen.buffQP[1]
+	for i, img := range keyImage(image) {
+		ringQP.InvNTTLvl(levelQ, levelP, img, pol)
+		if keys != nil && c >= 0 {
+			mulByMonomialQP(ringQP, keys[i], c, xk)
+			ringQP.SubLvl(levelQ, levelP, pol, xk, pol)
+		}
+		keygen.hashPolyQP(h, pol, buf)
+	}
+}
+
+func (keygen *PartialKeyGenerator) hashPolyQP(h hash.Hash, pol ringqp.Poly, buf []byte) {
+	hashPoly(h, keygen.params.RingQ(), pol.Q, buf)
+	if pol.P != nil {
+		hashPoly(h, keygen.params.RingP(), pol.P, buf)
+	}
+}
+
+// sampleBoundedQP sets the coefficients of pol to integers uniform in [-bound, bound].
+func (keygen *PartialKeyGenerator) sampleBoundedQP(bound uint64, pol ringqp.Poly) {
+	sampleBounded(keygen.prng, keygen.params.RingQ(), bound, pol.Q)
+	if pol.P != nil {
+		keygen.params.RingQP().ExtendBasisSmallNormAndCenter(pol.Q, pol.P.Level(), nil, pol.P)
+	}
+}
+
+// keyImage returns the polynomials of rlk that are linear in the witness of keyRelation: the b, d and v.
+func keyImage(rlk *RelinearizationKey) (image []ringqp.Poly) {
+	for u, ct := range []rlwe.GadgetCiphertext{rlk.BD, rlk.BD, rlk.V} {
+		for i := range ct.Value {
+			for j := range ct.Value[i] {
+				image = append(image, ct.Value[i][j].Value[u%2])
+			}
+		}
+	}
+	return
+}
+
+// keyProofChallenges derives the challenges of a proof of key generation from its digest, as the degree
+// of the monomial or -1 for zero.
+func keyProofChallenges(params Parameters, digest []byte) []int {
+	xof, err := blake2b.NewXOF(blake2b.OutputLengthUnknown, nil)
+	if err != nil {
+		panic(err)
+	}
+	xof.Write([]byte("hpbfv/key-proof/challenges"))
+	xof.Write(digest)
+	cs := make([]int, params.keyProofRepetitions())
+	for k := range cs {
+		cs[k] = readMonomialChallenge(xof, params.N())
+	}
+	return cs
+}
+
+// mulByMonomialQP sets out to X^deg * pol.
+func mulByMonomialQP(ringQP *ringqp.Ring, pol ringqp.Poly, deg int, out ringqp.Poly) {
+	ringQP.RingQ.MultByMonomial(pol.Q, deg, out.Q)
+	if pol.P != nil {
+		ringQP.RingP.MultByMonomial(pol.P, deg, out.P)
+	}
+}
+
+// isSmallQP reports whether the coefficients of pol are integers in [-bound, bound], for bound smaller
+// than half the first primes of Q and P.
+func isSmallQP(ringQP *ringqp.Ring, pol ringqp.Poly, bound uint64) bool {
+	if !isSmall(ringQP.RingQ, pol.Q, bound) {
+		return false
+	}
+	if pol.P == nil {
+		return true
+	}
+	q0 := ringQP.RingQ.Modulus[0]
+	for j, c := range pol.Q.Coeffs[0] {
+		v := centered(c, q0)
+		for i, coeffs := range pol.P.Coeffs {
+			if pi := ringQP.RingP.Modulus[i]; coeffs[j]%pi != smallMod(v, pi) {
+				return false
+			}
+		}
+	}
+	return true
+}
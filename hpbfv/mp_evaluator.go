@@ -2,6 +2,7 @@ package hpbfv
 
 import (
 	"fmt"
+	"math"
 
 	"spdz-go/ring"
 	"spdz-go/rlwe"
@@ -233,6 +234,7 @@ func (eval *MEvaluator) Add(op0, op1, ctOut *Ciphertext) {
 	el0, el1, elOut := eval.getElemAndCheckBinary(op0.Ciphertext, op1.Ciphertext,
 		ctOut.Ciphertext, utils.MaxInt(op0.Degree(), op1.Degree()))
 	eval.evaluateInPlaceBinary(el0, el1, elOut, eval.params.RingQ().Add)
+	ctOut.setNoiseOf(eval.params.AddNoise(op0.noise, op1.noise), op0, op1)
 }
 
 // AddNew adds op0 to op1 and creates a new element ctOut to store the result.
@@ -245,6 +247,8 @@ func (eval *MEvaluator) AddNew(op0, op1 *Ciphertext) (ctOut *Ciphertext) {
 func (eval *MEvaluator) PlaintextAdd(ct *Ciphertext, pt *Plaintext, ctOut *Ciphertext) {
 	el0, el1, elOut := eval.getElemAndCheckBinary(ct.Ciphertext, pt, ctOut.Ciphertext, utils.MaxInt(ct.Degree(), pt.Degree()))
 	eval.evaluateInPlaceBinary(el0, el1, elOut, eval.params.RingQ().Add)
+	// the encoding rounds to at most 1/2
+	ctOut.setNoiseOf(eval.params.AddNoise(ct.noise, -0.5*math.Log2(12)), ct)
 }
 
 func (eval *MEvaluator) PlaintextAddNew(ct *Ciphertext, pt *Plaintext) (ctOut *Ciphertext) {
@@ -264,6 +268,7 @@ func (eval *MEvaluator) Sub(op0, op1, ctOut *Ciphertext) {
 			eval.params.RingQ().Neg(ctOut.Value[i], ctOut.Value[i])
 		}
 	}
+	ctOut.setNoiseOf(eval.params.AddNoise(op0.noise, op1.noise), op0, op1)
 }
 
 // SubNew subtracts op1 from op0 and creates a new element ctOut to store the result.
@@ -278,6 +283,7 @@ func (eval *MEvaluator) Neg(ctIn, ctOut *Ciphertext) {
 	for i := 0; i <= ctIn.Degree(); i++ {
 		eval.params.RingQ().Neg(ctIn.Value[i], ctOut.Value[i])
 	}
+	ctOut.setNoiseOf(ctIn.noise, ctIn)
 }
 
 // NegNew negates op and creates a new element to store the result.
@@ -302,6 +308,7 @@ func (eval *MEvaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet
 	if k == 0 {
 
 		ctOut.Copy(ct0.El())
+		ctOut.setNoiseOf(ct0.noise, ct0)
 
 	} else {
 		galElL := eval.params.GaloisElementForColumnRotationBy(uint64(k))
@@ -309,6 +316,7 @@ func (eval *MEvaluator) RotateColumns(ct0 *Ciphertext, rtks *rlwe.RotationKeySet
 		if swk, inSet := rtks.GetRotationKey(galElL); inSet {
 
 			eval.permute(ct0, galElL, swk, ctOut)
+			ctOut.setNoiseOf(eval.params.AddNoise(ct0.noise, eval.params.KeySwitchNoise(ct0.numParties)), ct0)
 
 		} else {
 			panic(fmt.Errorf("MEvaluator has no rotation key for rotation by %d", k))
@@ -327,6 +335,8 @@ func (eval *MEvaluator) RotateColumnsNew(ct0 *Ciphertext, rtks *rlwe.RotationKey
 func (eval *MEvaluator) MulAndRelin(op0, op1 *Ciphertext, rlk *RelinearizationKey, ctOut *Ciphertext) {
 	eval.tensorAndRescale(op0.Ciphertext, op1.Ciphertext, eval.poolCtMul.Ciphertext)
 	eval.relinearize(eval.poolCtMul, rlk, ctOut)
+	numParties := utils.MaxInt(op0.numParties, op1.numParties)
+	ctOut.SetNoise(eval.params.MulNoise(op0.noise, op1.noise, numParties), numParties)
 }
 
 // Mul multiplies op0 by op1 and returns the result in ctOut.
@@ -341,6 +351,8 @@ func (eval *MEvaluator) MulAndRelinNew(op0, op1 *Ciphertext, rlk *Relinearizatio
 func (eval *MEvaluator) MulAndRelinHoisted(op0 []ringqp.Poly, op1 *Ciphertext, rlk *RelinearizationKey, ctOut *Ciphertext) {
 	eval.tensorAndRescaleHoisted(op0, op1.Ciphertext, eval.poolCtMul.Ciphertext)
 	eval.relinearize(eval.poolCtMul, rlk, ctOut)
+	// the noise of the hoisted operand is not known
+	ctOut.setNoiseOf(math.NaN(), op1)
 }
 
 func (eval *MEvaluator) tensorAndRescalePt(ct *rlwe.Ciphertext, pt *rlwe.Plaintext, ctOut *rlwe.Ciphertext) {
@@ -399,6 +411,7 @@ func (eval *MEvaluator) tensorAndRescalePt(ct *rlwe.Ciphertext, pt *rlwe.Plainte
 
 func (eval *MEvaluator) PlaintextMul(ct *Ciphertext, pt *Plaintext, ctOut *Ciphertext) {
 	eval.tensorAndRescalePt(ct.Ciphertext, pt.Plaintext, ctOut.Ciphertext)
+	ctOut.setNoiseOf(eval.params.PlaintextMulNoise(ct.noise, ct.numParties), ct)
 }
 
 func (eval *MEvaluator) PlaintextMulNew(ct *Ciphertext, pt *Plaintext) (ctOut *Ciphertext) {
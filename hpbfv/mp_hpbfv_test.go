@@ -9,10 +9,15 @@ import (
 			"github.com/stretchr/testify/require"
 	*/
 
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
 	"testing"
 
 	"spdz-go/ring"
 	"spdz-go/rlwe"
+	"spdz-go/rlwe/ringqp"
 	"spdz-go/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -76,8 +81,10 @@ func genMPTestParams(params Parameters, numParties int) (testctx *mpTestContext,
 		testctx.ppks[i], testctx.prlks[i] = testctx.kgens[i].GenPartialKeys(testctx.psks[i])
 		testctx.ddecs[i] = NewDistributedDecryptor(testctx.params, testctx.psks[i])
 	}
-	testctx.jpk, testctx.jrlk = testctx.kgens[0].AggregateKeys(testctx.ppks, testctx.prlks)
-	testctx.enc = NewEncryptor(testctx.params, testctx.jpk)
+	if testctx.jpk, testctx.jrlk, err = testctx.kgens[0].AggregateKeys(testctx.ppks, testctx.prlks); err != nil {
+		return nil, err
+	}
+	testctx.enc = NewJointEncryptor(testctx.params, testctx.jpk, numParties)
 	testctx.ecd = NewEncoder(testctx.params)
 	testctx.dcd = NewDecoder(testctx.params)
 	testctx.meval = NewMEvaluator(testctx.params)
@@ -96,18 +103,22 @@ func genMPTestParams(params Parameters, numParties int) (testctx *mpTestContext,
 func genMPTestVectors(testctx *mpTestContext) (msg *Message) {
 	params := testctx.params
 	coeffs := testctx.uSampler.ReadNew()
-	msg = NewMessage(params)
-	testctx.ringQ.PolyToBigint(coeffs, params.N()/params.Slots(), msg.Value)
+	values := make([]*big.Int, params.Slots())
+	testctx.ringQ.PolyToBigint(coeffs, params.N()/params.Slots(), values)
 
+	msg = NewMessage(params)
 	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i].Mod(msg.Value[i], params.T())
+		params.Field().SetBig(msg.Value[i], values[i])
 	}
 
 	return
 }
 
 func TestMPGPFV(t *testing.T) {
-	params := NewParametersFromLiteral(SOHO)
+	params, err := NewParametersFromLiteral(SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	crs := make([]byte, 32)
 	prng, err := utils.NewPRNG()
 	if err != nil {
@@ -123,6 +134,12 @@ func TestMPGPFV(t *testing.T) {
 	testSetup(testctx, t)
 	testDistDec(testctx, t)
 	testEval(testctx, t)
+	testNoiseEstimate(testctx, t)
+	testWire(testctx, t)
+	testPlaintextProof(testctx, t)
+	testKeyProof(testctx, t)
+	testDecryptionProof(testctx, t)
+	testThreshold(testctx, t)
 }
 
 func testSetup(testctx *mpTestContext, t *testing.T) {
@@ -169,6 +186,19 @@ func testSetup(testctx *mpTestContext, t *testing.T) {
 				t.Fatalf("Aggregation check failed at coefficient %d. Value: %d. (Expected noise < %d)", i, c, bound)
 			}
 		}
+
+		// the keys of a party using another common reference string
+		crs := append([]byte{}, testctx.crs...)
+		crs[0] ^= 1
+		pk, rlk := NewPartialKeyGenerator(params, crs).GenPartialKeys(testctx.psks[1])
+		pks := append([]*rlwe.PublicKey{}, testctx.ppks...)
+		rlks := append([]*RelinearizationKey{}, testctx.prlks...)
+		pks[1], rlks[1] = pk, rlk
+		_, _, err := testctx.kgens[0].AggregateKeys(pks, rlks)
+		var aggErr *KeyAggregationError
+		if assert.ErrorAs(t, err, &aggErr) {
+			assert.Equal(t, 1, aggErr.Party)
+		}
 	})
 
 	t.Run(testString("JointKey", params), func(t *testing.T) {
@@ -177,7 +207,7 @@ func testSetup(testctx *mpTestContext, t *testing.T) {
 		ct := testctx.enc.EncryptMsgNew(msg)
 		msgOut := dec.DecryptToMsgNew(ct)
 		for i := 0; i < params.Slots(); i++ {
-			assert.Equal(t, 0, msgOut.Value[i].Cmp(msg.Value[i]), "Joint key Encryption/Decryption test failed at index %d: got %s, want %s", i, msgOut.Value[i].Text(10), msg.Value[i].Text(10))
+			assert.True(t, params.Field().Equal(msgOut.Value[i], msg.Value[i]), "Joint key Encryption/Decryption test failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg.Value[i]))
 		}
 	})
 }
@@ -198,8 +228,8 @@ func testDistDec(testctx *mpTestContext, t *testing.T) {
 
 	t.Run(testString("DistributedDecryption", params), func(t *testing.T) {
 		for i := 0; i < params.Slots(); i++ {
-			if msgOutD.Value[i].Cmp(msg.Value[i]) != 0 {
-				t.Fatalf("Distributed Decryption test failed at index %d: got %s, want %s", i, msgOutD.Value[i].Text(10), msg.Value[i].Text(10))
+			if !params.Field().Equal(msgOutD.Value[i], msg.Value[i]) {
+				t.Fatalf("Distributed Decryption test failed at index %d: got %s, want %s", i, params.Field().Big(msgOutD.Value[i]), params.Field().Big(msg.Value[i]))
 			}
 		}
 	})
@@ -217,13 +247,11 @@ func testEval(testctx *mpTestContext, t *testing.T) {
 
 	msgAdd := NewMessage(params)
 	for i := 0; i < params.Slots(); i++ {
-		msgAdd.Value[i].Add(msg1.Value[i], msg2.Value[i])
-		msgAdd.Value[i].Mod(msgAdd.Value[i], params.T())
+		params.Field().Add(msgAdd.Value[i], msg1.Value[i], msg2.Value[i])
 	}
 	msgMul := NewMessage(params)
 	for i := 0; i < params.Slots(); i++ {
-		msgMul.Value[i].Mul(msg1.Value[i], msg2.Value[i])
-		msgMul.Value[i].Mod(msgMul.Value[i], params.T())
+		params.Field().Mul(msgMul.Value[i], msg1.Value[i], msg2.Value[i])
 	}
 
 	t.Run(testString("Add", params), func(t *testing.T) {
@@ -231,8 +259,8 @@ func testEval(testctx *mpTestContext, t *testing.T) {
 		msgOutD := testctx.jdec.DecryptToMsgNew(ctAdd)
 
 		for i := 0; i < params.Slots(); i++ {
-			if msgOutD.Value[i].Cmp(msgAdd.Value[i]) != 0 {
-				t.Fatalf("Add test failed at index %d: got %s, want %s", i, msgOutD.Value[i].Text(10), msgAdd.Value[i].Text(10))
+			if !params.Field().Equal(msgOutD.Value[i], msgAdd.Value[i]) {
+				t.Fatalf("Add test failed at index %d: got %s, want %s", i, params.Field().Big(msgOutD.Value[i]), params.Field().Big(msgAdd.Value[i]))
 			}
 		}
 	})
@@ -242,8 +270,8 @@ func testEval(testctx *mpTestContext, t *testing.T) {
 		msgOutD := testctx.jdec.DecryptToMsgNew(ctAdd)
 
 		for i := 0; i < params.Slots(); i++ {
-			if msgOutD.Value[i].Cmp(msgAdd.Value[i]) != 0 {
-				t.Fatalf("PlaintextAdd test failed at index %d: got %s, want %s", i, msgOutD.Value[i].Text(10), msgAdd.Value[i].Text(10))
+			if !params.Field().Equal(msgOutD.Value[i], msgAdd.Value[i]) {
+				t.Fatalf("PlaintextAdd test failed at index %d: got %s, want %s", i, params.Field().Big(msgOutD.Value[i]), params.Field().Big(msgAdd.Value[i]))
 			}
 		}
 	})
@@ -253,8 +281,8 @@ func testEval(testctx *mpTestContext, t *testing.T) {
 		msgOutD := testctx.jdec.DecryptToMsgNew(ctMul)
 
 		for i := 0; i < params.Slots(); i++ {
-			if msgOutD.Value[i].Cmp(msgMul.Value[i]) != 0 {
-				t.Fatalf("PlaintextMul test failed at index %d: got %s, want %s", i, msgOutD.Value[i].Text(10), msgMul.Value[i].Text(10))
+			if !params.Field().Equal(msgOutD.Value[i], msgMul.Value[i]) {
+				t.Fatalf("PlaintextMul test failed at index %d: got %s, want %s", i, params.Field().Big(msgOutD.Value[i]), params.Field().Big(msgMul.Value[i]))
 			}
 		}
 	})
@@ -264,9 +292,789 @@ func testEval(testctx *mpTestContext, t *testing.T) {
 		msgOutD := testctx.jdec.DecryptToMsgNew(ctMulRelin)
 
 		for i := 0; i < params.Slots(); i++ {
-			if msgOutD.Value[i].Cmp(msgMul.Value[i]) != 0 {
-				t.Fatalf("MulRelin test failed at index %d: got %s, want %s", i, msgOutD.Value[i].Text(10), msgMul.Value[i].Text(10))
+			if !params.Field().Equal(msgOutD.Value[i], msgMul.Value[i]) {
+				t.Fatalf("MulRelin test failed at index %d: got %s, want %s", i, params.Field().Big(msgOutD.Value[i]), params.Field().Big(msgMul.Value[i]))
 			}
 		}
 	})
 }
+
+func testWire(testctx *mpTestContext, t *testing.T) {
+	params := testctx.params
+	msg := genMPTestVectors(testctx)
+	ct := testctx.enc.EncryptMsgNew(msg)
+	share := testctx.ddecs[0].PartialDecrypt(ct, 80)
+
+	w := NewWireWriter(params)
+	w.WritePublicKey(testctx.ppks[0])
+	w.WriteRelinearizationKey(testctx.prlks[0])
+	w.WriteCiphertext(ct)
+	w.WriteDistDecShare(share)
+	w.WriteMessage(msg)
+	w.WriteScalar(msg.Value[0])
+	w.WriteBytes(testctx.crs)
+	data, err := w.Bytes()
+	assert.NoError(t, err)
+
+	t.Run(testString("Wire/RoundTrip", params), func(t *testing.T) {
+		r := NewWireReader(params, data)
+		pk, rlk, ctOut, shareOut, msgOut := r.ReadPublicKey(), r.ReadRelinearizationKey(), r.ReadCiphertext(), r.ReadDistDecShare(), r.ReadMessage()
+		x, crs := r.ReadScalar(), r.ReadBytes(len(testctx.crs))
+		assert.NoError(t, r.Close())
+
+		assert.True(t, pk.Equals(testctx.ppks[0]))
+		assert.True(t, rlk.BD.Equals(&testctx.prlks[0].BD))
+		assert.True(t, rlk.V.Equals(&testctx.prlks[0].V))
+		assert.Equal(t, ct.Degree(), ctOut.Degree())
+		for i := range ct.Value {
+			assert.True(t, ct.Value[i].Equals(ctOut.Value[i]))
+		}
+		assert.True(t, share.Poly.Equals(shareOut.Poly))
+		for i := 0; i < params.Slots(); i++ {
+			assert.Equal(t, msg.Value[i], msgOut.Value[i])
+		}
+		assert.Equal(t, msg.Value[0], x)
+		assert.Equal(t, testctx.crs, crs)
+
+		msgDec := testctx.ddecs[0].JointDecryptToMsgNew(ctOut, []*DistDecShare{shareOut})
+		msgRef := testctx.ddecs[0].JointDecryptToMsgNew(ct, []*DistDecShare{share})
+		for i := 0; i < params.Slots(); i++ {
+			assert.Equal(t, msgRef.Value[i], msgDec.Value[i])
+		}
+	})
+
+	t.Run(testString("Wire/Sizes", params), func(t *testing.T) {
+		sizes := params.WireSizes()
+		for _, c := range []struct {
+			write func(w *WireWriter)
+			size  int
+		}{
+			{func(w *WireWriter) { w.WritePublicKey(testctx.ppks[0]) }, sizes.PublicKey},
+			{func(w *WireWriter) { w.WriteRelinearizationKey(testctx.prlks[0]) }, sizes.RelinearizationKey},
+			{func(w *WireWriter) { w.WriteCiphertext(ct) }, sizes.Ciphertext},
+			{func(w *WireWriter) { w.WriteDistDecShare(share) }, sizes.DistDecShare},
+			{func(w *WireWriter) { w.WriteMessage(msg) }, sizes.Message},
+		} {
+			w := NewWireWriter(params)
+			c.write(w)
+			data, err := w.Bytes()
+			assert.NoError(t, err)
+			assert.Equal(t, sizes.Header+c.size, len(data))
+		}
+	})
+
+	readAll := func(params Parameters, data []byte) error {
+		r := NewWireReader(params, data)
+		r.ReadPublicKey()
+		r.ReadRelinearizationKey()
+		r.ReadCiphertext()
+		r.ReadDistDecShare()
+		r.ReadMessage()
+		r.ReadScalar()
+		r.ReadBytes(len(testctx.crs))
+		return r.Close()
+	}
+
+	t.Run(testString("Wire/Truncated", params), func(t *testing.T) {
+		for _, n := range []int{0, 1, wireHeaderSize - 1, wireHeaderSize, wireHeaderSize + 1, len(data) / 3, len(data) / 2, len(data) - 1} {
+			assert.ErrorIs(t, readAll(params, data[:n]), ErrWireFormat, "truncated to %d bytes", n)
+		}
+		assert.ErrorIs(t, readAll(params, append(append([]byte{}, data...), 0)), ErrWireFormat)
+	})
+
+	t.Run(testString("Wire/Mismatch", params), func(t *testing.T) {
+		hemi, err := NewParametersFromLiteral(HEMI)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, readAll(hemi, data), ErrWireFormat)
+
+		r := NewWireReader(params, data)
+		assert.Nil(t, r.ReadCiphertext())
+		assert.Nil(t, r.ReadPublicKey())
+		assert.ErrorIs(t, r.Close(), ErrWireFormat)
+
+		version := append([]byte{}, data...)
+		version[len(wireMagic)]++
+		assert.ErrorIs(t, readAll(params, version), ErrWireFormat)
+	})
+
+	t.Run(testString("Wire/Unreduced", params), func(t *testing.T) {
+		w := NewWireWriter(params)
+		w.WriteDistDecShare(share)
+		data, err := w.Bytes()
+		assert.NoError(t, err)
+		for i := wireHeaderSize + 2; i < wireHeaderSize+10; i++ {
+			data[i] = 0xff
+		}
+		r := NewWireReader(params, data)
+		r.ReadDistDecShare()
+		assert.ErrorIs(t, r.Close(), ErrWireFormat)
+	})
+}
+
+func testNoiseEstimate(testctx *mpTestContext, t *testing.T) {
+	params := testctx.params
+	statSec := 40
+
+	msg1 := genMPTestVectors(testctx)
+	msg2 := genMPTestVectors(testctx)
+	msgMul := NewMessage(params)
+	for i := 0; i < params.Slots(); i++ {
+		params.Field().Mul(msgMul.Value[i], msg1.Value[i], msg2.Value[i])
+	}
+
+	ct1 := testctx.enc.EncryptMsgNew(msg1)
+	ct2 := testctx.enc.EncryptMsgNew(msg2)
+
+	t.Run(testString("Noise/Estimate", params), func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			ct   *Ciphertext
+			msg  *Message
+		}{
+			{"Fresh", ct1, msg1},
+			{"MulRelin", testctx.meval.MulAndRelinNew(ct1, ct2, testctx.jrlk), msgMul},
+			{"PlaintextMul", testctx.meval.PlaintextMulNew(ct1, testctx.ecd.EncodeNew(msg2)), msgMul},
+		} {
+			noise, numParties := tc.ct.Noise()
+			assert.Equal(t, testctx.numParties, numParties, tc.name)
+
+			std, _, max := Noise(params, tc.ct, tc.msg, testctx.jdec)
+			assert.GreaterOrEqual(t, params.NoiseBound(noise, statSec), max, tc.name)
+			assert.InDelta(t, std+2, noise, 6, "%s: estimated %.1f bits, measured %.1f bits", tc.name, noise, std)
+		}
+	})
+
+	t.Run(testString("Noise/MulDepth", params), func(t *testing.T) {
+		testNoiseMulDepth(t, params, testctx.jdec, func(ct0, ct1 *Ciphertext) *Ciphertext {
+			return testctx.meval.MulAndRelinNew(ct0, ct1, testctx.jrlk)
+		}, ct1, msg1, ct2, msg2)
+	})
+
+	t.Run(testString("Noise/PartialDecryptWithSecurity", params), func(t *testing.T) {
+		shares := make([]*DistDecShare, testctx.numParties)
+		for i := range shares {
+			var err error
+			shares[i], err = testctx.ddecs[i].PartialDecryptWithSecurity(ct1, statSec)
+			assert.NoError(t, err)
+		}
+
+		msgOut := testctx.ddecs[0].JointDecryptToMsgNew(ct1, shares)
+		for i := 0; i < params.Slots(); i++ {
+			if !params.Field().Equal(msgOut.Value[i], msg1.Value[i]) {
+				t.Fatalf("PartialDecryptWithSecurity test failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg1.Value[i]))
+			}
+		}
+	})
+
+	t.Run(testString("Noise/ProofBound", params), func(t *testing.T) {
+		// every party adds the largest noise that its proof of decryption can cover, for the largest
+		// flooding that the decryption margin accepts
+		noise, numParties := ct1.Noise()
+		noiseBits, err := params.FloodingNoiseBits(noise, numParties, statSec)
+		assert.NoError(t, err)
+		for {
+			bits, err := params.FloodingNoiseBits(noise+1, numParties, statSec)
+			if err != nil {
+				break
+			}
+			noise, noiseBits = noise+1, bits
+		}
+		worst := new(big.Int).Lsh(big.NewInt(1), uint(noiseBits+PlaintextProofSlack+1))
+		worst.Sub(worst, big.NewInt(1))
+
+		shares := make([]*DistDecShare, testctx.numParties)
+		for i := range shares {
+			shares[i] = testctx.ddecs[i].PartialDecrypt(ct1, 0)
+			testctx.ringQ.AddScalarBigintLvl(ct1.Level(), shares[i].Poly, worst, shares[i].Poly)
+		}
+
+		msgOut := testctx.ddecs[0].JointDecryptToMsgNew(ct1, shares)
+		for i := 0; i < params.Slots(); i++ {
+			if !params.Field().Equal(msgOut.Value[i], msg1.Value[i]) {
+				t.Fatalf("ProofBound test failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg1.Value[i]))
+			}
+		}
+	})
+
+	t.Run(testString("Noise/Centered", params), func(t *testing.T) {
+		// the flooding noise is in (-2^(noiseBits-1), 2^(noiseBits-1)] and its mean is close to zero
+		noiseBits := 20
+		pol := testctx.ringQ.NewPoly()
+		addNoise(testctx.ringQ, pol, noiseBits, testctx.prng)
+		coeffs := make([]*big.Int, params.N())
+		for i := range coeffs {
+			coeffs[i] = new(big.Int)
+		}
+		testctx.ringQ.PolyToBigintCenteredLvl(params.MaxLevel(), pol, 1, coeffs)
+		half := new(big.Int).Lsh(big.NewInt(1), uint(noiseBits-1))
+		sum := new(big.Int)
+		for _, c := range coeffs {
+			assert.True(t, c.CmpAbs(half) <= 0 && c.Cmp(new(big.Int).Neg(half)) != 0, "coefficient %s out of bounds", c)
+			sum.Add(sum, c)
+		}
+		mean, _ := new(big.Float).Quo(new(big.Float).SetInt(sum), big.NewFloat(float64(params.N()))).Float64()
+		assert.Less(t, math.Abs(mean), math.Exp2(float64(noiseBits-4)))
+	})
+
+	t.Run(testString("Noise/DecryptionFailure", params), func(t *testing.T) {
+		_, err := testctx.ddecs[0].PartialDecryptWithSecurity(ct1, int(params.DecryptionMargin()))
+		assert.ErrorIs(t, err, ErrDecryptionFailure)
+
+		ct := ct1.CopyNew()
+		ct.SetNoise(params.DecryptionMargin(), testctx.numParties)
+		_, err = testctx.ddecs[0].PartialDecryptWithSecurity(ct, 0)
+		assert.ErrorIs(t, err, ErrDecryptionFailure)
+
+		w := NewWireWriter(params)
+		w.WriteCiphertext(ct1)
+		data, err := w.Bytes()
+		assert.NoError(t, err)
+		r := NewWireReader(params, data)
+		ct = r.ReadCiphertext()
+		assert.NoError(t, r.Close())
+		_, err = testctx.ddecs[0].PartialDecryptWithSecurity(ct, statSec)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrDecryptionFailure)
+	})
+}
+
+func testPlaintextProof(testctx *mpTestContext, t *testing.T) {
+	params := testctx.params
+	msgs := []*Message{genMPTestVectors(testctx), genMPTestVectors(testctx), genMPTestVectors(testctx)}
+	context := []byte("test")
+
+	prover := NewPlaintextProver(params, testctx.jpk, testctx.numParties)
+	verifier := NewPlaintextVerifier(params, testctx.jpk)
+	cts, proof := prover.EncryptAndProve(msgs, context)
+
+	t.Run(testString("PlaintextProof/Honest", params), func(t *testing.T) {
+		assert.NoError(t, verifier.Verify(cts, proof, context))
+		for k, ct := range cts {
+			msgOut := testctx.jdec.DecryptToMsgNew(ct)
+			for i := 0; i < params.Slots(); i++ {
+				if !params.Field().Equal(msgOut.Value[i], msgs[k].Value[i]) {
+					t.Fatalf("ciphertext %d decrypts to %s at index %d, want %s", k, params.Field().Big(msgOut.Value[i]), i, params.Field().Big(msgs[k].Value[i]))
+				}
+			}
+		}
+	})
+
+	t.Run(testString("PlaintextProof/Context", params), func(t *testing.T) {
+		assert.ErrorIs(t, verifier.Verify(cts, proof, []byte("other")), ErrInvalidProof)
+		assert.ErrorIs(t, verifier.Verify(cts[:2], proof, context), ErrInvalidProof)
+	})
+
+	t.Run(testString("PlaintextProof/LargeNoise", params), func(t *testing.T) {
+		// a well-formed proof for a ciphertext with too much noise
+		bad := []*Ciphertext{cts[0].CopyNew(), cts[1], cts[2]}
+		e := testctx.ringQ.NewPoly()
+		setSmallCoeff(testctx.ringQ, e, 0, 1<<60)
+		testctx.ringQ.Add(bad[0].Value[0], e, bad[0].Value[0])
+		assert.ErrorIs(t, verifier.Verify(bad, proof, context), ErrInvalidProof)
+
+		cts, proof := prover.EncryptAndProve(msgs[:1], context)
+		testctx.ringQ.Add(cts[0].Value[0], e, cts[0].Value[0])
+		assert.ErrorIs(t, verifier.Verify(cts, proof, context), ErrInvalidProof)
+	})
+
+	t.Run(testString("PlaintextProof/ProvenNoise", params), func(t *testing.T) {
+		// a dishonest prover encrypts with errors far larger than fresh ones, which the proof still accepts
+		_, maskE, _ := params.plaintextProofMaskBounds(len(msgs))
+		cheater := NewPlaintextProver(params, testctx.jpk, testctx.numParties)
+		bound := maskE / uint64(2*len(msgs))
+		cheater.gaussianSampler = ring.NewGaussianSampler(cheater.prng, testctx.ringQ, float64(bound/2), int(bound))
+		cts, proof := cheater.EncryptAndProve(msgs, context)
+		assert.NoError(t, verifier.Verify(cts, proof, context))
+
+		proven := params.ProvenNoise(len(msgs), testctx.numParties)
+		for k, ct := range cts {
+			std, _, _ := Noise(params, ct, msgs[k], testctx.jdec)
+			assert.Greater(t, std, params.FreshNoise(testctx.numParties)+30)
+			assert.Less(t, std, proven)
+			ct.SetNoise(proven, testctx.numParties)
+		}
+
+		// their product can still be flooded by all parties and decrypted
+		ct := testctx.meval.MulAndRelinNew(cts[0], cts[1], testctx.jrlk)
+		noise, _ := ct.Noise()
+		noiseBits, err := params.FloodingNoiseBits(noise, testctx.numParties, 40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares := make([]*DistDecShare, testctx.numParties)
+		for i := range shares {
+			shares[i] = testctx.ddecs[i].PartialDecrypt(ct, noiseBits)
+		}
+		msgOut := testctx.ddecs[0].JointDecryptToMsgNew(ct, shares)
+		want := params.Field().NewElement()
+		for i := 0; i < params.Slots(); i++ {
+			params.Field().Mul(want, msgs[0].Value[i], msgs[1].Value[i])
+			if !params.Field().Equal(msgOut.Value[i], want) {
+				t.Fatalf("flooded product of proven ciphertexts failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(want))
+			}
+		}
+	})
+
+	t.Run(testString("PlaintextProof/Wire", params), func(t *testing.T) {
+		w := NewWireWriter(params)
+		w.WritePlaintextProof(proof)
+		data, err := w.Bytes()
+		assert.NoError(t, err)
+		assert.Equal(t, params.WireSizes().Header+params.WireSizes().PlaintextProof, len(data))
+
+		r := NewWireReader(params, data)
+		proofOut := r.ReadPlaintextProof()
+		assert.NoError(t, r.Close())
+		assert.NoError(t, verifier.Verify(cts, proofOut, context))
+
+		params.Field().Add(proofOut.Z[0][0], proofOut.Z[0][0], proofOut.Z[0][1])
+		assert.ErrorIs(t, verifier.Verify(cts, proofOut, context), ErrInvalidProof)
+	})
+}
+
+func testKeyProof(testctx *mpTestContext, t *testing.T) {
+	params := testctx.params
+	ringQP := params.RingQP()
+	levelQ, levelP := params.QCount()-1, params.PCount()-1
+	numParties := 3
+
+	pks := make([]*rlwe.PublicKey, numParties)
+	rlks := make([]*RelinearizationKey, numParties)
+	proofs := make([]*KeyProof, numParties)
+	for i := range pks {
+		pks[i], rlks[i], proofs[i] = testctx.kgens[i].GenPartialKeysWithProof(testctx.psks[i], i)
+	}
+	keygen := testctx.kgens[0]
+
+	t.Run(testString("KeyProof/Honest", params), func(t *testing.T) {
+		jpk, jrlk, err := keygen.AggregateKeysVerified(pks, rlks, proofs)
+		assert.NoError(t, err)
+		jpkRef, jrlkRef, err := keygen.AggregateKeys(pks, rlks)
+		assert.NoError(t, err)
+		assert.True(t, jpk.Equals(jpkRef))
+		assert.True(t, jrlk.BD.Equals(&jrlkRef.BD))
+		assert.True(t, jrlk.V.Equals(&jrlkRef.V))
+	})
+
+	t.Run(testString("KeyProof/Replayed", params), func(t *testing.T) {
+		_, _, err := keygen.AggregateKeysVerified(pks, rlks, []*KeyProof{proofs[0], proofs[2], proofs[1]})
+		assert.ErrorIs(t, err, ErrInvalidKeyProof)
+		assert.ErrorContains(t, err, "party 1")
+	})
+
+	t.Run(testString("KeyProof/RogueKey", params), func(t *testing.T) {
+		// party 2 cancels the public key of party 0 out of the joint public key
+		rogue := rlwe.NewPublicKey(params.Parameters)
+		ringQP.SubLvl(levelQ, levelP, pks[2].Value[0], pks[0].Value[0], rogue.Value[0])
+		ringQP.CopyLvl(levelQ, levelP, pks[2].Value[1], rogue.Value[1])
+		rogueRlk := NewRelinearizationKey(params.Parameters, levelQ, levelP)
+		for i := range rogueRlk.BD.Value {
+			for j := range rogueRlk.BD.Value[i] {
+				for k := 0; k < 2; k++ {
+					ringQP.CopyLvl(levelQ, levelP, rlks[2].BD.Value[i][j].Value[k], rogueRlk.BD.Value[i][j].Value[k])
+					ringQP.CopyLvl(levelQ, levelP, rlks[2].V.Value[i][j].Value[k], rogueRlk.V.Value[i][j].Value[k])
+				}
+			}
+		}
+		ringQP.CopyLvl(levelQ, levelP, rogue.Value[0], rogueRlk.BD.Value[0][0].Value[0])
+
+		_, _, err := keygen.AggregateKeysVerified([]*rlwe.PublicKey{pks[0], pks[1], rogue}, []*RelinearizationKey{rlks[0], rlks[1], rogueRlk}, proofs)
+		assert.ErrorIs(t, err, ErrInvalidKeyProof)
+		assert.ErrorContains(t, err, "party 2")
+
+		// a public key that does not match the relinearization key
+		_, _, err = keygen.AggregateKeysVerified([]*rlwe.PublicKey{pks[0], pks[1], rogue}, rlks, proofs)
+		assert.ErrorIs(t, err, ErrInvalidKeyProof)
+		assert.ErrorContains(t, err, "party 2")
+	})
+
+	t.Run(testString("KeyProof/Bounds", params), func(t *testing.T) {
+		boundS, boundE := params.keyProofBounds()
+		maskS, maskE, _ := params.keyProofMasks()
+		assert.Equal(t, maskS-1, boundS)
+		assert.Less(t, boundE, maskE)
+		for _, proof := range proofs {
+			for _, z := range proof.Z {
+				for l := range z {
+					bound := boundE
+					if l < 2 {
+						bound = boundS
+					}
+					assert.True(t, isSmallQP(ringQP, z[l], bound))
+				}
+			}
+		}
+
+		// a masked secret out of the rejection bound, which an honest prover never publishes
+		bad := &KeyProof{Digest: proofs[0].Digest, Z: make([][]ringqp.Poly, len(proofs[0].Z))}
+		for k, z := range proofs[0].Z {
+			bad.Z[k] = append([]ringqp.Poly{z[0].CopyNew()}, z[1:]...)
+		}
+		setSmallCoeff(ringQP.RingQ, bad.Z[0][0].Q, 0, int64(boundS)+1)
+		if bad.Z[0][0].P != nil {
+			setSmallCoeff(ringQP.RingP, bad.Z[0][0].P, 0, int64(boundS)+1)
+		}
+		err := keygen.VerifyPartialKeys(pks[0], rlks[0], bad, 0)
+		assert.ErrorIs(t, err, ErrInvalidKeyProof)
+		assert.ErrorContains(t, err, "repetition 0 out of bounds")
+	})
+
+	t.Run(testString("KeyProof/Wire", params), func(t *testing.T) {
+		w := NewWireWriter(params)
+		w.WriteKeyProof(proofs[1])
+		data, err := w.Bytes()
+		assert.NoError(t, err)
+		assert.Equal(t, params.WireSizes().Header+params.WireSizes().KeyProof, len(data))
+
+		r := NewWireReader(params, data)
+		proof := r.ReadKeyProof()
+		assert.NoError(t, r.Close())
+		assert.NoError(t, keygen.VerifyPartialKeys(pks[1], rlks[1], proof, 1))
+
+		ringQP.AddLvl(levelQ, levelP, proof.Z[0][0], proof.Z[0][1], proof.Z[0][0])
+		assert.ErrorIs(t, keygen.VerifyPartialKeys(pks[1], rlks[1], proof, 1), ErrInvalidKeyProof)
+	})
+}
+
+func testDecryptionProof(testctx *mpTestContext, t *testing.T) {
+	params := testctx.params
+	numParties, noiseBits := 3, 60
+
+	// a joint key of the first parties only, so that their shares decrypt
+	pks := testctx.ppks[:numParties]
+	jpk, _, err := testctx.kgens[0].AggregateKeys(pks, testctx.prlks[:numParties])
+	assert.NoError(t, err)
+	msg := genMPTestVectors(testctx)
+	ct := NewJointEncryptor(params, jpk, numParties).EncryptMsgNew(msg)
+
+	shares := make([]*DistDecShare, numParties)
+	proofs := make([]*DecryptionProof, numParties)
+	for i := range shares {
+		var err error
+		shares[i], proofs[i], err = testctx.ddecs[i].PartialDecryptWithProof(ct, pks[i], noiseBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec := testctx.ddecs[0]
+
+	t.Run(testString("DecryptionProof/Honest", params), func(t *testing.T) {
+		msgOut := NewMessage(params)
+		assert.NoError(t, dec.JointDecryptToMsgVerified(ct, shares, proofs, pks, noiseBits, msgOut))
+		for i := 0; i < params.Slots(); i++ {
+			if !params.Field().Equal(msgOut.Value[i], msg.Value[i]) {
+				t.Fatalf("JointDecryptToMsgVerified failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg.Value[i]))
+			}
+		}
+	})
+
+	t.Run(testString("DecryptionProof/Cheater", params), func(t *testing.T) {
+		// party 1 shifts the joint decryption
+		level := shares[1].Level()
+		e := testctx.ringQ.NewPolyLvl(level)
+		setSmallCoeff(testctx.ringQ, e, 0, 1)
+		bad := &DistDecShare{testctx.ringQ.NewPolyLvl(level)}
+		testctx.ringQ.AddLvl(level, shares[1].Poly, e, bad.Poly)
+		err := dec.JointDecryptVerified(ct, []*DistDecShare{shares[0], bad, shares[2]}, proofs, pks, noiseBits, NewPlaintext(params))
+		assert.ErrorIs(t, err, ErrInvalidDecryptionShare)
+		assert.ErrorContains(t, err, "party 1")
+
+		// a valid share presented under the public key of another party
+		err = dec.JointDecryptVerified(ct, shares, proofs, []*rlwe.PublicKey{pks[0], pks[2], pks[1]}, noiseBits, NewPlaintext(params))
+		assert.ErrorIs(t, err, ErrInvalidDecryptionShare)
+		assert.ErrorContains(t, err, "party 1")
+
+		// a valid share of another ciphertext
+		other := NewJointEncryptor(params, jpk, numParties).EncryptMsgNew(msg)
+		assert.ErrorIs(t, dec.VerifyShare(other, shares[0], proofs[0], pks[0], noiseBits), ErrInvalidDecryptionShare)
+	})
+
+	t.Run(testString("DecryptionProof/NoiseBits", params), func(t *testing.T) {
+		assert.ErrorIs(t, dec.VerifyShare(ct, shares[0], proofs[0], pks[0], noiseBits-1), ErrInvalidDecryptionShare)
+		_, _, err := dec.PartialDecryptWithProof(ct, pks[0], params.LogQ())
+		assert.Error(t, err)
+
+		maxBits := params.MaxDecryptionProofNoiseBits(ct.Level())
+		assert.GreaterOrEqual(t, maxBits, noiseBits)
+		_, _, err = dec.PartialDecryptWithProof(ct, pks[0], maxBits)
+		assert.NoError(t, err)
+		_, _, err = dec.PartialDecryptWithProof(ct, pks[0], maxBits+1)
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("DecryptionProof/Bounds", params), func(t *testing.T) {
+		boundS, boundE, boundNoise := params.decryptionProofBounds(ct.Level(), noiseBits)
+		maskS, maskE, _, _ := params.decryptionProofMasks(ct.Level(), noiseBits)
+		assert.Equal(t, maskS-1, boundS)
+		assert.Less(t, boundE, maskE)
+		for _, proof := range proofs {
+			for _, z := range proof.Z {
+				assert.True(t, isSmall(testctx.ringQ, z[0], boundS))
+				assert.True(t, isSmall(testctx.ringQ, z[1], boundE))
+				assert.True(t, isBoundedBig(testctx.ringQ, z[2], boundNoise))
+			}
+		}
+
+		// a masked witness out of the rejection bound, which an honest prover never publishes
+		bad := &DecryptionProof{Digest: proofs[0].Digest, Z: make([][]*ring.Poly, len(proofs[0].Z))}
+		for k, z := range proofs[0].Z {
+			bad.Z[k] = []*ring.Poly{z[0].CopyNew(), z[1], z[2]}
+		}
+		setSmallCoeff(testctx.ringQ, bad.Z[0][0], 0, int64(boundS)+1)
+		err := dec.VerifyShare(ct, shares[0], bad, pks[0], noiseBits)
+		assert.ErrorIs(t, err, ErrInvalidDecryptionShare)
+		assert.ErrorContains(t, err, "repetition 0 out of bounds")
+	})
+
+	t.Run(testString("DecryptionProof/Wire", params), func(t *testing.T) {
+		w := NewWireWriter(params)
+		w.WriteDistDecShare(shares[2])
+		w.WriteDecryptionProof(proofs[2])
+		data, err := w.Bytes()
+		assert.NoError(t, err)
+		sizes := params.WireSizes()
+		assert.Equal(t, sizes.Header+sizes.DistDecShare+sizes.DecryptionProof, len(data))
+
+		r := NewWireReader(params, data)
+		share := r.ReadDistDecShare()
+		proof := r.ReadDecryptionProof()
+		assert.NoError(t, r.Close())
+		assert.NoError(t, dec.VerifyShare(ct, share, proof, pks[2], noiseBits))
+
+		testctx.ringQ.Add(proof.Z[0][2], proof.Z[1][2], proof.Z[0][2])
+		assert.ErrorIs(t, dec.VerifyShare(ct, share, proof, pks[2], noiseBits), ErrInvalidDecryptionShare)
+	})
+}
+
+func testThreshold(testctx *mpTestContext, t *testing.T) {
+	params := testctx.params
+	numParties, threshold, noiseBits := 5, 2, 80
+
+	// a joint key of the first parties only, with their secrets Shamir-shared among them
+	pks := testctx.ppks[:numParties]
+	jpk, _, err := testctx.kgens[0].AggregateKeys(pks, testctx.prlks[:numParties])
+	assert.NoError(t, err)
+	thr, err := NewThresholdizer(params, numParties, threshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coms := make([]*ShamirCommitment, numParties)
+	received := make([][]*ShamirShare, numParties)
+	for i := 0; i < numParties; i++ {
+		var shares []*ShamirShare
+		coms[i], shares = thr.GenShares(testctx.psks[i], pks[i])
+		for j, share := range shares {
+			received[j] = append(received[j], share)
+		}
+	}
+	key, err := thr.AggregateCommitments(pks, coms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdecs := make([]*ThresholdDecryptor, numParties)
+	for j := range tdecs {
+		for i, share := range received[j] {
+			if err := thr.VerifyShare(j, share, coms[i], pks[i]); err != nil {
+				t.Fatalf("share of party %d from party %d: %v", j, i, err)
+			}
+		}
+		share, err := thr.AggregateShares(received[j])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tdecs[j], err = NewThresholdDecryptor(params, key, share, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := genMPTestVectors(testctx)
+	ct := NewJointEncryptor(params, jpk, numParties).EncryptMsgNew(msg)
+
+	decrypt := func(t *testing.T, parties []int) *Message {
+		shares := make([]*DistDecShare, len(parties))
+		for i, j := range parties {
+			var err error
+			if shares[i], err = tdecs[j].PartialDecrypt(ct, parties, noiseBits); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tdecs[parties[0]].JointDecryptToMsgNew(ct, shares)
+	}
+
+	for _, parties := range [][]int{{0, 1, 3}, {2, 3, 4}, {0, 1, 2, 3, 4}} {
+		t.Run(testString(fmt.Sprintf("Threshold/Parties=%v", parties), params), func(t *testing.T) {
+			msgOut := decrypt(t, parties)
+			for i := 0; i < params.Slots(); i++ {
+				if !params.Field().Equal(msgOut.Value[i], msg.Value[i]) {
+					t.Fatalf("Threshold decryption failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg.Value[i]))
+				}
+			}
+		})
+	}
+
+	t.Run(testString("Threshold/Concurrent", params), func(t *testing.T) {
+		// party 1 decrypts for two sets of parties at once, without changing its share
+		share := tdecs[1].share.CopyNew()
+		sets := [][]int{{0, 1, 3}, {1, 2, 4}}
+		own := make([]*DistDecShare, len(sets))
+		errs := make([]error, len(sets))
+		var wg sync.WaitGroup
+		for k := range sets {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				own[k], errs[k] = tdecs[1].PartialDecrypt(ct, sets[k], noiseBits)
+			}(k)
+		}
+		wg.Wait()
+		assert.True(t, share.Equals(tdecs[1].share), "the share of party 1 changed")
+
+		for k, parties := range sets {
+			assert.NoError(t, errs[k])
+			shares := make([]*DistDecShare, len(parties))
+			for i, j := range parties {
+				if j == 1 {
+					shares[i] = own[k]
+					continue
+				}
+				var err error
+				if shares[i], err = tdecs[j].PartialDecrypt(ct, parties, noiseBits); err != nil {
+					t.Fatal(err)
+				}
+			}
+			msgOut := tdecs[parties[0]].JointDecryptToMsgNew(ct, shares)
+			for i := 0; i < params.Slots(); i++ {
+				if !params.Field().Equal(msgOut.Value[i], msg.Value[i]) {
+					t.Fatalf("Threshold decryption for %v failed at index %d: got %s, want %s", parties, i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg.Value[i]))
+				}
+			}
+		}
+	})
+
+	t.Run(testString("Threshold/TooFewParties", params), func(t *testing.T) {
+		_, err := tdecs[0].PartialDecrypt(ct, []int{0, 1}, noiseBits)
+		assert.ErrorIs(t, err, ErrTooFewParties)
+		_, err = tdecs[0].PartialDecrypt(ct, []int{1, 2, 3}, noiseBits)
+		assert.Error(t, err)
+		_, err = tdecs[0].PartialDecrypt(ct, []int{0, 1, 1}, noiseBits)
+		assert.Error(t, err)
+		_, err = tdecs[0].PartialDecrypt(ct, []int{0, 1, numParties}, noiseBits)
+		assert.Error(t, err)
+		_, err = NewThresholdizer(params, numParties, numParties)
+		assert.Error(t, err)
+
+		// the shares of parties 0 and 1 do not decrypt without the share of party 3
+		shares := make([]*DistDecShare, threshold)
+		for j := range shares {
+			var err error
+			if shares[j], err = tdecs[j].PartialDecrypt(ct, []int{0, 1, 3}, noiseBits); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msgOut := tdecs[0].JointDecryptToMsgNew(ct, shares)
+		equal := true
+		for i := 0; i < params.Slots(); i++ {
+			equal = equal && params.Field().Equal(msgOut.Value[i], msg.Value[i])
+		}
+		assert.False(t, equal, "the shares of %d parties decrypt", threshold)
+	})
+
+	t.Run(testString("Threshold/Parameters", params), func(t *testing.T) {
+		// the proofs of threshold decryption of 100 parties do not fit in Q
+		_, err := NewThresholdizer(params, testctx.numParties, 1)
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("Threshold/InvalidShare", params), func(t *testing.T) {
+		// party 0 deals a share to party 2 that is not the evaluation of its committed polynomial
+		com, shares := thr.GenShares(testctx.psks[0], pks[0])
+		assert.NoError(t, thr.VerifyShare(2, shares[2], com, pks[0]))
+		assert.ErrorIs(t, thr.VerifyShare(3, shares[2], com, pks[0]), ErrInvalidShamirShare)
+		assert.ErrorIs(t, thr.VerifyShare(2, shares[2], coms[0], pks[0]), ErrInvalidShamirShare)
+		assert.ErrorIs(t, thr.VerifyShare(2, shares[2], com, pks[1]), ErrInvalidShamirShare)
+		assert.ErrorIs(t, thr.VerifyShare(2, shares[2], &ShamirCommitment{Value: com.Value[:1]}, pks[0]), ErrInvalidShamirShare)
+
+		shifted := &ShamirShare{Value: shares[2].Value.CopyNew()}
+		one := testctx.ringQ.NewPoly()
+		setSmallCoeff(testctx.ringQ, one, 0, 1)
+		testctx.ringQ.Add(shifted.Value, one, shifted.Value)
+		assert.ErrorIs(t, thr.VerifyShare(2, shifted, com, pks[0]), ErrInvalidShamirShare)
+
+		// a share that does not match the threshold key is refused
+		agg, err := thr.AggregateShares(append([]*ShamirShare{shares[2]}, received[2][1:]...))
+		assert.NoError(t, err)
+		_, err = NewThresholdDecryptor(params, key, agg, 2)
+		assert.Error(t, err)
+		_, err = NewThresholdDecryptor(params, key, agg, 3)
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("Threshold/Proof", params), func(t *testing.T) {
+		parties := []int{0, 2, 4}
+		shares := make([]*DistDecShare, len(parties))
+		proofs := make([]*ThresholdDecryptionProof, len(parties))
+		for i, j := range parties {
+			var err error
+			if shares[i], proofs[i], err = tdecs[j].PartialDecryptWithProof(ct, parties, noiseBits); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, j := range parties {
+			assert.NoError(t, tdecs[1].VerifyShare(ct, j, parties, shares[i], proofs[i], noiseBits))
+		}
+		msgOut := tdecs[1].JointDecryptToMsgNew(ct, shares)
+		for i := 0; i < params.Slots(); i++ {
+			if !params.Field().Equal(msgOut.Value[i], msg.Value[i]) {
+				t.Fatalf("Threshold decryption failed at index %d: got %s, want %s", i, params.Field().Big(msgOut.Value[i]), params.Field().Big(msg.Value[i]))
+			}
+		}
+
+		// the proof is bound to the party, the set of parties, the flooding noise and the share
+		assert.ErrorIs(t, tdecs[1].VerifyShare(ct, 2, parties, shares[0], proofs[0], noiseBits), ErrInvalidDecryptionShare)
+		assert.ErrorIs(t, tdecs[1].VerifyShare(ct, 0, []int{0, 2, 3}, shares[0], proofs[0], noiseBits), ErrInvalidDecryptionShare)
+		assert.ErrorIs(t, tdecs[1].VerifyShare(ct, 0, []int{0, 2}, shares[0], proofs[0], noiseBits), ErrInvalidDecryptionShare)
+		assert.ErrorIs(t, tdecs[1].VerifyShare(ct, 0, parties, shares[0], proofs[0], noiseBits+1), ErrInvalidDecryptionShare)
+		forged := &DistDecShare{shares[0].CopyNew()}
+		testctx.ringQ.Add(forged.Poly, shares[1].Poly, forged.Poly)
+		assert.ErrorIs(t, tdecs[1].VerifyShare(ct, 0, parties, forged, proofs[0], noiseBits), ErrInvalidDecryptionShare)
+
+		// a share computed with the Lagrange coefficient of another set does not verify
+		other, err := tdecs[0].PartialDecrypt(ct, []int{0, 1, 2}, noiseBits)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, tdecs[1].VerifyShare(ct, 0, parties, other, proofs[0], noiseBits), ErrInvalidDecryptionShare)
+
+		_, _, err = tdecs[0].PartialDecryptWithProof(ct, parties, params.RingQ().ModulusAtLevel[ct.Level()].BitLen())
+		assert.Error(t, err)
+	})
+
+	t.Run(testString("Threshold/Wire", params), func(t *testing.T) {
+		parties := []int{1, 2, 3}
+		share, proof, err := tdecs[2].PartialDecryptWithProof(ct, parties, noiseBits)
+		assert.NoError(t, err)
+
+		w := NewWireWriter(params)
+		w.WriteShamirShare(received[1][2])
+		w.WriteShamirCommitment(coms[3])
+		w.WriteThresholdDecryptionProof(proof)
+		data, err := w.Bytes()
+		assert.NoError(t, err)
+
+		r := NewWireReader(params, data)
+		shareOut := r.ReadShamirShare()
+		comOut := r.ReadShamirCommitment()
+		proofOut := r.ReadThresholdDecryptionProof()
+		assert.NoError(t, r.Close())
+		assert.True(t, shareOut.Value.Equals(received[1][2].Value), "ShamirShare does not round-trip")
+		assert.Len(t, comOut.Value, threshold)
+		for k := range comOut.Value {
+			assert.True(t, comOut.Value[k].Equals(coms[3].Value[k]), "ShamirCommitment does not round-trip")
+		}
+		assert.NoError(t, tdecs[0].VerifyShare(ct, 2, parties, share, proofOut, noiseBits))
+
+		r = NewWireReader(params, data[:len(data)-1])
+		r.ReadShamirShare()
+		r.ReadShamirCommitment()
+		r.ReadThresholdDecryptionProof()
+		assert.ErrorIs(t, r.Close(), ErrWireFormat)
+	})
+}
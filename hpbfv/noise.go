@@ -0,0 +1,255 @@
+package hpbfv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"spdz-go/utils"
+)
+
+// The noise of a ciphertext ct under the secret key s is the polynomial e such that
+// ct[0] + ct[1]*s = Delta*m + e mod Q, where Delta*m is the encoding of the message m.
+// It decrypts correctly as long as |e| < Q/(2(B+1)), see DecryptionMargin.
+//
+// The estimates below are heuristic: they model the coefficients of e as independent centered
+// random variables and are given as the log2 of their standard deviation. When the key is the joint
+// key of numParties parties, its secret is the sum of as many secrets, which the estimates account for.
+
+// ErrDecryptionFailure is returned when a ciphertext cannot be decrypted correctly.
+var ErrDecryptionFailure = errors.New("decryption failure")
+
+// FreshNoise returns the estimated noise of a fresh encryption under the public key of numParties parties.
+func (p Parameters) FreshNoise(numParties int) float64 {
+	// u*e_pk + e0 + e1*s, with u and every secret of Hamming weight H
+	return 0.5 * (2*math.Log2(p.Sigma()) + math.Log2(1+2*float64(numParties*p.HammingWeight())))
+}
+
+// AddNoise returns the estimated noise of the sum or difference of two ciphertexts of noise noise0 and noise1.
+func (p Parameters) AddNoise(noise0, noise1 float64) float64 {
+	return 0.5 * logSum(2*noise0, 2*noise1)
+}
+
+// MulNoise returns the estimated noise of MulAndRelin of two ciphertexts of noise noise0 and noise1
+// under the joint key of numParties parties.
+func (p Parameters) MulNoise(noise0, noise1 float64, numParties int) float64 {
+	// tensoring multiplies the noise of each operand by (X^D - B) * ct(s)/Q, where ct(s)/Q has
+	// coefficients of variance (1+N*Vs)/3 and a mean of 1/2, which correlates the N terms of each
+	// product, and rescaling adds the rounding of the encodings
+	logN, nvs, lb := float64(p.LogN()), float64(p.N())*p.secretVariance(numParties), p.logB2()
+	tensor := 2*logN + logSum(2*noise0, 2*noise1) + lb + math.Log2((1+nvs)/3)
+	return 0.5 * logSum(tensor, p.encodingLogVar(), p.keySwitchLogVar(numParties))
+}
+
+// PlaintextMulNoise returns the estimated noise of PlaintextMul of a ciphertext of noise noise with an
+// encoded plaintext, under the joint key of numParties parties.
+func (p Parameters) PlaintextMulNoise(noise float64, numParties int) float64 {
+	// the plaintext is a noiseless ciphertext of degree zero with coefficients in [0, Q)
+	logN, nvs, lb := float64(p.LogN()), float64(p.N())*p.secretVariance(numParties), p.logB2()
+	tensor := 2*logN + 2*noise + lb - 2
+	rounding := lb + math.Log2((1+nvs)/3)
+	return 0.5 * logSum(tensor, rounding, p.encodingLogVar())
+}
+
+// KeySwitchNoise returns the estimated noise added by a key switch, as done by RotateColumns, under the
+// joint key of numParties parties.
+func (p Parameters) KeySwitchNoise(numParties int) float64 {
+	return 0.5 * p.keySwitchLogVar(numParties)
+}
+
+// NoiseBound returns the log2 of a bound on the coefficients of a noise of estimated standard deviation
+// 2^noise, which holds except with probability 2^-statSec.
+func (p Parameters) NoiseBound(noise float64, statSec int) float64 {
+	// Gaussian tail bound with a union bound over the N coefficients
+	return noise + 0.5*math.Log2(2*math.Ln2*float64(statSec+p.LogN()+1))
+}
+
+// DecryptionMargin returns the log2 of the largest noise with which ciphertexts decrypt correctly,
+// that is, Q/(2(B+1)).
+func (p Parameters) DecryptionMargin() float64 {
+	return p.LogQFloat() - 1 - bigLog2(new(big.Int).Add(p.b, big.NewInt(1)))
+}
+
+// FloodingNoiseBits returns the bit-size of the noise that each of the numParties parties must add to
+// its decryption share of a ciphertext of noise noise, so that the joint decryption statistically hides
+// the noise of the ciphertext with security parameter statSec. It returns an error wrapping
+// ErrDecryptionFailure if the flooded ciphertext would not decrypt correctly when the share of every party
+// carries the largest noise that passes VerifyShare, of PlaintextProofSlack+1 more bits.
+func (p Parameters) FloodingNoiseBits(noise float64, numParties, statSec int) (int, error) {
+	if math.IsNaN(noise) {
+		return 0, errors.New("the noise of the ciphertext is unknown")
+	}
+	if numParties < 1 || statSec < 0 {
+		return 0, errors.New("invalid number of parties or statistical security")
+	}
+
+	bound := p.NoiseBound(noise, statSec)
+	noiseBits := floodingNoiseBits(bound, statSec)
+
+	// the flooding noise of each party is centered in (-2^(noiseBits-1), 2^(noiseBits-1)] and adds no
+	// bias, and the proofs of decryption bound the noise of a verified share by 2^(noiseBits+PlaintextProofSlack+1)
+	total := logSum(bound, math.Log2(float64(numParties))+float64(noiseBits+PlaintextProofSlack+1))
+	if margin := p.DecryptionMargin(); total >= margin {
+		return 0, fmt.Errorf("%w: %d parties flooding with %d bits exceed the decryption margin of %.1f bits", ErrDecryptionFailure, numParties, noiseBits, margin)
+	}
+	return noiseBits, nil
+}
+
+// CiphertextFloodingNoiseBits returns the bit-size of the noise that a party must add to a ciphertext of
+// noise noise, see FloodNoise, so that the flooded ciphertext statistically hides its noise with security
+// parameter statSec, as when it answers the ciphertext of another party with its product by a secret.
+// It returns an error wrapping ErrDecryptionFailure if the flooded ciphertext would not decrypt correctly.
+func (p Parameters) CiphertextFloodingNoiseBits(noise float64, statSec int) (int, error) {
+	if math.IsNaN(noise) {
+		return 0, errors.New("the noise of the ciphertext is unknown")
+	}
+	if statSec < 0 {
+		return 0, errors.New("invalid statistical security")
+	}
+
+	bound := p.NoiseBound(noise, statSec)
+	noiseBits := floodingNoiseBits(bound, statSec)
+
+	// the flooding noise is centered in (-2^(noiseBits-1), 2^(noiseBits-1)]
+	total := logSum(bound, float64(noiseBits-1))
+	if margin := p.DecryptionMargin(); total >= margin {
+		return 0, fmt.Errorf("%w: flooding with %d bits exceeds the decryption margin of %.1f bits", ErrDecryptionFailure, noiseBits, margin)
+	}
+	return noiseBits, nil
+}
+
+// floodingNoiseBits returns the bit-size of a flooding noise that hides a noise bounded by 2^bound with
+// statistical security statSec.
+func floodingNoiseBits(bound float64, statSec int) int {
+	noiseBits := statSec + 1
+	if !math.IsInf(bound, -1) {
+		noiseBits = utils.MaxInt(noiseBits, int(math.Ceil(bound))+statSec)
+	}
+	return noiseBits
+}
+
+// Noise decrypts ct, an encryption of msg, with dec and returns the log2 of the standard deviation,
+// minimum and maximum norm of its noise, which is the difference between the decryption and the encoding
+// of msg. The minimum is -Inf when some coefficient is noiseless.
+// This function is used for testing/profiling/evaluation purposes.
+func Noise(params Parameters, ct *Ciphertext, msg *Message, dec *Decryptor) (std, min, max float64) {
+	ringQ := params.RingQ()
+	level := ct.Level()
+
+	pt := dec.DecryptNew(ct)
+	ringQ.SubLvl(level, pt.Value, NewEncoder(params).EncodeNew(msg).Value, pt.Value)
+
+	coeffs := make([]*big.Int, params.N())
+	for i := range coeffs {
+		coeffs[i] = new(big.Int)
+	}
+	ringQ.PolyToBigintCenteredLvl(level, pt.Value, 1, coeffs)
+
+	// the coefficients can exceed the range of a float64, so the statistics are computed in the log domain
+	min, max = math.Inf(1), math.Inf(-1)
+	logSquares := make([]float64, len(coeffs))
+	for i, c := range coeffs {
+		logSquares[i] = math.Inf(-1)
+		if c.Sign() != 0 {
+			logSquares[i] = 2 * bigLog2(c.Abs(c))
+		}
+		min = math.Min(min, 0.5*logSquares[i])
+		max = math.Max(max, 0.5*logSquares[i])
+	}
+	std = 0.5 * (logSum(logSquares...) - float64(params.LogN()))
+	return
+}
+
+// NoiseBudget decrypts ct, an encryption of msg, with dec and returns the number of bits of noise that ct can still absorb
+// while decrypting correctly, that is, DecryptionMargin minus the log2 of the maximum norm of its noise.
+// The budget is negative if ct does not decrypt correctly.
+// This function is used for testing/profiling/evaluation purposes.
+func NoiseBudget(params Parameters, ct *Ciphertext, msg *Message, dec *Decryptor) float64 {
+	_, _, max := Noise(params, ct, msg, dec)
+	return params.DecryptionMargin() - max
+}
+
+// LogQFloat returns the size of the modulus Q in bits as a float.
+func (p Parameters) LogQFloat() (logQ float64) {
+	for _, qi := range p.Q() {
+		logQ += math.Log2(float64(qi))
+	}
+	return
+}
+
+// secretVariance returns the variance of the coefficients of the sum of the secrets of numParties parties.
+func (p Parameters) secretVariance(numParties int) float64 {
+	return float64(numParties*p.HammingWeight()) / float64(p.N())
+}
+
+// logB2 returns the log2 of B^2 + 1, the squared norm of X^D - B.
+func (p Parameters) logB2() float64 {
+	return logSum(2*bigLog2(p.b), 0)
+}
+
+// encodingLogVar returns the log2 of the variance of the noise of a product of two noiseless ciphertexts,
+// which comes from the rounding of their encodings, measured to be about (N*B)^2/48.
+func (p Parameters) encodingLogVar() float64 {
+	return 2*float64(p.LogN()) + p.logB2() - math.Log2(48)
+}
+
+// keySwitchLogVar returns the log2 of the variance of the noise of the relinearization with the joint key
+// of numParties parties, which applies two gadget products with keys of noise of variance numParties*sigma^2.
+// The noise of the first one is multiplied by the joint secret, and the second one by the sum of the
+// ephemeral secrets of the relinearization keys, hence the factor 1+2*N*Vs.
+func (p Parameters) keySwitchLogVar(numParties int) float64 {
+	levelQ, levelP := p.QCount()-1, p.PCount()-1
+	digits := p.DecompRNS(levelQ, levelP) * p.DecompPw2(levelQ, levelP)
+
+	// the digits are products of PCount moduli of Q, or a single one without P
+	logW := float64(p.MaxBit(levelQ, levelP) * utils.MaxInt(1, p.PCount()))
+	if p.Pow2Base() != 0 {
+		logW = math.Min(logW, float64(p.Pow2Base()))
+	}
+
+	nvs := float64(p.N()) * p.secretVariance(numParties)
+	// the digits are uniform in [0, W)
+	logVar := math.Log2(float64(digits*p.N()*numParties)/3) + 2*logW + 2*math.Log2(p.Sigma()) + math.Log2(1+2*nvs)
+	if levelP == -1 {
+		return logVar
+	}
+	// division by P, and rounding of (1, s)
+	return logSum(logVar-2*p.LogPFloat(), math.Log2(2*(1+nvs)/12))
+}
+
+// LogPFloat returns the size of the modulus P in bits as a float.
+func (p Parameters) LogPFloat() (logP float64) {
+	for _, pi := range p.P() {
+		logP += math.Log2(float64(pi))
+	}
+	return
+}
+
+// logSum returns log2(2^x0 + 2^x1 + ...).
+func logSum(xs ...float64) float64 {
+	max := math.Inf(-1)
+	for _, x := range xs {
+		if math.IsNaN(x) {
+			return x
+		}
+		max = math.Max(max, x)
+	}
+	if math.IsInf(max, 0) {
+		return max
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += math.Exp2(x - max)
+	}
+	return max + math.Log2(sum)
+}
+
+// bigLog2 returns the log2 of x > 0 as a float.
+func bigLog2(x *big.Int) float64 {
+	if l := x.BitLen(); l > 64 {
+		f, _ := new(big.Float).SetInt(new(big.Int).Rsh(x, uint(l-64))).Float64()
+		return math.Log2(f) + float64(l-64)
+	}
+	return math.Log2(float64(x.Uint64()))
+}
@@ -1,10 +1,19 @@
 package hpbfv
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"math/big"
 
+	"spdz-go/field"
 	"spdz-go/ring"
 	"spdz-go/rlwe"
+	"spdz-go/utils"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 type ParametersLiteral struct {
@@ -30,12 +39,19 @@ type Parameters struct {
 	d        uint64
 	g        *big.Int
 	t        *big.Int //plaint text modulus
+	field    *field.Field
 }
 
-func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
+// NewParametersFromLiteral instantiates a set of HPBFV parameters from a ParametersLiteral specification.
+// It returns an error if the literal does not describe a valid set of parameters.
+func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters, err error) {
+	if pl.LogN <= 0 || pl.LogN > 20 || pl.B == nil || pl.G == nil || pl.B.Sign() <= 0 || pl.D == 0 || (1<<pl.LogN)%pl.D != 0 {
+		return params, errors.New("invalid plaintext parameters")
+	}
+
 	rlweParams, err := rlwe.NewParametersFromLiteral(rlwe.ParametersLiteral{LogN: pl.LogN, Q: pl.Q, P: pl.P, LogQ: pl.LogQ, LogP: pl.LogP, H: pl.H, Sigma: pl.Sigma})
 	if err != nil {
-		panic("cannot NewParametersFromLiteral: rlweParams cannot be generated")
+		return params, errors.New("rlweParams cannot be generated")
 	}
 
 	N := (1 << pl.LogN)
@@ -43,7 +59,7 @@ func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
 
 	ringQMul, err := ring.NewRing(N, pl.QMul)
 	if err != nil {
-		panic("cannot NewParametersFromLiteral: ring QMul cannot be generated")
+		return params, errors.New("ring QMul cannot be generated")
 	}
 
 	params.Parameters = rlweParams
@@ -55,17 +71,226 @@ func NewParametersFromLiteral(pl ParametersLiteral) (params Parameters) {
 	params.t.Add(params.t, big.NewInt(1))
 
 	if !params.t.ProbablyPrime(0) {
-		panic("cannot NewParametersFromLiteral: T is not a prime")
+		return params, errors.New("T is not a prime")
+	}
+	if params.field, err = field.NewField(params.t); err != nil {
+		return params, err
 	}
 
 	BK := new(big.Int).Exp(pl.B, big.NewInt(int64(K)), nil)
 	if BK.Mod(BK, big.NewInt(int64(2*N))).Int64() != 0 {
-		panic("cannot NewParametersFromLiteral: 2N does not divide b^k")
+		return params, errors.New("2N does not divide b^k")
+	}
+
+	e := new(big.Int).Sub(params.t, big.NewInt(1))
+	e.Div(e, big.NewInt(int64(2*K)))
+	if e.Exp(pl.G, e, params.t).Cmp(pl.B) != 0 {
+		return params, errors.New("g^((t-1)/2k) is not b")
+	}
+
+	return params, nil
+}
+
+// MinSecurityLevel is the minimum estimated security, in bits, of the parameters accepted in strict mode.
+const MinSecurityLevel = 128
+
+// ErrInsecureParameters is returned in strict mode for parameters below MinSecurityLevel.
+var ErrInsecureParameters = errors.New("insecure parameters")
+
+// NewParametersFromLiteralStrict is the strict mode of NewParametersFromLiteral: it also refuses
+// the parameters whose estimated security is below MinSecurityLevel.
+func NewParametersFromLiteralStrict(pl ParametersLiteral) (params Parameters, err error) {
+	if params, err = NewParametersFromLiteral(pl); err != nil {
+		return Parameters{}, err
+	}
+	if err = params.CheckSecurity(MinSecurityLevel); err != nil {
+		return Parameters{}, err
+	}
+	return params, nil
+}
+
+// CheckSecurity returns an error wrapping ErrInsecureParameters if the estimated security of the
+// parameters, see rlwe.Parameters.SecurityLevel, is below minLevel bits.
+func (p Parameters) CheckSecurity(minLevel float64) error {
+	if level := p.SecurityLevel(); level < minLevel {
+		return fmt.Errorf("%w: estimated security of %.1f bits for N = 2^%d and log(QP) = %.1f is below %.0f bits", ErrInsecureParameters, level, p.LogN(), p.LogQPFloat(), minLevel)
 	}
+	return nil
+}
+
+// ParametersLiteral returns the ParametersLiteral of the target Parameters.
+func (p Parameters) ParametersLiteral() ParametersLiteral {
+	return ParametersLiteral{
+		LogN:  p.LogN(),
+		Q:     p.Q(),
+		QMul:  append([]uint64{}, p.ringQMul.Modulus...),
+		P:     p.P(),
+		H:     p.HammingWeight(),
+		Sigma: p.Sigma(),
+		B:     p.B(),
+		D:     p.D(),
+		G:     p.G(),
+	}
+}
+
+// Equals checks two Parameter structs for equality.
+func (p Parameters) Equals(other Parameters) bool {
+	if p.t == nil || other.t == nil {
+		return p.t == other.t && p.Parameters.Equals(other.Parameters)
+	}
+	res := p.Parameters.Equals(other.Parameters)
+	res = res && utils.EqualSliceUint64(p.ringQMul.Modulus, other.ringQMul.Modulus)
+	res = res && p.b.Cmp(other.b) == 0
+	res = res && p.d == other.d
+	res = res && p.g.Cmp(other.g) == 0
+	return res
+}
+
+// MarshalBinary returns a []byte representation of the parameter set.
+func (p Parameters) MarshalBinary() ([]byte, error) {
+	if p.LogN() == 0 { // if N is 0, then p is the zero value
+		return []byte{}, nil
+	}
+
+	// 1 byte : logN
+	// 8 byte : H
+	// 8 byte : sigma
+	// 1 + 8 * (#Q) : Q
+	// 1 + 8 * (#QMul) : QMul
+	// 1 + 8 * (#P) : P
+	// 8 byte : D
+	// 2 + len(B) : B
+	// 2 + len(G) : G
+	pl := p.ParametersLiteral()
+	b := utils.NewBuffer(make([]byte, 0, p.MarshalBinarySize()))
+	b.WriteUint8(uint8(pl.LogN))
+	b.WriteUint64(uint64(pl.H))
+	b.WriteUint64(math.Float64bits(pl.Sigma))
+	for _, moduli := range [][]uint64{pl.Q, pl.QMul, pl.P} {
+		b.WriteUint8(uint8(len(moduli)))
+		b.WriteUint64Slice(moduli)
+	}
+	b.WriteUint64(pl.D)
+	for _, x := range []*big.Int{pl.B, pl.G} {
+		xb := x.Bytes()
+		b.WriteUint8(uint8(len(xb) >> 8))
+		b.WriteUint8(uint8(len(xb)))
+		b.WriteUint8Slice(xb)
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalBinarySize returns the length of the []byte encoding of the receiver.
+func (p Parameters) MarshalBinarySize() int {
+	if p.LogN() == 0 {
+		return 0
+	}
+	return 17 + 3 + 8*(p.QCount()+len(p.ringQMul.Modulus)+p.PCount()) + 8 + 4 + (p.b.BitLen()+7)/8 + (p.g.BitLen()+7)/8
+}
 
+// UnmarshalBinary decodes a []byte into a parameter set struct.
+func (p *Parameters) UnmarshalBinary(data []byte) (err error) {
+	if len(data) == 0 {
+		*p = Parameters{}
+		return nil
+	}
+
+	next := func(n int) []byte {
+		if err != nil || len(data) < n {
+			err = errors.New("invalid hpbfv.Parameters serialization")
+			return nil
+		}
+		b := data[:n]
+		data = data[n:]
+		return b
+	}
+	readUint64 := func() uint64 {
+		if b := next(8); b != nil {
+			return binary.BigEndian.Uint64(b)
+		}
+		return 0
+	}
+	readModuli := func() (moduli []uint64) {
+		if b := next(1); b != nil && b[0] > 0 {
+			moduli = make([]uint64, b[0])
+			for i := range moduli {
+				moduli[i] = readUint64()
+			}
+		}
+		return
+	}
+	readBig := func() *big.Int {
+		if b := next(2); b != nil {
+			return new(big.Int).SetBytes(next(int(b[0])<<8 | int(b[1])))
+		}
+		return nil
+	}
+
+	var pl ParametersLiteral
+	if b := next(1); b != nil {
+		pl.LogN = int(b[0])
+	}
+	pl.H = int(readUint64())
+	pl.Sigma = math.Float64frombits(readUint64())
+	pl.Q, pl.QMul, pl.P = readModuli(), readModuli(), readModuli()
+	pl.D = readUint64()
+	pl.B, pl.G = readBig(), readBig()
+	if err != nil {
+		return err
+	}
+	if len(data) != 0 {
+		return errors.New("invalid hpbfv.Parameters serialization: trailing bytes")
+	}
+	if pl.Q == nil || pl.QMul == nil {
+		return errors.New("invalid hpbfv.Parameters serialization: missing moduli")
+	}
+
+	params, err := NewParametersFromLiteral(pl)
+	if err != nil {
+		return fmt.Errorf("invalid hpbfv.Parameters serialization: %w", err)
+	}
+	*p = params
+	return nil
+}
+
+// FingerprintSize is the size in bytes of a parameter fingerprint.
+const FingerprintSize = 32
+
+// Fingerprint returns a hash of the binary encoding of the parameters, which parties compare
+// to make sure they agree on the parameters.
+func (p Parameters) Fingerprint() (fp [FingerprintSize]byte) {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	h, err := blake2b.New256([]byte("spdz-go/hpbfv.Parameters"))
+	if err != nil {
+		panic(err)
+	}
+	h.Write(data)
+	copy(fp[:], h.Sum(nil))
 	return
 }
 
+// MarshalJSON returns a JSON representation of this parameter set. See `Marshal` from the `encoding/json` package.
+func (p Parameters) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.ParametersLiteral())
+}
+
+// UnmarshalJSON reads a JSON representation of a parameter set into the receiver Parameter. See `Unmarshal` from the `encoding/json` package.
+func (p *Parameters) UnmarshalJSON(data []byte) (err error) {
+	var pl ParametersLiteral
+	if err = json.Unmarshal(data, &pl); err != nil {
+		return
+	}
+	params, err := NewParametersFromLiteral(pl)
+	if err != nil {
+		return fmt.Errorf("invalid hpbfv.Parameters: %w", err)
+	}
+	*p = params
+	return nil
+}
+
 func (p Parameters) RingQMul() *ring.Ring {
 	return p.ringQMul
 }
@@ -102,3 +327,8 @@ func (p Parameters) G() *big.Int {
 func (p Parameters) T() *big.Int {
 	return new(big.Int).Set(p.t)
 }
+
+// Field returns the plaintext field Z_T of the slots of the messages.
+func (p Parameters) Field() *field.Field {
+	return p.field
+}
@@ -0,0 +1,118 @@
+package hpbfv
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"spdz-go/rlwe"
+)
+
+// GenerateParametersLiteral completes pl into a valid ParametersLiteral whose plaintext modulus
+// T = B^K + 1, with K = N/D, is the largest one of at most logT bits.
+//
+// pl must set LogN and D. B and G are found with FindPlaintextBasis and override the ones of pl.
+// If pl.Q is nil, Q, QMul and P are generated with ring.GenerateNTTPrimes from the bit-sizes
+// pl.LogQ, pl.LogQMul and pl.LogP, where LogQMul defaults to LogQ. All the primes are distinct.
+func GenerateParametersLiteral(pl ParametersLiteral, logT int) (ParametersLiteral, error) {
+	var err error
+	if pl.B, pl.G, err = FindPlaintextBasis(pl.LogN, pl.D, logT); err != nil {
+		return ParametersLiteral{}, err
+	}
+
+	if pl.Q == nil {
+		if len(pl.LogQ) == 0 {
+			return ParametersLiteral{}, errors.New("either Q or LogQ must be set")
+		}
+		logQMul := pl.LogQMul
+		if logQMul == nil {
+			logQMul = pl.LogQ
+		}
+		if len(logQMul) != len(pl.LogQ) {
+			return ParametersLiteral{}, errors.New("LogQMul and LogQ must have the same length")
+		}
+
+		var q, p []uint64
+		if q, p, err = rlwe.GenModuli(pl.LogN, pl.LogQ, append(append([]int{}, logQMul...), pl.LogP...)); err != nil {
+			return ParametersLiteral{}, err
+		}
+		pl.Q, pl.QMul = q, p[:len(logQMul)]
+		if len(pl.LogP) != 0 {
+			pl.P = p[len(logQMul):]
+		}
+	}
+	pl.LogQ, pl.LogQMul, pl.LogP = nil, nil, nil
+
+	if _, err = NewParametersFromLiteral(pl); err != nil {
+		return ParametersLiteral{}, err
+	}
+	return pl, nil
+}
+
+// FindPlaintextBasis returns the largest plaintext basis B such that T = B^K + 1 is a prime
+// of at most logT bits and 2N divides B^K, where N = 2^logN and K = N/d, together with a
+// generator G such that G^((T-1)/2K) = B.
+// The search stops with an error once B has halved, that is, once T has lost K bits.
+func FindPlaintextBasis(logN int, d uint64, logT int) (b, g *big.Int, err error) {
+	if logN <= 0 || logN > 20 || d == 0 || (uint64(1)<<logN)%d != 0 {
+		return nil, nil, errors.New("invalid LogN or D")
+	}
+	k := (1 << logN) / int(d)
+	if logT <= k {
+		return nil, nil, fmt.Errorf("LogT must be larger than K = %d", k)
+	}
+
+	// 2N | B^K iff the 2-adic valuation of B is at least ceil((logN+1)/K)
+	step := new(big.Int).Lsh(big.NewInt(1), uint((logN+k)/k))
+
+	// largest B with B^K < 2^logT, rounded down to a multiple of step
+	bound := new(big.Int).Lsh(big.NewInt(1), uint(logT))
+	bK := big.NewInt(int64(k))
+	lo, hi := big.NewInt(1), new(big.Int).Lsh(big.NewInt(1), uint((logT+k-1)/k)+1)
+	mid, pow := new(big.Int), new(big.Int)
+	for new(big.Int).Sub(hi, lo).Cmp(big.NewInt(1)) > 0 {
+		mid.Add(lo, hi).Rsh(mid, 1)
+		if pow.Exp(mid, bK, nil).Cmp(bound) < 0 {
+			lo.Set(mid)
+		} else {
+			hi.Set(mid)
+		}
+	}
+	b = new(big.Int).Sub(lo, new(big.Int).Mod(lo, step))
+	min := new(big.Int).Rsh(lo, 1)
+
+	t := new(big.Int)
+	for ; b.Cmp(min) > 0; b.Sub(b, step) {
+		t.Exp(b, bK, nil).Add(t, big.NewInt(1))
+		if t.ProbablyPrime(0) {
+			return b, findGenerator(b, t, k), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no plaintext basis found for LogN = %d, D = %d and LogT = %d", logN, d, logT)
+}
+
+// findGenerator returns the smallest G of the form a^j, for a small integer a and an odd j < 2K,
+// such that G^((T-1)/2K) = B. B must be a primitive 2K-th root of unity modulo T, which holds
+// when B^K = -1 and K is a power of two.
+func findGenerator(b, t *big.Int, k int) *big.Int {
+	tMinusOne := new(big.Int).Sub(t, big.NewInt(1))
+	e := new(big.Int).Div(tMinusOne, big.NewInt(int64(2*k)))
+
+	y, y2, yj := new(big.Int), new(big.Int), new(big.Int)
+	for a := int64(2); ; a++ {
+		// y = a^((T-1)/2K) is a primitive 2K-th root of unity iff y^K = -1
+		y.Exp(big.NewInt(a), e, t)
+		if new(big.Int).Exp(y, big.NewInt(int64(k)), t).Cmp(tMinusOne) != 0 {
+			continue
+		}
+		// B = y^j for an odd j, since both are primitive 2K-th roots of unity
+		y2.Mul(y, y).Mod(y2, t)
+		yj.Set(y)
+		for j := 1; j < 2*k; j += 2 {
+			if yj.Cmp(b) == 0 {
+				return new(big.Int).Exp(big.NewInt(a), big.NewInt(int64(j)), t)
+			}
+			yj.Mul(yj, y2).Mod(yj, t)
+		}
+	}
+}
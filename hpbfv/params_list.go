@@ -32,6 +32,29 @@ var (
 		G: MustBigFromDecimal("328256967394537077627"), // 3^43
 	}
 
+	// SOHO_V2 extends SOHO with a third and a fourth modulus: a product of ciphertexts verified by proofs of
+	// plaintext knowledge has about 100 bits of noise, see Parameters.ProvenNoise, which the flooding of its
+	// decryption shares and their proofs of decryption must hide with 40 bits of statistical security. The
+	// Soho preprocessing cannot flood its decryptions with SOHO.
+	SOHO_V2 = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001, 0x1fffffffffd08001,
+		}, // 61 * 4 = 244
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001, 0x1fffffffff978001,
+		},
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("10792"), // 10792 = 2^14 - 5592
+		D: 1 << 9,
+		G: MustBigFromDecimal("328256967394537077627"), // 3^43
+	}
+
 	HEMI = ParametersLiteral{
 		LogN: 14,
 		
@@ -49,6 +72,29 @@ var (
 		G: MustBigFromDecimal("328256967394537077627"), // 3^43
 	}
 
+	// HEMI_V2 replaces the moduli of HEMI by three 61-bit moduli, which the proofs of plaintext knowledge of
+	// the pairwise encryptions require: an OLE answer to a proven ciphertext has about 80 bits of noise, see
+	// Parameters.ProvenNoise and Parameters.PlaintextMulNoise, which its flooding must hide with 40 bits of
+	// statistical security. The Hemi preprocessing cannot prove nor flood its ciphertexts with HEMI.
+	HEMI_V2 = ParametersLiteral{
+		LogN: 14,
+
+		Q: []uint64{
+			0x1fffffffffe10001, 0x1fffffffffe00001,
+			0x1fffffffffdd0001,
+		}, // 61 * 3 = 183
+		QMul: []uint64{
+			0x1fffffffffab0001, 0x1fffffffffa10001,
+			0x1fffffffff998001,
+		},
+
+		Sigma: rlwe.DefaultSigma,
+
+		B: MustBigFromDecimal("10792"), // 10792 = 2^14 - 5592
+		D: 1 << 9,
+		G: MustBigFromDecimal("328256967394537077627"), // 3^43
+	}
+
 
 	HPN14D13T128 = ParametersLiteral{
 		LogN: 14,
@@ -496,3 +542,27 @@ var (
 		G: big.NewInt(3),
 	}
 )
+
+// Presets maps the names of the parameter sets of this file to their literals.
+var Presets = map[string]ParametersLiteral{
+	"SOHO":          SOHO,
+	"SOHO_V2":       SOHO_V2,
+	"HEMI":          HEMI,
+	"HEMI_V2":       HEMI_V2,
+	"HPN14D13T128":  HPN14D13T128,
+	"HPN14D12T256":  HPN14D12T256,
+	"HPN14D11T512":  HPN14D11T512,
+	"HPN14D10T1024": HPN14D10T1024,
+	"HPN14D9T2048":  HPN14D9T2048,
+	"HPN14D8T4096":  HPN14D8T4096,
+	"HPN13D10T128":  HPN13D10T128,
+	"HPN13D9T256":   HPN13D9T256,
+	"HPN13D8T512":   HPN13D8T512,
+	"HPN13D7T1024":  HPN13D7T1024,
+	"HPN13D6T2048":  HPN13D6T2048,
+	"HPN13D5T4096":  HPN13D5T4096,
+	"PN15T128":      PN15T128,
+	"PN16T256":      PN16T256,
+	"PN17T512":      PN17T512,
+	"PN18T1024":     PN18T1024,
+}
@@ -1,6 +1,8 @@
 package hpbfv
 
 import (
+	"fmt"
+
 	"spdz-go/rlwe"
 
 	"spdz-go/ring"
@@ -18,7 +20,8 @@ type PartialKeyGenerator struct {
 
 	sk *rlwe.SecretKey
 
-	buffQ [2]*ring.Poly
+	buffQ  [2]*ring.Poly
+	buffQP [2]ringqp.Poly
 
 	gaussianSampler *ring.GaussianSampler
 	ternarySampler  *ring.TernarySampler
@@ -81,6 +84,7 @@ func NewPartialKeyGenerator(params Parameters, crs []byte) *PartialKeyGenerator
 		params:          params,
 		sk:              sk,
 		buffQ:           [2]*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly()},
+		buffQP:          [2]ringqp.Poly{params.RingQP().NewPoly(), params.RingQP().NewPoly()},
 		prng:            prng,
 		gaussianSampler: gaussianSampler,
 		ternarySampler:  ternarySampler,
@@ -94,7 +98,7 @@ func (keygen *PartialKeyGenerator) GenSecretKey() (sk *rlwe.SecretKey) {
 	ringQP := keygen.params.RingQP()
 	sk.Value = ringQP.NewPoly()
 	levelQ, levelP := sk.LevelQ(), sk.LevelP()
-	keygen.ternarySampler.ReadLvl(levelQ, sk.Value.Q)
+	keygen.sampleTernary(sk.Value)
 
 	ringQP.NTTLvl(levelQ, levelP, sk.Value, sk.Value)
 	ringQP.MFormLvl(levelQ, levelP, sk.Value, sk.Value)
@@ -103,78 +107,152 @@ func (keygen *PartialKeyGenerator) GenSecretKey() (sk *rlwe.SecretKey) {
 }
 
 func (keygen *PartialKeyGenerator) GenPartialKeys(sk *rlwe.SecretKey) (pk *rlwe.PublicKey, rlk *RelinearizationKey) {
+	pk, rlk, _ = keygen.genPartialKeys(sk)
+	return
+}
+
+func (keygen *PartialKeyGenerator) GenKeys() (sk *rlwe.SecretKey, pk *rlwe.PublicKey, rlk *RelinearizationKey) {
+	sk = keygen.GenSecretKey()
+	pk, rlk = keygen.GenPartialKeys(sk)
+	return
+}
+
+// genPartialKeys samples the randomness of the partial keys of sk and returns the keys with their
+// witness, see keyRelation.
+func (keygen *PartialKeyGenerator) genPartialKeys(sk *rlwe.SecretKey) (pk *rlwe.PublicKey, rlk *RelinearizationKey, witness []ringqp.Poly) {
+	params := keygen.params
+	ringQP := params.RingQP()
+	levelQ := params.QCount() - 1
+	levelP := params.PCount() - 1
+
+	witness = make([]ringqp.Poly, params.keyWitnessSize())
+	for i := range witness {
+		witness[i] = ringQP.NewPoly()
+	}
+
+	// s in the coefficient domain, r ternary and the errors Gaussian
+	ringQP.InvMFormLvl(levelQ, levelP, sk.Value, witness[0])
+	ringQP.InvNTTLvl(levelQ, levelP, witness[0], witness[0])
+	if levelP != -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(witness[0].Q, levelP, nil, witness[0].P)
+	}
+	keygen.sampleTernary(witness[1])
+	for _, e := range witness[2:] {
+		keygen.gaussianSampler.ReadLvl(levelQ, e.Q)
+		if levelP != -1 {
+			ringQP.ExtendBasisSmallNormAndCenter(e.Q, levelP, nil, e.P)
+		}
+	}
+
+	rlk = NewRelinearizationKey(params.Parameters, levelQ, levelP)
+	keygen.keyRelation(witness, rlk)
+
+	// pk = rlk.B[0][0]
+	pk = rlwe.NewPublicKey(params.Parameters)
+	pk.Value[0] = rlk.BD.Value[0][0].Value[0]
+	pk.Value[1] = keygen.A[0][0]
+	return
+}
+
+// keyRelation sets rlk to the partial keys generated from the witness w = (s, r, e_b..., e_d..., e_v...)
+// in the coefficient domain, with one error per entry of the gadget ciphertexts for each of
+//
+//	b = -a*s + e_b, d = -a*r + s*g + e_d and v = -u*s - r*g + e_v,
+//
+// so that BD = (b, d) and V = (v, u). Apart from u, the keys are linear in w.
+func (keygen *PartialKeyGenerator) keyRelation(w []ringqp.Poly, rlk *RelinearizationKey) {
 	params := keygen.params.Parameters
 	ringQP := keygen.params.RingQP()
 	levelQ := keygen.params.QCount() - 1
 	levelP := keygen.params.PCount() - 1
 
-	// Generate Relinearization Key
-	rlk = NewRelinearizationKey(params, levelQ, levelP)
+	s, r := keygen.buffQP[0], keygen.buffQP[1]
+	ringQP.NTTLvl(levelQ, levelP, w[0], s)
+	ringQP.MFormLvl(levelQ, levelP, s, s)
+	ringQP.NTTLvl(levelQ, levelP, w[1], r)
+	ringQP.MFormLvl(levelQ, levelP, r, r)
 
-	// Sample random r
-	r := keygen.GenSecretKey()
+	rows, cols := len(rlk.BD.Value), len(rlk.BD.Value[0])
+	eB, eD, eV := w[2:], w[2+rows*cols:], w[2+2*rows*cols:]
 
 	// Calculate d = - a * r + s * g + e'
-	for i := 0; i < len(rlk.BD.Value); i++ {
-		for j := 0; j < len(rlk.BD.Value[0]); j++ {
-			// Copy a to rlk.BD.Value[i][j].Value[1]
-			keygen.encryptZeroQP(rlk.BD.Value[i][j].Value[0], keygen.A[i][j], r)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			keygen.encryptZeroQP(rlk.BD.Value[i][j].Value[0], keygen.A[i][j], eD[i*cols+j], r)
 		}
 	}
-	rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(sk.Value.Q,
+	rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(s.Q,
 		[]rlwe.GadgetCiphertext{rlk.BD}, *ringQP, params.Pow2Base(), keygen.buffQ[0])
 
 	// Calculate b = - a * s + e
-	for i := 0; i < len(rlk.BD.Value); i++ {
-		for j := 0; j < len(rlk.BD.Value[0]); j++ {
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
 			ringQP.CopyLvl(levelQ, levelP, rlk.BD.Value[i][j].Value[0], rlk.BD.Value[i][j].Value[1])
-			keygen.encryptZeroQP(rlk.BD.Value[i][j].Value[0], keygen.A[i][j], sk)
+			keygen.encryptZeroQP(rlk.BD.Value[i][j].Value[0], keygen.A[i][j], eB[i*cols+j], s)
 		}
 	}
 
 	// Calculate v = - u * s - r * g + e"
-	for i := 0; i < len(rlk.V.Value); i++ {
-		for j := 0; j < len(rlk.V.Value[0]); j++ {
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
 			// Copy u to rlk.V.Value[i][j].Value[1]
 			ringQP.CopyLvl(levelQ, levelP, keygen.U[i][j], rlk.V.Value[i][j].Value[1])
-			keygen.encryptZeroQP(rlk.V.Value[i][j].Value[0], rlk.V.Value[i][j].Value[1], sk)
+			keygen.encryptZeroQP(rlk.V.Value[i][j].Value[0], rlk.V.Value[i][j].Value[1], eV[i*cols+j], s)
 		}
 	}
-	keygen.params.RingQP().NegLvl(levelQ, levelP, r.Value, r.Value)
-	rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(r.Value.Q,
+	ringQP.NegLvl(levelQ, levelP, r, r)
+	rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(r.Q,
 		[]rlwe.GadgetCiphertext{rlk.V}, *ringQP, params.Pow2Base(), keygen.buffQ[0])
-
-	// Generate Public Key
-	pk = rlwe.NewPublicKey(params)
-
-	// pk = rlk.B[0][0]
-	pk.Value[0] = rlk.BD.Value[0][0].Value[0]
-	pk.Value[1] = keygen.A[0][0]
-	return
 }
 
-func (keygen *PartialKeyGenerator) GenKeys() (sk *rlwe.SecretKey, pk *rlwe.PublicKey, rlk *RelinearizationKey) {
-	sk = keygen.GenSecretKey()
-	pk, rlk = keygen.GenPartialKeys(sk)
-	return
+// sampleTernary sets pol to a ternary polynomial of Hamming weight H in the coefficient domain.
+func (keygen *PartialKeyGenerator) sampleTernary(pol ringqp.Poly) {
+	levelQ, levelP := pol.LevelQ(), pol.LevelP()
+	keygen.ternarySampler.ReadLvl(levelQ, pol.Q)
+	if levelP != -1 {
+		keygen.params.RingQP().ExtendBasisSmallNormAndCenter(pol.Q, levelP, nil, pol.P)
+	}
 }
 
-func (keygen *PartialKeyGenerator) encryptZeroQP(c0, c1 ringqp.Poly, sk *rlwe.SecretKey) {
+// encryptZeroQP sets c0 to e - c1*s, for e in the coefficient domain and c1, s in the NTT and Montgomery domain.
+func (keygen *PartialKeyGenerator) encryptZeroQP(c0, c1, e ringqp.Poly, s ringqp.Poly) {
 	levelQ, levelP := c0.LevelQ(), c1.LevelP()
 	ringQP := keygen.params.RingQP()
 
-	keygen.gaussianSampler.ReadLvl(levelQ, c0.Q)
-	if levelP != -1 {
-		ringQP.ExtendBasisSmallNormAndCenter(c0.Q, levelP, nil, c0.P)
-	}
-
-	ringQP.NTTLvl(levelQ, levelP, c0, c0)
+	ringQP.NTTLvl(levelQ, levelP, e, c0)
 	ringQP.MFormLvl(levelQ, levelP, c0, c0)
 
-	ringQP.MulCoeffsMontgomeryAndSubLvl(levelQ, levelP, c1, sk.Value, c0)
+	ringQP.MulCoeffsMontgomeryAndSubLvl(levelQ, levelP, c1, s, c0)
 }
 
-func (keygen *PartialKeyGenerator) AggregateKeys(pks []*rlwe.PublicKey, rlks []*RelinearizationKey) (jpk *rlwe.PublicKey, jrlk *RelinearizationKey) {
+// KeyAggregationError is returned by AggregateKeys when the partial keys of party Party cannot be
+// aggregated with those of the other parties.
+type KeyAggregationError struct {
+	Party int
+	Err   error
+}
+
+func (e *KeyAggregationError) Error() string {
+	return fmt.Sprintf("cannot AggregateKeys: party %d: %v", e.Party, e.Err)
+}
+
+func (e *KeyAggregationError) Unwrap() error {
+	return e.Err
+}
+
+// AggregateKeys sums the partial public and relinearization keys of all parties into the joint keys.
+// It returns a KeyAggregationError naming the first party whose keys have the wrong shape or do not use
+// the common reference string.
+func (keygen *PartialKeyGenerator) AggregateKeys(pks []*rlwe.PublicKey, rlks []*RelinearizationKey) (jpk *rlwe.PublicKey, jrlk *RelinearizationKey, err error) {
+	if len(pks) == 0 || len(rlks) != len(pks) {
+		return nil, nil, fmt.Errorf("cannot AggregateKeys: got %d public keys and %d relinearization keys", len(pks), len(rlks))
+	}
+	for i := range pks {
+		if err = keygen.checkPartialKeys(pks[i], rlks[i]); err != nil {
+			return nil, nil, &KeyAggregationError{Party: i, Err: err}
+		}
+	}
+
 	ringQP := keygen.params.RingQP()
 
 	levelQ := pks[0].Value[0].LevelQ()
@@ -194,10 +272,6 @@ func (keygen *PartialKeyGenerator) AggregateKeys(pks []*rlwe.PublicKey, rlks []*
 			for k := 1; k < len(rlks); k++ {
 				ringQP.AddLvl(levelQ, levelP, jrlk.BD.Value[i][j].Value[0], rlks[k].BD.Value[i][j].Value[0], jrlk.BD.Value[i][j].Value[0])
 				ringQP.AddLvl(levelQ, levelP, jrlk.BD.Value[i][j].Value[1], rlks[k].BD.Value[i][j].Value[1], jrlk.BD.Value[i][j].Value[1])
-
-				if !rlks[k].V.Value[i][j].Value[1].Equals(keygen.U[i][j]) {
-					panic("AggregateRelinKeys: mismatch in common reference string")
-				}
 				ringQP.AddLvl(levelQ, levelP, jrlk.V.Value[i][j].Value[0], rlks[k].V.Value[i][j].Value[0], jrlk.V.Value[i][j].Value[0])
 			}
 		}
@@ -211,14 +285,8 @@ func (keygen *PartialKeyGenerator) AggregateKeys(pks []*rlwe.PublicKey, rlks []*
 	ringQP.CopyLvl(levelQ, levelP, pks[0].Value[1], jpk.Value[1])
 
 	for i := 1; i < len(pks); i++ {
-		if pks[i].Value[0].LevelQ() != levelQ || pks[i].Value[0].LevelP() != levelP {
-			panic("AggregatePublicKeys: mismatch in ciphertext levels")
-		}
-		if !pks[i].Value[1].Equals(keygen.A[0][0]) {
-			panic("AggregatePublicKeys: mismatch in common reference string")
-		}
 		ringQP.AddLvl(levelQ, levelP, jpk.Value[0], pks[i].Value[0], jpk.Value[0])
 	}
 
-	return jpk, jrlk
+	return jpk, jrlk, nil
 }
@@ -1,8 +1,7 @@
 package hpbfv
 
 import (
-	"math/big"
-
+	"spdz-go/field"
 	"spdz-go/rlwe"
 )
 
@@ -23,17 +22,12 @@ func NewPlaintext(params Parameters) *Plaintext {
 	return plaintext
 }
 
+// Message is a vector of D slots in the plaintext field Z_T, see Parameters.Field.
 type Message struct {
-	Value []*big.Int
+	Value []field.Element
 }
 
+// NewMessage allocates a new message with all its slots set to zero.
 func NewMessage(params Parameters) *Message {
-	msg := new(Message)
-	msg.Value = make([]*big.Int, params.Slots())
-
-	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i] = big.NewInt(0)
-	}
-
-	return msg
+	return &Message{Value: params.Field().NewVector(params.Slots())}
 }
@@ -0,0 +1,448 @@
+package hpbfv
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	"spdz-go/ring"
+	"spdz-go/rlwe"
+	"spdz-go/utils"
+)
+
+// In the threshold variant of the joint key, every party i also Shamir-shares its secret s_i among the N
+// parties, over the integers, with the polynomial f_i(x) = Delta*s_i + f_i1*x + ... + f_it*x^t of degree t,
+// where Delta = N! and the coefficients f_ik are uniform integers of coeffBits bits, and sends privately to
+// party j the evaluation x_ij = f_i(j+1). The sum X_j of the shares received by party j is its share of the
+// joint secret s = sum_i s_i: for any set S of t+1 parties or more, sum_{j in S} mu_j * X_j = Delta^2 * s
+// with mu_j = Delta * lambda_j, an integer, and lambda_j the Lagrange coefficient of j in S at 0. Decryption
+// is linear in the secret, so every party of S applies mu_j to its share in PartialDecrypt, and
+// JointDecryptToMsgNew decrypts Delta^2 times the ciphertext and divides the message by Delta^2 in Z_T.
+// The coefficients f_ik are large enough that the shares of t parties are statistically independent of
+// s_i, up to 2^-ShamirSlack.
+//
+// The dealer commits to its polynomial with the RLWE samples C_ik = -a*f_ik + e_ik under the common
+// polynomial a of the partial public keys, and its partial public key b_i = -a*s_i + e_i commits to the
+// constant term, as C_i0 = Delta*b_i. These are Feldman commitments up to a small error: party j accepts its
+// share only if it is bounded and sum_k (j+1)^k * C_ik + a*x_ij is small, which VerifyShare checks. The
+// commitments of all dealers then give, for every party j, the public key B_j = sum_i sum_k (j+1)^k * C_ik
+// = -a*X_j + E_j of its share X_j with a small E_j, see ThresholdKey. The shares are bounded integers, so
+// the proofs of threshold decryption, see ThresholdDecryptor.PartialDecryptWithProof, bind a decryption
+// share to the X_j of its public key as the proofs of decryption bind it to the secret of a partial public
+// key. This holds when the witnesses that the proofs extract are small enough for Q, which
+// NewThresholdizer checks.
+//
+// The joint public and relinearization keys are still those of AggregateKeys, so every party takes part in
+// key generation and in the dealing of the shares; the threshold lets any t+1 parties decrypt afterwards.
+// Every dealer whose commitments are accepted by t+1 honest parties is bound to a polynomial of degree t,
+// so with t corrupted parties the threshold key needs N >= 2t+1.
+
+// ShamirSlack is the statistical security in bits with which the Shamir shares of threshold parties hide
+// the secret they share.
+const ShamirSlack = 40
+
+// ErrTooFewParties is returned when fewer than threshold+1 parties take part in a threshold decryption.
+var ErrTooFewParties = errors.New("too few parties for the threshold")
+
+// ErrInvalidShamirShare is returned when a Shamir share does not match the commitments of its dealer.
+var ErrInvalidShamirShare = errors.New("invalid Shamir share")
+
+// ShamirShare is the share of a party of a secret key Shamir-shared with a Thresholdizer.
+type ShamirShare struct {
+	Value *ring.Poly // integer coefficients, in the coefficient domain
+}
+
+// ShamirCommitment is the commitment of a dealer to the coefficients f_1, ..., f_t of its sharing polynomial.
+type ShamirCommitment struct {
+	Value []*ring.Poly // -a*f_k + e_k, in the NTT and Montgomery domain as the partial public keys
+}
+
+// ThresholdKey is the public part of a threshold key: the public key B_j = -a*X_j + E_j of the share X_j of
+// every party j, from which the proofs of threshold decryption are verified.
+type ThresholdKey struct {
+	Threshold int
+	A         *ring.Poly   // common polynomial of the partial public keys, in the NTT and Montgomery domain
+	Shares    []*ring.Poly // B_j of every party j, in the NTT and Montgomery domain
+}
+
+// thresholdBounds holds the sizes of the values of a threshold key of numParties parties.
+type thresholdBounds struct {
+	numParties, threshold int
+
+	delta     *big.Int // numParties!
+	coeffBits int      // the coefficients f_ik are uniform in [-2^coeffBits, 2^coeffBits)
+	share     *big.Int // bound on the coefficients of a share x_ij of a dealer
+	keyShare  *big.Int // bound on the coefficients of a share X_j of the joint secret
+	noise     uint64   // bound on the coefficients of the error of sum_k (j+1)^k * C_ik + a*x_ij
+	keyNoise  uint64   // bound on the coefficients of the error E_j of B_j
+	maskBits  int      // bit-size of the mask of X_j in a proof of threshold decryption
+	maskNoise uint64   // bound on the coefficients of the mask of E_j in a proof of threshold decryption
+}
+
+// newThresholdBounds returns the bounds of a threshold key of numParties parties with threshold, and an error
+// if the proofs of threshold decryption do not bind the shares to their public keys with the parameters.
+func newThresholdBounds(params Parameters, numParties, threshold int) (*thresholdBounds, error) {
+	if threshold < 0 || threshold >= numParties {
+		return nil, fmt.Errorf("threshold %d for %d parties", threshold, numParties)
+	}
+	b := &thresholdBounds{numParties: numParties, threshold: threshold, delta: big.NewInt(1)}
+	for k := 2; k <= numParties; k++ {
+		b.delta.Mul(b.delta, big.NewInt(int64(k)))
+	}
+
+	// the shares of two secrets for t parties differ by a polynomial with coefficients below 2*Delta*2^t,
+	// hidden by the tN coefficients f_ik up to 2^-ShamirSlack
+	b.coeffBits = ShamirSlack + params.LogN() + bits.Len(uint(utils.MaxInt(1, threshold))) + b.delta.BitLen() + threshold + 1
+
+	// sum_{k=1}^t n^k, the largest sum of the evaluation points (j+1)^k
+	powers, pow := new(big.Int), big.NewInt(1)
+	for k := 1; k <= threshold; k++ {
+		pow.Mul(pow, big.NewInt(int64(numParties)))
+		powers.Add(powers, pow)
+	}
+	b.share = new(big.Int).Lsh(powers, uint(b.coeffBits))
+	b.share.Add(b.share, b.delta)
+	b.keyShare = new(big.Int).Mul(b.share, big.NewInt(int64(numParties)))
+
+	noise := new(big.Int).Add(powers, b.delta)
+	noise.Mul(noise, new(big.Int).SetUint64(uint64(6*params.Sigma())))
+	keyNoise := new(big.Int).Mul(noise, big.NewInt(int64(numParties)))
+	maskNoise := new(big.Int).Lsh(keyNoise, PlaintextProofSlack)
+	q0 := params.RingQ().Modulus[0]
+	if maskNoise.BitLen() > 62 || 2*maskNoise.Uint64() >= q0>>1 {
+		return nil, fmt.Errorf("the parameters do not support a threshold key of %d parties", numParties)
+	}
+	b.noise, b.keyNoise, b.maskNoise = noise.Uint64(), keyNoise.Uint64(), maskNoise.Uint64()
+	b.maskBits = b.keyShare.BitLen() + PlaintextProofSlack
+
+	// two witnesses of the public key B_j extracted from the proofs differ by (x, u) with a*x = u, where the
+	// coefficients of x and u are below 2N times those of the masks. Such x and u exist for a uniform a with
+	// probability about (range of x * range of u / Q)^N, which must be negligible.
+	logN := params.LogN()
+	need := (b.maskBits + 2 + logN) + (maskNoise.BitLen() + 2 + logN) + 2
+	if q := params.RingQ().ModulusAtLevel[params.MaxLevel()]; need >= q.BitLen()-1 {
+		return nil, fmt.Errorf("the parameters do not support a threshold key of %d parties: the proofs of threshold decryption need %d bits of modulus, have %d", numParties, need+1, q.BitLen())
+	}
+	return b, nil
+}
+
+// Thresholdizer generates and checks the Shamir shares of the threshold variant of the joint key.
+type Thresholdizer struct {
+	params          Parameters
+	bounds          *thresholdBounds
+	prng            utils.PRNG
+	gaussianSampler *ring.GaussianSampler
+}
+
+// NewThresholdizer creates a new Thresholdizer for a joint key of numParties parties of which any
+// threshold+1 decrypt. It returns an error if threshold is not in [0, numParties) or if the parameters are
+// too small for the proofs of threshold decryption of numParties parties.
+func NewThresholdizer(params Parameters, numParties, threshold int) (*Thresholdizer, error) {
+	bounds, err := newThresholdBounds(params, numParties, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewThresholdizer: %w", err)
+	}
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewThresholdizer: %w", err)
+	}
+	return &Thresholdizer{
+		params:          params,
+		bounds:          bounds,
+		prng:            prng,
+		gaussianSampler: ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma())),
+	}, nil
+}
+
+// GenShares Shamir-shares the secret key sk of the partial public key pk among the parties, and returns the
+// commitment to the sharing, to be broadcast, and the shares. The share of index j is for party j and must be
+// sent to it privately. It assumes that sk was generated by GenSecretKey.
+func (thr *Thresholdizer) GenShares(sk *rlwe.SecretKey, pk *rlwe.PublicKey) (*ShamirCommitment, []*ShamirShare) {
+	ringQ := thr.params.RingQ()
+	levelQ := thr.params.MaxLevel()
+	a := pk.Value[1].Q
+
+	// f_0 = Delta*s and f_1, ..., f_t uniform, in the coefficient domain
+	coeffs := make([]*ring.Poly, thr.bounds.threshold+1)
+	coeffs[0] = ringQ.NewPoly()
+	ringQ.InvMFormLvl(levelQ, sk.Value.Q, coeffs[0])
+	ringQ.InvNTTLvl(levelQ, coeffs[0], coeffs[0])
+	ringQ.MulScalarBigintLvl(levelQ, coeffs[0], thr.bounds.delta, coeffs[0])
+
+	// C_k = -a*f_k + e_k
+	com := &ShamirCommitment{Value: make([]*ring.Poly, thr.bounds.threshold)}
+	f := ringQ.NewPoly()
+	for k := 1; k < len(coeffs); k++ {
+		coeffs[k] = ringQ.NewPoly()
+		sampleUniformBig(thr.prng, ringQ, thr.bounds.coeffBits, coeffs[k])
+
+		c := ringQ.NewPoly()
+		thr.gaussianSampler.ReadLvl(levelQ, c)
+		ringQ.NTTLvl(levelQ, c, c)
+		ringQ.MFormLvl(levelQ, c, c)
+		ringQ.NTTLvl(levelQ, coeffs[k], f)
+		ringQ.MFormLvl(levelQ, f, f)
+		ringQ.MulCoeffsMontgomeryAndSubLvl(levelQ, a, f, c)
+		com.Value[k-1] = c
+	}
+
+	shares := make([]*ShamirShare, thr.bounds.numParties)
+	for j := range shares {
+		shares[j] = &ShamirShare{Value: ringQ.NewPoly()}
+		ringQ.EvalPolyScalar(coeffs, uint64(j+1), shares[j].Value)
+	}
+	return com, shares
+}
+
+// VerifyShare checks that share is the share of party j of the dealer of the partial public key pk, committed
+// to by com. It returns an error wrapping ErrInvalidShamirShare if it is not.
+func (thr *Thresholdizer) VerifyShare(j int, share *ShamirShare, com *ShamirCommitment, pk *rlwe.PublicKey) error {
+	params := thr.params
+	ringQ := params.RingQ()
+	levelQ := params.MaxLevel()
+
+	if j < 0 || j >= thr.bounds.numParties {
+		return fmt.Errorf("%w: invalid party %d", ErrInvalidShamirShare, j)
+	}
+	if share == nil || !checkPolyLvl(params, share.Value, levelQ) {
+		return fmt.Errorf("%w: invalid shape of the share", ErrInvalidShamirShare)
+	}
+	if err := thr.checkCommitment(com, pk); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidShamirShare, err)
+	}
+	if !isBoundedBig(ringQ, share.Value, thr.bounds.share) {
+		return fmt.Errorf("%w: share out of bounds", ErrInvalidShamirShare)
+	}
+
+	// sum_k (j+1)^k * C_k + a*x_j must be small
+	e := thr.evalCommitment(j, com, pk)
+	x := ringQ.NewPoly()
+	ringQ.NTTLvl(levelQ, share.Value, x)
+	ringQ.MFormLvl(levelQ, x, x)
+	ringQ.MulCoeffsMontgomeryAndAddLvl(levelQ, pk.Value[1].Q, x, e)
+	ringQ.InvNTTLvl(levelQ, e, e)
+	ringQ.InvMFormLvl(levelQ, e, e)
+	if !isSmall(ringQ, e, thr.bounds.noise) {
+		return fmt.Errorf("%w: share does not match the commitment", ErrInvalidShamirShare)
+	}
+	return nil
+}
+
+// AggregateShares sums the shares received by a party from every party into its share of the joint secret key.
+// The shares must have been checked with VerifyShare.
+func (thr *Thresholdizer) AggregateShares(shares []*ShamirShare) (*ShamirShare, error) {
+	if len(shares) != thr.bounds.numParties {
+		return nil, fmt.Errorf("cannot AggregateShares: got %d shares for %d parties", len(shares), thr.bounds.numParties)
+	}
+	ringQ := thr.params.RingQ()
+	levelQ := thr.params.MaxLevel()
+	agg := &ShamirShare{Value: ringQ.NewPoly()}
+	for i, share := range shares {
+		if share == nil || !checkPolyLvl(thr.params, share.Value, levelQ) {
+			return nil, fmt.Errorf("cannot AggregateShares: invalid share of party %d", i)
+		}
+		ringQ.AddLvl(levelQ, agg.Value, share.Value, agg.Value)
+	}
+	return agg, nil
+}
+
+// AggregateCommitments returns the threshold key of the commitments coms of every party to the sharing of the
+// secret key of its partial public key pks[i].
+func (thr *Thresholdizer) AggregateCommitments(pks []*rlwe.PublicKey, coms []*ShamirCommitment) (*ThresholdKey, error) {
+	n := thr.bounds.numParties
+	if len(pks) != n || len(coms) != n {
+		return nil, fmt.Errorf("cannot AggregateCommitments: got %d public keys and %d commitments for %d parties", len(pks), len(coms), n)
+	}
+	for i := range coms {
+		if err := thr.checkCommitment(coms[i], pks[i]); err != nil {
+			return nil, fmt.Errorf("cannot AggregateCommitments: party %d: %w", i, err)
+		}
+	}
+
+	ringQ := thr.params.RingQ()
+	levelQ := thr.params.MaxLevel()
+	key := &ThresholdKey{Threshold: thr.bounds.threshold, A: pks[0].Value[1].Q.CopyNew(), Shares: make([]*ring.Poly, n)}
+	for j := range key.Shares {
+		key.Shares[j] = ringQ.NewPoly()
+		for i := range coms {
+			ringQ.AddLvl(levelQ, key.Shares[j], thr.evalCommitment(j, coms[i], pks[i]), key.Shares[j])
+		}
+	}
+	return key, nil
+}
+
+// checkCommitment returns an error if com or pk are not allocated at the maximum level.
+func (thr *Thresholdizer) checkCommitment(com *ShamirCommitment, pk *rlwe.PublicKey) error {
+	levelQ := thr.params.MaxLevel()
+	if pk == nil || !checkPolyLvl(thr.params, pk.Value[0].Q, levelQ) || !checkPolyLvl(thr.params, pk.Value[1].Q, levelQ) {
+		return errors.New("invalid shape of the public key")
+	}
+	if com == nil || len(com.Value) != thr.bounds.threshold {
+		return fmt.Errorf("expected a commitment to %d coefficients", thr.bounds.threshold)
+	}
+	for _, c := range com.Value {
+		if !checkPolyLvl(thr.params, c, levelQ) {
+			return errors.New("invalid shape of the commitment")
+		}
+	}
+	return nil
+}
+
+// evalCommitment returns sum_k (j+1)^k * C_k for the commitment com of the dealer of pk, with C_0 = Delta*b.
+func (thr *Thresholdizer) evalCommitment(j int, com *ShamirCommitment, pk *rlwe.PublicKey) *ring.Poly {
+	ringQ := thr.params.RingQ()
+	levelQ := thr.params.MaxLevel()
+	out, tmp := ringQ.NewPoly(), ringQ.NewPoly()
+	ringQ.MulScalarBigintLvl(levelQ, pk.Value[0].Q, thr.bounds.delta, out)
+	pow := big.NewInt(1)
+	for _, c := range com.Value {
+		pow.Mul(pow, big.NewInt(int64(j+1)))
+		ringQ.MulScalarBigintLvl(levelQ, c, pow, tmp)
+		ringQ.AddLvl(levelQ, out, tmp, out)
+	}
+	return out
+}
+
+// checkPolyLvl reports whether pol is allocated at level.
+func checkPolyLvl(params Parameters, pol *ring.Poly, level int) bool {
+	return pol != nil && pol.N() == params.N() && pol.Level() == level
+}
+
+// ThresholdDecryptor holds the share of the joint secret key of a party, with which it decrypts together with
+// any threshold other parties.
+type ThresholdDecryptor struct {
+	params Parameters
+	ddec   *DistributedDecryptor
+	bounds *thresholdBounds
+	key    *ThresholdKey
+	id     int
+
+	share    *ring.Poly // X_id in the coefficient domain
+	shareNTT *ring.Poly // X_id in the NTT and Montgomery domain
+	noise    *ring.Poly // E_id = B_id + a*X_id in the coefficient domain
+}
+
+// NewThresholdDecryptor creates a new ThresholdDecryptor for party id from the threshold key and its share of
+// the joint secret key, see Thresholdizer.AggregateShares and Thresholdizer.AggregateCommitments. It returns
+// an error if the share does not match the public key of the party in the threshold key.
+func NewThresholdDecryptor(params Parameters, key *ThresholdKey, share *ShamirShare, id int) (*ThresholdDecryptor, error) {
+	ringQ := params.RingQ()
+	levelQ := params.MaxLevel()
+	if key == nil || !checkPolyLvl(params, key.A, levelQ) {
+		return nil, errors.New("cannot NewThresholdDecryptor: invalid threshold key")
+	}
+	bounds, err := newThresholdBounds(params, len(key.Shares), key.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewThresholdDecryptor: %w", err)
+	}
+	for _, b := range key.Shares {
+		if !checkPolyLvl(params, b, levelQ) {
+			return nil, errors.New("cannot NewThresholdDecryptor: invalid threshold key")
+		}
+	}
+	if id < 0 || id >= bounds.numParties {
+		return nil, fmt.Errorf("cannot NewThresholdDecryptor: invalid party %d", id)
+	}
+	if share == nil || !checkPolyLvl(params, share.Value, levelQ) || !isBoundedBig(ringQ, share.Value, bounds.keyShare) {
+		return nil, errors.New("cannot NewThresholdDecryptor: invalid share")
+	}
+
+	dec := &ThresholdDecryptor{
+		params:   params,
+		ddec:     NewDistributedDecryptor(params, rlwe.NewSecretKey(params.Parameters)),
+		bounds:   bounds,
+		key:      key,
+		id:       id,
+		share:    share.Value.CopyNew(),
+		shareNTT: ringQ.NewPoly(),
+		noise:    ringQ.NewPoly(),
+	}
+	ringQ.NTTLvl(levelQ, dec.share, dec.shareNTT)
+	ringQ.MFormLvl(levelQ, dec.shareNTT, dec.shareNTT)
+	ringQ.MulCoeffsMontgomeryLvl(levelQ, key.A, dec.shareNTT, dec.noise)
+	ringQ.AddLvl(levelQ, dec.noise, key.Shares[id], dec.noise)
+	ringQ.InvNTTLvl(levelQ, dec.noise, dec.noise)
+	ringQ.InvMFormLvl(levelQ, dec.noise, dec.noise)
+	if !isSmall(ringQ, dec.noise, bounds.keyNoise) {
+		return nil, fmt.Errorf("cannot NewThresholdDecryptor: the share does not match the public key of party %d", id)
+	}
+	return dec, nil
+}
+
+// FloodingNoiseBits returns the bit-size of the flooding noise of the threshold decryption shares of
+// numParties parties of a ciphertext of noise noise, see Parameters.FloodingNoiseBits. The joint decryption
+// decrypts Delta^2 times the ciphertext, whose noise the shares must hide.
+func (dec *ThresholdDecryptor) FloodingNoiseBits(noise float64, numParties, statSec int) (int, error) {
+	return dec.params.FloodingNoiseBits(noise+2*bigLog2(dec.bounds.delta), numParties, statSec)
+}
+
+// PartialDecrypt returns the decryption share of ct of the party for the set of parties taking part in the
+// decryption, which must contain the party and at least threshold others. The shares of these parties, and
+// of no other, decrypt ct with JointDecryptToMsgNew.
+// It returns an error wrapping ErrTooFewParties if parties has fewer than threshold+1 parties.
+// PartialDecrypt leaves the share of the party unchanged and floods with its own PRNG, so it is safe for
+// concurrent use.
+func (dec *ThresholdDecryptor) PartialDecrypt(ct *Ciphertext, parties []int, noiseBits int) (*DistDecShare, error) {
+	mu, err := dec.lagrangeCoefficient(dec.id, parties)
+	if err != nil {
+		return nil, fmt.Errorf("cannot PartialDecrypt: %w", err)
+	}
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return nil, fmt.Errorf("cannot PartialDecrypt: %w", err)
+	}
+	ringQ := dec.params.RingQ()
+	levelQ := dec.params.MaxLevel()
+	sk := ringQ.NewPoly()
+	ringQ.MulScalarBigintLvl(levelQ, dec.shareNTT, mu, sk)
+	return dec.ddec.partialDecrypt(ct, sk, noiseBits, prng), nil
+}
+
+// JointDecryptToMsgNew decrypts ct from the decryption shares of a set of parties, which must all have
+// been computed for that set. It decrypts Delta^2 times ct and divides the message by Delta^2.
+func (dec *ThresholdDecryptor) JointDecryptToMsgNew(ct *Ciphertext, shares []*DistDecShare) *Message {
+	ringQ := dec.params.RingQ()
+	scale := new(big.Int).Mul(dec.bounds.delta, dec.bounds.delta)
+
+	scaled := ct.CopyNew()
+	ringQ.MulScalarBigintLvl(ct.Level(), ct.Value[0], scale, scaled.Value[0])
+	msg := dec.ddec.JointDecryptToMsgNew(scaled, shares)
+
+	f := dec.params.Field()
+	inv := f.Inv(f.NewElement(), f.NewElementFromBig(scale))
+	for i := range msg.Value {
+		f.Mul(msg.Value[i], msg.Value[i], inv)
+	}
+	return msg
+}
+
+// lagrangeCoefficient returns mu_j = Delta * lambda_j, with lambda_j the Lagrange coefficient at 0 of party j
+// in parties, with party k evaluated at k+1. It returns an error if parties is not a set of at least
+// threshold+1 parties containing j.
+func (dec *ThresholdDecryptor) lagrangeCoefficient(j int, parties []int) (*big.Int, error) {
+	if len(parties) < dec.bounds.threshold+1 {
+		return nil, fmt.Errorf("%w: %d parties for threshold %d", ErrTooFewParties, len(parties), dec.bounds.threshold)
+	}
+	seen := make(map[int]bool, len(parties))
+	for _, k := range parties {
+		if k < 0 || k >= dec.bounds.numParties || seen[k] {
+			return nil, fmt.Errorf("invalid set of parties %v", parties)
+		}
+		seen[k] = true
+	}
+	if !seen[j] {
+		return nil, fmt.Errorf("party %d is not in %v", j, parties)
+	}
+
+	// mu_j = Delta * prod_{k != j} (k+1) / (k - j), which is an integer
+	num, den := new(big.Int).Set(dec.bounds.delta), big.NewInt(1)
+	for _, k := range parties {
+		if k == j {
+			continue
+		}
+		num.Mul(num, big.NewInt(int64(k+1)))
+		den.Mul(den, big.NewInt(int64(k-j)))
+	}
+	return num.Quo(num, den), nil
+}
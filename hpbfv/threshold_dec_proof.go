@@ -0,0 +1,211 @@
+package hpbfv
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"spdz-go/ring"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The proofs of threshold decryption show that a threshold decryption share of a ciphertext (c0, c1) for a
+// set of parties S is mu_j*c1*X_j + e modulo Q for the share X_j of the public key B_j = E_j - a*X_j of the
+// party in the threshold key and a flooding noise e of at most noiseBits bits, with mu_j the scaled Lagrange
+// coefficient of the party in S.
+//
+// The relation (B_j, share) = (E_j - a*X_j, (mu_j*c1)*X_j + e) is the relation of the proofs of decryption,
+// with c1 multiplied by mu_j and the witness (X_j, E_j, e), so the proof is the same Fiat-Shamir Sigma
+// protocol. Only the bounds differ: the share X_j is an integer of about coeffBits bits rather than ternary,
+// and E_j sums the errors of the commitments of every dealer, so their masks are larger, see
+// thresholdBounds. The statement binds the set of parties, so that a share cannot be replayed for another set.
+
+// ThresholdDecryptionProof is a non-interactive proof that a threshold decryption share was computed with the
+// share of the joint secret key of the public key of a party in a threshold key, see
+// ThresholdDecryptor.PartialDecryptWithProof.
+type ThresholdDecryptionProof struct {
+	Digest []byte         // hash of the statement and the commitments, from which the challenges are derived
+	Z      [][]*ring.Poly // masked witness (X_j, E_j, e) of every repetition, in the coefficient domain
+}
+
+// proofBounds returns the bounds on the coefficients of the masked witness z = y + X^c * w of a proof of
+// threshold decryption with flooding noise of noiseBits bits, the bounds on the masks minus the bounds on the
+// witness, and false if the flooding noise is too large for the modulus of Q at level.
+func (dec *ThresholdDecryptor) proofBounds(level, noiseBits int) (boundX *big.Int, boundE uint64, boundNoise *big.Int, ok bool) {
+	if _, _, _, ok = dec.params.decryptionProofMasks(level, noiseBits); !ok {
+		return
+	}
+	_, _, boundNoise = dec.params.decryptionProofBounds(level, noiseBits)
+	boundX = new(big.Int).Lsh(big.NewInt(1), uint(dec.bounds.maskBits))
+	boundX.Sub(boundX, big.NewInt(1))
+	boundX.Sub(boundX, dec.bounds.keyShare)
+	return boundX, dec.bounds.maskNoise - dec.bounds.keyNoise, boundNoise, true
+}
+
+// PartialDecryptWithProof behaves as PartialDecrypt and additionally returns a proof that the share was
+// computed for parties with the share of the party in the threshold key, with a flooding noise of noiseBits
+// bits. It returns an error if noiseBits is too large for the modulus of ct. The masked witness of the proof is
+// rejection sampled as in DistributedDecryptor.PartialDecryptWithProof.
+// Unlike PartialDecrypt, it is not safe for concurrent use.
+func (dec *ThresholdDecryptor) PartialDecryptWithProof(ct *Ciphertext, parties []int, noiseBits int) (*DistDecShare, *ThresholdDecryptionProof, error) {
+	params := dec.params
+	ringQ := params.RingQ()
+	level := ct.Level()
+
+	if ct.Degree() != 1 {
+		panic("cannot PartialDecryptWithProof: ct.Degree() != 1")
+	}
+	mu, err := dec.lagrangeCoefficient(dec.id, parties)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot PartialDecryptWithProof: %w", err)
+	}
+	boundX, boundE, boundNoise, ok := dec.proofBounds(level, noiseBits)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot PartialDecryptWithProof: %d bits of flooding noise are too large for the proof", noiseBits)
+	}
+	maskBits := noiseBits + PlaintextProofSlack
+
+	// witness (X_j, E_j, e) in the coefficient domain
+	witness := []*ring.Poly{dec.share, dec.noise, ringQ.NewPoly()}
+	addNoise(ringQ, witness[2], noiseBits, dec.ddec.prng)
+	witness[2].Resize(level)
+
+	c1 := dec.scaledC1(ct, mu)
+	key, share := ringQ.NewPoly(), ringQ.NewPolyLvl(level)
+	dec.ddec.decryptionRelation(dec.key.A, c1, witness, key, share)
+	shareImg := ringQ.NewPolyLvl(level)
+
+	numReps := params.decryptionProofRepetitions()
+	buf := make([]byte, 8*params.N())
+	xw := ringQ.NewPoly()
+	for {
+		// commitments decryptionRelation(y) for masks y
+		ys := make([][]*ring.Poly, numReps)
+		h := dec.proofHash(ct, dec.id, parties, share, noiseBits)
+		for k := range ys {
+			ys[k] = []*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPolyLvl(level)}
+			dec.ddec.sampleFlooding(dec.bounds.maskBits, ys[k][0])
+			sampleBounded(dec.ddec.prng, ringQ, dec.bounds.maskNoise, ys[k][1])
+			dec.ddec.sampleFlooding(maskBits, ys[k][2])
+			dec.ddec.decryptionRelation(dec.key.A, c1, ys[k], key, shareImg)
+			hashPoly(h, ringQ, key, buf)
+			hashPoly(h, ringQ, shareImg, buf)
+		}
+
+		// z = y + X^c * w
+		proof := &ThresholdDecryptionProof{Digest: h.Sum(nil), Z: ys}
+		accept := true
+		for k, c := range decryptionProofChallenges(params, proof.Digest) {
+			if c >= 0 {
+				for l := range witness {
+					lvl := witness[l].Level()
+					mulByMonomialLvl(ringQ, lvl, witness[l], c, xw)
+					ringQ.AddLvl(lvl, proof.Z[k][l], xw, proof.Z[k][l])
+				}
+			}
+			z := proof.Z[k]
+			if !isBoundedBig(ringQ, z[0], boundX) || !isSmall(ringQ, z[1], boundE) || !isBoundedBig(ringQ, z[2], boundNoise) {
+				accept = false
+				break
+			}
+		}
+		if accept {
+			return &DistDecShare{share}, proof, nil
+		}
+	}
+}
+
+// VerifyShare checks the proof that share is the threshold decryption share of ct of party j for parties,
+// computed with the share of party j in the threshold key and a flooding noise of noiseBits bits. It returns
+// an error wrapping ErrInvalidDecryptionShare if it is not.
+func (dec *ThresholdDecryptor) VerifyShare(ct *Ciphertext, j int, parties []int, share *DistDecShare, proof *ThresholdDecryptionProof, noiseBits int) error {
+	params := dec.params
+	ringQ := params.RingQ()
+	levelQ, level := params.MaxLevel(), ct.Level()
+
+	if ct.Degree() != 1 {
+		return fmt.Errorf("%w: ct.Degree() != 1", ErrInvalidDecryptionShare)
+	}
+	mu, err := dec.lagrangeCoefficient(j, parties)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDecryptionShare, err)
+	}
+	boundX, boundE, boundNoise, ok := dec.proofBounds(level, noiseBits)
+	if !ok {
+		return fmt.Errorf("%w: %d bits of flooding noise are too large for the proof", ErrInvalidDecryptionShare, noiseBits)
+	}
+	if share == nil || !checkPolyLvl(params, share.Poly, level) {
+		return fmt.Errorf("%w: invalid shape of the share", ErrInvalidDecryptionShare)
+	}
+	numReps := params.decryptionProofRepetitions()
+	if proof == nil || len(proof.Digest) != blake2b.Size256 || len(proof.Z) != numReps {
+		return fmt.Errorf("%w: expected %d repetitions", ErrInvalidDecryptionShare, numReps)
+	}
+	for k, z := range proof.Z {
+		if len(z) != 3 || !checkPolyLvl(params, z[0], levelQ) || !checkPolyLvl(params, z[1], levelQ) || !checkPolyLvl(params, z[2], level) {
+			return fmt.Errorf("%w: invalid shape of repetition %d", ErrInvalidDecryptionShare, k)
+		}
+	}
+
+	// the statement in the coefficient domain
+	key := ringQ.NewPoly()
+	ringQ.InvNTTLvl(levelQ, dec.key.Shares[j], key)
+	c1 := dec.scaledC1(ct, mu)
+
+	h := dec.proofHash(ct, j, parties, share.Poly, noiseBits)
+	buf := make([]byte, 8*params.N())
+	keyImg, shareImg, xk := ringQ.NewPoly(), ringQ.NewPolyLvl(level), ringQ.NewPoly()
+	for k, c := range decryptionProofChallenges(params, proof.Digest) {
+		z := proof.Z[k]
+		if !isBoundedBig(ringQ, z[0], boundX) || !isSmall(ringQ, z[1], boundE) || !isBoundedBig(ringQ, z[2], boundNoise) {
+			return fmt.Errorf("%w: repetition %d out of bounds", ErrInvalidDecryptionShare, k)
+		}
+		dec.ddec.decryptionRelation(dec.key.A, c1, z, keyImg, shareImg)
+		if c >= 0 {
+			mulByMonomialLvl(ringQ, levelQ, key, c, xk)
+			ringQ.SubLvl(levelQ, keyImg, xk, keyImg)
+			mulByMonomialLvl(ringQ, level, share.Poly, c, xk)
+			ringQ.SubLvl(level, shareImg, xk, shareImg)
+		}
+		hashPoly(h, ringQ, keyImg, buf)
+		hashPoly(h, ringQ, shareImg, buf)
+	}
+	if subtle.ConstantTimeCompare(h.Sum(nil), proof.Digest) != 1 {
+		return fmt.Errorf("%w: challenges do not match", ErrInvalidDecryptionShare)
+	}
+	return nil
+}
+
+// scaledC1 returns mu*c1 for the ciphertext ct, at its level.
+func (dec *ThresholdDecryptor) scaledC1(ct *Ciphertext, mu *big.Int) *ring.Poly {
+	ringQ := dec.params.RingQ()
+	c1 := ringQ.NewPolyLvl(ct.Level())
+	ringQ.MulScalarBigintLvl(ct.Level(), ct.Value[1], mu, c1)
+	return c1
+}
+
+// proofHash returns a hash of the statement of a proof of threshold decryption of party j for parties.
+func (dec *ThresholdDecryptor) proofHash(ct *Ciphertext, j int, parties []int, share *ring.Poly, noiseBits int) hash.Hash {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	fp := dec.params.Fingerprint()
+	h.Write([]byte("hpbfv/threshold-decryption-proof"))
+	h.Write(fp[:])
+	var b [8]byte
+	for _, x := range append([]int{noiseBits, dec.bounds.numParties, dec.bounds.threshold, j, len(parties)}, parties...) {
+		binary.BigEndian.PutUint64(b[:], uint64(x))
+		h.Write(b[:])
+	}
+
+	ringQ := dec.params.RingQ()
+	buf := make([]byte, 8*dec.params.N())
+	for _, pol := range []*ring.Poly{dec.key.A, dec.key.Shares[j], ct.Value[0], ct.Value[1], share} {
+		hashPoly(h, ringQ, pol, buf)
+	}
+	return h
+}
@@ -0,0 +1,987 @@
+package hpbfv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"spdz-go/field"
+	"spdz-go/ring"
+	"spdz-go/rlwe"
+	"spdz-go/rlwe/ringqp"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// WireVersion is the version of the wire format written by WireWriter.
+const WireVersion = 1
+
+// ErrWireFormat is returned by WireReader on malformed input.
+var ErrWireFormat = errors.New("invalid wire encoding")
+
+var wireMagic = [4]byte{'H', 'P', 'B', 'F'}
+
+// wireHeaderSize is the size of the header: magic, version and parameter fingerprint.
+const wireHeaderSize = len(wireMagic) + 1 + FingerprintSize
+
+// wireKind tags every object of a wire message, so that objects read in the wrong order are rejected.
+type wireKind uint8
+
+const (
+	wireCiphertext wireKind = iota + 1
+	wireDistDecShare
+	wireMessage
+	wirePublicKey
+	wireRelinearizationKey
+	wireScalar
+	wireBytes
+	wirePlaintextProof
+	wireKeyProof
+	wireDecryptionProof
+	wireShamirShare
+	wireShamirCommitment
+	wireThresholdDecryptionProof
+)
+
+func (k wireKind) String() string {
+	switch k {
+	case wireCiphertext:
+		return "Ciphertext"
+	case wireDistDecShare:
+		return "DistDecShare"
+	case wireMessage:
+		return "Message"
+	case wirePublicKey:
+		return "PublicKey"
+	case wireRelinearizationKey:
+		return "RelinearizationKey"
+	case wireScalar:
+		return "scalar"
+	case wireBytes:
+		return "bytes"
+	case wirePlaintextProof:
+		return "PlaintextProof"
+	case wireKeyProof:
+		return "KeyProof"
+	case wireDecryptionProof:
+		return "DecryptionProof"
+	case wireShamirShare:
+		return "ShamirShare"
+	case wireShamirCommitment:
+		return "ShamirCommitment"
+	case wireThresholdDecryptionProof:
+		return "ThresholdDecryptionProof"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// WireWriter encodes a sequence of objects into a single versioned message whose header
+// carries the fingerprint of the parameters. The first error is sticky and returned by Bytes.
+//
+// Layout: "HPBF" | version (1 byte) | fingerprint (32 bytes) | objects, each prefixed by its kind (1 byte).
+// Integers are big-endian, polynomial coefficients are 8 bytes each and elements of Z_T are
+// (T.BitLen()+7)/8 bytes each.
+type WireWriter struct {
+	params Parameters
+	buf    []byte
+	err    error
+}
+
+// NewWireWriter creates a new WireWriter and writes the header.
+func NewWireWriter(params Parameters) *WireWriter {
+	w := &WireWriter{params: params}
+	fp := params.Fingerprint()
+	w.buf = append(w.buf, wireMagic[:]...)
+	w.buf = append(w.buf, WireVersion)
+	w.buf = append(w.buf, fp[:]...)
+	return w
+}
+
+// Bytes returns the encoded message, or the first error encountered while writing.
+func (w *WireWriter) Bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+func (w *WireWriter) fail(kind wireKind, format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf("cannot write %s: %s", kind, fmt.Sprintf(format, args...))
+	}
+}
+
+// WriteCiphertext writes a ciphertext of degree at most 2.
+func (w *WireWriter) WriteCiphertext(ct *Ciphertext) {
+	if w.err != nil {
+		return
+	}
+	if ct == nil || ct.Ciphertext == nil || len(ct.Value) == 0 || len(ct.Value) > 3 {
+		w.fail(wireCiphertext, "invalid degree")
+		return
+	}
+	level := ct.Value[0].Level()
+	for _, pol := range ct.Value {
+		if pol.Level() != level || pol.N() != w.params.N() || level > w.params.MaxLevel() {
+			w.fail(wireCiphertext, "invalid shape")
+			return
+		}
+	}
+
+	w.buf = append(w.buf, byte(wireCiphertext), byte(ct.Degree()), wireFlags(ct.MetaData), byte(level))
+	for _, pol := range ct.Value {
+		w.writePoly(w.params.RingQ(), pol)
+	}
+}
+
+// WriteDistDecShare writes a distributed decryption share.
+func (w *WireWriter) WriteDistDecShare(share *DistDecShare) {
+	if w.err != nil {
+		return
+	}
+	if share == nil || share.Poly == nil || share.N() != w.params.N() || share.Level() > w.params.MaxLevel() {
+		w.fail(wireDistDecShare, "invalid shape")
+		return
+	}
+	w.buf = append(w.buf, byte(wireDistDecShare), byte(share.Level()))
+	w.writePoly(w.params.RingQ(), share.Poly)
+}
+
+// WriteMessage writes a message.
+func (w *WireWriter) WriteMessage(msg *Message) {
+	if w.err != nil {
+		return
+	}
+	if msg == nil || len(msg.Value) != w.params.Slots() {
+		w.fail(wireMessage, "invalid number of slots")
+		return
+	}
+	for _, x := range msg.Value {
+		if len(x) != w.params.Field().Limbs() {
+			w.fail(wireMessage, "invalid element")
+			return
+		}
+	}
+	w.buf = append(w.buf, byte(wireMessage))
+	for _, x := range msg.Value {
+		w.writeElement(x)
+	}
+}
+
+// WriteScalar writes an element of Z_T.
+func (w *WireWriter) WriteScalar(x field.Element) {
+	if w.err != nil {
+		return
+	}
+	if len(x) != w.params.Field().Limbs() {
+		w.fail(wireScalar, "invalid element")
+		return
+	}
+	w.buf = append(w.buf, byte(wireScalar))
+	w.writeElement(x)
+}
+
+// WriteBytes writes a byte string, such as a commitment or a seed.
+func (w *WireWriter) WriteBytes(b []byte) {
+	if w.err != nil {
+		return
+	}
+	w.buf = append(w.buf, byte(wireBytes))
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// WritePlaintextProof writes a proof of plaintext knowledge. The masked randomness t_j is written as
+// signed 8-byte integers, which the verifier bounds anyway.
+func (w *WireWriter) WritePlaintextProof(proof *PlaintextProof) {
+	if w.err != nil {
+		return
+	}
+	numMasks := w.params.plaintextProofMasks()
+	if proof == nil || len(proof.A) != numMasks || len(proof.Z) != numMasks || len(proof.T) != numMasks {
+		w.fail(wirePlaintextProof, "invalid number of masks")
+		return
+	}
+	pc := plaintextProofContext{params: w.params}
+	for j := range proof.A {
+		if !pc.checkCiphertext(proof.A[j]) || !pc.checkCoeffs(proof.Z[j]) || proof.T[j] == nil || proof.T[j].N() != w.params.N() {
+			w.fail(wirePlaintextProof, "invalid shape")
+			return
+		}
+	}
+
+	w.buf = append(w.buf, byte(wirePlaintextProof), byte(numMasks))
+	for j := range proof.A {
+		for _, pol := range proof.A[j].Value {
+			w.writePoly(w.params.RingQ(), pol)
+		}
+		for _, x := range proof.Z[j] {
+			w.writeElement(x)
+		}
+		w.writeSmallPoly(proof.T[j])
+	}
+}
+
+// WriteKeyProof writes a proof of key generation. The masked witness is written as signed 8-byte integers.
+func (w *WireWriter) WriteKeyProof(proof *KeyProof) {
+	if w.err != nil {
+		return
+	}
+	numReps, size := w.params.keyProofRepetitions(), w.params.keyWitnessSize()
+	if proof == nil || len(proof.Digest) != blake2b.Size256 || len(proof.Z) != numReps {
+		w.fail(wireKeyProof, "invalid number of repetitions")
+		return
+	}
+	for _, z := range proof.Z {
+		if len(z) != size {
+			w.fail(wireKeyProof, "invalid shape")
+			return
+		}
+		for _, pol := range z {
+			if pol.Q == nil || pol.Q.N() != w.params.N() {
+				w.fail(wireKeyProof, "invalid shape")
+				return
+			}
+		}
+	}
+
+	w.buf = append(w.buf, byte(wireKeyProof), byte(numReps))
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(size))
+	w.buf = append(w.buf, proof.Digest...)
+	for _, z := range proof.Z {
+		for _, pol := range z {
+			w.writeSmallPoly(pol.Q)
+		}
+	}
+}
+
+// WriteDecryptionProof writes a proof of decryption. The masked s and e_pk are written as signed 8-byte
+// integers, and the masked flooding noise in full at the level of the share.
+func (w *WireWriter) WriteDecryptionProof(proof *DecryptionProof) {
+	if w.err != nil {
+		return
+	}
+	numReps := w.params.decryptionProofRepetitions()
+	if proof == nil || len(proof.Digest) != blake2b.Size256 || len(proof.Z) != numReps {
+		w.fail(wireDecryptionProof, "invalid number of repetitions")
+		return
+	}
+	level := -1
+	for _, z := range proof.Z {
+		if len(z) != 3 || z[2] == nil || level >= 0 && z[2].Level() != level {
+			w.fail(wireDecryptionProof, "invalid shape")
+			return
+		}
+		level = z[2].Level()
+		for _, pol := range z {
+			if pol == nil || pol.N() != w.params.N() || pol.Level() > w.params.MaxLevel() {
+				w.fail(wireDecryptionProof, "invalid shape")
+				return
+			}
+		}
+	}
+
+	w.buf = append(w.buf, byte(wireDecryptionProof), byte(numReps), byte(level))
+	w.buf = append(w.buf, proof.Digest...)
+	for _, z := range proof.Z {
+		w.writeSmallPoly(z[0])
+		w.writeSmallPoly(z[1])
+		w.writePoly(w.params.RingQ(), z[2])
+	}
+}
+
+// WritePublicKey writes a public key.
+func (w *WireWriter) WritePublicKey(pk *rlwe.PublicKey) {
+	if w.err != nil {
+		return
+	}
+	if pk == nil || pk.Value[0].Q == nil {
+		w.fail(wirePublicKey, "invalid shape")
+		return
+	}
+	levelQ, levelP := pk.LevelQ(), pk.LevelP()
+	if !w.checkLevels(wirePublicKey, levelQ, levelP) {
+		return
+	}
+	w.buf = append(w.buf, byte(wirePublicKey), byte(levelQ), byte(int8(levelP)))
+	w.writeCiphertextQP(wirePublicKey, &pk.CiphertextQP, levelQ, levelP)
+}
+
+// WriteShamirShare writes a Shamir share of a secret key.
+func (w *WireWriter) WriteShamirShare(share *ShamirShare) {
+	if w.err != nil {
+		return
+	}
+	if share == nil || share.Value == nil || share.Value.N() != w.params.N() || share.Value.Level() != w.params.MaxLevel() {
+		w.fail(wireShamirShare, "invalid shape")
+		return
+	}
+	w.buf = append(w.buf, byte(wireShamirShare))
+	w.writePoly(w.params.RingQ(), share.Value)
+}
+
+// WriteShamirCommitment writes the commitment of a dealer to its Shamir shares.
+func (w *WireWriter) WriteShamirCommitment(com *ShamirCommitment) {
+	if w.err != nil {
+		return
+	}
+	if com == nil || len(com.Value) > math.MaxUint8 {
+		w.fail(wireShamirCommitment, "invalid number of coefficients")
+		return
+	}
+	for _, pol := range com.Value {
+		if pol == nil || pol.N() != w.params.N() || pol.Level() != w.params.MaxLevel() {
+			w.fail(wireShamirCommitment, "invalid shape")
+			return
+		}
+	}
+	w.buf = append(w.buf, byte(wireShamirCommitment), byte(len(com.Value)))
+	for _, pol := range com.Value {
+		w.writePoly(w.params.RingQ(), pol)
+	}
+}
+
+// WriteThresholdDecryptionProof writes a proof of threshold decryption. The masked share of the secret key
+// is written in full, the masked error of its public key as signed 8-byte integers, and the masked flooding
+// noise in full at the level of the share.
+func (w *WireWriter) WriteThresholdDecryptionProof(proof *ThresholdDecryptionProof) {
+	if w.err != nil {
+		return
+	}
+	numReps := w.params.decryptionProofRepetitions()
+	if proof == nil || len(proof.Digest) != blake2b.Size256 || len(proof.Z) != numReps {
+		w.fail(wireThresholdDecryptionProof, "invalid number of repetitions")
+		return
+	}
+	level := -1
+	for _, z := range proof.Z {
+		if len(z) != 3 || z[2] == nil || level >= 0 && z[2].Level() != level {
+			w.fail(wireThresholdDecryptionProof, "invalid shape")
+			return
+		}
+		level = z[2].Level()
+		for _, pol := range z {
+			if pol == nil || pol.N() != w.params.N() || pol.Level() > w.params.MaxLevel() {
+				w.fail(wireThresholdDecryptionProof, "invalid shape")
+				return
+			}
+		}
+		if z[0].Level() != w.params.MaxLevel() {
+			w.fail(wireThresholdDecryptionProof, "invalid shape")
+			return
+		}
+	}
+
+	w.buf = append(w.buf, byte(wireThresholdDecryptionProof), byte(numReps), byte(level))
+	w.buf = append(w.buf, proof.Digest...)
+	for _, z := range proof.Z {
+		w.writePoly(w.params.RingQ(), z[0])
+		w.writeSmallPoly(z[1])
+		w.writePoly(w.params.RingQ(), z[2])
+	}
+}
+
+// WriteRelinearizationKey writes a relinearization key.
+func (w *WireWriter) WriteRelinearizationKey(rlk *RelinearizationKey) {
+	if w.err != nil {
+		return
+	}
+	if rlk == nil {
+		w.fail(wireRelinearizationKey, "nil key")
+		return
+	}
+	w.buf = append(w.buf, byte(wireRelinearizationKey))
+	w.writeGadgetCiphertext(&rlk.BD)
+	w.writeGadgetCiphertext(&rlk.V)
+}
+
+func (w *WireWriter) writeGadgetCiphertext(ct *rlwe.GadgetCiphertext) {
+	if len(ct.Value) == 0 || len(ct.Value[0]) == 0 || ct.Value[0][0].Value[0].Q == nil {
+		w.fail(wireRelinearizationKey, "invalid shape")
+		return
+	}
+	levelQ, levelP := ct.LevelQ(), ct.LevelP()
+	if !w.checkLevels(wireRelinearizationKey, levelQ, levelP) {
+		return
+	}
+	rows, cols := w.params.DecompRNS(levelQ, levelP), w.params.DecompPw2(levelQ, levelP)
+	if len(ct.Value) != rows {
+		w.fail(wireRelinearizationKey, "invalid decomposition")
+		return
+	}
+	w.buf = append(w.buf, byte(levelQ), byte(int8(levelP)))
+	for i := range ct.Value {
+		if len(ct.Value[i]) != cols {
+			w.fail(wireRelinearizationKey, "invalid decomposition")
+			return
+		}
+		for j := range ct.Value[i] {
+			w.writeCiphertextQP(wireRelinearizationKey, &ct.Value[i][j], levelQ, levelP)
+		}
+	}
+}
+
+func (w *WireWriter) checkLevels(kind wireKind, levelQ, levelP int) bool {
+	if levelQ > w.params.MaxLevel() || levelP > w.params.PCount()-1 {
+		w.fail(kind, "invalid levels")
+		return false
+	}
+	return true
+}
+
+func (w *WireWriter) writeCiphertextQP(kind wireKind, ct *rlwe.CiphertextQP, levelQ, levelP int) {
+	if w.err != nil {
+		return
+	}
+	w.buf = append(w.buf, wireFlags(ct.MetaData))
+	for _, pol := range ct.Value {
+		if pol.Q == nil || pol.Q.Level() != levelQ || (levelP >= 0) != (pol.P != nil) || (pol.P != nil && pol.P.Level() != levelP) {
+			w.fail(kind, "invalid shape")
+			return
+		}
+		w.writePoly(w.params.RingQ(), pol.Q)
+		if pol.P != nil {
+			w.writePoly(w.params.RingP(), pol.P)
+		}
+	}
+}
+
+// writePoly writes the coefficients of pol reduced modulo the moduli of r.
+func (w *WireWriter) writePoly(r *ring.Ring, pol *ring.Poly) {
+	for i, coeffs := range pol.Coeffs {
+		qi := r.Modulus[i]
+		for _, c := range coeffs {
+			w.buf = binary.BigEndian.AppendUint64(w.buf, c%qi)
+		}
+	}
+}
+
+// writeSmallPoly writes the coefficients of pol centered modulo the first modulus of Q.
+func (w *WireWriter) writeSmallPoly(pol *ring.Poly) {
+	q0 := w.params.RingQ().Modulus[0]
+	for _, c := range pol.Coeffs[0] {
+		v := int64(c % q0)
+		if uint64(v) > q0>>1 {
+			v -= int64(q0)
+		}
+		w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v))
+	}
+}
+
+func (w *WireWriter) writeElement(x field.Element) {
+	f := w.params.Field()
+	n := len(w.buf)
+	w.buf = append(w.buf, make([]byte, f.ElementSize())...)
+	f.Encode(w.buf[n:], x)
+}
+
+func wireFlags(m rlwe.MetaData) (flags byte) {
+	if m.IsNTT {
+		flags |= 1
+	}
+	if m.IsMontgomery {
+		flags |= 2
+	}
+	return
+}
+
+// WireSizes holds the sizes in bytes of the header of a wire message and of the encodings of the
+// objects written by WireWriter at the maximum level, with which a receiver can bound the size of the
+// messages it accepts. The size of a ciphertext is the one of a ciphertext of degree 1.
+type WireSizes struct {
+	Header             int
+	Ciphertext         int
+	DistDecShare       int
+	Message            int
+	PublicKey          int
+	RelinearizationKey int
+	PlaintextProof     int
+	KeyProof           int
+	DecryptionProof    int
+	ShamirShare        int
+
+	ThresholdDecryptionProof int
+}
+
+// WireSizes returns the sizes of the wire encodings of the objects of the parameters.
+func (p Parameters) WireSizes() WireSizes {
+	n := p.N()
+	polyQ, polyP, small := 8*n*p.QCount(), 8*n*p.PCount(), 8*n
+	polyQP := polyQ + polyP
+	elem := p.Field().ElementSize()
+	levelQ, levelP := p.MaxLevel(), p.PCount()-1
+	rows, cols := p.DecompRNS(levelQ, levelP), p.DecompPw2(levelQ, levelP)
+
+	return WireSizes{
+		Header:             wireHeaderSize,
+		Ciphertext:         4 + 2*polyQ,
+		DistDecShare:       2 + polyQ,
+		Message:            1 + p.Slots()*elem,
+		PublicKey:          4 + 2*polyQP,
+		RelinearizationKey: 1 + 2*(2+rows*cols*(1+2*polyQP)),
+		PlaintextProof:     2 + p.plaintextProofMasks()*(2*polyQ+p.Slots()*elem+small),
+		KeyProof:           4 + blake2b.Size256 + p.keyProofRepetitions()*p.keyWitnessSize()*small,
+		DecryptionProof:    3 + blake2b.Size256 + p.decryptionProofRepetitions()*(2*small+polyQ),
+		ShamirShare:        1 + polyQ,
+
+		ThresholdDecryptionProof: 3 + blake2b.Size256 + p.decryptionProofRepetitions()*(small+2*polyQ),
+	}
+}
+
+// WireReader decodes a message written by WireWriter. Objects must be read in the order they
+// were written. The first error is sticky: once an error occurred, every read returns nil and
+// Close returns the error. Decoded objects must not be used before Close returned nil.
+type WireReader struct {
+	params Parameters
+	data   []byte
+	err    error
+}
+
+// NewWireReader creates a new WireReader on data and checks the header against params.
+func NewWireReader(params Parameters, data []byte) *WireReader {
+	r := &WireReader{params: params, data: data}
+	if len(data) < wireHeaderSize || !bytes.Equal(data[:len(wireMagic)], wireMagic[:]) {
+		r.err = fmt.Errorf("%w: missing header", ErrWireFormat)
+		return r
+	}
+	if v := data[len(wireMagic)]; v != WireVersion {
+		r.err = fmt.Errorf("%w: unsupported version %d", ErrWireFormat, v)
+		return r
+	}
+	fp := params.Fingerprint()
+	if !bytes.Equal(data[len(wireMagic)+1:wireHeaderSize], fp[:]) {
+		r.err = fmt.Errorf("%w: parameter fingerprint mismatch", ErrWireFormat)
+		return r
+	}
+	r.data = data[wireHeaderSize:]
+	return r
+}
+
+// Close returns the first error encountered while reading, or an error if some bytes were not read.
+func (r *WireReader) Close() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = fmt.Errorf("%w: %d trailing bytes", ErrWireFormat, len(r.data))
+	}
+	return r.err
+}
+
+func (r *WireReader) fail(kind wireKind, format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: cannot read %s: %s", ErrWireFormat, kind, fmt.Sprintf(format, args...))
+	}
+}
+
+// next consumes n bytes, or fails if fewer remain.
+func (r *WireReader) next(kind wireKind, n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) < n {
+		r.fail(kind, "truncated input")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// header consumes the kind tag and n bytes of fixed header.
+func (r *WireReader) header(kind wireKind, n int) []byte {
+	b := r.next(kind, 1+n)
+	if b == nil {
+		return nil
+	}
+	if wireKind(b[0]) != kind {
+		r.fail(kind, "found %s", wireKind(b[0]))
+		return nil
+	}
+	return b[1:]
+}
+
+// ReadCiphertext reads a ciphertext.
+func (r *WireReader) ReadCiphertext() *Ciphertext {
+	h := r.header(wireCiphertext, 3)
+	if h == nil {
+		return nil
+	}
+	degree, flags, level := int(h[0]), h[1], int(h[2])
+	if degree < 1 || degree > 2 || level > r.params.MaxLevel() {
+		r.fail(wireCiphertext, "invalid degree or level")
+		return nil
+	}
+
+	ct := &Ciphertext{Ciphertext: rlwe.NewCiphertext(r.params.Parameters, degree, level), noise: math.NaN()}
+	setWireFlags(&ct.MetaData, flags)
+	for _, pol := range ct.Value {
+		if !r.readPoly(wireCiphertext, r.params.RingQ(), pol) {
+			return nil
+		}
+	}
+	return ct
+}
+
+// ReadDistDecShare reads a distributed decryption share.
+func (r *WireReader) ReadDistDecShare() *DistDecShare {
+	h := r.header(wireDistDecShare, 1)
+	if h == nil {
+		return nil
+	}
+	level := int(h[0])
+	if level > r.params.MaxLevel() {
+		r.fail(wireDistDecShare, "invalid level")
+		return nil
+	}
+	share := &DistDecShare{r.params.RingQ().NewPolyLvl(level)}
+	if !r.readPoly(wireDistDecShare, r.params.RingQ(), share.Poly) {
+		return nil
+	}
+	return share
+}
+
+// ReadMessage reads a message.
+func (r *WireReader) ReadMessage() *Message {
+	if r.header(wireMessage, 0) == nil {
+		return nil
+	}
+	msg := NewMessage(r.params)
+	for _, x := range msg.Value {
+		if !r.readElement(wireMessage, x) {
+			return nil
+		}
+	}
+	return msg
+}
+
+// ReadScalar reads an element of Z_T.
+func (r *WireReader) ReadScalar() field.Element {
+	if r.header(wireScalar, 0) == nil {
+		return nil
+	}
+	x := r.params.Field().NewElement()
+	if !r.readElement(wireScalar, x) {
+		return nil
+	}
+	return x
+}
+
+// ReadBytes reads a byte string of at most maxLen bytes.
+func (r *WireReader) ReadBytes(maxLen int) []byte {
+	h := r.header(wireBytes, 4)
+	if h == nil {
+		return nil
+	}
+	n := binary.BigEndian.Uint32(h)
+	if uint64(n) > uint64(maxLen) {
+		r.fail(wireBytes, "length %d exceeds %d", n, maxLen)
+		return nil
+	}
+	b := r.next(wireBytes, int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// ReadPlaintextProof reads a proof of plaintext knowledge.
+func (r *WireReader) ReadPlaintextProof() *PlaintextProof {
+	h := r.header(wirePlaintextProof, 1)
+	if h == nil {
+		return nil
+	}
+	numMasks := r.params.plaintextProofMasks()
+	if int(h[0]) != numMasks {
+		r.fail(wirePlaintextProof, "invalid number of masks")
+		return nil
+	}
+
+	ringQ, f := r.params.RingQ(), r.params.Field()
+	proof := &PlaintextProof{
+		A: make([]*Ciphertext, numMasks),
+		Z: make([][]field.Element, numMasks),
+		T: make([]*ring.Poly, numMasks),
+	}
+	for j := range proof.A {
+		proof.A[j] = NewCiphertext(r.params, 1)
+		for _, pol := range proof.A[j].Value {
+			if !r.readPoly(wirePlaintextProof, ringQ, pol) {
+				return nil
+			}
+		}
+		proof.Z[j] = f.NewVector(r.params.Slots())
+		for _, x := range proof.Z[j] {
+			if !r.readElement(wirePlaintextProof, x) {
+				return nil
+			}
+		}
+		proof.T[j] = ringQ.NewPoly()
+		if !r.readSmallPoly(wirePlaintextProof, proof.T[j], nil) {
+			return nil
+		}
+	}
+	return proof
+}
+
+// ReadKeyProof reads a proof of key generation.
+func (r *WireReader) ReadKeyProof() *KeyProof {
+	h := r.header(wireKeyProof, 3)
+	if h == nil {
+		return nil
+	}
+	numReps, size := r.params.keyProofRepetitions(), r.params.keyWitnessSize()
+	if int(h[0]) != numReps || int(binary.BigEndian.Uint16(h[1:])) != size {
+		r.fail(wireKeyProof, "invalid number of repetitions")
+		return nil
+	}
+	digest := r.next(wireKeyProof, blake2b.Size256)
+	if digest == nil {
+		return nil
+	}
+
+	ringQP := r.params.RingQP()
+	proof := &KeyProof{Digest: append([]byte{}, digest...), Z: make([][]ringqp.Poly, numReps)}
+	for k := range proof.Z {
+		proof.Z[k] = make([]ringqp.Poly, size)
+		for l := range proof.Z[k] {
+			proof.Z[k][l] = ringQP.NewPoly()
+			if !r.readSmallPoly(wireKeyProof, proof.Z[k][l].Q, proof.Z[k][l].P) {
+				return nil
+			}
+		}
+	}
+	return proof
+}
+
+// ReadDecryptionProof reads a proof of decryption.
+func (r *WireReader) ReadDecryptionProof() *DecryptionProof {
+	h := r.header(wireDecryptionProof, 2)
+	if h == nil {
+		return nil
+	}
+	numReps, level := r.params.decryptionProofRepetitions(), int(h[1])
+	if int(h[0]) != numReps {
+		r.fail(wireDecryptionProof, "invalid number of repetitions")
+		return nil
+	}
+	if level > r.params.MaxLevel() {
+		r.fail(wireDecryptionProof, "invalid level")
+		return nil
+	}
+	digest := r.next(wireDecryptionProof, blake2b.Size256)
+	if digest == nil {
+		return nil
+	}
+
+	ringQ := r.params.RingQ()
+	proof := &DecryptionProof{Digest: append([]byte{}, digest...), Z: make([][]*ring.Poly, numReps)}
+	for k := range proof.Z {
+		proof.Z[k] = []*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPolyLvl(level)}
+		if !r.readSmallPoly(wireDecryptionProof, proof.Z[k][0], nil) ||
+			!r.readSmallPoly(wireDecryptionProof, proof.Z[k][1], nil) ||
+			!r.readPoly(wireDecryptionProof, ringQ, proof.Z[k][2]) {
+			return nil
+		}
+	}
+	return proof
+}
+
+// ReadPublicKey reads a public key.
+func (r *WireReader) ReadPublicKey() *rlwe.PublicKey {
+	h := r.header(wirePublicKey, 2)
+	if h == nil {
+		return nil
+	}
+	levelQ, levelP, ok := r.levels(wirePublicKey, h)
+	if !ok {
+		return nil
+	}
+	pk := new(rlwe.PublicKey)
+	if !r.readCiphertextQP(wirePublicKey, &pk.CiphertextQP, levelQ, levelP) {
+		return nil
+	}
+	return pk
+}
+
+// ReadShamirShare reads a Shamir share of a secret key.
+func (r *WireReader) ReadShamirShare() *ShamirShare {
+	if r.header(wireShamirShare, 0) == nil {
+		return nil
+	}
+	share := &ShamirShare{Value: r.params.RingQ().NewPoly()}
+	if !r.readPoly(wireShamirShare, r.params.RingQ(), share.Value) {
+		return nil
+	}
+	return share
+}
+
+// ReadShamirCommitment reads the commitment of a dealer to its Shamir shares.
+func (r *WireReader) ReadShamirCommitment() *ShamirCommitment {
+	h := r.header(wireShamirCommitment, 1)
+	if h == nil {
+		return nil
+	}
+	com := &ShamirCommitment{Value: make([]*ring.Poly, h[0])}
+	for k := range com.Value {
+		// allocated one by one, so that a truncated input does not allocate 255 polynomials
+		com.Value[k] = r.params.RingQ().NewPoly()
+		if !r.readPoly(wireShamirCommitment, r.params.RingQ(), com.Value[k]) {
+			return nil
+		}
+	}
+	return com
+}
+
+// ReadThresholdDecryptionProof reads a proof of threshold decryption.
+func (r *WireReader) ReadThresholdDecryptionProof() *ThresholdDecryptionProof {
+	h := r.header(wireThresholdDecryptionProof, 2)
+	if h == nil {
+		return nil
+	}
+	numReps, level := r.params.decryptionProofRepetitions(), int(h[1])
+	if int(h[0]) != numReps {
+		r.fail(wireThresholdDecryptionProof, "invalid number of repetitions")
+		return nil
+	}
+	if level > r.params.MaxLevel() {
+		r.fail(wireThresholdDecryptionProof, "invalid level")
+		return nil
+	}
+	digest := r.next(wireThresholdDecryptionProof, blake2b.Size256)
+	if digest == nil {
+		return nil
+	}
+
+	ringQ := r.params.RingQ()
+	proof := &ThresholdDecryptionProof{Digest: append([]byte{}, digest...), Z: make([][]*ring.Poly, numReps)}
+	for k := range proof.Z {
+		proof.Z[k] = []*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPolyLvl(level)}
+		if !r.readPoly(wireThresholdDecryptionProof, ringQ, proof.Z[k][0]) ||
+			!r.readSmallPoly(wireThresholdDecryptionProof, proof.Z[k][1], nil) ||
+			!r.readPoly(wireThresholdDecryptionProof, ringQ, proof.Z[k][2]) {
+			return nil
+		}
+	}
+	return proof
+}
+
+// ReadRelinearizationKey reads a relinearization key.
+func (r *WireReader) ReadRelinearizationKey() *RelinearizationKey {
+	if r.header(wireRelinearizationKey, 0) == nil {
+		return nil
+	}
+	rlk := new(RelinearizationKey)
+	if !r.readGadgetCiphertext(&rlk.BD) || !r.readGadgetCiphertext(&rlk.V) {
+		return nil
+	}
+	return rlk
+}
+
+func (r *WireReader) readGadgetCiphertext(ct *rlwe.GadgetCiphertext) bool {
+	h := r.next(wireRelinearizationKey, 2)
+	if h == nil {
+		return false
+	}
+	levelQ, levelP, ok := r.levels(wireRelinearizationKey, h)
+	if !ok {
+		return false
+	}
+	rows, cols := r.params.DecompRNS(levelQ, levelP), r.params.DecompPw2(levelQ, levelP)
+	ct.Value = make([][]rlwe.CiphertextQP, rows)
+	for i := range ct.Value {
+		ct.Value[i] = make([]rlwe.CiphertextQP, cols)
+		for j := range ct.Value[i] {
+			if !r.readCiphertextQP(wireRelinearizationKey, &ct.Value[i][j], levelQ, levelP) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *WireReader) levels(kind wireKind, h []byte) (levelQ, levelP int, ok bool) {
+	levelQ, levelP = int(h[0]), int(int8(h[1]))
+	if levelQ > r.params.MaxLevel() || levelP < -1 || levelP > r.params.PCount()-1 {
+		r.fail(kind, "invalid levels")
+		return 0, 0, false
+	}
+	return levelQ, levelP, true
+}
+
+func (r *WireReader) readCiphertextQP(kind wireKind, ct *rlwe.CiphertextQP, levelQ, levelP int) bool {
+	flags := r.next(kind, 1)
+	if flags == nil {
+		return false
+	}
+	setWireFlags(&ct.MetaData, flags[0])
+	for i := range ct.Value {
+		ct.Value[i] = ringqp.Poly{Q: r.params.RingQ().NewPolyLvl(levelQ)}
+		if !r.readPoly(kind, r.params.RingQ(), ct.Value[i].Q) {
+			return false
+		}
+		if levelP >= 0 {
+			ct.Value[i].P = r.params.RingP().NewPolyLvl(levelP)
+			if !r.readPoly(kind, r.params.RingP(), ct.Value[i].P) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readPoly reads the coefficients of the allocated pol, checking that they are reduced.
+func (r *WireReader) readPoly(kind wireKind, rg *ring.Ring, pol *ring.Poly) bool {
+	b := r.next(kind, 8*len(pol.Coeffs)*rg.N)
+	if b == nil {
+		return false
+	}
+	for i, coeffs := range pol.Coeffs {
+		qi := rg.Modulus[i]
+		for j := range coeffs {
+			c := binary.BigEndian.Uint64(b)
+			if c >= qi {
+				r.fail(kind, "unreduced coefficient")
+				return false
+			}
+			coeffs[j] = c
+			b = b[8:]
+		}
+	}
+	return true
+}
+
+// readSmallPoly reads the signed coefficients of the allocated polQ and sets them modulo every modulus of Q,
+// and of P if polP is not nil.
+func (r *WireReader) readSmallPoly(kind wireKind, polQ, polP *ring.Poly) bool {
+	ringQ := r.params.RingQ()
+	b := r.next(kind, 8*ringQ.N)
+	if b == nil {
+		return false
+	}
+	for j := 0; j < ringQ.N; j++ {
+		v := int64(binary.BigEndian.Uint64(b[8*j:]))
+		setSmallCoeff(ringQ, polQ, j, v)
+		if polP != nil {
+			setSmallCoeff(r.params.RingP(), polP, j, v)
+		}
+	}
+	return true
+}
+
+func (r *WireReader) readElement(kind wireKind, x field.Element) bool {
+	f := r.params.Field()
+	b := r.next(kind, f.ElementSize())
+	if b == nil {
+		return false
+	}
+	if err := f.Decode(x, b); err != nil {
+		r.fail(kind, "unreduced element")
+		return false
+	}
+	return true
+}
+
+func setWireFlags(m *rlwe.MetaData, flags byte) {
+	m.IsNTT = flags&1 != 0
+	m.IsMontgomery = flags&2 != 0
+}
@@ -0,0 +1,481 @@
+package hpbfv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+
+	"spdz-go/field"
+	"spdz-go/ring"
+	"spdz-go/rlwe"
+	"spdz-go/utils"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// The zero-knowledge proofs of plaintext knowledge (ZKPoPK) below are the amortized proofs of TopGear
+// (Baum, Cozzo and Smart, SAC 2019), made non-interactive with the Fiat-Shamir transform. They show that
+// each of U ciphertexts is a well-formed encryption
+//
+//	ct_k = (pk0*u_k + e0_k + Delta*m_k, pk1*u_k + e1_k) mod Q
+//
+// of some m_k in Z_T[X]/(X^D - B) under the public key pk, with small u_k and e_k, and that the prover
+// knows m_k and u_k. The prover encrypts V uniform masks y_j with randomness v_j whose coefficients are
+// 2^PlaintextProofSlack times larger than those of u_k and e_k, hashes the statement and the masks into
+// challenges w_jk in {0, 1, X, ..., X^(2N-1)}, and reveals z_j = y_j + sum_k w_jk*m_k and
+// t_j = v_j + sum_k w_jk*u_k. The verifier recomputes the noise of A_j + sum_k w_jk*ct_k as an encryption
+// of z_j with randomness t_j, and checks that it and t_j are small.
+//
+// Since the encoding of X^i*m is X^i times the encoding of m, and the encoding rounds each term by at most
+// 1/2, the relation is linear up to a rounding error of U/2+1 on the noise. The plaintexts are not
+// bounded: the encoding only depends on m mod (X^D - B, T), so z_j is uniform mod T and reveals nothing.
+// As in TopGear, soundness comes with a slack: the extracted randomness of 2*ct_k can be larger than an
+// honest one by a factor polynomial in N, U and 2^PlaintextProofSlack, which the parameters must absorb.
+//
+// The ciphertexts are encrypted modulo Q, without the special primes P, so that the relation is linear.
+
+const (
+	// PlaintextProofSoundness is the soundness of the proofs of plaintext knowledge in bits.
+	PlaintextProofSoundness = 128
+
+	// PlaintextProofSlack is the statistical security in bits of the zero-knowledge of the proofs.
+	PlaintextProofSlack = 40
+)
+
+// ErrInvalidProof is returned when a proof of plaintext knowledge does not verify.
+var ErrInvalidProof = errors.New("invalid proof of plaintext knowledge")
+
+// PlaintextProof is a non-interactive proof of plaintext knowledge for a batch of ciphertexts, see
+// PlaintextProver. Its size only depends on the parameters: the V masks amortize over the batch.
+type PlaintextProof struct {
+	A []*Ciphertext     // encryptions of the masks y_j
+	Z [][]field.Element // masked plaintexts z_j, as the D coefficients of polynomials mod X^D - B
+	T []*ring.Poly      // masked randomness t_j, with small coefficients
+}
+
+// plaintextProofContext holds the state shared by the prover and the verifier of a public key.
+type plaintextProofContext struct {
+	params Parameters
+	pk     *rlwe.PublicKey
+	pkHash []byte
+
+	ecd   *Encoder
+	bPows []field.Element // B^q mod T for q <= 2K
+
+	pt      *Plaintext
+	buffQ   [3]*ring.Poly
+	hashBuf []byte
+}
+
+// PlaintextProver encrypts messages under a public key and proves knowledge of their plaintexts.
+// A PlaintextProver must not be used concurrently.
+type PlaintextProver struct {
+	plaintextProofContext
+	numParties int
+
+	prng            utils.PRNG
+	gaussianSampler *ring.GaussianSampler
+	ternarySampler  *ring.TernarySampler
+}
+
+// PlaintextVerifier verifies the proofs of plaintext knowledge of ciphertexts under a public key.
+// A PlaintextVerifier must not be used concurrently.
+type PlaintextVerifier struct {
+	plaintextProofContext
+	lhs, rhs *Ciphertext
+}
+
+// NewPlaintextProver creates a PlaintextProver for the public key pk of numParties parties, whose
+// ciphertexts carry the same noise estimate as those of NewJointEncryptor.
+func NewPlaintextProver(params Parameters, pk *rlwe.PublicKey, numParties int) *PlaintextProver {
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		panic(err)
+	}
+	return &PlaintextProver{
+		plaintextProofContext: newPlaintextProofContext(params, pk),
+		numParties:            numParties,
+		prng:                  prng,
+		gaussianSampler:       ring.NewGaussianSampler(prng, params.RingQ(), params.Sigma(), int(6*params.Sigma())),
+		ternarySampler:        ring.NewTernarySamplerWithHammingWeight(prng, params.RingQ(), params.HammingWeight(), false),
+	}
+}
+
+// NewPlaintextVerifier creates a PlaintextVerifier for the public key pk.
+func NewPlaintextVerifier(params Parameters, pk *rlwe.PublicKey) *PlaintextVerifier {
+	return &PlaintextVerifier{
+		plaintextProofContext: newPlaintextProofContext(params, pk),
+		lhs:                   NewCiphertext(params, 1),
+		rhs:                   NewCiphertext(params, 1),
+	}
+}
+
+func newPlaintextProofContext(params Parameters, pk *rlwe.PublicKey) plaintextProofContext {
+	ringQ := params.RingQ()
+	pc := plaintextProofContext{
+		params:  params,
+		pk:      pk,
+		ecd:     NewEncoder(params),
+		pt:      NewPlaintext(params),
+		buffQ:   [3]*ring.Poly{ringQ.NewPoly(), ringQ.NewPoly(), ringQ.NewPoly()},
+		hashBuf: make([]byte, 8*params.N()),
+	}
+
+	f := params.Field()
+	pc.bPows = f.NewVector(2*params.N()/params.Slots() + 1)
+	b := f.NewElementFromBig(params.B())
+	f.SetUint64(pc.bPows[0], 1)
+	for q := 1; q < len(pc.bPows); q++ {
+		f.Mul(pc.bPows[q], pc.bPows[q-1], b)
+	}
+
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	hashPoly(h, ringQ, pk.Value[0].Q, pc.hashBuf)
+	hashPoly(h, ringQ, pk.Value[1].Q, pc.hashBuf)
+	pc.pkHash = h.Sum(nil)
+	return pc
+}
+
+// plaintextProofMasks returns the number V of masks of a proof of plaintext knowledge, so that the
+// (2N+1)^V challenges give PlaintextProofSoundness bits of soundness.
+func (p Parameters) plaintextProofMasks() int {
+	return int(math.Ceil(float64(PlaintextProofSoundness+2) / math.Log2(float64(2*p.N()+1))))
+}
+
+// EncryptAndProve encrypts msgs under the public key and returns the ciphertexts with a proof of
+// plaintext knowledge bound to context, which the verifier must pass as well.
+func (prover *PlaintextProver) EncryptAndProve(msgs []*Message, context []byte) (cts []*Ciphertext, proof *PlaintextProof) {
+	params := prover.params
+	ringQ := params.RingQ()
+	f := params.Field()
+	numCts, numMasks := len(msgs), params.plaintextProofMasks()
+
+	maskU, maskE, ok := params.plaintextProofMaskBounds(numCts)
+	if !ok {
+		panic(fmt.Sprintf("cannot EncryptAndProve: cannot prove %d ciphertexts at once", numCts))
+	}
+
+	e0, e1 := prover.buffQ[1], prover.buffQ[2]
+
+	ms := make([][]field.Element, numCts)
+	us := make([]*ring.Poly, numCts)
+	cts = make([]*Ciphertext, numCts)
+	for k, msg := range msgs {
+		ms[k] = f.NewVector(params.Slots())
+		prover.ecd.coeffs(msg, ms[k])
+		prover.ecd.encodeCoeffs(ms[k], prover.pt)
+
+		us[k] = ringQ.NewPoly()
+		prover.ternarySampler.Read(us[k])
+		prover.gaussianSampler.Read(e0)
+		prover.gaussianSampler.Read(e1)
+
+		cts[k] = NewCiphertext(params, 1)
+		prover.encrypt(prover.pt, us[k], e0, e1, cts[k])
+		cts[k].SetNoise(params.FreshNoise(prover.numParties), prover.numParties)
+	}
+
+	proof = &PlaintextProof{
+		A: make([]*Ciphertext, numMasks),
+		Z: make([][]field.Element, numMasks),
+		T: make([]*ring.Poly, numMasks),
+	}
+	for j := range proof.A {
+		proof.Z[j] = f.NewVector(params.Slots())
+		f.Sample(prover.prng, proof.Z[j]...)
+		prover.ecd.encodeCoeffs(proof.Z[j], prover.pt)
+
+		proof.T[j] = ringQ.NewPoly()
+		sampleBounded(prover.prng, ringQ, maskU, proof.T[j])
+		sampleBounded(prover.prng, ringQ, maskE, e0)
+		sampleBounded(prover.prng, ringQ, maskE, e1)
+
+		proof.A[j] = NewCiphertext(params, 1)
+		prover.encrypt(prover.pt, proof.T[j], e0, e1, proof.A[j])
+	}
+
+	// z_j = y_j + sum_k w_jk*m_k and t_j = v_j + sum_k w_jk*u_k
+	w := prover.challenges(cts, proof.A, context)
+	tmp, xu := f.NewElement(), prover.buffQ[0]
+	for j := range proof.A {
+		for k := range cts {
+			if w[j][k] < 0 {
+				continue
+			}
+			prover.mulAddMonomial(proof.Z[j], ms[k], w[j][k], tmp)
+			ringQ.MultByMonomial(us[k], w[j][k], xu)
+			ringQ.Add(proof.T[j], xu, proof.T[j])
+		}
+	}
+	return cts, proof
+}
+
+// Verify checks the proof of plaintext knowledge of cts bound to context, and returns an error wrapping
+// ErrInvalidProof if it does not verify.
+func (verifier *PlaintextVerifier) Verify(cts []*Ciphertext, proof *PlaintextProof, context []byte) error {
+	params := verifier.params
+	ringQ := params.RingQ()
+	numCts, numMasks := len(cts), params.plaintextProofMasks()
+
+	maskU, maskE, ok := params.plaintextProofMaskBounds(numCts)
+	if numCts == 0 || !ok {
+		return fmt.Errorf("%w: cannot verify %d ciphertexts at once", ErrInvalidProof, numCts)
+	}
+	if proof == nil || len(proof.A) != numMasks || len(proof.Z) != numMasks || len(proof.T) != numMasks {
+		return fmt.Errorf("%w: expected %d masks", ErrInvalidProof, numMasks)
+	}
+	for k, ct := range cts {
+		if !verifier.checkCiphertext(ct) {
+			return fmt.Errorf("%w: invalid shape of ciphertext %d", ErrInvalidProof, k)
+		}
+	}
+	for j := range proof.A {
+		if !verifier.checkCiphertext(proof.A[j]) || !verifier.checkCoeffs(proof.Z[j]) || proof.T[j] == nil || proof.T[j].N() != params.N() || proof.T[j].Level() != params.MaxLevel() {
+			return fmt.Errorf("%w: invalid shape of mask %d", ErrInvalidProof, j)
+		}
+	}
+
+	boundU, boundE := plaintextProofBounds(maskU, maskE, numCts)
+
+	w := verifier.challenges(cts, proof.A, context)
+	lhs, rhs, xct := verifier.lhs, verifier.rhs, verifier.buffQ[0]
+	for j := range proof.A {
+		if !isSmall(ringQ, proof.T[j], boundU) {
+			return fmt.Errorf("%w: randomness of mask %d out of bounds", ErrInvalidProof, j)
+		}
+
+		// A_j + sum_k w_jk*ct_k - Enc(z_j; t_j, 0, 0)
+		for i := range lhs.Value {
+			lhs.Value[i].Copy(proof.A[j].Value[i])
+			for k, ct := range cts {
+				if w[j][k] >= 0 {
+					ringQ.MultByMonomial(ct.Value[i], w[j][k], xct)
+					ringQ.Add(lhs.Value[i], xct, lhs.Value[i])
+				}
+			}
+		}
+		verifier.ecd.encodeCoeffs(proof.Z[j], verifier.pt)
+		verifier.mulPublicKey(proof.T[j], rhs)
+		ringQ.Add(rhs.Value[0], verifier.pt.Value, rhs.Value[0])
+
+		for i := range lhs.Value {
+			ringQ.Sub(lhs.Value[i], rhs.Value[i], lhs.Value[i])
+			if !isSmall(ringQ, lhs.Value[i], boundE) {
+				return fmt.Errorf("%w: noise of mask %d out of bounds", ErrInvalidProof, j)
+			}
+		}
+	}
+	return nil
+}
+
+// plaintextProofMaskBounds returns the bounds on the coefficients of the masks of the randomness u and of
+// the noise e for a proof of numCts ciphertexts, and false if they are too large to be checked modulo the
+// first prime of Q.
+func (p Parameters) plaintextProofMaskBounds(numCts int) (maskU, maskE uint64, ok bool) {
+	if numCts < 0 || numCts > 1<<16 {
+		return 0, 0, false
+	}
+	maskU = uint64(numCts) << PlaintextProofSlack
+	maskE = maskU * uint64(6*p.Sigma())
+	_, boundE := plaintextProofBounds(maskU, maskE, numCts)
+	return maskU, maskE, boundE < p.RingQ().Modulus[0]>>1
+}
+
+// plaintextProofBounds returns the bounds that Verify accepts on the masked randomness t_j and on the noise
+// of A_j + sum_k w_jk*ct_k - Enc(z_j; t_j), of which the honest ones are at most maskU + U and
+// maskE + U*6*sigma + U/2 + 1.
+func plaintextProofBounds(maskU, maskE uint64, numCts int) (boundU, boundE uint64) {
+	return 2 * maskU, 2*maskE + uint64(numCts) + 1
+}
+
+// ProvenNoise returns the estimated noise of a ciphertext under the public key of numParties parties that
+// was verified by a proof of plaintext knowledge of numCts ciphertexts. The proof does not show that the
+// ciphertext is a fresh encryption: its randomness and errors can be as large as the bounds that Verify
+// accepts, which carry the soundness slack of 2^PlaintextProofSlack, and the estimate takes them as such.
+// Noise flooding must hide this noise rather than FreshNoise, which only holds for honest parties.
+func (p Parameters) ProvenNoise(numCts, numParties int) float64 {
+	maskU, maskE, _ := p.plaintextProofMaskBounds(numCts)
+	boundU, boundE := plaintextProofBounds(maskU, maskE, numCts)
+	// u*e_pk + e0 + e1*s, with u and the errors as large as the bounds and every secret of Hamming weight H
+	logU, logE := math.Log2(float64(boundU)), math.Log2(float64(boundE))
+	logUE := float64(p.LogN()) + 2*logU + math.Log2(float64(numParties)) + 2*math.Log2(p.Sigma())
+	return 0.5 * logSum(logUE, 2*logE+math.Log2(1+float64(numParties*p.HammingWeight())))
+}
+
+func (pc *plaintextProofContext) checkCiphertext(ct *Ciphertext) bool {
+	return ct != nil && ct.Ciphertext != nil && ct.Degree() == 1 && ct.Level() == pc.params.MaxLevel() && !ct.IsNTT
+}
+
+func (pc *plaintextProofContext) checkCoeffs(coeffs []field.Element) bool {
+	if len(coeffs) != pc.params.Slots() {
+		return false
+	}
+	for _, x := range coeffs {
+		if len(x) != pc.params.Field().Limbs() {
+			return false
+		}
+	}
+	return true
+}
+
+// encrypt sets ct to (pk0*u + e0 + pt, pk1*u + e1), for u, e0 and e1 in the coefficient domain.
+func (pc *plaintextProofContext) encrypt(pt *Plaintext, u, e0, e1 *ring.Poly, ct *Ciphertext) {
+	ringQ := pc.params.RingQ()
+	pc.mulPublicKey(u, ct)
+	ringQ.Add(ct.Value[0], e0, ct.Value[0])
+	ringQ.Add(ct.Value[1], e1, ct.Value[1])
+	ringQ.Add(ct.Value[0], pt.Value, ct.Value[0])
+}
+
+// mulPublicKey sets ct to (pk0*u, pk1*u), for u in the coefficient domain.
+func (pc *plaintextProofContext) mulPublicKey(u *ring.Poly, ct *Ciphertext) {
+	ringQ := pc.params.RingQ()
+	uNTT := pc.buffQ[0]
+	ringQ.NTT(u, uNTT)
+	for i := range ct.Value {
+		ringQ.MulCoeffsMontgomery(uNTT, pc.pk.Value[i].Q, ct.Value[i])
+		ringQ.InvNTT(ct.Value[i], ct.Value[i])
+	}
+}
+
+// mulAddMonomial sets z = z + X^i*m mod (X^D - B, T) for 0 <= i < 2N, where z and m are given by their
+// D coefficients. Writing i = q*D + r, X^i*m = B^q * X^r*m and the terms of X^r*m of degree at least D
+// wrap around with a factor B.
+func (pc *plaintextProofContext) mulAddMonomial(z, m []field.Element, i int, tmp field.Element) {
+	f := pc.params.Field()
+	d := len(m)
+	q, r := i/d, i%d
+	for j := range m {
+		if j+r < d {
+			f.Add(z[j+r], z[j+r], f.Mul(tmp, m[j], pc.bPows[q]))
+		} else {
+			f.Add(z[j+r-d], z[j+r-d], f.Mul(tmp, m[j], pc.bPows[q+1]))
+		}
+	}
+}
+
+// challenges derives the challenges w_jk from the public key, context, ciphertexts and encrypted masks,
+// as the degree i of the monomial X^i, or -1 for zero.
+func (pc *plaintextProofContext) challenges(cts, masks []*Ciphertext, context []byte) [][]int {
+	xof, err := blake2b.NewXOF(blake2b.OutputLengthUnknown, nil)
+	if err != nil {
+		panic(err)
+	}
+	fp := pc.params.Fingerprint()
+	xof.Write([]byte("hpbfv/plaintext-proof"))
+	xof.Write(fp[:])
+	xof.Write(pc.pkHash)
+	var lengths [24]byte
+	binary.BigEndian.PutUint64(lengths[0:], uint64(len(context)))
+	binary.BigEndian.PutUint64(lengths[8:], uint64(len(cts)))
+	binary.BigEndian.PutUint64(lengths[16:], uint64(len(masks)))
+	xof.Write(lengths[:])
+	xof.Write(context)
+	for _, ct := range append(append([]*Ciphertext{}, cts...), masks...) {
+		for _, pol := range ct.Value {
+			hashPoly(xof, pc.params.RingQ(), pol, pc.hashBuf)
+		}
+	}
+
+	w := make([][]int, len(masks))
+	for j := range w {
+		w[j] = make([]int, len(cts))
+		for k := range w[j] {
+			w[j][k] = readMonomialChallenge(xof, pc.params.N())
+		}
+	}
+	return w
+}
+
+// readMonomialChallenge reads from xof a challenge uniform in {0, 1, X, ..., X^(2N-1)}, returned as the
+// degree of the monomial, or -1 for zero.
+func readMonomialChallenge(xof io.Reader, n int) int {
+	// uniform in [0, 2N] by rejection
+	bound := uint32(2*n + 1)
+	mask := uint32(1)<<bits.Len32(bound-1) - 1
+	var buf [4]byte
+	for {
+		if _, err := io.ReadFull(xof, buf[:]); err != nil {
+			panic(err)
+		}
+		if x := binary.BigEndian.Uint32(buf[:]) & mask; x < bound {
+			return int(x) - 1
+		}
+	}
+}
+
+// hashPoly writes the reduced coefficients of pol modulo the moduli of r to h, using buf of 8*N bytes.
+func hashPoly(h io.Writer, r *ring.Ring, pol *ring.Poly, buf []byte) {
+	for i, coeffs := range pol.Coeffs {
+		qi := r.Modulus[i]
+		for j, c := range coeffs {
+			binary.BigEndian.PutUint64(buf[8*j:], c%qi)
+		}
+		h.Write(buf[:8*len(coeffs)])
+	}
+}
+
+// sampleBounded sets the coefficients of pol to integers uniform in [-bound, bound].
+func sampleBounded(prng utils.PRNG, ringQ *ring.Ring, bound uint64, pol *ring.Poly) {
+	mask := uint64(1)<<bits.Len64(2*bound) - 1
+	var buf [8]byte
+	for j := 0; j < ringQ.N; j++ {
+		for {
+			if _, err := prng.Read(buf[:]); err != nil {
+				panic(err)
+			}
+			if x := binary.BigEndian.Uint64(buf[:]) & mask; x <= 2*bound {
+				setSmallCoeff(ringQ, pol, j, int64(x)-int64(bound))
+				break
+			}
+		}
+	}
+}
+
+// setSmallCoeff sets the j-th coefficient of pol to the integer v.
+func setSmallCoeff(r *ring.Ring, pol *ring.Poly, j int, v int64) {
+	for i, qi := range r.Modulus[:pol.Level()+1] {
+		pol.Coeffs[i][j] = smallMod(v, qi)
+	}
+}
+
+// isSmall reports whether the coefficients of pol are integers in [-bound, bound], for bound < q_0/2:
+// each coefficient must be the same integer of [-bound, bound] modulo every prime of Q.
+func isSmall(ringQ *ring.Ring, pol *ring.Poly, bound uint64) bool {
+	q0 := ringQ.Modulus[0]
+	for j, c := range pol.Coeffs[0] {
+		v := centered(c, q0)
+		if v < -int64(bound) || v > int64(bound) {
+			return false
+		}
+		for i := 1; i < len(pol.Coeffs); i++ {
+			if qi := ringQ.Modulus[i]; pol.Coeffs[i][j]%qi != smallMod(v, qi) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// centered returns the representative of c mod q in (-q/2, q/2].
+func centered(c, q uint64) int64 {
+	c %= q
+	if c > q>>1 {
+		return -int64(q - c)
+	}
+	return int64(c)
+}
+
+// smallMod returns v mod q in [0, q).
+func smallMod(v int64, q uint64) uint64 {
+	if v >= 0 {
+		return uint64(v) % q
+	}
+	return (q - uint64(-v)%q) % q
+}
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// GenerateIdentity generates a static Ed25519 identity for a party as a self-signed certificate
+// valid for validity, and returns the PEM encodings of the certificate and of its private key.
+// Parties pin each other's certificates, so no certificate authority is involved.
+func GenerateIdentity(name string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// ParseCertificatePEM parses the first PEM encoded certificate of data.
+func ParseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, errors.New("cannot ParseCertificatePEM: no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// LoadCertificateFile reads and parses the PEM encoded certificate in file.
+func LoadCertificateFile(file string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseCertificatePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return cert, nil
+}
+
+// IdentityKeys returns the Ed25519 identity key of the local party of cfg and the public identity keys
+// of all parties, as used by NewSignedTransport.
+func IdentityKeys(cfg PartyConfig) (ed25519.PrivateKey, []ed25519.PublicKey, error) {
+	key, ok := cfg.Certificate.PrivateKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("cannot IdentityKeys: the local identity is not an Ed25519 key")
+	}
+	pubs := make([]ed25519.PublicKey, len(cfg.Certificates))
+	for j, cert := range cfg.Certificates {
+		if pubs[j], ok = cert.PublicKey.(ed25519.PublicKey); !ok {
+			return nil, nil, fmt.Errorf("cannot IdentityKeys: the identity of party %d is not an Ed25519 key", j)
+		}
+	}
+	return key, pubs, nil
+}
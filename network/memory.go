@@ -0,0 +1,73 @@
+package network
+
+import (
+	"sync"
+)
+
+// MemoryTransport is a Transport between parties running in the same process.
+type MemoryTransport struct {
+	id    int
+	boxes []*mailbox // boxes[j] is the mailbox of party j
+
+	closeOnce sync.Once
+}
+
+// NewMemoryTransports creates connected in-memory transports for numParties parties,
+// the transport of party i being at index i. The mailboxes have the default Limits.
+func NewMemoryTransports(numParties int) []*MemoryTransport {
+	boxes := make([]*mailbox, numParties)
+	for i := range boxes {
+		boxes[i] = newMailbox(Limits{})
+	}
+	transports := make([]*MemoryTransport, numParties)
+	for i := range transports {
+		transports[i] = &MemoryTransport{id: i, boxes: boxes}
+	}
+	return transports
+}
+
+// ID returns the ID of the local party.
+func (tr *MemoryTransport) ID() int {
+	return tr.id
+}
+
+// NumParties returns the number of parties.
+func (tr *MemoryTransport) NumParties() int {
+	return len(tr.boxes)
+}
+
+// Send delivers a copy of payload to the mailbox of party dst. It returns an error wrapping ErrMailboxFull
+// if the mailbox of dst cannot buffer it.
+func (tr *MemoryTransport) Send(dst int, tag Tag, payload []byte) error {
+	if err := checkPeer(tr.id, len(tr.boxes), dst); err != nil {
+		return err
+	}
+	return tr.boxes[dst].put(tr.id, tag, append([]byte(nil), payload...))
+}
+
+// Broadcast delivers a copy of payload to every other party.
+func (tr *MemoryTransport) Broadcast(tag Tag, payload []byte) error {
+	for dst := range tr.boxes {
+		if dst == tr.id {
+			continue
+		}
+		if err := tr.Send(dst, tag, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive blocks until a message with tag from party src is in the local mailbox.
+func (tr *MemoryTransport) Receive(src int, tag Tag) ([]byte, error) {
+	if err := checkPeer(tr.id, len(tr.boxes), src); err != nil {
+		return nil, err
+	}
+	return tr.boxes[tr.id].get(src, tag)
+}
+
+// Close closes the local mailbox. Messages sent to a closed party are dropped.
+func (tr *MemoryTransport) Close() error {
+	tr.closeOnce.Do(tr.boxes[tr.id].close)
+	return nil
+}
@@ -0,0 +1,441 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testExchange has every party broadcast one message and send one message to each peer,
+// then receives them in the reverse order of the rounds.
+func testExchange(t *testing.T, transports []Transport) {
+	numParties := len(transports)
+	var wg sync.WaitGroup
+	for _, tr := range transports {
+		wg.Add(1)
+		go func(tr Transport) {
+			defer wg.Done()
+			id := tr.ID()
+			require.Equal(t, numParties, tr.NumParties())
+
+			require.NoError(t, tr.Broadcast(Tag{"s", "r1"}, []byte{byte(id)}))
+			for dst := 0; dst < numParties; dst++ {
+				if dst == id {
+					require.Error(t, tr.Send(dst, Tag{"s", "r2"}, nil))
+					continue
+				}
+				require.NoError(t, tr.Send(dst, Tag{"s", "r2"}, []byte(fmt.Sprintf("%d->%d", id, dst))))
+			}
+
+			for src := 0; src < numParties; src++ {
+				if src == id {
+					continue
+				}
+				msg, err := tr.Receive(src, Tag{"s", "r2"})
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("%d->%d", src, id), string(msg))
+
+				msg, err = tr.Receive(src, Tag{"s", "r1"})
+				require.NoError(t, err)
+				require.Equal(t, []byte{byte(src)}, msg)
+			}
+		}(tr)
+	}
+	wg.Wait()
+}
+
+func TestMemoryTransport(t *testing.T) {
+	mem := NewMemoryTransports(3)
+	transports := make([]Transport, len(mem))
+	for i := range mem {
+		transports[i] = mem[i]
+	}
+
+	testExchange(t, transports)
+
+	t.Run("Close", func(t *testing.T) {
+		done := make(chan error)
+		go func() {
+			_, err := mem[0].Receive(1, Tag{"s", "never"})
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, mem[0].Close())
+		require.ErrorIs(t, <-done, ErrClosed)
+	})
+}
+
+func TestTCPTransport(t *testing.T) {
+	numParties := 3
+
+	listeners := make([]net.Listener, numParties)
+	addrs := make([]string, numParties)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i] = ln
+		addrs[i] = ln.Addr().String()
+	}
+
+	transports := make([]Transport, numParties)
+	var wg sync.WaitGroup
+	for i := 0; i < numParties; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			tr, err := NewTCPTransportWithListener(id, listeners[id], addrs, 5*time.Second, Limits{})
+			require.NoError(t, err)
+			transports[id] = tr
+		}(i)
+	}
+	wg.Wait()
+
+	testExchange(t, transports)
+
+	t.Run("PeerClosed", func(t *testing.T) {
+		require.NoError(t, transports[2].Close())
+		_, err := transports[0].Receive(2, Tag{"s", "r3"})
+		require.Error(t, err)
+	})
+
+	for _, tr := range transports {
+		require.NoError(t, tr.Close())
+	}
+
+	t.Run("SilentPeer", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addrs := []string{ln.Addr().String(), "unused"}
+
+		// a connection that never greets is accepted before the one of party 1
+		silent, err := net.Dial("tcp", addrs[0])
+		require.NoError(t, err)
+		defer silent.Close()
+
+		start := time.Now()
+		errs := make(chan error, 2)
+		trs := make([]*TCPTransport, 2)
+		for i := range trs {
+			go func(id int) {
+				var err error
+				if id == 0 {
+					trs[id], err = NewTCPTransportWithListener(id, ln, addrs, 5*time.Second, Limits{})
+				} else {
+					time.Sleep(50 * time.Millisecond)
+					trs[id], err = NewTCPTransport(id, []string{addrs[0], "127.0.0.1:0"}, 5*time.Second, Limits{})
+				}
+				errs <- err
+			}(i)
+		}
+		require.NoError(t, <-errs)
+		require.NoError(t, <-errs)
+		require.Less(t, time.Since(start), 2*time.Second, "the silent connection held up the mesh")
+		for _, tr := range trs {
+			require.NoError(t, tr.Close())
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		mem := NewMemoryTransports(2)
+		require.NoError(t, mem[1].Send(0, Tag{"s", "r"}, []byte("first")))
+		require.ErrorIs(t, mem[1].Send(0, Tag{"s", "r"}, []byte("second")), ErrMailboxFull)
+		msg, err := mem[0].Receive(1, Tag{"s", "r"})
+		require.NoError(t, err)
+		require.Equal(t, []byte("first"), msg)
+	})
+
+	// party 0 accepts frames of at most 1 KiB and two messages per tag, party 1 has the default limits
+	connect := func(t *testing.T) []*TCPTransport {
+		listeners := make([]net.Listener, 2)
+		addrs := make([]string, 2)
+		for i := range listeners {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			listeners[i], addrs[i] = ln, ln.Addr().String()
+		}
+		limits := []Limits{{MaxFrameSize: 1 << 10, MaxPending: 2}, {}}
+		trs := make([]*TCPTransport, 2)
+		var wg sync.WaitGroup
+		for i := range trs {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				var err error
+				trs[id], err = NewTCPTransportWithListener(id, listeners[id], addrs, 5*time.Second, limits[id])
+				require.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+		return trs
+	}
+
+	t.Run("FrameSize", func(t *testing.T) {
+		trs := connect(t)
+		defer trs[0].Close()
+		defer trs[1].Close()
+
+		require.ErrorContains(t, trs[0].Send(1, Tag{"s", "r"}, make([]byte, 1<<10)), "maximum frame size")
+		require.NoError(t, trs[1].Send(0, Tag{"s", "small"}, make([]byte, 1<<9)))
+		require.NoError(t, trs[1].Send(0, Tag{"s", "large"}, make([]byte, 1<<10)))
+		_, err := trs[0].Receive(1, Tag{"s", "small"})
+		require.NoError(t, err)
+		_, err = trs[0].Receive(1, Tag{"s", "large"})
+		require.ErrorContains(t, err, "maximum frame size")
+	})
+
+	t.Run("Pending", func(t *testing.T) {
+		trs := connect(t)
+		defer trs[0].Close()
+		defer trs[1].Close()
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, trs[1].Send(0, Tag{"s", "r"}, []byte{byte(i)}))
+		}
+		// the third message fails the link with party 1, the buffered ones are still delivered
+		_, err := trs[0].Receive(1, Tag{"s", "other"})
+		require.ErrorIs(t, err, ErrMailboxFull)
+		for i := 0; i < 2; i++ {
+			msg, err := trs[0].Receive(1, Tag{"s", "r"})
+			require.NoError(t, err)
+			require.Equal(t, []byte{byte(i)}, msg)
+		}
+		_, err = trs[0].Receive(1, Tag{"s", "r"})
+		require.ErrorIs(t, err, ErrMailboxFull)
+	})
+}
+
+// genPartyConfigs generates an identity for each party and the matching configurations
+// with pre-bound localhost listeners.
+func genPartyConfigs(t *testing.T, numParties int) ([]PartyConfig, []net.Listener) {
+	keyPairs := make([]tls.Certificate, numParties)
+	certs := make([]*x509.Certificate, numParties)
+	for i := range certs {
+		certPEM, keyPEM, err := GenerateIdentity(fmt.Sprintf("party-%d", i), time.Hour)
+		require.NoError(t, err)
+		keyPairs[i], err = tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		certs[i], err = ParseCertificatePEM(certPEM)
+		require.NoError(t, err)
+	}
+
+	listeners := make([]net.Listener, numParties)
+	addrs := make([]string, numParties)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i] = ln
+		addrs[i] = ln.Addr().String()
+	}
+
+	cfgs := make([]PartyConfig, numParties)
+	for i := range cfgs {
+		cfgs[i] = PartyConfig{ID: i, Addrs: addrs, Certificate: keyPairs[i], Certificates: append([]*x509.Certificate{}, certs...)}
+	}
+	return cfgs, listeners
+}
+
+func TestTLSTransport(t *testing.T) {
+	numParties := 3
+
+	t.Run("Honest", func(t *testing.T) {
+		cfgs, listeners := genPartyConfigs(t, numParties)
+
+		transports := make([]Transport, numParties)
+		var wg sync.WaitGroup
+		for i := 0; i < numParties; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				tr, err := NewTLSTransportWithListener(cfgs[id], listeners[id], 5*time.Second)
+				require.NoError(t, err)
+				transports[id] = tr
+			}(i)
+		}
+		wg.Wait()
+
+		testExchange(t, transports)
+
+		for _, tr := range transports {
+			require.NoError(t, tr.Close())
+		}
+	})
+
+	t.Run("Impersonation", func(t *testing.T) {
+		cfgs, listeners := genPartyConfigs(t, numParties)
+
+		// party 2 presents party 1's identity, which party 0 must not accept as party 2
+		cfgs[2].Certificate = cfgs[1].Certificate
+
+		errs := make([]error, numParties)
+		transports := make([]*TCPTransport, numParties)
+		var wg sync.WaitGroup
+		for i := 0; i < numParties; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				transports[id], errs[id] = NewTLSTransportWithListener(cfgs[id], listeners[id], time.Second)
+			}(i)
+		}
+		wg.Wait()
+
+		require.Error(t, errs[0])
+		require.Error(t, errs[1])
+		for _, tr := range transports {
+			if tr != nil {
+				tr.Close()
+			}
+		}
+	})
+
+	t.Run("UnpinnedServer", func(t *testing.T) {
+		cfgs, listeners := genPartyConfigs(t, 2)
+		other, otherListeners := genPartyConfigs(t, 1)
+		otherListeners[0].Close()
+
+		// party 1 expects another certificate for party 0
+		cfgs[1].Certificates[0] = other[0].Certificates[0]
+
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				var tr *TCPTransport
+				if tr, errs[id] = NewTLSTransportWithListener(cfgs[id], listeners[id], time.Second); tr != nil {
+					tr.Close()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		require.Error(t, errs[0])
+		require.Error(t, errs[1])
+	})
+}
+
+func TestFrame(t *testing.T) {
+	tag := Tag{"session", "round"}
+	payload := []byte("payload")
+
+	frame, err := encodeFrame(tag, payload, DefaultMaxFrameSize)
+	require.NoError(t, err)
+
+	tagOut, payloadOut, err := readFrame(bytes.NewReader(frame), DefaultMaxFrameSize)
+	require.NoError(t, err)
+	require.Equal(t, tag, tagOut)
+	require.Equal(t, payload, payloadOut)
+
+	for i := 0; i < len(frame); i++ {
+		_, _, err = readFrame(bytes.NewReader(frame[:i]), DefaultMaxFrameSize)
+		require.Error(t, err, "truncated frame of %d bytes", i)
+	}
+
+	// inconsistent inner length
+	frame[5] = 0xff
+	_, _, err = readFrame(bytes.NewReader(frame), DefaultMaxFrameSize)
+	require.Error(t, err)
+}
+
+// genSignedTransports wraps in-memory transports of numParties parties with fresh identity keys.
+func genSignedTransports(t *testing.T, numParties int) ([]*SignedTransport, []*MemoryTransport) {
+	keys := make([]ed25519.PrivateKey, numParties)
+	pubs := make([]ed25519.PublicKey, numParties)
+	for i := range keys {
+		var err error
+		pubs[i], keys[i], err = ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+	}
+	mem := NewMemoryTransports(numParties)
+	transports := make([]*SignedTransport, numParties)
+	for i := range transports {
+		var err error
+		transports[i], err = NewSignedTransport(mem[i], keys[i], pubs)
+		require.NoError(t, err)
+	}
+	return transports, mem
+}
+
+func TestSignedTransport(t *testing.T) {
+	numParties := 3
+	signed, mem := genSignedTransports(t, numParties)
+	transports := make([]Transport, numParties)
+	for i := range signed {
+		transports[i] = signed[i]
+	}
+
+	testExchange(t, transports)
+
+	t.Run("Evidence", func(t *testing.T) {
+		tag := Tag{"s", "r3"}
+		require.NoError(t, signed[1].Send(0, tag, []byte("payload")))
+		msg, err := signed[0].ReceiveSigned(1, tag)
+		require.NoError(t, err)
+		require.NoError(t, msg.Verify(signed[0].peers[1]))
+
+		data, err := msg.MarshalBinary()
+		require.NoError(t, err)
+		var msgOut SignedMessage
+		require.NoError(t, msgOut.UnmarshalBinary(data))
+		require.Equal(t, *msg, msgOut)
+
+		// the signature binds the sender, the tag and the payload
+		require.ErrorIs(t, msgOut.Verify(signed[0].peers[2]), ErrInvalidSignature)
+		msgOut.Tag.Round = "r4"
+		require.ErrorIs(t, msgOut.Verify(signed[0].peers[1]), ErrInvalidSignature)
+		msgOut.Tag.Round, msgOut.Payload = "r3", []byte("forged")
+		require.ErrorIs(t, msgOut.Verify(signed[0].peers[1]), ErrInvalidSignature)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		// party 1 sends different payloads to parties 0 and 2 under the same tag
+		tag := Tag{"s", "r6"}
+		require.NoError(t, signed[1].Send(0, tag, []byte("payload")))
+		require.NoError(t, signed[1].Send(2, tag, []byte("other")))
+		msg0, err := signed[0].ReceiveSigned(1, tag)
+		require.NoError(t, err)
+		msg2, err := signed[2].ReceiveSigned(1, tag)
+		require.NoError(t, err)
+
+		// the signed digest relayed by party 2 proves the equivocation without the payload
+		data, err := msg2.SignedDigest().MarshalBinary()
+		require.NoError(t, err)
+		var relayed SignedDigest
+		require.NoError(t, relayed.UnmarshalBinary(data))
+		require.NoError(t, relayed.Verify(signed[0].PublicKey(1)))
+		require.True(t, msg0.SignedDigest().Conflicts(&relayed))
+		require.False(t, msg0.SignedDigest().Conflicts(msg0.SignedDigest()))
+
+		relayed.Digest = Digest([]byte("payload"))
+		require.ErrorIs(t, relayed.Verify(signed[0].PublicKey(1)), ErrInvalidSignature)
+	})
+
+	t.Run("Forged", func(t *testing.T) {
+		// party 2 bypasses the signature, or replays a signature of party 1
+		tag := Tag{"s", "r5"}
+		require.NoError(t, mem[2].Send(0, tag, []byte("unsigned")))
+		_, err := signed[0].Receive(2, tag)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+
+		require.NoError(t, signed[1].Send(2, tag, []byte("payload")))
+		data, err := mem[2].Receive(1, tag)
+		require.NoError(t, err)
+		require.NoError(t, mem[2].Send(0, tag, data))
+		_, err = signed[0].Receive(2, tag)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	for _, tr := range transports {
+		require.NoError(t, tr.Close())
+	}
+}
@@ -0,0 +1,221 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrInvalidSignature is returned when a message received over a SignedTransport is not signed by its sender.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// signatureDomain separates the signatures of protocol messages from other uses of the identity keys.
+const signatureDomain = "spdz-go/signed-message"
+
+// DigestSize is the size in bytes of the digest of a payload, see Digest.
+const DigestSize = blake2b.Size256
+
+// Digest returns the digest of payload covered by the signature of a message.
+func Digest(payload []byte) []byte {
+	d := blake2b.Sum256(payload)
+	return d[:]
+}
+
+// SignedMessage is a payload signed by the party that sent it under tag. Unlike the authentication of the
+// channel, the signature is transferable: any party holding the public key of the sender can check that the
+// sender sent the payload, which lets a party prove the misbehaviour of another.
+type SignedMessage struct {
+	Sender    int
+	Tag       Tag
+	Payload   []byte
+	Signature []byte
+}
+
+// signedBytes returns the bytes covered by the signature of a message, which sign the digest of its payload
+// so that the signature can be checked without the payload, see SignedDigest.
+func signedBytes(sender int, tag Tag, digest []byte) []byte {
+	b := make([]byte, 0, len(signatureDomain)+12+len(tag.Session)+len(tag.Round)+len(digest))
+	b = append(b, signatureDomain...)
+	b = binary.BigEndian.AppendUint32(b, uint32(sender))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(tag.Session)))
+	b = append(b, tag.Session...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(tag.Round)))
+	b = append(b, tag.Round...)
+	return append(b, digest...)
+}
+
+// Verify checks the signature of msg under the public key pub of its sender, and returns an error
+// wrapping ErrInvalidSignature if it does not verify.
+func (msg *SignedMessage) Verify(pub ed25519.PublicKey) error {
+	return msg.SignedDigest().Verify(pub)
+}
+
+// SignedDigest returns the signature of msg on the digest of its payload.
+func (msg *SignedMessage) SignedDigest() *SignedDigest {
+	return &SignedDigest{Sender: msg.Sender, Tag: msg.Tag, Digest: Digest(msg.Payload), Signature: msg.Signature}
+}
+
+// SignedDigest is the signature of a message without its payload, which proves that the sender sent a
+// payload with this digest under tag. Two signed digests of a sender that differ under the same tag prove
+// that it sent different messages to different parties.
+type SignedDigest struct {
+	Sender    int
+	Tag       Tag
+	Digest    []byte
+	Signature []byte
+}
+
+// Verify checks the signature of d under the public key pub of its sender, and returns an error
+// wrapping ErrInvalidSignature if it does not verify.
+func (d *SignedDigest) Verify(pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize || len(d.Digest) != DigestSize || !ed25519.Verify(pub, signedBytes(d.Sender, d.Tag, d.Digest), d.Signature) {
+		return fmt.Errorf("%w of party %d on %s", ErrInvalidSignature, d.Sender, d.Tag)
+	}
+	return nil
+}
+
+// Conflicts returns true if d and other are signatures of the same sender on different payloads under
+// the same tag. It does not check the signatures.
+func (d *SignedDigest) Conflicts(other *SignedDigest) bool {
+	return d.Sender == other.Sender && d.Tag == other.Tag && !bytes.Equal(d.Digest, other.Digest)
+}
+
+// MarshalBinary encodes d as the signed message of MarshalBinary whose payload is the digest.
+func (d *SignedDigest) MarshalBinary() ([]byte, error) {
+	return (&SignedMessage{Sender: d.Sender, Tag: d.Tag, Payload: d.Digest, Signature: d.Signature}).MarshalBinary()
+}
+
+// UnmarshalBinary decodes d from the encoding of MarshalBinary.
+func (d *SignedDigest) UnmarshalBinary(data []byte) error {
+	var msg SignedMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if len(msg.Payload) != DigestSize {
+		return errors.New("cannot UnmarshalBinary: invalid digest size")
+	}
+	*d = SignedDigest{Sender: msg.Sender, Tag: msg.Tag, Digest: msg.Payload, Signature: msg.Signature}
+	return nil
+}
+
+// MarshalBinary encodes msg as
+//
+//	uint32 sender | uint16 len(session) | session | uint16 len(round) | round | signature | payload
+func (msg *SignedMessage) MarshalBinary() ([]byte, error) {
+	if len(msg.Signature) != ed25519.SignatureSize || len(msg.Tag.Session) > 0xffff || len(msg.Tag.Round) > 0xffff {
+		return nil, errors.New("cannot MarshalBinary: invalid signed message")
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(msg.Sender))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(msg.Tag.Session)))
+	b = append(b, msg.Tag.Session...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(msg.Tag.Round)))
+	b = append(b, msg.Tag.Round...)
+	b = append(b, msg.Signature...)
+	return append(b, msg.Payload...), nil
+}
+
+// UnmarshalBinary decodes msg from the encoding of MarshalBinary.
+func (msg *SignedMessage) UnmarshalBinary(data []byte) error {
+	errShort := errors.New("cannot UnmarshalBinary: signed message too short")
+	if len(data) < 6 {
+		return errShort
+	}
+	sender := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	var fields [2]string
+	for i := range fields {
+		if len(data) < 2 {
+			return errShort
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			return errShort
+		}
+		fields[i], data = string(data[2:2+n]), data[2+n:]
+	}
+	if len(data) < ed25519.SignatureSize {
+		return errShort
+	}
+	msg.Sender = int(sender)
+	msg.Tag = Tag{Session: fields[0], Round: fields[1]}
+	msg.Signature = append([]byte{}, data[:ed25519.SignatureSize]...)
+	msg.Payload = append([]byte{}, data[ed25519.SignatureSize:]...)
+	return nil
+}
+
+// SignedTransport is a Transport that signs every payload it sends with the identity key of the local party
+// and verifies the signature of every payload it receives. The signature is appended to the payload of
+// the underlying transport.
+type SignedTransport struct {
+	Transport
+	key   ed25519.PrivateKey
+	peers []ed25519.PublicKey
+}
+
+// NewSignedTransport wraps tr so that every message is signed with key, the identity key of the local
+// party, and checked against peers[j], the identity key of party j.
+func NewSignedTransport(tr Transport, key ed25519.PrivateKey, peers []ed25519.PublicKey) (*SignedTransport, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("cannot NewSignedTransport: invalid private key")
+	}
+	if len(peers) != tr.NumParties() {
+		return nil, fmt.Errorf("cannot NewSignedTransport: %d public keys for %d parties", len(peers), tr.NumParties())
+	}
+	for j, pub := range peers {
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("cannot NewSignedTransport: invalid public key of party %d", j)
+		}
+	}
+	return &SignedTransport{Transport: tr, key: key, peers: peers}, nil
+}
+
+// Send signs payload and sends it to party dst under tag.
+func (tr *SignedTransport) Send(dst int, tag Tag, payload []byte) error {
+	return tr.Transport.Send(dst, tag, tr.sign(tag, payload))
+}
+
+// Broadcast signs payload once and sends it to every other party under tag.
+func (tr *SignedTransport) Broadcast(tag Tag, payload []byte) error {
+	return tr.Transport.Broadcast(tag, tr.sign(tag, payload))
+}
+
+// Receive behaves as ReceiveSigned and returns the payload of the message.
+func (tr *SignedTransport) Receive(src int, tag Tag) ([]byte, error) {
+	msg, err := tr.ReceiveSigned(src, tag)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Payload, nil
+}
+
+// ReceiveSigned blocks until a message with tag has been received from party src and returns it with its
+// signature. It returns an error wrapping ErrInvalidSignature if the message is not signed by src.
+func (tr *SignedTransport) ReceiveSigned(src int, tag Tag) (*SignedMessage, error) {
+	data, err := tr.Transport.Receive(src, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w of party %d on %s: message too short", ErrInvalidSignature, src, tag)
+	}
+	n := len(data) - ed25519.SignatureSize
+	msg := &SignedMessage{Sender: src, Tag: tag, Payload: data[:n:n], Signature: data[n:]}
+	if err = msg.Verify(tr.peers[src]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// PublicKey returns the identity key of party j.
+func (tr *SignedTransport) PublicKey(j int) ed25519.PublicKey {
+	return tr.peers[j]
+}
+
+func (tr *SignedTransport) sign(tag Tag, payload []byte) []byte {
+	sig := ed25519.Sign(tr.key, signedBytes(tr.ID(), tag, Digest(payload)))
+	return append(append(make([]byte, 0, len(payload)+len(sig)), payload...), sig...)
+}
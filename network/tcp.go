@@ -0,0 +1,363 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// greetTimeout bounds the time an accepted connection has to send its greeting.
+const greetTimeout = 10 * time.Second
+
+// TCPTransport is a Transport over a full mesh of TCP connections. Every frame is length-prefixed:
+//
+//	uint32 length | uint16 len(session) | session | uint16 len(round) | round | payload
+//
+// where length counts the bytes following it and is at most the MaxFrameSize of the limits of the
+// transport. A connection is opened by the party with the larger ID, which first sends its ID as a uint32.
+// A peer sending a larger frame, or more messages than the limits allow, is disconnected.
+type TCPTransport struct {
+	id       int
+	listener net.Listener
+	conns    []net.Conn
+	writeMu  []sync.Mutex
+	limits   Limits
+	box      *mailbox
+
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewTCPTransport listens on addrs[id] and connects to every other party at addrs[j],
+// retrying until timeout for the peers that are not up yet. The messages of the peers are bounded by limits.
+func NewTCPTransport(id int, addrs []string, timeout time.Duration, limits Limits) (*TCPTransport, error) {
+	if id < 0 || id >= len(addrs) {
+		return nil, fmt.Errorf("cannot NewTCPTransport: invalid party %d for %d addresses", id, len(addrs))
+	}
+	ln, err := net.Listen("tcp", addrs[id])
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewTCPTransport: %w", err)
+	}
+	return NewTCPTransportWithListener(id, ln, addrs, timeout, limits)
+}
+
+// NewTCPTransportWithListener is NewTCPTransport with an already bound listener for the local party;
+// addrs[id] is ignored. The transport takes ownership of the listener.
+func NewTCPTransportWithListener(id int, ln net.Listener, addrs []string, timeout time.Duration, limits Limits) (*TCPTransport, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	hooks := connHooks{
+		dial: func(j int, addr string) (net.Conn, error) {
+			return dialer.Dial("tcp", addr)
+		},
+	}
+	return newConnTransport(id, ln, addrs, timeout, limits, hooks)
+}
+
+// connHooks customizes how newConnTransport secures its connections.
+type connHooks struct {
+	// dial connects to party j at addr.
+	dial func(j int, addr string) (net.Conn, error)
+	// server, if not nil, wraps every accepted connection before the peer greets.
+	server func(conn net.Conn) net.Conn
+	// verify, if not nil, is called on every accepted connection with the ID the peer claims,
+	// and the connection is dropped if it returns an error.
+	verify func(conn net.Conn, j int) error
+}
+
+// newConnTransport establishes the mesh of connections using ln to accept peers with larger IDs
+// and hooks.dial to connect to peers with smaller IDs.
+func newConnTransport(id int, ln net.Listener, addrs []string, timeout time.Duration, limits Limits, hooks connHooks) (*TCPTransport, error) {
+	numParties := len(addrs)
+	limits = limits.withDefaults()
+	tr := &TCPTransport{
+		id:       id,
+		listener: ln,
+		conns:    make([]net.Conn, numParties),
+		writeMu:  make([]sync.Mutex, numParties),
+		limits:   limits,
+		box:      newMailbox(limits),
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	accepted := make(chan error, 1)
+	go func() {
+		accepted <- tr.acceptPeers(deadline, hooks)
+	}()
+
+	var err error
+	for j := 0; j < id && err == nil; j++ {
+		err = tr.dialPeer(j, addrs[j], deadline, hooks.dial)
+	}
+	if err != nil {
+		// unblock acceptPeers
+		ln.Close()
+	}
+	if acceptErr := <-accepted; err == nil {
+		err = acceptErr
+	}
+	if err != nil {
+		tr.Close()
+		return nil, fmt.Errorf("cannot connect party %d: %w", id, err)
+	}
+
+	for j, conn := range tr.conns {
+		if conn == nil {
+			continue
+		}
+		tr.wg.Add(1)
+		go tr.readLoop(j, conn)
+	}
+	return tr, nil
+}
+
+func (tr *TCPTransport) dialPeer(j int, addr string, deadline time.Time, dial func(j int, addr string) (net.Conn, error)) error {
+	for {
+		conn, err := dial(j, addr)
+		if err == nil {
+			hello := make([]byte, 4)
+			binary.BigEndian.PutUint32(hello, uint32(tr.id))
+			if _, err = conn.Write(hello); err != nil {
+				conn.Close()
+				return fmt.Errorf("cannot greet party %d: %w", j, err)
+			}
+			tr.conns[j] = conn
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cannot dial party %d at %s: %w", j, addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// acceptPeers accepts the connections of the peers with larger IDs until all of them have greeted.
+// Connections are accepted by a separate goroutine and greeted concurrently, each within greetTimeout,
+// so that a connection that never greets does not hold up the others.
+func (tr *TCPTransport) acceptPeers(deadline time.Time, hooks connHooks) error {
+	numParties := len(tr.conns)
+	if dl, ok := tr.listener.(interface{ SetDeadline(time.Time) error }); ok {
+		dl.SetDeadline(deadline)
+		defer dl.SetDeadline(time.Time{})
+	}
+
+	type greeting struct {
+		j    int
+		conn net.Conn
+	}
+	done := make(chan struct{})
+	defer close(done)
+	accepted := make(chan net.Conn)
+	acceptErr := make(chan error, 1)
+	greeted := make(chan greeting)
+
+	// the accepting goroutine runs until the listener fails, at the deadline or when the transport is closed,
+	// and drops the connections accepted once the mesh is set up
+	tr.wg.Add(1)
+	go func() {
+		defer tr.wg.Done()
+		for {
+			conn, err := tr.listener.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			select {
+			case accepted <- conn:
+			case <-done:
+				conn.Close()
+			}
+		}
+	}()
+
+	greet := func(conn net.Conn) {
+		if hooks.server != nil {
+			conn = hooks.server(conn)
+		}
+		greetDeadline := time.Now().Add(greetTimeout)
+		if deadline.Before(greetDeadline) {
+			greetDeadline = deadline
+		}
+		conn.SetDeadline(greetDeadline)
+		hello := make([]byte, 4)
+		j := -1
+		if _, err := io.ReadFull(conn, hello); err == nil {
+			j = int(binary.BigEndian.Uint32(hello))
+			if j <= tr.id || j >= numParties || (hooks.verify != nil && hooks.verify(conn, j) != nil) {
+				j = -1
+			}
+		}
+		conn.SetDeadline(time.Time{})
+		select {
+		case greeted <- greeting{j: j, conn: conn}:
+		case <-done:
+			conn.Close()
+		}
+	}
+
+	for remaining := numParties - 1 - tr.id; remaining > 0; {
+		select {
+		case conn := <-accepted:
+			go greet(conn)
+		case g := <-greeted:
+			if g.j < 0 || tr.conns[g.j] != nil {
+				g.conn.Close()
+				continue
+			}
+			tr.conns[g.j] = g.conn
+			remaining--
+		case err := <-acceptErr:
+			return fmt.Errorf("cannot accept peers: %w", err)
+		}
+	}
+	return nil
+}
+
+// ID returns the ID of the local party.
+func (tr *TCPTransport) ID() int {
+	return tr.id
+}
+
+// NumParties returns the number of parties.
+func (tr *TCPTransport) NumParties() int {
+	return len(tr.conns)
+}
+
+// Addr returns the address the transport listens on.
+func (tr *TCPTransport) Addr() net.Addr {
+	return tr.listener.Addr()
+}
+
+// Send writes a frame with tag and payload on the connection to party dst.
+func (tr *TCPTransport) Send(dst int, tag Tag, payload []byte) error {
+	if err := checkPeer(tr.id, len(tr.conns), dst); err != nil {
+		return err
+	}
+
+	frame, err := encodeFrame(tag, payload, tr.limits.MaxFrameSize)
+	if err != nil {
+		return err
+	}
+
+	tr.writeMu[dst].Lock()
+	defer tr.writeMu[dst].Unlock()
+	if _, err = tr.conns[dst].Write(frame); err != nil {
+		return fmt.Errorf("cannot send %s to party %d: %w", tag, dst, err)
+	}
+	return nil
+}
+
+// Broadcast sends payload to every other party.
+func (tr *TCPTransport) Broadcast(tag Tag, payload []byte) error {
+	for dst := range tr.conns {
+		if dst == tr.id {
+			continue
+		}
+		if err := tr.Send(dst, tag, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive blocks until a frame with tag has been read from the connection to party src.
+func (tr *TCPTransport) Receive(src int, tag Tag) ([]byte, error) {
+	if err := checkPeer(tr.id, len(tr.conns), src); err != nil {
+		return nil, err
+	}
+	return tr.box.get(src, tag)
+}
+
+// Close closes the listener and all connections, and waits for the readers to return.
+func (tr *TCPTransport) Close() error {
+	tr.closeOnce.Do(func() {
+		tr.box.close()
+		tr.listener.Close()
+		for _, conn := range tr.conns {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	})
+	tr.wg.Wait()
+	return nil
+}
+
+// readLoop reads the frames of party src until the connection fails or src exceeds the limits of the
+// transport, in which case the connection is closed.
+func (tr *TCPTransport) readLoop(src int, conn net.Conn) {
+	defer tr.wg.Done()
+	for {
+		tag, payload, err := readFrame(conn, tr.limits.MaxFrameSize)
+		if err == nil {
+			err = tr.box.put(src, tag, payload)
+		}
+		if err != nil {
+			tr.box.fail(src, err)
+			conn.Close()
+			return
+		}
+	}
+}
+
+// encodeFrame encodes a frame of tag and payload of at most maxSize bytes.
+func encodeFrame(tag Tag, payload []byte, maxSize int) ([]byte, error) {
+	if len(tag.Session) > 0xffff || len(tag.Round) > 0xffff {
+		return nil, errors.New("cannot encode frame: tag too long")
+	}
+	length := 2 + len(tag.Session) + 2 + len(tag.Round) + len(payload)
+	if length > maxSize {
+		return nil, fmt.Errorf("cannot encode frame: %d bytes exceeds the maximum frame size of %d bytes", length, maxSize)
+	}
+
+	frame := make([]byte, 4, 4+length)
+	binary.BigEndian.PutUint32(frame, uint32(length))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(tag.Session)))
+	frame = append(frame, tag.Session...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(tag.Round)))
+	frame = append(frame, tag.Round...)
+	frame = append(frame, payload...)
+	return frame, nil
+}
+
+// readFrame reads a frame of at most maxSize bytes from r, and fails before allocating it if it is larger.
+func readFrame(r io.Reader, maxSize int) (tag Tag, payload []byte, err error) {
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(header)
+	if uint64(length) > uint64(maxSize) {
+		return tag, nil, fmt.Errorf("frame of %d bytes exceeds the maximum frame size of %d bytes", length, maxSize)
+	}
+
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+
+	var session, round []byte
+	if session, data, err = readField(data); err != nil {
+		return
+	}
+	if round, data, err = readField(data); err != nil {
+		return
+	}
+	return Tag{Session: string(session), Round: string(round)}, data, nil
+}
+
+// readField reads a uint16 length-prefixed field from data and returns the field and the remaining bytes.
+func readField(data []byte) (field, rest []byte, err error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated frame")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, errors.New("truncated frame")
+	}
+	return data[2 : 2+n], data[2+n:], nil
+}
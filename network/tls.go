@@ -0,0 +1,100 @@
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// PartyConfig is the network configuration of a party in a mutually authenticated session:
+// the addresses of all parties, the local certificate, the pinned certificates of all parties
+// and the limits on the messages of the peers.
+type PartyConfig struct {
+	ID           int
+	Addrs        []string            // Addrs[j] is the address party j listens on
+	Certificate  tls.Certificate     // certificate and private key of the local party
+	Certificates []*x509.Certificate // Certificates[j] is the pinned certificate of party j
+	Limits       Limits
+}
+
+// NewTLSTransport is NewTCPTransport over mutually authenticated TLS 1.3 connections. Every connection
+// is bound to a party ID: a peer is accepted as party j only if it presents the certificate pinned
+// for party j, and the local party only talks to party j if it presents that certificate when dialed.
+func NewTLSTransport(cfg PartyConfig, timeout time.Duration) (*TCPTransport, error) {
+	if cfg.ID < 0 || cfg.ID >= len(cfg.Addrs) {
+		return nil, fmt.Errorf("cannot NewTLSTransport: invalid party %d for %d addresses", cfg.ID, len(cfg.Addrs))
+	}
+	ln, err := net.Listen("tcp", cfg.Addrs[cfg.ID])
+	if err != nil {
+		return nil, fmt.Errorf("cannot NewTLSTransport: %w", err)
+	}
+	return NewTLSTransportWithListener(cfg, ln, timeout)
+}
+
+// NewTLSTransportWithListener is NewTLSTransport with an already bound TCP listener for the local party;
+// cfg.Addrs[cfg.ID] is ignored. The transport takes ownership of the listener.
+func NewTLSTransportWithListener(cfg PartyConfig, ln net.Listener, timeout time.Duration) (*TCPTransport, error) {
+	if len(cfg.Certificates) != len(cfg.Addrs) {
+		ln.Close()
+		return nil, fmt.Errorf("cannot NewTLSTransport: %d certificates for %d parties", len(cfg.Certificates), len(cfg.Addrs))
+	}
+
+	serverConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{cfg.Certificate},
+		// Client certificates are pinned rather than verified against a CA.
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for j, cert := range cfg.Certificates {
+				if j != cfg.ID && len(rawCerts) > 0 && bytes.Equal(rawCerts[0], cert.Raw) {
+					return nil
+				}
+			}
+			return errors.New("unknown client certificate")
+		},
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	hooks := connHooks{}
+	hooks.dial = func(j int, addr string) (net.Conn, error) {
+		clientConfig := &tls.Config{
+			MinVersion:   tls.VersionTLS13,
+			Certificates: []tls.Certificate{cfg.Certificate},
+			// The server certificate is pinned rather than verified against a CA.
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: pinnedVerifier(cfg.Certificates[j], j),
+		}
+		return tls.DialWithDialer(dialer, "tcp", addr, clientConfig)
+	}
+
+	hooks.server = func(conn net.Conn) net.Conn {
+		return tls.Server(conn, serverConfig)
+	}
+	hooks.verify = func(conn net.Conn, j int) error {
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			return errors.New("not a TLS connection")
+		}
+		state := tlsConn.ConnectionState()
+		if len(state.PeerCertificates) == 0 || !state.PeerCertificates[0].Equal(cfg.Certificates[j]) {
+			return fmt.Errorf("peer claiming to be party %d presented another certificate", j)
+		}
+		return nil
+	}
+
+	return newConnTransport(cfg.ID, ln, cfg.Addrs, timeout, cfg.Limits, hooks)
+}
+
+// pinnedVerifier returns a certificate verifier accepting only the pinned certificate of party j.
+func pinnedVerifier(pinned *x509.Certificate, j int) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], pinned.Raw) {
+			return fmt.Errorf("party %d presented an unexpected certificate", j)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,178 @@
+// Package network implements the transports over which the parties of the SPDZ protocols exchange messages.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrClosed is returned by the operations on a closed Transport.
+var ErrClosed = errors.New("transport closed")
+
+// Tag identifies a protocol message: the session it belongs to and the round within the session.
+// A receiver matches messages by sender and tag, so rounds may be received in any order.
+type Tag struct {
+	Session string
+	Round   string
+}
+
+func (tag Tag) String() string {
+	return fmt.Sprintf("%s/%s", tag.Session, tag.Round)
+}
+
+// Transport is a reliable authenticated point-to-point channel between a party and each of its peers.
+// Parties are identified by their IDs in [0, NumParties()).
+type Transport interface {
+	// ID returns the ID of the local party.
+	ID() int
+	// NumParties returns the number of parties, including the local party.
+	NumParties() int
+	// Send sends payload to party dst under tag.
+	Send(dst int, tag Tag, payload []byte) error
+	// Broadcast sends payload to every other party under tag.
+	Broadcast(tag Tag, payload []byte) error
+	// Receive blocks until a message with tag has been received from party src and returns its payload.
+	Receive(src int, tag Tag) ([]byte, error)
+	// Close releases the resources of the transport and unblocks pending calls to Receive.
+	Close() error
+}
+
+// ErrMailboxFull is returned when a peer sends more messages than a transport buffers for it, see Limits.
+var ErrMailboxFull = errors.New("mailbox full")
+
+const (
+	// DefaultMaxFrameSize is the size in bytes of the largest frame accepted by a transport whose Limits
+	// leave it unset. It fits the messages of the preprocessing with the parameters of N = 2^14 and a modulus
+	// of three primes, the limit for other parameters follows from them, see protocol.TransportLimits.
+	DefaultMaxFrameSize = 1 << 25
+	// DefaultMaxPending is the number of messages buffered per sender and tag by a transport whose Limits
+	// leave it unset. The protocols send one message per sender and tag.
+	DefaultMaxPending = 1
+)
+
+// Limits bounds the memory that the peers of a transport can make it allocate before the messages are
+// received, and so before any protocol check. A peer exceeding the limits is treated as a failed link.
+// The zero value of a field selects its default.
+type Limits struct {
+	// MaxFrameSize is the size in bytes of the largest frame, tag included, accepted from a peer.
+	// It defaults to DefaultMaxFrameSize.
+	MaxFrameSize int
+	// MaxPending is the number of messages buffered per sender, session and round.
+	// It defaults to DefaultMaxPending.
+	MaxPending int
+	// MaxPendingBytes is the number of bytes buffered per sender over all sessions and rounds.
+	// It defaults to 4*MaxFrameSize.
+	MaxPendingBytes int
+}
+
+// withDefaults returns the limits with the defaults of the unset fields.
+func (l Limits) withDefaults() Limits {
+	if l.MaxFrameSize <= 0 {
+		l.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if l.MaxPending <= 0 {
+		l.MaxPending = DefaultMaxPending
+	}
+	if l.MaxPendingBytes <= 0 {
+		l.MaxPendingBytes = 4 * l.MaxFrameSize
+	}
+	return l
+}
+
+type mailboxKey struct {
+	src int
+	tag Tag
+}
+
+// mailbox buffers received messages until they are requested by sender and tag.
+// The buffered messages are bounded by limits.
+type mailbox struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limits  Limits
+	queues  map[mailboxKey][][]byte
+	pending map[int]int   // bytes buffered for each sender
+	errs    map[int]error // error of the link with each sender
+	closed  bool
+}
+
+func newMailbox(limits Limits) *mailbox {
+	m := &mailbox{
+		limits:  limits.withDefaults(),
+		queues:  make(map[mailboxKey][][]byte),
+		pending: make(map[int]int),
+		errs:    make(map[int]error),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// put buffers payload, or drops it and returns an error wrapping ErrMailboxFull if it exceeds the limits.
+func (m *mailbox) put(src int, tag Tag, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mailboxKey{src, tag}
+	if len(m.queues[key]) >= m.limits.MaxPending {
+		return fmt.Errorf("%w: more than %d messages %s from party %d", ErrMailboxFull, m.limits.MaxPending, tag, src)
+	}
+	if m.pending[src]+len(payload) > m.limits.MaxPendingBytes {
+		return fmt.Errorf("%w: more than %d bytes from party %d", ErrMailboxFull, m.limits.MaxPendingBytes, src)
+	}
+	m.queues[key] = append(m.queues[key], payload)
+	m.pending[src] += len(payload)
+	m.cond.Broadcast()
+	return nil
+}
+
+func (m *mailbox) get(src int, tag Tag) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := mailboxKey{src, tag}
+	for {
+		if queue := m.queues[key]; len(queue) > 0 {
+			if len(queue) == 1 {
+				delete(m.queues, key)
+			} else {
+				m.queues[key] = queue[1:]
+			}
+			m.pending[src] -= len(queue[0])
+			return queue[0], nil
+		}
+		if m.closed {
+			return nil, ErrClosed
+		}
+		if err := m.errs[src]; err != nil {
+			return nil, fmt.Errorf("cannot receive %s from party %d: %w", tag, src, err)
+		}
+		m.cond.Wait()
+	}
+}
+
+// fail records that no more messages will be received from src.
+func (m *mailbox) fail(src int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.errs[src] == nil {
+		m.errs[src] = err
+	}
+	m.cond.Broadcast()
+}
+
+func (m *mailbox) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.cond.Broadcast()
+}
+
+// checkPeer returns an error if dst is not a valid peer of party id.
+func checkPeer(id, numParties, dst int) error {
+	if dst < 0 || dst >= numParties {
+		return fmt.Errorf("invalid party %d: expected an ID in [0, %d)", dst, numParties)
+	}
+	if dst == id {
+		return fmt.Errorf("invalid party %d: cannot send to self", dst)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"spdz-go/network"
+
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// AbortError is returned by a protocol step that cannot complete because of the messages of party Party
+// in round Round, such as a message that does not decode, a proof that does not verify or a commitment
+// opened incorrectly. Failures that cannot be attributed to a single party, such as a failed MAC check,
+// are not AbortErrors.
+//
+// When the messages were received by the drivers of this package over a transport that signs them, such
+// as network.SignedTransport, Evidence is the signed message of Party on which the abort was decided:
+// any party holding the identity key of Party can check that Party sent it and redo the check that failed.
+// The steps called directly with the messages of all parties, such as those of the online phase, leave
+// Evidence nil for the caller to fill in.
+//
+// When Party sent different messages to different parties in a broadcast, Err wraps ErrEquivocation and
+// Conflict is the signature of Party on another payload under the same tag, relayed by another party:
+// Evidence and Conflict together prove the equivocation.
+type AbortError struct {
+	Party    int
+	Round    string
+	Err      error
+	Evidence *network.SignedMessage
+	Conflict *network.SignedDigest
+}
+
+// ErrEquivocation is returned when a party sent different messages to different parties in a broadcast.
+var ErrEquivocation = errors.New("sent different messages to different parties")
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("abort in round %s: party %d: %v", e.Round, e.Party, e.Err)
+}
+
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// signedReceiver is implemented by the transports that sign their messages, such as network.SignedTransport.
+type signedReceiver interface {
+	ReceiveSigned(src int, tag network.Tag) (*network.SignedMessage, error)
+	PublicKey(j int) ed25519.PublicKey
+}
+
+// inbox holds the payloads received in a round indexed by sender, including the local one, and the signed
+// messages they came in if the transport signs them.
+type inbox struct {
+	tag      network.Tag
+	payloads [][]byte
+	signed   []*network.SignedMessage
+}
+
+func newInbox(tr network.Transport, tag network.Tag) *inbox {
+	return &inbox{
+		tag:      tag,
+		payloads: make([][]byte, tr.NumParties()),
+		signed:   make([]*network.SignedMessage, tr.NumParties()),
+	}
+}
+
+// echoTag returns the tag of the echo round of the broadcast received in in, see echo.
+func (in *inbox) echoTag() network.Tag {
+	return network.Tag{Session: in.tag.Session, Round: in.tag.Round + "/echo"}
+}
+
+// receive receives the message of party j, and blames j if it cannot.
+func (in *inbox) receive(tr network.Transport, j int) error {
+	var err error
+	if sr, ok := tr.(signedReceiver); ok {
+		if in.signed[j], err = sr.ReceiveSigned(j, in.tag); err == nil {
+			in.payloads[j] = in.signed[j].Payload
+		}
+	} else {
+		in.payloads[j], err = tr.Receive(j, in.tag)
+	}
+	if err != nil && !errors.Is(err, network.ErrClosed) {
+		return in.blame(j, err)
+	}
+	return err
+}
+
+// blame returns an AbortError naming party j for its message in the round of in.
+func (in *inbox) blame(j int, err error) error {
+	return &AbortError{Party: j, Round: in.tag.Round, Err: err, Evidence: in.signed[j]}
+}
+
+// check completes the AbortError returned by a protocol step on the messages of in with the round of in
+// and the evidence of the accused party. Other errors are returned unchanged.
+func (in *inbox) check(err error) error {
+	var abort *AbortError
+	if errors.As(err, &abort) && abort.Evidence == nil && abort.Party >= 0 && abort.Party < len(in.signed) {
+		abort.Round = in.tag.Round
+		abort.Evidence = in.signed[abort.Party]
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"spdz-go/field"
+
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrNoSquares is returned when a SquareSource has no authenticated square pair left.
+var ErrNoSquares = errors.New("no authenticated square pairs left")
+
+// SquareSource hands out authenticated square pairs to the online phase.
+// Every pair must be handed out at most once.
+type SquareSource interface {
+	NextAuthSquare() (*AuthSquare, error)
+}
+
+// BitBatch holds the square pairs of a batch of random bits between BitsInit and BitsFinalize.
+type BitBatch struct {
+	squares []*AuthSquare
+}
+
+// SetSquareSource sets the source of the square pairs consumed by BitsInit.
+func (e *Engine) SetSquareSource(squares SquareSource) {
+	e.squares = squares
+}
+
+// BitsInit starts the generation of n shared random bits, consuming one square pair (a, a^2) per bit.
+// It returns the shares of a^2 to be broadcast.
+func (e *Engine) BitsInit(n int) (*BitBatch, []field.Element, error) {
+	if e.squares == nil {
+		return nil, nil, fmt.Errorf("cannot BitsInit: no square source")
+	}
+
+	batch := &BitBatch{squares: make([]*AuthSquare, n)}
+	a2s := make([]AuthShare, n)
+	for k := range batch.squares {
+		square, err := e.squares.NextAuthSquare()
+		if err != nil {
+			return nil, nil, err
+		}
+		batch.squares[k] = square
+		a2s[k] = square.A2
+	}
+	return batch, e.OpenInit(a2s), nil
+}
+
+// BitsFinalize opens a^2, computes a public square root s and returns the shares of the bits
+// (a/s + 1)/2, as a/s is a uniformly random sign. Pairs with a = 0 are discarded, so fewer than
+// n bits may be returned.
+func (e *Engine) BitsFinalize(batch *BitBatch, shares [][]field.Element) ([]AuthShare, error) {
+	a2s := make([]AuthShare, len(batch.squares))
+	for k, square := range batch.squares {
+		a2s[k] = square.A2
+	}
+	opened, err := e.OpenFinalize(a2s, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	f, t := e.f, e.f.Modulus()
+	twoInv := f.Inv(f.NewElement(), f.SetUint64(f.NewElement(), 2))
+
+	bits := make([]AuthShare, 0, len(opened))
+	coeff := f.NewElement()
+	for k, a2 := range opened {
+		if f.IsZero(a2) {
+			continue
+		}
+		// the square root is only needed once per bit, so it is left to math/big
+		s := new(big.Int).ModSqrt(f.Big(a2), t)
+		if s == nil {
+			return nil, fmt.Errorf("cannot BitsFinalize: opened value %d is not a square", k)
+		}
+
+		// b = a * (2s)^-1 + 2^-1
+		f.SetBig(coeff, s.Lsh(s, 1))
+		bits = append(bits, e.AddConst(e.MulScalar(batch.squares[k].A, f.Inv(coeff, coeff)), twoInv))
+	}
+	return bits, nil
+}
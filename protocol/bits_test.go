@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+)
+
+func TestSohoBits(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numParties := 3
+
+	parties := setupSohoParties(t, params, numParties)
+
+	// Square pair generation
+	batches := make([]*SohoSquareBatch, numParties)
+	cas := make([]*hpbfv.Ciphertext, numParties)
+	csss := make([][]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for i, party := range parties {
+		batches[i], cas[i], csss[i], proofs[i] = party.SquaresRoundOne()
+	}
+	shA2s := make([]*ReshareShare, numParties)
+	shMacAs := make([]*ReshareShare, numParties)
+	for i, party := range parties {
+		var err error
+		if shA2s[i], shMacAs[i], err = party.SquaresRoundTwo(batches[i], cas, csss, proofs, 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// party 1 passes off the decryption share of party 2 as its own
+	forged := []*ReshareShare{shA2s[0], {Share: shA2s[2].Share, Proof: shA2s[1].Proof}, shA2s[2]}
+	var abort *AbortError
+	if _, err := parties[0].SquaresRoundThree(batches[0], forged, shMacAs, 40); !errors.Is(err, hpbfv.ErrInvalidDecryptionShare) || !errors.As(err, &abort) || abort.Party != 1 {
+		t.Fatalf("expected an invalid decryption share of party 1, got %v", err)
+	}
+
+	shMacA2s := make([]*ReshareShare, numParties)
+	for i, party := range parties {
+		var err error
+		if shMacA2s[i], err = party.SquaresRoundThree(batches[i], shA2s, shMacAs, 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, party := range parties {
+		if err := party.FinalizeSquares(batches[i], shMacA2s, 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := params.Field()
+	alphas := make([]field.Element, numParties)
+	alpha := f.NewElement()
+	for i, party := range parties {
+		alphas[i] = party.MacKeyShare()
+		f.Add(alpha, alpha, alphas[i])
+	}
+
+	checkMac := func(t *testing.T, name string, k int, shares []AuthShare) field.Element {
+		value, mac := f.NewElement(), f.NewElement()
+		for _, sh := range shares {
+			f.Add(value, value, sh.Value)
+			f.Add(mac, mac, sh.Mac)
+		}
+		expected := f.Mul(f.NewElement(), alpha, value)
+		if !f.Equal(mac, expected) {
+			t.Fatalf("MAC check failed for %s at index %d: mac=%s, alpha*value=%s", name, k, f.Big(mac), f.Big(expected))
+		}
+		return value
+	}
+
+	t.Run("Squares", func(t *testing.T) {
+		if len(parties[0].squares) != params.Slots() {
+			t.Fatalf("expected %d square pairs, got %d", params.Slots(), len(parties[0].squares))
+		}
+		for k := range parties[0].squares {
+			as := make([]AuthShare, numParties)
+			a2s := make([]AuthShare, numParties)
+			for i, party := range parties {
+				as[i] = party.squares[k].A
+				a2s[i] = party.squares[k].A2
+			}
+			a := checkMac(t, "a", k, as)
+			a2 := checkMac(t, "a^2", k, a2s)
+
+			expected := f.Mul(f.NewElement(), a, a)
+			if !f.Equal(a2, expected) {
+				t.Fatalf("Square check failed at index %d: a=%s, a2=%s, but a*a=%s", k, f.Big(a), f.Big(a2), f.Big(expected))
+			}
+		}
+	})
+
+	t.Run("Bits", func(t *testing.T) {
+		n := 64
+		ctx := &onlineTestContext{params: params, alphas: alphas, engines: make([]*Engine, numParties)}
+		for i, party := range parties {
+			ctx.engines[i] = NewEngine(i, params, numParties, alphas[i], NewTripleQueue(nil))
+			ctx.engines[i].SetSquareSource(party)
+		}
+
+		bitBatches := make([]*BitBatch, numParties)
+		shares := make([][]field.Element, numParties)
+		for i, e := range ctx.engines {
+			var err error
+			if bitBatches[i], shares[i], err = e.BitsInit(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		bits := make([][]AuthShare, numParties)
+		for i, e := range ctx.engines {
+			var err error
+			if bits[i], err = e.BitsFinalize(bitBatches[i], shares); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ones := 0
+		for k := range bits[0] {
+			bitShares := make([]AuthShare, numParties)
+			for i := range parties {
+				bitShares[i] = bits[i][k]
+			}
+			b := f.Big(checkMac(t, "bit", k, bitShares))
+			if b.BitLen() > 1 {
+				t.Fatalf("Bit check failed at index %d: got %s", k, b)
+			}
+			ones += int(b.Int64())
+		}
+		if ones == 0 || ones == len(bits[0]) {
+			t.Fatalf("%d ones out of %d bits", ones, len(bits[0]))
+		}
+
+		_, errs := ctx.macCheck(t)
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("party %d: unexpected MAC check error: %v", i, err)
+			}
+		}
+	})
+}
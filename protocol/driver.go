@@ -0,0 +1,732 @@
+package protocol
+
+import (
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+	"spdz-go/network"
+	"spdz-go/rlwe"
+	"spdz-go/utils"
+
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// RunHemiPreprocessing runs the Hemi key setup and MAC key setup, followed by numBatches batches of
+// authenticated triple generation, exchanging all messages over tr. The triples are stored in the
+// party and handed out by NextAuthTriple. The OLE outputs are flooded with statistical security statSec,
+// see RunHemiBatch.
+func RunHemiPreprocessing(party *HemiParty, tr network.Transport, session string, numBatches, statSec int) error {
+	if err := SetupHemi(party, tr, session); err != nil {
+		return err
+	}
+	for b := 0; b < numBatches; b++ {
+		if err := RunHemiBatch(party, tr, session, b, statSec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupHemi runs the Hemi key setup and MAC key setup, exchanging all messages over tr.
+// It returns an AbortError naming the first party whose message is missing or invalid.
+func SetupHemi(party *HemiParty, tr network.Transport, session string) error {
+	params := party.params
+	numParties := len(party.pks)
+	if err := checkTransport(tr, party.id, numParties); err != nil {
+		return err
+	}
+	if err := ExchangeParameters(tr, session, params); err != nil {
+		return err
+	}
+
+	//  --- Round 0: Key Generation & Exchange ---
+	pk := party.InitSetup(numParties)
+	out := make([][]byte, numParties)
+	for peer := range out {
+		if peer == party.id {
+			continue
+		}
+		w := hpbfv.NewWireWriter(params)
+		w.WritePublicKey(pk[peer])
+		var err error
+		if out[peer], err = w.Bytes(); err != nil {
+			return err
+		}
+	}
+	in, err := exchangePairwise(tr, network.Tag{Session: session, Round: "hemi/keys"}, out)
+	if err != nil {
+		return err
+	}
+	pks := make([]*rlwe.PublicKey, numParties)
+	for peer, data := range in.payloads {
+		if peer == party.id {
+			continue
+		}
+		r := hpbfv.NewWireReader(params, data)
+		pks[peer] = r.ReadPublicKey()
+		if err = r.Close(); err != nil {
+			return in.blame(peer, fmt.Errorf("cannot decode public key: %w", err))
+		}
+	}
+	party.FinalizeSetup(pks)
+	party.SetupMacKey()
+	return nil
+}
+
+// RunHemiBatch runs the b-th batch of authenticated triple generation after SetupHemi, sending the pairwise
+// encryptions with their proofs of plaintext knowledge and flooding the OLE outputs to hide the secrets of
+// the party with statistical security statSec.
+// It returns an AbortError naming the first party whose message is missing or invalid.
+func RunHemiBatch(party *HemiParty, tr network.Transport, session string, b, statSec int) error {
+	params := party.params
+	numParties := len(party.pks)
+	tag := func(round int) network.Tag {
+		return network.Tag{Session: session, Round: fmt.Sprintf("hemi/batch-%d/round-%d", b, round)}
+	}
+
+	batch := party.SampleAuthBatch()
+
+	// --- Round 1 (Pairwise): Encrypt a and b with own key for peer ---
+	out := make([][]byte, numParties)
+	for peer := range out {
+		if peer == party.id {
+			continue
+		}
+		cA, cB, proof := party.AuthPairwiseRoundOne(batch, peer)
+		w := hpbfv.NewWireWriter(params)
+		w.WriteCiphertext(cA)
+		w.WriteCiphertext(cB)
+		w.WritePlaintextProof(proof)
+		var err error
+		if out[peer], err = w.Bytes(); err != nil {
+			return err
+		}
+	}
+	in, err := exchangePairwise(tr, tag(1), out)
+	if err != nil {
+		return err
+	}
+
+	// --- Round 2 (Pairwise): OLE for b*a, alpha*a, alpha*b ---
+	for peer := range out {
+		if peer == party.id {
+			continue
+		}
+		r := hpbfv.NewWireReader(params, in.payloads[peer])
+		cA, cB, proof := r.ReadCiphertext(), r.ReadCiphertext(), r.ReadPlaintextProof()
+		if err = r.Close(); err != nil {
+			return in.blame(peer, fmt.Errorf("cannot decode message: %w", err))
+		}
+		cAB, cMacA, cMacB, err := party.AuthPairwiseRoundTwo(batch, cA, cB, proof, peer, statSec)
+		if err != nil {
+			return in.check(err)
+		}
+		if out[peer], err = encodeCiphertexts(params, cAB, cMacA, cMacB); err != nil {
+			return err
+		}
+	}
+	if in, err = exchangePairwise(tr, tag(2), out); err != nil {
+		return err
+	}
+	cABs := make([]*hpbfv.Ciphertext, numParties)
+	cMacAs := make([]*hpbfv.Ciphertext, numParties)
+	cMacBs := make([]*hpbfv.Ciphertext, numParties)
+	for peer, data := range in.payloads {
+		if peer == party.id {
+			continue
+		}
+		r := hpbfv.NewWireReader(params, data)
+		cABs[peer], cMacAs[peer], cMacBs[peer] = r.ReadCiphertext(), r.ReadCiphertext(), r.ReadCiphertext()
+		if err = r.Close(); err != nil {
+			return in.blame(peer, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+	party.AuthCombineRoundTwo(batch, cABs, cMacAs, cMacBs)
+
+	// --- Round 3 (Pairwise): Encrypt c with own key for peer ---
+	for peer := range out {
+		if peer == party.id {
+			continue
+		}
+		cC, proof := party.AuthPairwiseRoundThree(batch, peer)
+		w := hpbfv.NewWireWriter(params)
+		w.WriteCiphertext(cC)
+		w.WritePlaintextProof(proof)
+		if out[peer], err = w.Bytes(); err != nil {
+			return err
+		}
+	}
+	if in, err = exchangePairwise(tr, tag(3), out); err != nil {
+		return err
+	}
+
+	// --- Round 4 (Pairwise): OLE for alpha*c ---
+	for peer := range out {
+		if peer == party.id {
+			continue
+		}
+		r := hpbfv.NewWireReader(params, in.payloads[peer])
+		cC, proof := r.ReadCiphertext(), r.ReadPlaintextProof()
+		if err = r.Close(); err != nil {
+			return in.blame(peer, fmt.Errorf("cannot decode message: %w", err))
+		}
+		cMacC, err := party.AuthPairwiseRoundFour(batch, cC, proof, peer, statSec)
+		if err != nil {
+			return in.check(err)
+		}
+		if out[peer], err = encodeCiphertexts(params, cMacC); err != nil {
+			return err
+		}
+	}
+	if in, err = exchangePairwise(tr, tag(4), out); err != nil {
+		return err
+	}
+	cMacCs := make([]*hpbfv.Ciphertext, numParties)
+	for peer, data := range in.payloads {
+		if peer == party.id {
+			continue
+		}
+		r := hpbfv.NewWireReader(params, data)
+		cMacCs[peer] = r.ReadCiphertext()
+		if err = r.Close(); err != nil {
+			return in.blame(peer, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+
+	// --- Finalize ---
+	party.FinalizeAuthTriple(batch, cMacCs)
+	return nil
+}
+
+// RunSohoPreprocessing runs the Soho key aggregation and MAC key setup among numParties parties,
+// followed by numBatches batches of authenticated triple generation, exchanging all messages over tr.
+// The triples are stored in the party and handed out by NextAuthTriple. The decryption shares are flooded
+// with statistical security statSec, see RunSohoBatch.
+func RunSohoPreprocessing(party *SohoParty, tr network.Transport, session string, numBatches, statSec int) error {
+	if err := SetupSoho(party, tr, session); err != nil {
+		return err
+	}
+	for b := 0; b < numBatches; b++ {
+		if err := RunSohoBatch(party, tr, session, b, statSec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupSoho runs the Soho key aggregation and MAC key setup, exchanging all messages over tr.
+// It returns an AbortError naming the first party whose message is missing or invalid, with the
+// signed message as evidence if tr signs its messages.
+func SetupSoho(party *SohoParty, tr network.Transport, session string) error {
+	params := party.params
+	numParties := tr.NumParties()
+	if err := checkTransport(tr, party.id, numParties); err != nil {
+		return err
+	}
+	if err := ExchangeParameters(tr, session, params); err != nil {
+		return err
+	}
+
+	// --- Round 0: Key Generation & Exchange ---
+	w := hpbfv.NewWireWriter(params)
+	w.WritePublicKey(party.ppk)
+	w.WriteRelinearizationKey(party.prlk)
+	w.WriteKeyProof(party.keyProof)
+	out, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	in, err := exchange(tr, network.Tag{Session: session, Round: "soho/keys"}, out)
+	if err != nil {
+		return err
+	}
+	ppks := make([]*rlwe.PublicKey, numParties)
+	prlks := make([]*hpbfv.RelinearizationKey, numParties)
+	keyProofs := make([]*hpbfv.KeyProof, numParties)
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		ppks[j], prlks[j], keyProofs[j] = r.ReadPublicKey(), r.ReadRelinearizationKey(), r.ReadKeyProof()
+		if err = r.Close(); err != nil {
+			return in.blame(j, fmt.Errorf("cannot decode keys: %w", err))
+		}
+	}
+	if err = party.Setup(ppks, prlks, keyProofs); err != nil {
+		return in.check(err)
+	}
+
+	// --- MAC Key Setup ---
+	cAlpha, proof := party.GenMacKeyShare()
+	w = hpbfv.NewWireWriter(params)
+	w.WriteCiphertext(cAlpha)
+	w.WritePlaintextProof(proof)
+	if out, err = w.Bytes(); err != nil {
+		return err
+	}
+	if in, err = exchange(tr, network.Tag{Session: session, Round: "soho/mac-key"}, out); err != nil {
+		return err
+	}
+	cAlphas := make([]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		cAlphas[j], proofs[j] = r.ReadCiphertext(), r.ReadPlaintextProof()
+		if err = r.Close(); err != nil {
+			return in.blame(j, fmt.Errorf("cannot decode MAC key share: %w", err))
+		}
+	}
+	return in.check(party.SetupMacKey(cAlphas, proofs))
+}
+
+// RunSohoBatch runs the b-th batch of authenticated triple generation after SetupSoho, flooding the
+// decryption shares to hide the noise of the decrypted ciphertexts with statistical security statSec and
+// sending them with their proofs of decryption.
+// It returns an AbortError naming the first party whose message is missing or invalid, with the signed
+// message as evidence if tr signs its messages.
+func RunSohoBatch(party *SohoParty, tr network.Transport, session string, b, statSec int) error {
+	params := party.params
+	numParties := tr.NumParties()
+	tag := func(round int) network.Tag {
+		return network.Tag{Session: session, Round: fmt.Sprintf("soho/batch-%d/round-%d", b, round)}
+	}
+
+	// --- Round 1: Sampling & Exchange ---
+	batch, ca, cb, css, proof := party.AuthTriplesRoundOne()
+	w := hpbfv.NewWireWriter(params)
+	w.WriteCiphertext(ca)
+	w.WriteCiphertext(cb)
+	for _, cs := range css {
+		w.WriteCiphertext(cs)
+	}
+	w.WritePlaintextProof(proof)
+	out, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	in, err := exchange(tr, tag(1), out)
+	if err != nil {
+		return err
+	}
+	cas := make([]*hpbfv.Ciphertext, numParties)
+	cbs := make([]*hpbfv.Ciphertext, numParties)
+	csss := make([][]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		cas[j], cbs[j] = r.ReadCiphertext(), r.ReadCiphertext()
+		csss[j] = make([]*hpbfv.Ciphertext, len(css))
+		for k := range csss[j] {
+			csss[j][k] = r.ReadCiphertext()
+		}
+		proofs[j] = r.ReadPlaintextProof()
+		if err = r.Close(); err != nil {
+			return in.blame(j, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+
+	// --- Round 2: Multiplication & Resharing of c, alpha*a, alpha*b ---
+	shC, shMacA, shMacB, err := party.AuthTriplesRoundTwo(batch, cas, cbs, csss, proofs, statSec)
+	if err != nil {
+		return in.check(err)
+	}
+	w = hpbfv.NewWireWriter(params)
+	writeReshareShare(w, shC)
+	writeReshareShare(w, shMacA)
+	writeReshareShare(w, shMacB)
+	if out, err = w.Bytes(); err != nil {
+		return err
+	}
+	if in, err = exchange(tr, tag(2), out); err != nil {
+		return err
+	}
+	shCs := make([]*ReshareShare, numParties)
+	shMacAs := make([]*ReshareShare, numParties)
+	shMacBs := make([]*ReshareShare, numParties)
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		shCs[j], shMacAs[j], shMacBs[j] = readReshareShare(r), readReshareShare(r), readReshareShare(r)
+		if err = r.Close(); err != nil {
+			return in.blame(j, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+
+	// --- Round 3: Resharing of alpha*c ---
+	shMacC, err := party.AuthTriplesRoundThree(batch, shCs, shMacAs, shMacBs, statSec)
+	if err != nil {
+		return in.check(err)
+	}
+	w = hpbfv.NewWireWriter(params)
+	writeReshareShare(w, shMacC)
+	if out, err = w.Bytes(); err != nil {
+		return err
+	}
+	if in, err = exchange(tr, tag(3), out); err != nil {
+		return err
+	}
+	shMacCs := make([]*ReshareShare, numParties)
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		shMacCs[j] = readReshareShare(r)
+		if err = r.Close(); err != nil {
+			return in.blame(j, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+
+	// --- Finalize ---
+	return in.check(party.FinalizeAuthTriple(batch, shMacCs, statSec))
+}
+
+// RunSacrifice checks n triples drawn from the source of vt by sacrificing n others, followed by the MAC
+// check on all openings, exchanging all messages over tr under the b-th batch of the session. The checked
+// triples are handed out by vt once both pass. It returns an error wrapping ErrSacrificeFailed or
+// ErrMacCheckFailed if a triple is incorrect, or an AbortError naming the first party whose message is
+// missing or invalid.
+func RunSacrifice(vt *VerifiedTriples, tr network.Transport, session string, b, n int) error {
+	params := vt.params
+	if err := checkTransport(tr, vt.engine.id, vt.engine.numParties); err != nil {
+		return err
+	}
+	tag := func(round int) network.Tag {
+		return network.Tag{Session: session, Round: fmt.Sprintf("sacrifice/batch-%d/round-%d", b, round)}
+	}
+	revealSize := utils.MaxInt(coinTossSeedSize, utils.CommitmentOpeningSize)
+
+	// --- Round 1: Commitment to the seed of the coefficients ---
+	sac, com, err := vt.SacrificeInit(n)
+	if err != nil {
+		return err
+	}
+	_, coms, err := exchangeBytes(params, tr, tag(1), blake2b.Size256, com)
+	if err != nil {
+		return err
+	}
+
+	// --- Round 2: Reveal of the seed ---
+	seed, opening := sac.RoundTwo(column(coms, 0))
+	in, reveals, err := exchangeBytes(params, tr, tag(2), revealSize, seed, opening)
+	if err != nil {
+		return err
+	}
+
+	// --- Round 3: Opening of rho and sigma ---
+	shares, err := sac.RoundThree(column(reveals, 0), column(reveals, 1))
+	if err != nil {
+		return in.check(err)
+	}
+	in, allShares, err := exchangeScalars(params, tr, tag(3), shares)
+	if err != nil {
+		return err
+	}
+
+	// --- Round 4: Opening of the check values ---
+	if shares, err = sac.RoundFour(allShares); err != nil {
+		return in.check(err)
+	}
+	in, allShares, err = exchangeScalars(params, tr, tag(4), shares)
+	if err != nil {
+		return err
+	}
+
+	// --- Round 5: Commitment to the seed of the MAC check ---
+	mc, com, err := sac.RoundFive(allShares)
+	if err != nil {
+		return in.check(err)
+	}
+	if _, coms, err = exchangeBytes(params, tr, tag(5), blake2b.Size256, com); err != nil {
+		return err
+	}
+
+	// --- Round 6: Reveal of the seed of the MAC check ---
+	seed, opening = mc.RoundTwo(column(coms, 0))
+	if in, reveals, err = exchangeBytes(params, tr, tag(6), revealSize, seed, opening); err != nil {
+		return err
+	}
+
+	// --- Round 7: Commitment to sigma ---
+	sigmaCom, err := mc.RoundThree(column(reveals, 0), column(reveals, 1))
+	if err != nil {
+		return in.check(err)
+	}
+	if _, coms, err = exchangeBytes(params, tr, tag(7), blake2b.Size256, sigmaCom); err != nil {
+		return err
+	}
+
+	// --- Round 8: Reveal of sigma ---
+	sigma, opening := mc.RoundFour(column(coms, 0))
+	revealSize = utils.MaxInt(params.Field().ElementSize(), utils.CommitmentOpeningSize)
+	if in, reveals, err = exchangeBytes(params, tr, tag(8), revealSize, sigma, opening); err != nil {
+		return err
+	}
+	return in.check(sac.Finalize(mc, column(reveals, 0), column(reveals, 1)))
+}
+
+// exchangeBytes broadcasts the byte strings bs, such as commitments, seeds and their openings, under tag and
+// returns the byte strings of all parties indexed by sender, each of at most maxLen bytes.
+// It returns an AbortError naming the first party whose message cannot be decoded.
+func exchangeBytes(params hpbfv.Parameters, tr network.Transport, tag network.Tag, maxLen int, bs ...[]byte) (*inbox, [][][]byte, error) {
+	w := hpbfv.NewWireWriter(params)
+	for _, b := range bs {
+		w.WriteBytes(b)
+	}
+	out, err := w.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := exchange(tr, tag, out)
+	if err != nil {
+		return nil, nil, err
+	}
+	all := make([][][]byte, len(in.payloads))
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		all[j] = make([][]byte, len(bs))
+		for k := range bs {
+			all[j][k] = r.ReadBytes(maxLen)
+		}
+		if err = r.Close(); err != nil {
+			return nil, nil, in.blame(j, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+	return in, all, nil
+}
+
+// exchangeScalars broadcasts the shares xs under tag and returns the shares of all parties indexed by sender,
+// as many as xs. It returns an AbortError naming the first party whose message cannot be decoded.
+func exchangeScalars(params hpbfv.Parameters, tr network.Transport, tag network.Tag, xs []field.Element) (*inbox, [][]field.Element, error) {
+	w := hpbfv.NewWireWriter(params)
+	for _, x := range xs {
+		w.WriteScalar(x)
+	}
+	out, err := w.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := exchange(tr, tag, out)
+	if err != nil {
+		return nil, nil, err
+	}
+	all := make([][]field.Element, len(in.payloads))
+	for j, data := range in.payloads {
+		r := hpbfv.NewWireReader(params, data)
+		all[j] = make([]field.Element, len(xs))
+		for k := range xs {
+			all[j][k] = r.ReadScalar()
+		}
+		if err = r.Close(); err != nil {
+			return nil, nil, in.blame(j, fmt.Errorf("cannot decode message: %w", err))
+		}
+	}
+	return in, all, nil
+}
+
+// column returns the k-th byte string of every party.
+func column(all [][][]byte, k int) [][]byte {
+	col := make([][]byte, len(all))
+	for j := range all {
+		col[j] = all[j][k]
+	}
+	return col
+}
+
+// writeReshareShare writes a decryption share of a resharing followed by its proof of decryption.
+func writeReshareShare(w *hpbfv.WireWriter, share *ReshareShare) {
+	w.WriteDistDecShare(share.Share)
+	w.WriteDecryptionProof(share.Proof)
+}
+
+// readReshareShare reads a decryption share of a resharing written by writeReshareShare.
+func readReshareShare(r *hpbfv.WireReader) *ReshareShare {
+	share := r.ReadDistDecShare()
+	return &ReshareShare{Share: share, Proof: r.ReadDecryptionProof()}
+}
+
+// encodeCiphertexts encodes cts in a single wire message.
+func encodeCiphertexts(params hpbfv.Parameters, cts ...*hpbfv.Ciphertext) ([]byte, error) {
+	w := hpbfv.NewWireWriter(params)
+	for _, ct := range cts {
+		w.WriteCiphertext(ct)
+	}
+	return w.Bytes()
+}
+
+// ExchangeParameters sends the parameters to every other party and checks that all parties use the same parameters.
+// It returns an AbortError naming the first party using other parameters.
+func ExchangeParameters(tr network.Transport, session string, params hpbfv.Parameters) error {
+	out, err := params.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	in, err := exchange(tr, network.Tag{Session: session, Round: "params"}, out)
+	if err != nil {
+		return err
+	}
+	for j, data := range in.payloads {
+		var paramsJ hpbfv.Parameters
+		if err = paramsJ.UnmarshalBinary(data); err != nil {
+			return in.blame(j, fmt.Errorf("cannot decode parameters: %w", err))
+		}
+		if !paramsJ.Equals(params) {
+			return in.blame(j, fmt.Errorf("uses parameters with fingerprint %x, expected %x", paramsJ.Fingerprint(), params.Fingerprint()))
+		}
+	}
+	return nil
+}
+
+func checkTransport(tr network.Transport, id, numParties int) error {
+	if tr.ID() != id {
+		return fmt.Errorf("transport of party %d used by party %d", tr.ID(), id)
+	}
+	if tr.NumParties() != numParties {
+		return fmt.Errorf("transport connects %d parties, expected %d", tr.NumParties(), numParties)
+	}
+	return nil
+}
+
+// frameOverhead bounds the bytes that the framing and the signatures of the transports add to a message,
+// with tags of up to 64 KiB.
+const frameOverhead = 1 << 18
+
+// MaxMessageSize returns the size in bytes of the largest message that the preprocessing drivers exchange
+// among numParties parties with params.
+func MaxMessageSize(params hpbfv.Parameters, numParties int) int {
+	sz := params.WireSizes()
+	sizes := []int{
+		(numParties - 1) * (network.DigestSize + ed25519.SignatureSize), // echo
+		sz.Header + sz.PublicKey + sz.RelinearizationKey + sz.KeyProof,  // soho/keys
+		sz.Header + sz.Ciphertext + sz.PlaintextProof,                   // soho/mac-key
+		sz.Header + 6*sz.Ciphertext + sz.PlaintextProof,                 // soho/batch round 1
+		sz.Header + 3*(sz.DistDecShare+sz.DecryptionProof),              // soho/batch round 2
+		sz.Header + sz.PublicKey,                                        // hemi/keys
+		sz.Header + 2*sz.Ciphertext + sz.PlaintextProof,                 // hemi/batch round 1
+		sz.Header + 3*sz.Ciphertext,                                     // hemi/batch round 2
+		sz.Header + params.Slots()*(1+params.Field().ElementSize()),     // sacrifice round 3
+	}
+	if data, err := params.MarshalBinary(); err == nil {
+		sizes = append(sizes, len(data))
+	}
+	max := 0
+	for _, size := range sizes {
+		max = utils.MaxInt(max, size)
+	}
+	return max
+}
+
+// TransportLimits returns the limits of a transport among numParties parties running the preprocessing with
+// params: the largest frame fits the largest message of the drivers, see MaxMessageSize, and one message is
+// buffered per sender and tag.
+func TransportLimits(params hpbfv.Parameters, numParties int) network.Limits {
+	return network.Limits{MaxFrameSize: MaxMessageSize(params, numParties) + frameOverhead, MaxPending: 1}
+}
+
+// exchange broadcasts payload under tag and returns the messages of all parties, including the local one.
+// It returns an AbortError naming the first party whose message cannot be received, and checks with an echo
+// round that every party received the same messages, see echo.
+func exchange(tr network.Transport, tag network.Tag, payload []byte) (*inbox, error) {
+	if err := tr.Broadcast(tag, payload); err != nil {
+		return nil, err
+	}
+	in := newInbox(tr, tag)
+	for j := range in.payloads {
+		if j == tr.ID() {
+			in.payloads[j] = payload
+			continue
+		}
+		if err := in.receive(tr, j); err != nil {
+			return nil, err
+		}
+	}
+	if err := echo(tr, in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// echo broadcasts the digests of the messages of in received from the other parties, with their signatures
+// if tr signs them, and compares them with the digests echoed by every other party. Over a signing transport,
+// it returns an AbortError naming a party whose echoed signature does not verify, or a party that sent
+// different messages to different parties, with its two conflicting signatures as evidence. Over other
+// transports a mismatch cannot be attributed, and echo returns an error wrapping ErrEquivocation.
+func echo(tr network.Transport, in *inbox) error {
+	sr, signs := tr.(signedReceiver)
+	entrySize := network.DigestSize
+	if signs {
+		entrySize += ed25519.SignatureSize
+	}
+
+	digests := make([][]byte, len(in.payloads))
+	for j, data := range in.payloads {
+		digests[j] = network.Digest(data)
+	}
+	out := make([]byte, 0, (len(digests)-1)*entrySize)
+	for j, digest := range digests {
+		if j == tr.ID() {
+			continue
+		}
+		out = append(out, digest...)
+		if signs {
+			out = append(out, in.signed[j].Signature...)
+		}
+	}
+	if err := tr.Broadcast(in.echoTag(), out); err != nil {
+		return err
+	}
+
+	echoes := newInbox(tr, in.echoTag())
+	for k := range echoes.payloads {
+		if k == tr.ID() {
+			continue
+		}
+		if err := echoes.receive(tr, k); err != nil {
+			return err
+		}
+		data := echoes.payloads[k]
+		if len(data) != (len(digests)-1)*entrySize {
+			return echoes.blame(k, errors.New("malformed echo"))
+		}
+		for j := range digests {
+			if j == k {
+				continue
+			}
+			entry := data[:entrySize]
+			data = data[entrySize:]
+			if bytes.Equal(entry[:network.DigestSize], digests[j]) {
+				continue
+			}
+			if !signs {
+				return fmt.Errorf("%w: party %d relays another message of party %d in round %s", ErrEquivocation, k, j, in.tag.Round)
+			}
+			relayed := &network.SignedDigest{Sender: j, Tag: in.tag, Digest: entry[:network.DigestSize], Signature: entry[network.DigestSize:]}
+			if err := relayed.Verify(sr.PublicKey(j)); err != nil {
+				return echoes.blame(k, fmt.Errorf("relays a forged message of party %d: %w", j, err))
+			}
+			return &AbortError{Party: j, Round: in.tag.Round, Err: ErrEquivocation, Evidence: in.signed[j], Conflict: relayed}
+		}
+	}
+	return nil
+}
+
+// exchangePairwise sends payloads[j] to every other party j under tag and returns the messages received
+// from every other party. It returns an AbortError naming the first party whose message cannot be received.
+func exchangePairwise(tr network.Transport, tag network.Tag, payloads [][]byte) (*inbox, error) {
+	for j, payload := range payloads {
+		if j == tr.ID() {
+			continue
+		}
+		if err := tr.Send(j, tag, payload); err != nil {
+			return nil, err
+		}
+	}
+	in := newInbox(tr, tag)
+	for j := range in.payloads {
+		if j == tr.ID() {
+			continue
+		}
+		if err := in.receive(tr, j); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
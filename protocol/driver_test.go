@@ -0,0 +1,399 @@
+package protocol
+
+import (
+	"testing"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+	"spdz-go/network"
+
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// checkAuthTriples checks that the i-th triples of all parties form a correct authenticated triple under alpha
+func checkAuthTriples(t *testing.T, params hpbfv.Parameters, alpha field.Element, triples [][]*AuthTriple) {
+	f := params.Field()
+	for i := range triples[0] {
+		sums := f.NewVector(6)
+		for j := range triples {
+			triple := triples[j][i]
+			for k, v := range []field.Element{triple.A.Value, triple.B.Value, triple.C.Value, triple.A.Mac, triple.B.Mac, triple.C.Mac} {
+				f.Add(sums[k], sums[k], v)
+			}
+		}
+
+		ab := f.Mul(f.NewElement(), sums[0], sums[1])
+		if !f.Equal(sums[2], ab) {
+			t.Fatalf("Triple check failed at index %d: a=%s, b=%s, c=%s, ab=%s", i, f.Big(sums[0]), f.Big(sums[1]), f.Big(sums[2]), f.Big(ab))
+		}
+		expected := f.NewElement()
+		for k, name := range []string{"a", "b", "c"} {
+			if !f.Equal(sums[k+3], f.Mul(expected, alpha, sums[k])) {
+				t.Fatalf("MAC check failed for %s at index %d", name, i)
+			}
+		}
+	}
+}
+
+func TestHemiDriver(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numParties := 3
+
+	transports := network.NewMemoryTransports(numParties)
+	parties := make([]*HemiParty, numParties)
+	errs := make([]error, numParties)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numParties; i++ {
+		parties[i] = NewHemiParty(i, params, numParties)
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			errs[pid] = RunHemiPreprocessing(parties[pid], transports[pid], "test", 1, 40)
+		}(i)
+	}
+	wg.Wait()
+
+	f := params.Field()
+	alpha := f.NewElement()
+	triples := make([][]*AuthTriple, numParties)
+	for i, party := range parties {
+		if errs[i] != nil {
+			t.Fatalf("party %d: %v", i, errs[i])
+		}
+		f.Add(alpha, alpha, party.MacKeyShare())
+		triples[i] = party.authTriples
+	}
+
+	if len(triples[0]) != params.Slots() {
+		t.Fatalf("expected %d triples, got %d", params.Slots(), len(triples[0]))
+	}
+	checkAuthTriples(t, params, alpha, triples)
+}
+
+func TestSohoDriver(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numParties := 3
+
+	crs := make([]byte, 32)
+	if _, err := rand.Read(crs); err != nil {
+		t.Fatalf("cannot generate crs: %v", err)
+	}
+
+	listeners := make([]net.Listener, numParties)
+	addrs := make([]string, numParties)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = ln
+		addrs[i] = ln.Addr().String()
+	}
+
+	parties := make([]*SohoParty, numParties)
+	errs := make([]error, numParties)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numParties; i++ {
+		parties[i] = NewSohoParty(i, params, crs)
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			tr, err := network.NewTCPTransportWithListener(pid, listeners[pid], addrs, 10*time.Second, TransportLimits(params, numParties))
+			if err != nil {
+				errs[pid] = err
+				return
+			}
+			defer tr.Close()
+			errs[pid] = RunSohoPreprocessing(parties[pid], tr, "test", 1, 40)
+		}(i)
+	}
+	wg.Wait()
+
+	f := params.Field()
+	alpha := f.NewElement()
+	triples := make([][]*AuthTriple, numParties)
+	for i, party := range parties {
+		if errs[i] != nil {
+			t.Fatalf("party %d: %v", i, errs[i])
+		}
+		f.Add(alpha, alpha, party.MacKeyShare())
+		triples[i] = party.authTriples
+	}
+
+	if len(triples[0]) != params.Slots() {
+		t.Fatalf("expected %d triples, got %d", params.Slots(), len(triples[0]))
+	}
+	checkAuthTriples(t, params, alpha, triples)
+}
+
+func TestSacrificeDriver(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := params.Field()
+	numParties, n := 3, 4
+
+	run := func(corrupt bool) ([][]*AuthTriple, []*VerifiedTriples, []error) {
+		alphas := make([]field.Element, numParties)
+		alpha := f.NewElement()
+		for j := range alphas {
+			alphas[j] = randModT(t, params)
+			f.Add(alpha, alpha, alphas[j])
+		}
+		triples := dealAuthTriples(t, params, alphas, 2*n)
+		if corrupt {
+			// c of the last checked triple is off by one, with a consistent MAC
+			c := triples[1][n-1].C
+			f.Add(c.Value, c.Value, f.SetUint64(f.NewElement(), 1))
+			f.Add(c.Mac, c.Mac, alpha)
+		}
+
+		transports := network.NewMemoryTransports(numParties)
+		vts := make([]*VerifiedTriples, numParties)
+		errs := make([]error, numParties)
+		var wg sync.WaitGroup
+		for i := range vts {
+			vts[i] = NewVerifiedTriples(i, params, numParties, alphas[i], NewTripleQueue(triples[i]))
+			wg.Add(1)
+			go func(pid int) {
+				defer wg.Done()
+				errs[pid] = RunSacrifice(vts[pid], transports[pid], "test", 0, n)
+			}(i)
+		}
+		wg.Wait()
+		return triples, vts, errs
+	}
+
+	t.Run("Honest", func(t *testing.T) {
+		triples, vts, errs := run(false)
+		for i, vt := range vts {
+			if errs[i] != nil {
+				t.Fatalf("party %d: %v", i, errs[i])
+			}
+			if vt.Len() != n {
+				t.Fatalf("party %d: expected %d verified triples, got %d", i, n, vt.Len())
+			}
+			if triple, err := vt.NextAuthTriple(); err != nil || triple != triples[i][0] {
+				t.Fatalf("party %d: verified triples are not the checked ones", i)
+			}
+		}
+	})
+
+	t.Run("WrongProduct", func(t *testing.T) {
+		_, vts, errs := run(true)
+		for i, vt := range vts {
+			if !errors.Is(errs[i], ErrSacrificeFailed) {
+				t.Fatalf("party %d: expected ErrSacrificeFailed, got %v", i, errs[i])
+			}
+			if vt.Len() != 0 {
+				t.Fatalf("party %d: triples released despite failed sacrifice", i)
+			}
+		}
+	})
+}
+
+// signedTransports returns connected in-memory transports of numParties parties signing their messages,
+// and the identity keys of the parties.
+func signedTransports(t *testing.T, numParties int) ([]*network.SignedTransport, []ed25519.PublicKey) {
+	keys := make([]ed25519.PrivateKey, numParties)
+	pubs := make([]ed25519.PublicKey, numParties)
+	for i := range keys {
+		var err error
+		if pubs[i], keys[i], err = ed25519.GenerateKey(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mem := network.NewMemoryTransports(numParties)
+	trs := make([]*network.SignedTransport, numParties)
+	for i := range trs {
+		var err error
+		if trs[i], err = network.NewSignedTransport(mem[i], keys[i], pubs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return trs, pubs
+}
+
+// checkAbort checks that err is an AbortError blaming party in round, with a signed message of party as evidence.
+func checkAbort(t *testing.T, err error, party int, round string, pub ed25519.PublicKey) *AbortError {
+	var abort *AbortError
+	if !errors.As(err, &abort) || abort.Party != party || abort.Round != round {
+		t.Fatalf("expected an abort blaming party %d in round %s, got %v", party, round, err)
+	}
+	if abort.Evidence == nil || abort.Evidence.Sender != party || abort.Evidence.Tag.Round != round {
+		t.Fatalf("abort blaming party %d without evidence", party)
+	}
+	if err = abort.Evidence.Verify(pub); err != nil {
+		t.Fatal(err)
+	}
+	return abort
+}
+
+func TestExchangeParameters(t *testing.T) {
+	params := make([]hpbfv.Parameters, 3)
+	for i, pl := range []hpbfv.ParametersLiteral{hpbfv.SOHO_V2, hpbfv.SOHO_V2, hpbfv.SOHO} {
+		var err error
+		if params[i], err = hpbfv.NewParametersFromLiteral(pl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trs, pubs := signedTransports(t, len(params))
+
+	errs := make([]error, len(params))
+	var wg sync.WaitGroup
+	for i := range params {
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			errs[pid] = ExchangeParameters(trs[pid], "test", params[pid])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			t.Fatalf("party %d accepted mismatching parameters", i)
+		}
+	}
+	// the parties using SOHO_V2 blame party 2, which blames party 0
+	checkAbort(t, errs[0], 2, "params", pubs[2])
+	checkAbort(t, errs[1], 2, "params", pubs[2])
+	checkAbort(t, errs[2], 0, "params", pubs[0])
+}
+
+func TestHemiDriverAbort(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numParties := 3
+	trs, pubs := signedTransports(t, numParties)
+
+	errs := make([]error, numParties)
+	var wg sync.WaitGroup
+	for i := 0; i < numParties-1; i++ {
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			errs[pid] = SetupHemi(NewHemiParty(pid, params, numParties), trs[pid], "test")
+		}(i)
+	}
+
+	// party 2 sends a public key that does not decode
+	if err := ExchangeParameters(trs[2], "test", params); err != nil {
+		t.Fatal(err)
+	}
+	for j := 0; j < numParties-1; j++ {
+		if err := trs[2].Send(j, network.Tag{Session: "test", Round: "hemi/keys"}, []byte("garbage")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < numParties-1; i++ {
+		abort := checkAbort(t, errs[i], 2, "hemi/keys", pubs[2])
+		if string(abort.Evidence.Payload) != "garbage" {
+			t.Fatalf("party %d: evidence %q is not the message of party 2", i, abort.Evidence.Payload)
+		}
+	}
+}
+
+// equivocate sends payloads[j] to party j under tag over tr, receives the messages of
+// the other parties and echoes their digests, relaying relayed[j] as the message of party j if it is set.
+func equivocate(t *testing.T, tr *network.SignedTransport, tag network.Tag, payloads [][]byte, relayed map[int]*network.SignedDigest) {
+	in := newInbox(tr, tag)
+	for j := range payloads {
+		if j == tr.ID() {
+			continue
+		}
+		if err := tr.Send(j, tag, payloads[j]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out []byte
+	for j := range payloads {
+		if j == tr.ID() {
+			continue
+		}
+		if err := in.receive(tr, j); err != nil {
+			t.Fatal(err)
+		}
+		digest := in.signed[j].SignedDigest()
+		if relayed[j] != nil {
+			digest = relayed[j]
+		}
+		out = append(append(out, digest.Digest...), digest.Signature...)
+	}
+	if err := tr.Broadcast(in.echoTag(), out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExchangeEquivocation(t *testing.T) {
+	numParties := 3
+	tag := network.Tag{Session: "test", Round: "round"}
+
+	t.Run("Sender", func(t *testing.T) {
+		trs, pubs := signedTransports(t, numParties)
+		errs := make([]error, numParties-1)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(pid int) {
+				defer wg.Done()
+				_, errs[pid] = exchange(trs[pid], tag, []byte{byte(pid)})
+			}(i)
+		}
+		// party 2 sends different messages to parties 0 and 1
+		equivocate(t, trs[2], tag, [][]byte{[]byte("zero"), []byte("one"), nil}, nil)
+		wg.Wait()
+
+		for i, err := range errs {
+			abort := checkAbort(t, err, 2, tag.Round, pubs[2])
+			if !errors.Is(err, ErrEquivocation) || abort.Conflict == nil {
+				t.Fatalf("party %d: expected an equivocation with a conflicting message, got %v", i, err)
+			}
+			if err = abort.Conflict.Verify(pubs[2]); err != nil {
+				t.Fatal(err)
+			}
+			if !abort.Evidence.SignedDigest().Conflicts(abort.Conflict) {
+				t.Fatalf("party %d: the evidence does not conflict", i)
+			}
+		}
+	})
+
+	t.Run("Echo", func(t *testing.T) {
+		trs, pubs := signedTransports(t, numParties)
+		errs := make([]error, numParties-1)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(pid int) {
+				defer wg.Done()
+				_, errs[pid] = exchange(trs[pid], tag, []byte{byte(pid)})
+			}(i)
+		}
+		// party 2 sends the same message to all but relays a message of party 0 that party 0 did not sign
+		forged := &network.SignedDigest{Sender: 0, Tag: tag, Digest: network.Digest([]byte("forged")), Signature: make([]byte, ed25519.SignatureSize)}
+		equivocate(t, trs[2], tag, [][]byte{[]byte("two"), []byte("two"), nil}, map[int]*network.SignedDigest{0: forged})
+		wg.Wait()
+
+		for _, err := range errs {
+			checkAbort(t, err, 2, tag.Round+"/echo", pubs[2])
+		}
+	})
+}
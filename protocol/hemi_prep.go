@@ -1,13 +1,21 @@
 package protocol
 
 import (
+	"fmt"
+
+	"spdz-go/field"
 	"spdz-go/hpbfv"
 	"spdz-go/rlwe"
 	"spdz-go/utils"
-
-	"math/big"
 )
 
+// The pairwise OLE of Hemi answers the encryption ct of a secret x_j of party j, under the key of j for
+// party i, with b*ct - Enc(e_ij) for a secret b of party i, which j decrypts to b*x_j - e_ij. The noise of
+// the answer depends on b, so party i floods it with statistical security statSec, sized from the noise of
+// ct. Party j proves knowledge of the plaintexts of its encryptions, which bounds their noise: without the
+// proof, j could send a ciphertext whose noise the flooding does not hide, and learn b, such as the share
+// alpha_i of the MAC key.
+
 type HemiParty struct {
 	id int
 
@@ -18,15 +26,30 @@ type HemiParty struct {
 	sks []*rlwe.SecretKey // sks[j] = sk_{id, j}
 	pks []*rlwe.PublicKey // pks[j] = pk_{j, id}
 
-	ecd      *hpbfv.Encoder
-	eval     *hpbfv.Evaluator
-	encSelfs []*hpbfv.Encryptor
-	encs     []*hpbfv.Encryptor
-	decs     []*hpbfv.Decryptor
+	ecd       *hpbfv.Encoder
+	eval      *hpbfv.Evaluator
+	provers   []*hpbfv.PlaintextProver   // provers[j] encrypts and proves under pk_{id, j}
+	verifiers []*hpbfv.PlaintextVerifier // verifiers[j] verifies the encryptions of j under pk_{j, id}
+	encs      []*hpbfv.Encryptor
+	decs      []*hpbfv.Decryptor
 
 	prng utils.PRNG
 
-	triples []*Triple
+	alpha    field.Element  // share of the global MAC key
+	alphaMsg *hpbfv.Message // alpha replicated in every slot
+
+	triples     []*Triple
+	authTriples []*AuthTriple
+}
+
+// HemiAuthBatch holds the secret values a party keeps between the rounds of
+// authenticated triple generation for one batch of params.Slots() triples.
+// The pairwise masks are indexed by the peer they were sent to.
+type HemiAuthBatch struct {
+	a, b, c          *hpbfv.Message
+	macA, macB, macC *hpbfv.Message
+
+	eABs, eMacAs, eMacBs, eMacCs []*hpbfv.Message
 }
 
 func NewHemiParty(id int, params hpbfv.Parameters, numParties int) *HemiParty {
@@ -37,18 +60,22 @@ func NewHemiParty(id int, params hpbfv.Parameters, numParties int) *HemiParty {
 	}
 
 	return &HemiParty{
-		id:       id,
-		params:   params,
-		keygen:   keygen,
-		sks:      make([]*rlwe.SecretKey, numParties),
-		pks:      make([]*rlwe.PublicKey, numParties),
-		prng:     prng,
-		ecd:      hpbfv.NewEncoder(params),
-		encSelfs: make([]*hpbfv.Encryptor, numParties),
-		encs:     make([]*hpbfv.Encryptor, numParties),
-		decs:     make([]*hpbfv.Decryptor, numParties),
-		eval:     hpbfv.NewEvaluator(params),
-		triples:  make([]*Triple, 0),
+		id:      id,
+		params:  params,
+		keygen:  keygen,
+		sks:     make([]*rlwe.SecretKey, numParties),
+		pks:     make([]*rlwe.PublicKey, numParties),
+		prng:    prng,
+		ecd:     hpbfv.NewEncoder(params),
+		encs:    make([]*hpbfv.Encryptor, numParties),
+		decs:    make([]*hpbfv.Decryptor, numParties),
+		eval:    hpbfv.NewEvaluator(params),
+		triples: make([]*Triple, 0),
+
+		provers:   make([]*hpbfv.PlaintextProver, numParties),
+		verifiers: make([]*hpbfv.PlaintextVerifier, numParties),
+
+		authTriples: make([]*AuthTriple, 0),
 	}
 }
 
@@ -62,7 +89,7 @@ func (party *HemiParty) InitSetup(numParties int) []*rlwe.PublicKey {
 		sk, pk := party.keygen.GenKeyPair()
 		party.sks[j] = sk
 		pks[j] = pk
-		party.encSelfs[j] = hpbfv.NewEncryptor(party.params, pk)
+		party.provers[j] = hpbfv.NewPlaintextProver(party.params, pk, 1)
 		party.decs[j] = hpbfv.NewDecryptor(party.params, sk)
 	}
 	return pks
@@ -75,41 +102,13 @@ func (party *HemiParty) FinalizeSetup(pks []*rlwe.PublicKey) {
 			continue
 		}
 		party.encs[j] = hpbfv.NewEncryptor(party.params, pks[j])
+		party.verifiers[j] = hpbfv.NewPlaintextVerifier(party.params, pks[j])
 	}
 }
 
 // SampleUniformModT samples a message with coefficients uniformly random in [0, t)
 func (p *HemiParty) SampleUniformModT() *hpbfv.Message {
-	params := p.params
-	// Rejection sampling
-	samples := make([]*big.Int, params.Slots())
-	t := params.T()
-	bitLen := t.BitLen()
-	byteLen := (bitLen + 7) / 8
-	mask := big.NewInt(1)
-	mask.Lsh(mask, uint(bitLen))
-	mask.Sub(mask, big.NewInt(1))
-
-	for i := 0; i < params.Slots(); i++ {
-		for {
-			buf := make([]byte, byteLen)
-			_, err := p.prng.Read(buf)
-			if err != nil {
-				panic("cannot SampleUniformModT: PRNG read error")
-			}
-			sample := new(big.Int).SetBytes(buf)
-			sample.And(sample, mask)
-			if sample.Cmp(t) < 0 {
-				samples[i] = sample
-				break
-			}
-		}
-	}
-	msg := hpbfv.NewMessage(params)
-	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i] = samples[i]
-	}
-	return msg
+	return sampleUniformModT(p.params, p.prng)
 }
 
 func (party *HemiParty) SampleAandB() (*hpbfv.Message, *hpbfv.Message) {
@@ -118,29 +117,90 @@ func (party *HemiParty) SampleAandB() (*hpbfv.Message, *hpbfv.Message) {
 	return a, b
 }
 
-func (party *HemiParty) PairwiseRoundOne(a *hpbfv.Message, dst int) *hpbfv.Ciphertext {
-	ct := party.encSelfs[dst].EncryptMsgNew(a)
-	return ct
+// hemiProofContext binds a proof of plaintext knowledge to the step of the protocol and to the pair of
+// parties, so that a party cannot replay the ciphertexts and proof of another party.
+func hemiProofContext(label string, src, dst int) []byte {
+	return []byte(fmt.Sprintf("hemi/%s/party-%d/to-%d", label, src, dst))
+}
+
+// encryptAndProve encrypts msgs with the party's key for dst with a single proof of plaintext knowledge.
+func (party *HemiParty) encryptAndProve(label string, dst int, msgs ...*hpbfv.Message) ([]*hpbfv.Ciphertext, *hpbfv.PlaintextProof) {
+	return party.provers[dst].EncryptAndProve(msgs, hemiProofContext(label, party.id, dst))
+}
+
+// verifyPairwise checks the proof of plaintext knowledge of the ciphertexts cts that src encrypted with its
+// key for the party, and returns an AbortError naming src if it does not verify. The noise of the
+// ciphertexts is estimated as the largest that the proof accepts, see hpbfv.Parameters.ProvenNoise.
+func (party *HemiParty) verifyPairwise(label string, src int, proof *hpbfv.PlaintextProof, cts ...*hpbfv.Ciphertext) error {
+	if err := party.verifiers[src].Verify(cts, proof, hemiProofContext(label, src, party.id)); err != nil {
+		return &AbortError{Party: src, Round: label, Err: err}
+	}
+	for _, ct := range cts {
+		ct.SetNoise(party.params.ProvenNoise(len(cts), 1), 1)
+	}
+	return nil
 }
 
-func (party *HemiParty) PairwiseRoundTwo(ctIn *hpbfv.Ciphertext, b *hpbfv.Message, src int) (*hpbfv.Message, *hpbfv.Ciphertext) {
+// ole answers the verified encryption ctIn of src with an encryption of x times its message minus a fresh
+// mask e_ij under the key of src, flooded to hide x with statistical security statSec, and returns e_ij.
+// It returns an error wrapping hpbfv.ErrDecryptionFailure if the flooded answer would not decrypt correctly.
+func (party *HemiParty) ole(ctIn *hpbfv.Ciphertext, x *hpbfv.Message, src, statSec int) (*hpbfv.Message, *hpbfv.Ciphertext, error) {
 	eij := party.SampleUniformModT()
 	encEij := party.encs[src].EncryptMsgNew(eij)
 
-	ptB := party.ecd.EncodeNew(b)
+	ptX := party.ecd.EncodeNew(x)
 
-	cij := party.eval.PlaintextMulNew(ptB, ctIn)
+	cij := party.eval.PlaintextMulNew(ptX, ctIn)
 	party.eval.Sub(cij, encEij, cij)
 
-	return eij, cij
+	noise, _ := cij.Noise()
+	noiseBits, err := party.params.CiphertextFloodingNoiseBits(noise, statSec)
+	if err != nil {
+		return nil, nil, err
+	}
+	hpbfv.FloodNoise(party.params, cij, noiseBits, party.prng)
+	return eij, cij, nil
+}
+
+// PairwiseRoundOne encrypts a with the party's key for dst, with a proof of plaintext knowledge.
+func (party *HemiParty) PairwiseRoundOne(a *hpbfv.Message, dst int) (*hpbfv.Ciphertext, *hpbfv.PlaintextProof) {
+	cts, proof := party.encryptAndProve("triples", dst, a)
+	return cts[0], proof
+}
+
+// PairwiseRoundTwo verifies the proof of plaintext knowledge of the encryption ctIn of a_src and answers
+// it with the OLE output for b * a_src flooded with statistical security statSec, to be sent back to src.
+// It returns an AbortError naming src if the proof does not verify.
+func (party *HemiParty) PairwiseRoundTwo(ctIn *hpbfv.Ciphertext, proof *hpbfv.PlaintextProof, b *hpbfv.Message, src, statSec int) (*hpbfv.Message, *hpbfv.Ciphertext, error) {
+	if err := party.verifyPairwise("triples", src, proof, ctIn); err != nil {
+		return nil, nil, err
+	}
+	return party.ole(ctIn, b, src, statSec)
 }
 
 func (party *HemiParty) Finalize(a, b *hpbfv.Message, ejis []*hpbfv.Message, cijs []*hpbfv.Ciphertext) {
 	// Multiply a and b
+	f := party.params.Field()
 	ab := hpbfv.NewMessage(party.params)
 	for i := 0; i < party.params.Slots(); i++ {
-		ab.Value[i].Mul(a.Value[i], b.Value[i])
+		f.Mul(ab.Value[i], a.Value[i], b.Value[i])
 	}
+	ab = party.combinePairwise(ab, ejis, cijs)
+
+	for i := 0; i < party.params.Slots(); i++ {
+		party.triples = append(party.triples, &Triple{
+			A: a.Value[i],
+			B: b.Value[i],
+			C: ab.Value[i],
+		})
+	}
+}
+
+// combinePairwise adds to the local product the decryptions of the pairwise OLE outputs c_{i,j}
+// and the masks e_{i,j}, and returns the party's additive share of the cross product mod T.
+// The local message is modified in place.
+func (party *HemiParty) combinePairwise(local *hpbfv.Message, ejis []*hpbfv.Message, cijs []*hpbfv.Ciphertext) *hpbfv.Message {
+	f := party.params.Field()
 	for j, cij := range cijs {
 		if j == party.id {
 			continue
@@ -150,17 +210,116 @@ func (party *HemiParty) Finalize(a, b *hpbfv.Message, ejis []*hpbfv.Message, cij
 
 		// Add e_{i,j}
 		for i := 0; i < party.params.Slots(); i++ {
-			ab.Value[i].Add(ab.Value[i], dij.Value[i])
-			ab.Value[i].Add(ab.Value[i], ejis[j].Value[i])
+			f.Add(local.Value[i], local.Value[i], dij.Value[i])
+			f.Add(local.Value[i], local.Value[i], ejis[j].Value[i])
 		}
 	}
+	return local
+}
 
+// mulAlpha returns alpha_i * x mod T slot-wise, the local term of the MAC share of x.
+func (party *HemiParty) mulAlpha(x *hpbfv.Message) *hpbfv.Message {
+	f := party.params.Field()
+	out := hpbfv.NewMessage(party.params)
 	for i := 0; i < party.params.Slots(); i++ {
-		ab.Value[i].Mod(ab.Value[i], party.params.T())
-		party.triples = append(party.triples, &Triple{
-			A: a.Value[i],
-			B: b.Value[i],
-			C: ab.Value[i],
-		})
+		f.Mul(out.Value[i], party.alpha, x.Value[i])
+	}
+	return out
+}
+
+// SetupMacKey samples the party's share of the global MAC key alpha.
+// Unlike Soho, no encryption of alpha is needed: the cross terms alpha_j * x_i are obtained by pairwise OLE.
+func (party *HemiParty) SetupMacKey() {
+	f := party.params.Field()
+	party.alpha = party.SampleUniformModT().Value[0]
+	party.alphaMsg = hpbfv.NewMessage(party.params)
+	for i := 0; i < party.params.Slots(); i++ {
+		f.Set(party.alphaMsg.Value[i], party.alpha)
 	}
 }
+
+// MacKeyShare returns the party's share of the global MAC key.
+func (party *HemiParty) MacKeyShare() field.Element {
+	f := party.params.Field()
+	return f.Set(f.NewElement(), party.alpha)
+}
+
+// SampleAuthBatch samples the shares of a and b for a new batch of authenticated triples.
+func (party *HemiParty) SampleAuthBatch() *HemiAuthBatch {
+	numParties := len(party.pks)
+	batch := &HemiAuthBatch{
+		eABs:   make([]*hpbfv.Message, numParties),
+		eMacAs: make([]*hpbfv.Message, numParties),
+		eMacBs: make([]*hpbfv.Message, numParties),
+		eMacCs: make([]*hpbfv.Message, numParties),
+	}
+	batch.a, batch.b = party.SampleAandB()
+	return batch
+}
+
+// AuthPairwiseRoundOne encrypts a and b with the party's own key for dst, with a single proof of plaintext
+// knowledge.
+func (party *HemiParty) AuthPairwiseRoundOne(batch *HemiAuthBatch, dst int) (ca, cb *hpbfv.Ciphertext, proof *hpbfv.PlaintextProof) {
+	var cts []*hpbfv.Ciphertext
+	cts, proof = party.encryptAndProve("auth-triples", dst, batch.a, batch.b)
+	return cts[0], cts[1], proof
+}
+
+// AuthPairwiseRoundTwo verifies the proof of plaintext knowledge of the encryptions of a_src and b_src and
+// answers them with the OLE outputs for b_i * a_src, alpha_i * a_src and alpha_i * b_src, flooded with
+// statistical security statSec, to be sent back to src.
+// It returns an AbortError naming src if the proof does not verify.
+func (party *HemiParty) AuthPairwiseRoundTwo(batch *HemiAuthBatch, ca, cb *hpbfv.Ciphertext, proof *hpbfv.PlaintextProof, src, statSec int) (cAB, cMacA, cMacB *hpbfv.Ciphertext, err error) {
+	if err = party.verifyPairwise("auth-triples", src, proof, ca, cb); err != nil {
+		return
+	}
+	if batch.eABs[src], cAB, err = party.ole(ca, batch.b, src, statSec); err != nil {
+		return
+	}
+	if batch.eMacAs[src], cMacA, err = party.ole(ca, party.alphaMsg, src, statSec); err != nil {
+		return
+	}
+	batch.eMacBs[src], cMacB, err = party.ole(cb, party.alphaMsg, src, statSec)
+	return
+}
+
+// AuthCombineRoundTwo computes the party's shares of c = a*b and of the MACs of a and b
+// from the OLE outputs received from every peer.
+func (party *HemiParty) AuthCombineRoundTwo(batch *HemiAuthBatch, cABs, cMacAs, cMacBs []*hpbfv.Ciphertext) {
+	f := party.params.Field()
+	ab := hpbfv.NewMessage(party.params)
+	for i := 0; i < party.params.Slots(); i++ {
+		f.Mul(ab.Value[i], batch.a.Value[i], batch.b.Value[i])
+	}
+	batch.c = party.combinePairwise(ab, batch.eABs, cABs)
+	batch.macA = party.combinePairwise(party.mulAlpha(batch.a), batch.eMacAs, cMacAs)
+	batch.macB = party.combinePairwise(party.mulAlpha(batch.b), batch.eMacBs, cMacBs)
+}
+
+// AuthPairwiseRoundThree encrypts the party's share of c with its own key for dst, with a proof of
+// plaintext knowledge.
+func (party *HemiParty) AuthPairwiseRoundThree(batch *HemiAuthBatch, dst int) (*hpbfv.Ciphertext, *hpbfv.PlaintextProof) {
+	cts, proof := party.encryptAndProve("auth-triples/c", dst, batch.c)
+	return cts[0], proof
+}
+
+// AuthPairwiseRoundFour verifies the proof of plaintext knowledge of the encryption of c_src and answers
+// it with the OLE output for alpha_i * c_src, flooded with statistical security statSec.
+// It returns an AbortError naming src if the proof does not verify.
+func (party *HemiParty) AuthPairwiseRoundFour(batch *HemiAuthBatch, cc *hpbfv.Ciphertext, proof *hpbfv.PlaintextProof, src, statSec int) (*hpbfv.Ciphertext, error) {
+	if err := party.verifyPairwise("auth-triples/c", src, proof, cc); err != nil {
+		return nil, err
+	}
+	var cMacC *hpbfv.Ciphertext
+	var err error
+	batch.eMacCs[src], cMacC, err = party.ole(cc, party.alphaMsg, src, statSec)
+	return cMacC, err
+}
+
+// FinalizeAuthTriple computes the party's share of the MAC of c and stores the authenticated triples of the batch.
+func (party *HemiParty) FinalizeAuthTriple(batch *HemiAuthBatch, cMacCs []*hpbfv.Ciphertext) {
+	batch.macC = party.combinePairwise(party.mulAlpha(batch.c), batch.eMacCs, cMacCs)
+
+	party.authTriples = append(party.authTriples,
+		newAuthTriples(party.params, batch.a, batch.b, batch.c, batch.macA, batch.macB, batch.macC)...)
+}
@@ -1,12 +1,13 @@
 package protocol
 
 import (
+	"errors"
 	"testing"
 
+	"spdz-go/field"
 	"spdz-go/hpbfv"
 	"spdz-go/rlwe"
 
-	"math/big"
 	"sync"
 )
 
@@ -19,6 +20,7 @@ type hemiPkMsg struct {
 type hemiRoundOneMessage struct {
 	SenderID   int
 	Ciphertext *hpbfv.Ciphertext
+	Proof      *hpbfv.PlaintextProof
 }
 
 type hemiRoundTwoMessage struct {
@@ -34,7 +36,10 @@ type hemiPartyChannels struct {
 }
 
 func TestHemiPrep(t *testing.T) {
-	params := hpbfv.NewParametersFromLiteral(hpbfv.HEMI)
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Number of Parties
 	numParties := 3
@@ -68,26 +73,23 @@ func TestHemiPrep(t *testing.T) {
 		parties[p.id] = p
 	}
 
+	f := params.Field()
 	for i := range parties[0].triples {
-		aSum := new(big.Int)
-		bSum := new(big.Int)
-		cSum := new(big.Int)
+		aSum := f.NewElement()
+		bSum := f.NewElement()
+		cSum := f.NewElement()
 
 		for _, party := range parties {
-			aSum.Add(aSum, party.triples[i].A)
-			bSum.Add(bSum, party.triples[i].B)
-			cSum.Add(cSum, party.triples[i].C)
+			f.Add(aSum, aSum, party.triples[i].A)
+			f.Add(bSum, bSum, party.triples[i].B)
+			f.Add(cSum, cSum, party.triples[i].C)
 		}
-		aSum.Mod(aSum, params.T())
-		bSum.Mod(bSum, params.T())
-		cSum.Mod(cSum, params.T())
 
 		// Check if cSum = aSum * bSum
-		ab := new(big.Int).Mul(aSum, bSum)
-		ab.Mod(ab, params.T())
+		ab := f.Mul(f.NewElement(), aSum, bSum)
 
-		if cSum.Cmp(ab) != 0 {
-			t.Errorf("Triple check failed at index %d: a=%s, b=%s, c=%s, ab=%s", i, aSum.String(), bSum.String(), cSum.String(), ab.String())
+		if !f.Equal(cSum, ab) {
+			t.Errorf("Triple check failed at index %d: a=%s, b=%s, c=%s, ab=%s", i, f.Big(aSum), f.Big(bSum), f.Big(cSum), f.Big(ab))
 		}
 	}
 }
@@ -118,17 +120,17 @@ func runParty(id, numParties int, params hpbfv.Parameters, allChans []hemiPartyC
 		if peer == id {
 			continue
 		}
-		cA := party.PairwiseRoundOne(a, peer)
+		cA, proof := party.PairwiseRoundOne(a, peer)
 
 		// Send a's encryption by peer's public key to peer
-		allChans[peer].r1In <- hemiRoundOneMessage{SenderID: id, Ciphertext: cA}
+		allChans[peer].r1In <- hemiRoundOneMessage{SenderID: id, Ciphertext: cA, Proof: proof}
 	}
 
 	// Receive cA from other parties
-	cAs := make([]*hpbfv.Ciphertext, numParties)
+	r1 := make([]hemiRoundOneMessage, numParties)
 	for i := 0; i < numParties - 1; i++ {
 		msg := <-allChans[id].r1In
-		cAs[msg.SenderID] = msg.Ciphertext
+		r1[msg.SenderID] = msg
 	}
 
 	// --- Round 2 (Pairwise): compute e_{i,j} and c_{i,j} ---
@@ -139,7 +141,10 @@ func runParty(id, numParties int, params hpbfv.Parameters, allChans []hemiPartyC
 		}
 		// Compute e_{i,j} and c_{i,j}
 		var cij *hpbfv.Ciphertext
-		ejis[peer], cij = party.PairwiseRoundTwo(cAs[peer], b, peer)
+		var err error
+		if ejis[peer], cij, err = party.PairwiseRoundTwo(r1[peer].Ciphertext, r1[peer].Proof, b, peer, 40); err != nil {
+			panic(err)
+		}
 
 		// Send cij to peer
 		allChans[peer].r2In <- hemiRoundTwoMessage{SenderID: id, Ciphertext: cij}
@@ -155,3 +160,248 @@ func runParty(id, numParties int, params hpbfv.Parameters, allChans []hemiPartyC
 	party.Finalize(a, b, ejis, cijs)
 	resultChan <- party
 }
+
+type hemiAuthRoundOneMessage struct {
+	SenderID int
+	CA       *hpbfv.Ciphertext
+	CB       *hpbfv.Ciphertext
+	Proof    *hpbfv.PlaintextProof
+}
+
+type hemiAuthRoundTwoMessage struct {
+	SenderID int
+	CAB      *hpbfv.Ciphertext
+	CMacA    *hpbfv.Ciphertext
+	CMacB    *hpbfv.Ciphertext
+}
+
+// hemiAuthPartyChannels holds the input channels for a specific party
+type hemiAuthPartyChannels struct {
+	pkIn chan hemiPkMsg
+	r1In chan hemiAuthRoundOneMessage
+	r2In chan hemiAuthRoundTwoMessage
+	r3In chan hemiRoundOneMessage
+	r4In chan hemiRoundTwoMessage
+}
+
+func TestHemiAuthPrep(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numParties := 3
+
+	partyChans := make([]hemiAuthPartyChannels, numParties)
+	for i := 0; i < numParties; i++ {
+		partyChans[i] = hemiAuthPartyChannels{
+			pkIn: make(chan hemiPkMsg, numParties),
+			r1In: make(chan hemiAuthRoundOneMessage, numParties),
+			r2In: make(chan hemiAuthRoundTwoMessage, numParties),
+			r3In: make(chan hemiRoundOneMessage, numParties),
+			r4In: make(chan hemiRoundTwoMessage, numParties),
+		}
+	}
+
+	finishedParties := make(chan *HemiParty, numParties)
+	var wg sync.WaitGroup
+
+	for i := 0; i < numParties; i++ {
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			runAuthParty(pid, numParties, params, partyChans, finishedParties)
+		}(i)
+	}
+	wg.Wait()
+	close(finishedParties)
+
+	parties := make([]*HemiParty, numParties)
+	for p := range finishedParties {
+		parties[p.id] = p
+	}
+
+	f := params.Field()
+	alpha := f.NewElement()
+	for _, party := range parties {
+		f.Add(alpha, alpha, party.MacKeyShare())
+	}
+
+	if len(parties[0].authTriples) != params.Slots() {
+		t.Fatalf("expected %d authenticated triples, got %d", params.Slots(), len(parties[0].authTriples))
+	}
+
+	for i := range parties[0].authTriples {
+		sums := f.NewVector(6)
+		for _, party := range parties {
+			triple := party.authTriples[i]
+			for k, v := range []field.Element{triple.A.Value, triple.B.Value, triple.C.Value, triple.A.Mac, triple.B.Mac, triple.C.Mac} {
+				f.Add(sums[k], sums[k], v)
+			}
+		}
+
+		ab := f.Mul(f.NewElement(), sums[0], sums[1])
+		if !f.Equal(sums[2], ab) {
+			t.Fatalf("Triple check failed at index %d: a=%s, b=%s, c=%s, ab=%s", i, f.Big(sums[0]), f.Big(sums[1]), f.Big(sums[2]), f.Big(ab))
+		}
+
+		for k, name := range []string{"a", "b", "c"} {
+			expected := f.Mul(f.NewElement(), alpha, sums[k])
+			if !f.Equal(sums[k+3], expected) {
+				t.Fatalf("MAC check failed for %s at index %d: mac=%s, alpha*%s=%s", name, i, f.Big(sums[k+3]), name, f.Big(expected))
+			}
+		}
+	}
+}
+
+// runAuthParty executes the authenticated triple generation for a single party
+func runAuthParty(id, numParties int, params hpbfv.Parameters, allChans []hemiAuthPartyChannels, resultChan chan<- *HemiParty) {
+	party := NewHemiParty(id, params, numParties)
+
+	//  --- Round 0: Key Generation & Exchange ---
+	pk := party.InitSetup(numParties)
+	for peer := 0; peer < numParties; peer++ {
+		allChans[peer].pkIn <- hemiPkMsg{SenderID: id, Key: pk[peer]}
+	}
+	pks := make([]*rlwe.PublicKey, numParties)
+	for j := 0; j < numParties; j++ {
+		msg := <-allChans[id].pkIn
+		pks[msg.SenderID] = msg.Key
+	}
+	party.FinalizeSetup(pks)
+	party.SetupMacKey()
+
+	batch := party.SampleAuthBatch()
+
+	// --- Round 1 (Pairwise): Encrypt a and b with own key for peer ---
+	for peer := 0; peer < numParties; peer++ {
+		if peer == id {
+			continue
+		}
+		cA, cB, proof := party.AuthPairwiseRoundOne(batch, peer)
+		allChans[peer].r1In <- hemiAuthRoundOneMessage{SenderID: id, CA: cA, CB: cB, Proof: proof}
+	}
+	r1 := make([]hemiAuthRoundOneMessage, numParties)
+	for i := 0; i < numParties-1; i++ {
+		msg := <-allChans[id].r1In
+		r1[msg.SenderID] = msg
+	}
+
+	// --- Round 2 (Pairwise): OLE for b*a, alpha*a, alpha*b ---
+	for peer := 0; peer < numParties; peer++ {
+		if peer == id {
+			continue
+		}
+		cAB, cMacA, cMacB, err := party.AuthPairwiseRoundTwo(batch, r1[peer].CA, r1[peer].CB, r1[peer].Proof, peer, 40)
+		if err != nil {
+			panic(err)
+		}
+		allChans[peer].r2In <- hemiAuthRoundTwoMessage{SenderID: id, CAB: cAB, CMacA: cMacA, CMacB: cMacB}
+	}
+	cABs := make([]*hpbfv.Ciphertext, numParties)
+	cMacAs := make([]*hpbfv.Ciphertext, numParties)
+	cMacBs := make([]*hpbfv.Ciphertext, numParties)
+	for i := 0; i < numParties-1; i++ {
+		msg := <-allChans[id].r2In
+		cABs[msg.SenderID] = msg.CAB
+		cMacAs[msg.SenderID] = msg.CMacA
+		cMacBs[msg.SenderID] = msg.CMacB
+	}
+	party.AuthCombineRoundTwo(batch, cABs, cMacAs, cMacBs)
+
+	// --- Round 3 (Pairwise): Encrypt c with own key for peer ---
+	for peer := 0; peer < numParties; peer++ {
+		if peer == id {
+			continue
+		}
+		cC, proof := party.AuthPairwiseRoundThree(batch, peer)
+		allChans[peer].r3In <- hemiRoundOneMessage{SenderID: id, Ciphertext: cC, Proof: proof}
+	}
+	r3 := make([]hemiRoundOneMessage, numParties)
+	for i := 0; i < numParties-1; i++ {
+		msg := <-allChans[id].r3In
+		r3[msg.SenderID] = msg
+	}
+
+	// --- Round 4 (Pairwise): OLE for alpha*c ---
+	for peer := 0; peer < numParties; peer++ {
+		if peer == id {
+			continue
+		}
+		cMacC, err := party.AuthPairwiseRoundFour(batch, r3[peer].Ciphertext, r3[peer].Proof, peer, 40)
+		if err != nil {
+			panic(err)
+		}
+		allChans[peer].r4In <- hemiRoundTwoMessage{SenderID: id, Ciphertext: cMacC}
+	}
+	cMacCs := make([]*hpbfv.Ciphertext, numParties)
+	for i := 0; i < numParties-1; i++ {
+		msg := <-allChans[id].r4In
+		cMacCs[msg.SenderID] = msg.Ciphertext
+	}
+
+	// --- Finalize ---
+	party.FinalizeAuthTriple(batch, cMacCs)
+	resultChan <- party
+}
+
+// setupHemiParties runs the key generation and MAC key setup of numParties Hemi parties sequentially
+func setupHemiParties(params hpbfv.Parameters, numParties int) []*HemiParty {
+	parties := make([]*HemiParty, numParties)
+	pks := make([][]*rlwe.PublicKey, numParties)
+	for i := range parties {
+		parties[i] = NewHemiParty(i, params, numParties)
+		pks[i] = parties[i].InitSetup(numParties)
+	}
+	for i, party := range parties {
+		received := make([]*rlwe.PublicKey, numParties)
+		for j := range parties {
+			received[j] = pks[j][i]
+		}
+		party.FinalizeSetup(received)
+		party.SetupMacKey()
+	}
+	return parties
+}
+
+func TestHemiRejectsUnprovenCiphertexts(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parties := setupHemiParties(params, 3)
+
+	batch1 := parties[1].SampleAuthBatch()
+	ca, cb, proof := parties[1].AuthPairwiseRoundOne(batch1, 0)
+
+	t.Run("Replayed", func(t *testing.T) {
+		// party 2 replays the ciphertexts and proof of party 1
+		_, _, _, err := parties[0].AuthPairwiseRoundTwo(parties[0].SampleAuthBatch(), ca, cb, proof, 2, 40)
+		var abort *AbortError
+		if !errors.Is(err, hpbfv.ErrInvalidProof) || !errors.As(err, &abort) || abort.Party != 2 {
+			t.Fatalf("expected an invalid proof of party 2, got %v", err)
+		}
+	})
+
+	t.Run("Unproven", func(t *testing.T) {
+		// party 1 sends an encryption that is not covered by its proof
+		unproven := parties[1].encs[0].EncryptMsgNew(parties[1].SampleUniformModT())
+		_, _, _, err := parties[0].AuthPairwiseRoundTwo(parties[0].SampleAuthBatch(), unproven, cb, proof, 1, 40)
+		var abort *AbortError
+		if !errors.Is(err, hpbfv.ErrInvalidProof) || !errors.As(err, &abort) || abort.Party != 1 {
+			t.Fatalf("expected an invalid proof of party 1, got %v", err)
+		}
+	})
+
+	t.Run("Flooded", func(t *testing.T) {
+		_, cMacA, _, err := parties[0].AuthPairwiseRoundTwo(parties[0].SampleAuthBatch(), ca, cb, proof, 1, 40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the answer is flooded beyond the noise of alpha_0 times the proven encryption
+		noise, _ := cMacA.Noise()
+		if unflooded := params.PlaintextMulNoise(params.ProvenNoise(2, 1), 1); noise < unflooded+40 {
+			t.Fatalf("noise of the answer %.1f is not flooded above %.1f", noise, unflooded)
+		}
+	})
+}
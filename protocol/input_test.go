@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"testing"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+)
+
+func TestSohoInput(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numParties := 3
+	owner := 1
+
+	parties := setupSohoParties(t, params, numParties)
+
+	// Input mask generation
+	batches := make([]*SohoInputBatch, numParties)
+	cRs := make([]*hpbfv.Ciphertext, numParties)
+	css := make([]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for i, party := range parties {
+		batches[i], cRs[i], css[i], proofs[i] = party.InputMasksRoundOne(owner)
+	}
+	shMacs := make([]*ReshareShare, numParties)
+	shRs := make([]*ReshareShare, numParties)
+	for i, party := range parties {
+		var err error
+		if shMacs[i], shRs[i], err = party.InputMasksRoundTwo(batches[i], cRs, css, proofs, 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, party := range parties {
+		received := shRs
+		if i != owner {
+			received = nil
+		}
+		if err := party.FinalizeInputMasks(batches[i], shMacs, received, 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := params.Field()
+	alphas := make([]field.Element, numParties)
+	for i, party := range parties {
+		alphas[i] = party.MacKeyShare()
+	}
+	alpha := f.NewElement()
+	for _, a := range alphas {
+		f.Add(alpha, alpha, a)
+	}
+
+	t.Run("InputMasks", func(t *testing.T) {
+		masks := parties[owner].inputMasks[owner]
+		if len(masks) != params.Slots() {
+			t.Fatalf("expected %d input masks, got %d", params.Slots(), len(masks))
+		}
+		for k := range masks {
+			r, mac := f.NewElement(), f.NewElement()
+			for _, party := range parties {
+				f.Add(r, r, party.inputMasks[owner][k].Share.Value)
+				f.Add(mac, mac, party.inputMasks[owner][k].Share.Mac)
+			}
+
+			if !f.Equal(r, masks[k].Value) {
+				t.Fatalf("owner learnt r=%s at index %d, but shares sum to %s", f.Big(masks[k].Value), k, f.Big(r))
+			}
+			expected := f.Mul(f.NewElement(), alpha, r)
+			if !f.Equal(mac, expected) {
+				t.Fatalf("MAC check failed at index %d: mac=%s, alpha*r=%s", k, f.Big(mac), f.Big(expected))
+			}
+			if parties[0].inputMasks[owner][k].Value != nil {
+				t.Fatalf("non-owner learnt the input mask at index %d", k)
+			}
+		}
+	})
+
+	t.Run("Input", func(t *testing.T) {
+		triples := dealAuthTriples(t, params, alphas, 1)
+		ctx := &onlineTestContext{params: params, alphas: alphas, engines: make([]*Engine, numParties)}
+		for i, party := range parties {
+			ctx.engines[i] = NewEngine(i, params, numParties, alphas[i], NewTripleQueue(triples[i]))
+			ctx.engines[i].SetInputMaskSource(party)
+		}
+
+		values := []field.Element{randModT(t, params), randModT(t, params)}
+
+		inputBatches := make([]*InputBatch, numParties)
+		var masked []field.Element
+		for i, e := range ctx.engines {
+			var vs []field.Element
+			if i == owner {
+				vs = values
+			}
+			batch, out, err := e.InputInit(owner, len(values), vs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inputBatches[i] = batch
+			if i == owner {
+				masked = out
+			}
+		}
+
+		xs := make([][]AuthShare, numParties)
+		ys := make([][]AuthShare, numParties)
+		for i, e := range ctx.engines {
+			shares, err := e.InputFinalize(inputBatches[i], masked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xs[i], ys[i] = shares[:1], shares[1:]
+		}
+
+		zs := ctx.mul(t, xs, ys)
+		ctx.output(t, zs)
+
+		expected := f.Mul(f.NewElement(), values[0], values[1])
+
+		outputs, errs := ctx.macCheck(t)
+		for i := range ctx.engines {
+			if errs[i] != nil {
+				t.Fatalf("party %d: unexpected MAC check error: %v", i, errs[i])
+			}
+			if !f.Equal(outputs[i][0], expected) {
+				t.Fatalf("party %d: got %s, want %s", i, f.Big(outputs[i][0]), f.Big(expected))
+			}
+		}
+	})
+}
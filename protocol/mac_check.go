@@ -0,0 +1,196 @@
+package protocol
+
+import (
+	"spdz-go/field"
+	"spdz-go/utils"
+
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrMacCheckFailed is returned when the MAC shares of the opened values do not sum to zero,
+// i.e. some party has cheated during an opening.
+var ErrMacCheckFailed = errors.New("MAC check failed")
+
+// coinTossSeedSize is the size in bytes of each party's contribution to a coin toss.
+const coinTossSeedSize = 32
+
+// MacCheck runs the commit-and-open MAC check on every value opened by an Engine since the
+// previous check. The protocol takes four broadcast rounds:
+//
+//  1. each party commits to a random seed (MacCheckInit);
+//  2. each party reveals its seed (RoundTwo);
+//  3. each party derives the joint coefficients r_j, computes sigma_i = sum_j r_j (gamma_ij - alpha_i x_j)
+//     and commits to it (RoundThree);
+//  4. each party reveals sigma_i (RoundFour), and the check passes iff sum_i sigma_i = 0 (Finalize).
+//
+// Messages of all parties are indexed by sender ID.
+type MacCheck struct {
+	engine *Engine
+
+	values  []field.Element
+	macs    []field.Element
+	outputs []field.Element
+
+	coin *coinToss
+
+	sigma, sigmaOpening []byte
+	sigmaComs           [][]byte
+}
+
+// coinToss is a commit-and-open coin toss: each party commits to a random seed, then all seeds
+// are revealed and hashed into a joint random stream.
+type coinToss struct {
+	seed, opening []byte
+	coms          [][]byte
+}
+
+// MacCheckInit starts a MAC check over the values opened so far and returns the commitment
+// to the party's coin-toss seed. Values opened and outputs withheld after this call are left
+// for the next check.
+func (e *Engine) MacCheckInit() (*MacCheck, []byte, error) {
+	coin, com, err := newCoinToss()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mc := &MacCheck{
+		engine:  e,
+		values:  e.openedValues,
+		macs:    e.openedMacs,
+		outputs: e.outputs,
+		coin:    coin,
+	}
+	e.openedValues, e.openedMacs, e.outputs = nil, nil, nil
+
+	return mc, com, nil
+}
+
+// RoundTwo records the seed commitments of all parties and reveals the party's seed and its opening.
+func (mc *MacCheck) RoundTwo(seedComs [][]byte) (seed, opening []byte) {
+	return mc.coin.reveal(seedComs)
+}
+
+// RoundThree checks the revealed seeds against their commitments, derives the joint random
+// coefficients and returns the commitment to sigma_i.
+func (mc *MacCheck) RoundThree(seeds, openings [][]byte) ([]byte, error) {
+	xof, err := mc.coin.finalize(seeds, openings)
+	if err != nil {
+		return nil, err
+	}
+
+	f := mc.engine.f
+	v := f.NewVector(4)
+	a, gamma, r, prod := v[0], v[1], v[2], v[3]
+	for j := range mc.values {
+		f.Sample(xof, r)
+		f.Add(a, a, f.Mul(prod, r, mc.values[j]))
+		f.Add(gamma, gamma, f.Mul(prod, r, mc.macs[j]))
+	}
+
+	// sigma_i = gamma_i - alpha_i * a
+	sigma := f.Sub(a, gamma, f.Mul(a, a, mc.engine.alpha))
+	mc.sigma = make([]byte, f.ElementSize())
+	f.Encode(mc.sigma, sigma)
+
+	var com []byte
+	if com, mc.sigmaOpening, err = utils.Commit(mc.sigma); err != nil {
+		return nil, err
+	}
+	return com, nil
+}
+
+// RoundFour records the sigma commitments of all parties and reveals sigma_i and its opening.
+func (mc *MacCheck) RoundFour(sigmaComs [][]byte) (sigma, opening []byte) {
+	mc.sigmaComs = sigmaComs
+	return mc.sigma, mc.sigmaOpening
+}
+
+// Finalize checks the revealed sigmas against their commitments and that they sum to zero.
+// On success it releases the outputs withheld by Engine.OutputFinalize before MacCheckInit.
+// On failure it returns ErrMacCheckFailed, or an AbortError naming a party that opened its commitment
+// incorrectly, and the outputs are discarded.
+func (mc *MacCheck) Finalize(sigmas, openings [][]byte) ([]field.Element, error) {
+	f := mc.engine.f
+
+	if err := checkOpenings("sigma", mc.sigmaComs, sigmas, openings); err != nil {
+		return nil, err
+	}
+
+	v := f.NewVector(2)
+	sum, sigma := v[0], v[1]
+	for j := range sigmas {
+		if err := f.Decode(sigma, sigmas[j]); err != nil {
+			return nil, &AbortError{Party: j, Round: "reveal-sigma", Err: fmt.Errorf("invalid sigma: %w", err)}
+		}
+		f.Add(sum, sum, sigma)
+	}
+	if !f.IsZero(sum) {
+		return nil, ErrMacCheckFailed
+	}
+	return mc.outputs, nil
+}
+
+// newCoinToss samples the party's seed and returns the commitment to it.
+func newCoinToss() (*coinToss, []byte, error) {
+	coin := &coinToss{seed: make([]byte, coinTossSeedSize)}
+
+	prng, err := utils.NewPRNG()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = prng.Read(coin.seed); err != nil {
+		return nil, nil, err
+	}
+
+	var com []byte
+	if com, coin.opening, err = utils.Commit(coin.seed); err != nil {
+		return nil, nil, err
+	}
+	return coin, com, nil
+}
+
+// reveal records the seed commitments of all parties and returns the party's seed and its opening.
+func (coin *coinToss) reveal(coms [][]byte) (seed, opening []byte) {
+	coin.coms = coms
+	return coin.seed, coin.opening
+}
+
+// finalize checks the revealed seeds against their commitments and returns the joint random stream.
+// It returns an AbortError naming the first party whose seed is not coinTossSeedSize bytes long, so that
+// the concatenation of the seeds hashed into the stream is unambiguous.
+func (coin *coinToss) finalize(seeds, openings [][]byte) (utils.PRNG, error) {
+	if err := checkOpenings("seed", coin.coms, seeds, openings); err != nil {
+		return nil, err
+	}
+	for j, seed := range seeds {
+		if len(seed) != coinTossSeedSize {
+			return nil, &AbortError{Party: j, Round: "reveal-seed", Err: fmt.Errorf("seed of %d bytes, expected %d", len(seed), coinTossSeedSize)}
+		}
+	}
+
+	xof, err := blake2b.NewXOF(blake2b.OutputLengthUnknown, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, seed := range seeds {
+		xof.Write(seed)
+	}
+	return xof, nil
+}
+
+// checkOpenings verifies that every party opened its commitment correctly, and returns an AbortError
+// naming the first party that did not.
+func checkOpenings(name string, coms, msgs, openings [][]byte) error {
+	if len(msgs) != len(coms) || len(openings) != len(coms) {
+		return fmt.Errorf("cannot open %s commitments: got %d messages and %d openings for %d commitments", name, len(msgs), len(openings), len(coms))
+	}
+	for j := range coms {
+		if !utils.VerifyCommitment(coms[j], msgs[j], openings[j]) {
+			return &AbortError{Party: j, Round: "reveal-" + name, Err: fmt.Errorf("%s commitment opened incorrectly", name)}
+		}
+	}
+	return nil
+}
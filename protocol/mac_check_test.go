@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+	"spdz-go/utils"
+)
+
+// macCheck runs a MAC check across all engines and returns the released outputs and errors of each party
+func (ctx *onlineTestContext) macCheck(t *testing.T) ([][]field.Element, []error) {
+	n := len(ctx.engines)
+	mcs := make([]*MacCheck, n)
+	coms := make([][]byte, n)
+	for j, e := range ctx.engines {
+		var err error
+		if mcs[j], coms[j], err = e.MacCheckInit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seeds := make([][]byte, n)
+	openings := make([][]byte, n)
+	for j, mc := range mcs {
+		seeds[j], openings[j] = mc.RoundTwo(coms)
+	}
+
+	sigmaComs := make([][]byte, n)
+	for j, mc := range mcs {
+		var err error
+		if sigmaComs[j], err = mc.RoundThree(seeds, openings); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sigmas := make([][]byte, n)
+	for j, mc := range mcs {
+		sigmas[j], openings[j] = mc.RoundFour(sigmaComs)
+	}
+
+	outputs := make([][]field.Element, n)
+	errs := make([]error, n)
+	for j, mc := range mcs {
+		outputs[j], errs[j] = mc.Finalize(sigmas, openings)
+	}
+	return outputs, errs
+}
+
+// output opens xs as outputs across all engines
+func (ctx *onlineTestContext) output(t *testing.T, xs [][]AuthShare) {
+	shares := make([][]field.Element, len(ctx.engines))
+	for j, e := range ctx.engines {
+		shares[j] = e.OpenInit(xs[j])
+	}
+	for j, e := range ctx.engines {
+		if err := e.OutputFinalize(xs[j], shares); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMacCheck(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := params.Field()
+	numParties := 3
+
+	deal := func(ctx *onlineTestContext, x field.Element) [][]AuthShare {
+		shares := make([][]AuthShare, numParties)
+		for j, sh := range dealAuthShares(t, params, ctx.alphas, x) {
+			shares[j] = []AuthShare{sh}
+		}
+		return shares
+	}
+
+	t.Run("Honest", func(t *testing.T) {
+		ctx := genOnlineTestContext(t, params, numParties, 1)
+		x, y := randModT(t, params), randModT(t, params)
+
+		zs := ctx.mul(t, deal(ctx, x), deal(ctx, y))
+		ctx.output(t, zs)
+
+		expected := f.Mul(f.NewElement(), x, y)
+
+		outputs, errs := ctx.macCheck(t)
+		for j := range ctx.engines {
+			if errs[j] != nil {
+				t.Fatalf("party %d: unexpected MAC check error: %v", j, errs[j])
+			}
+			if len(outputs[j]) != 1 || !f.Equal(outputs[j][0], expected) {
+				t.Fatalf("party %d: released outputs %v, want [%s]", j, outputs[j], f.Big(expected))
+			}
+		}
+
+		// nothing left to check
+		outputs, errs = ctx.macCheck(t)
+		for j := range ctx.engines {
+			if errs[j] != nil || len(outputs[j]) != 0 {
+				t.Fatalf("party %d: second MAC check returned %v, %v", j, outputs[j], errs[j])
+			}
+		}
+	})
+
+	t.Run("Cheating", func(t *testing.T) {
+		ctx := genOnlineTestContext(t, params, numParties, 1)
+		xs := deal(ctx, randModT(t, params))
+
+		// party 1 adds an error to its value share before opening
+		f.Add(xs[1][0].Value, xs[1][0].Value, f.SetUint64(f.NewElement(), 1))
+		ctx.output(t, xs)
+
+		outputs, errs := ctx.macCheck(t)
+		for j := range ctx.engines {
+			if errs[j] != ErrMacCheckFailed {
+				t.Fatalf("party %d: expected ErrMacCheckFailed, got %v", j, errs[j])
+			}
+			if outputs[j] != nil {
+				t.Fatalf("party %d: outputs released despite failed MAC check", j)
+			}
+		}
+	})
+	t.Run("BadOpening", func(t *testing.T) {
+		ctx := genOnlineTestContext(t, params, numParties, 1)
+		mcs := make([]*MacCheck, numParties)
+		coms := make([][]byte, numParties)
+		for j, e := range ctx.engines {
+			var err error
+			if mcs[j], coms[j], err = e.MacCheckInit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		seeds := make([][]byte, numParties)
+		openings := make([][]byte, numParties)
+		for j, mc := range mcs {
+			seeds[j], openings[j] = mc.RoundTwo(coms)
+		}
+
+		// party 2 reveals another seed than the one it committed to
+		seeds[2] = append([]byte{}, seeds[2]...)
+		seeds[2][0] ^= 1
+		for j, mc := range mcs {
+			_, err := mc.RoundThree(seeds, openings)
+			var abort *AbortError
+			if !errors.As(err, &abort) || abort.Party != 2 || abort.Round != "reveal-seed" {
+				t.Fatalf("party %d: expected an abort blaming party 2, got %v", j, err)
+			}
+		}
+	})
+	t.Run("ShortSeed", func(t *testing.T) {
+		ctx := genOnlineTestContext(t, params, numParties, 1)
+		mcs := make([]*MacCheck, numParties)
+		coms := make([][]byte, numParties)
+		for j, e := range ctx.engines {
+			var err error
+			if mcs[j], coms[j], err = e.MacCheckInit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// party 1 commits to a seed shorter than coinTossSeedSize and opens it correctly
+		var err error
+		mcs[1].coin.seed = mcs[1].coin.seed[:coinTossSeedSize/2]
+		if coms[1], mcs[1].coin.opening, err = utils.Commit(mcs[1].coin.seed); err != nil {
+			t.Fatal(err)
+		}
+		seeds := make([][]byte, numParties)
+		openings := make([][]byte, numParties)
+		for j, mc := range mcs {
+			seeds[j], openings[j] = mc.RoundTwo(coms)
+		}
+		for j, mc := range mcs {
+			_, err := mc.RoundThree(seeds, openings)
+			var abort *AbortError
+			if !errors.As(err, &abort) || abort.Party != 1 || abort.Round != "reveal-seed" {
+				t.Fatalf("party %d: expected an abort blaming party 1, got %v", j, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package protocol
+
+import (
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+
+	"errors"
+	"fmt"
+)
+
+// ErrNoTriples is returned when a TripleSource has no authenticated triple left.
+var ErrNoTriples = errors.New("no authenticated triples left")
+
+// ErrNoInputMasks is returned when an InputMaskSource has no input mask left for the requested owner.
+var ErrNoInputMasks = errors.New("no input masks left")
+
+// TripleSource hands out authenticated triples to the online phase.
+// Every triple must be handed out at most once.
+type TripleSource interface {
+	NextAuthTriple() (*AuthTriple, error)
+}
+
+// InputMaskSource hands out the input masks of each input owner to the online phase.
+// Every mask must be handed out at most once.
+type InputMaskSource interface {
+	NextInputMask(owner int) (*InputMask, error)
+}
+
+// TripleQueue is a TripleSource over an in-memory slice of triples.
+type TripleQueue struct {
+	triples []*AuthTriple
+}
+
+// NewTripleQueue creates a TripleQueue handing out the given triples in order.
+func NewTripleQueue(triples []*AuthTriple) *TripleQueue {
+	return &TripleQueue{triples: triples}
+}
+
+// NextAuthTriple pops the next triple of the queue.
+func (q *TripleQueue) NextAuthTriple() (*AuthTriple, error) {
+	if len(q.triples) == 0 {
+		return nil, ErrNoTriples
+	}
+	triple := q.triples[0]
+	q.triples = q.triples[1:]
+	return triple, nil
+}
+
+// Len returns the number of triples left in the queue.
+func (q *TripleQueue) Len() int {
+	return len(q.triples)
+}
+
+// NextAuthTriple pops the next authenticated triple produced by the party.
+func (party *HemiParty) NextAuthTriple() (*AuthTriple, error) {
+	if len(party.authTriples) == 0 {
+		return nil, ErrNoTriples
+	}
+	triple := party.authTriples[0]
+	party.authTriples = party.authTriples[1:]
+	return triple, nil
+}
+
+// NextAuthTriple pops the next authenticated triple produced by the party.
+func (party *SohoParty) NextAuthTriple() (*AuthTriple, error) {
+	if len(party.authTriples) == 0 {
+		return nil, ErrNoTriples
+	}
+	triple := party.authTriples[0]
+	party.authTriples = party.authTriples[1:]
+	return triple, nil
+}
+
+// Engine evaluates the SPDZ online phase over authenticated additive shares mod T.
+// Linear operations are local; openings and multiplications are split in an Init method,
+// whose output is broadcast to all parties, and a Finalize method consuming the messages
+// of every party indexed by sender ID. Party 0 is the one adding public constants.
+type Engine struct {
+	id         int
+	numParties int
+	f          *field.Field
+	alpha      field.Element
+
+	triples TripleSource
+	masks   InputMaskSource
+	squares SquareSource
+
+	// values opened since the last MAC check and the party's MAC shares on them
+	openedValues []field.Element
+	openedMacs   []field.Element
+
+	// outputs withheld until the next MAC check passes
+	outputs []field.Element
+}
+
+// MulBatch holds the state of a batch of Beaver multiplications between MulInit and MulFinalize.
+type MulBatch struct {
+	triples []*AuthTriple
+	masked  []AuthShare // d_k = x_k - a_k for all k, followed by e_k = y_k - b_k
+}
+
+// InputBatch holds the input masks of a batch of private inputs between InputInit and InputFinalize.
+type InputBatch struct {
+	masks []*InputMask
+}
+
+// NewEngine creates an online engine for party id of numParties with MAC key share alpha drawing triples from triples.
+func NewEngine(id int, params hpbfv.Parameters, numParties int, alpha field.Element, triples TripleSource) *Engine {
+	f := params.Field()
+	return &Engine{
+		id:         id,
+		numParties: numParties,
+		f:          f,
+		alpha:      f.Set(f.NewElement(), alpha),
+		triples:    triples,
+	}
+}
+
+// SetInputMaskSource sets the source of the input masks consumed by InputInit.
+func (e *Engine) SetInputMaskSource(masks InputMaskSource) {
+	e.masks = masks
+}
+
+// Add returns x + y.
+func (e *Engine) Add(x, y AuthShare) AuthShare {
+	z := newAuthShare(e.f)
+	e.f.Add(z.Value, x.Value, y.Value)
+	e.f.Add(z.Mac, x.Mac, y.Mac)
+	return z
+}
+
+// Sub returns x - y.
+func (e *Engine) Sub(x, y AuthShare) AuthShare {
+	z := newAuthShare(e.f)
+	e.f.Sub(z.Value, x.Value, y.Value)
+	e.f.Sub(z.Mac, x.Mac, y.Mac)
+	return z
+}
+
+// MulScalar returns c * x for a public constant c.
+func (e *Engine) MulScalar(x AuthShare, c field.Element) AuthShare {
+	z := newAuthShare(e.f)
+	e.f.Mul(z.Value, x.Value, c)
+	e.f.Mul(z.Mac, x.Mac, c)
+	return z
+}
+
+// AddConst returns x + c for a public constant c: party 0 adds c to its value share
+// and every party adds alpha_i * c to its MAC share.
+func (e *Engine) AddConst(x AuthShare, c field.Element) AuthShare {
+	z := newAuthShare(e.f)
+	e.f.Set(z.Value, x.Value)
+	if e.id == 0 {
+		e.f.Add(z.Value, z.Value, c)
+	}
+	e.f.Mul(z.Mac, e.alpha, c)
+	e.f.Add(z.Mac, z.Mac, x.Mac)
+	return z
+}
+
+// OpenInit returns the value shares of xs to be broadcast to all parties.
+func (e *Engine) OpenInit(xs []AuthShare) []field.Element {
+	shares := e.f.NewVector(len(xs))
+	for k, x := range xs {
+		e.f.Set(shares[k], x.Value)
+	}
+	return shares
+}
+
+// OpenFinalize sums the value shares broadcast by every party and returns the opened values.
+// It returns an error if shares does not hold the shares of every party, and an AbortError naming the
+// first party that sent the wrong number of shares. The opened values and the party's MAC shares on them
+// are recorded for the next MAC check.
+func (e *Engine) OpenFinalize(xs []AuthShare, shares [][]field.Element) ([]field.Element, error) {
+	if len(shares) != e.numParties {
+		return nil, fmt.Errorf("cannot OpenFinalize: got the shares of %d parties, expected %d", len(shares), e.numParties)
+	}
+	for j, sh := range shares {
+		if len(sh) != len(xs) {
+			return nil, &AbortError{Party: j, Round: "open", Err: fmt.Errorf("sent %d shares, expected %d", len(sh), len(xs))}
+		}
+	}
+
+	values := e.f.NewVector(len(xs))
+	for _, sh := range shares {
+		for k := range xs {
+			e.f.Add(values[k], values[k], sh[k])
+		}
+	}
+
+	recorded := e.f.NewVector(2 * len(xs))
+	for k := range values {
+		e.openedValues = append(e.openedValues, e.f.Set(recorded[2*k], values[k]))
+		e.openedMacs = append(e.openedMacs, e.f.Set(recorded[2*k+1], xs[k].Mac))
+	}
+	return values, nil
+}
+
+// OutputFinalize opens xs as OpenFinalize does but withholds the opened values:
+// they are only released by MacCheck.Finalize once the MAC check passes.
+// The shares to broadcast are obtained with OpenInit.
+func (e *Engine) OutputFinalize(xs []AuthShare, shares [][]field.Element) error {
+	values, err := e.OpenFinalize(xs, shares)
+	if err != nil {
+		return err
+	}
+	e.outputs = append(e.outputs, values...)
+	return nil
+}
+
+// MulInit starts the Beaver multiplications xs[k] * ys[k], consuming one triple per product.
+// It returns the batch state and the shares of the masked operands to be broadcast.
+func (e *Engine) MulInit(xs, ys []AuthShare) (*MulBatch, []field.Element, error) {
+	if len(xs) != len(ys) {
+		return nil, nil, fmt.Errorf("cannot MulInit: %d left operands but %d right operands", len(xs), len(ys))
+	}
+
+	batch := &MulBatch{
+		triples: make([]*AuthTriple, len(xs)),
+		masked:  make([]AuthShare, 2*len(xs)),
+	}
+	for k := range xs {
+		triple, err := e.triples.NextAuthTriple()
+		if err != nil {
+			return nil, nil, err
+		}
+		batch.triples[k] = triple
+		batch.masked[k] = e.Sub(xs[k], triple.A)
+		batch.masked[len(xs)+k] = e.Sub(ys[k], triple.B)
+	}
+	return batch, e.OpenInit(batch.masked), nil
+}
+
+// MulFinalize opens the masked operands and returns z_k = c + d*b + e*a + d*e for every product.
+func (e *Engine) MulFinalize(batch *MulBatch, shares [][]field.Element) ([]AuthShare, error) {
+	opened, err := e.OpenFinalize(batch.masked, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(batch.triples)
+	zs := make([]AuthShare, n)
+	df := e.f.NewElement()
+	for k, triple := range batch.triples {
+		d, f := opened[k], opened[n+k]
+
+		z := e.Add(triple.C, e.MulScalar(triple.B, d))
+		z = e.Add(z, e.MulScalar(triple.A, f))
+		zs[k] = e.AddConst(z, e.f.Mul(df, d, f))
+	}
+	return zs, nil
+}
+
+// InputInit starts secret sharing n private inputs of owner, consuming one input mask r per input.
+// The owner passes its n values and gets the masked values x - r to broadcast; the other parties
+// pass nil values and get nil.
+func (e *Engine) InputInit(owner, n int, values []field.Element) (*InputBatch, []field.Element, error) {
+	if e.masks == nil {
+		return nil, nil, fmt.Errorf("cannot InputInit: no input mask source")
+	}
+	if e.id == owner && len(values) != n {
+		return nil, nil, fmt.Errorf("cannot InputInit: got %d values for %d inputs", len(values), n)
+	}
+
+	batch := &InputBatch{masks: make([]*InputMask, n)}
+	for k := range batch.masks {
+		mask, err := e.masks.NextInputMask(owner)
+		if err != nil {
+			return nil, nil, err
+		}
+		batch.masks[k] = mask
+	}
+
+	if e.id != owner {
+		return batch, nil, nil
+	}
+
+	masked := e.f.NewVector(n)
+	for k, mask := range batch.masks {
+		if mask.Value == nil {
+			return nil, nil, fmt.Errorf("cannot InputInit: input mask %d does not carry its value", k)
+		}
+		e.f.Sub(masked[k], values[k], mask.Value)
+	}
+	return batch, masked, nil
+}
+
+// InputFinalize returns the authenticated shares r + (x - r) of the inputs from the masked
+// values broadcast by the input owner.
+func (e *Engine) InputFinalize(batch *InputBatch, masked []field.Element) ([]AuthShare, error) {
+	if len(masked) != len(batch.masks) {
+		return nil, fmt.Errorf("cannot InputFinalize: got %d masked values for %d inputs", len(masked), len(batch.masks))
+	}
+
+	shares := make([]AuthShare, len(masked))
+	for k, mask := range batch.masks {
+		shares[k] = e.AddConst(mask.Share, masked[k])
+	}
+	return shares, nil
+}
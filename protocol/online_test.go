@@ -0,0 +1,200 @@
+package protocol
+
+import (
+	"testing"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+
+	"crypto/rand"
+)
+
+// randModT samples a uniformly random value in [0, t)
+func randModT(t *testing.T, params hpbfv.Parameters) field.Element {
+	x := params.Field().NewElement()
+	params.Field().Sample(rand.Reader, x)
+	return x
+}
+
+// dealAuthShares secret shares x among the parties with MACs under alpha = sum(alphas)
+func dealAuthShares(t *testing.T, params hpbfv.Parameters, alphas []field.Element, x field.Element) []AuthShare {
+	f := params.Field()
+	alpha := f.NewElement()
+	for _, a := range alphas {
+		f.Add(alpha, alpha, a)
+	}
+	mac := f.Mul(f.NewElement(), alpha, x)
+
+	shares := make([]AuthShare, len(alphas))
+	valueRest := f.Set(f.NewElement(), x)
+	macRest := mac
+	for j := range shares {
+		if j == len(shares)-1 {
+			shares[j] = AuthShare{Value: valueRest, Mac: macRest}
+			break
+		}
+		shares[j] = AuthShare{Value: randModT(t, params), Mac: randModT(t, params)}
+		f.Sub(valueRest, valueRest, shares[j].Value)
+		f.Sub(macRest, macRest, shares[j].Mac)
+	}
+	return shares
+}
+
+// dealAuthTriples generates n authenticated triples and returns the triples of each party
+func dealAuthTriples(t *testing.T, params hpbfv.Parameters, alphas []field.Element, n int) [][]*AuthTriple {
+	f := params.Field()
+	triples := make([][]*AuthTriple, len(alphas))
+	for k := 0; k < n; k++ {
+		a := randModT(t, params)
+		b := randModT(t, params)
+		c := f.Mul(f.NewElement(), a, b)
+
+		as := dealAuthShares(t, params, alphas, a)
+		bs := dealAuthShares(t, params, alphas, b)
+		cs := dealAuthShares(t, params, alphas, c)
+		for j := range alphas {
+			triples[j] = append(triples[j], &AuthTriple{A: as[j], B: bs[j], C: cs[j]})
+		}
+	}
+	return triples
+}
+
+type onlineTestContext struct {
+	params  hpbfv.Parameters
+	alphas  []field.Element
+	engines []*Engine
+}
+
+func genOnlineTestContext(t *testing.T, params hpbfv.Parameters, numParties, numTriples int) *onlineTestContext {
+	ctx := &onlineTestContext{params: params, alphas: make([]field.Element, numParties), engines: make([]*Engine, numParties)}
+	for j := range ctx.alphas {
+		ctx.alphas[j] = randModT(t, params)
+	}
+	triples := dealAuthTriples(t, params, ctx.alphas, numTriples)
+	for j := range ctx.engines {
+		ctx.engines[j] = NewEngine(j, params, numParties, ctx.alphas[j], NewTripleQueue(triples[j]))
+	}
+	return ctx
+}
+
+// mul runs a batch of Beaver multiplications across all engines
+func (ctx *onlineTestContext) mul(t *testing.T, xs, ys [][]AuthShare) [][]AuthShare {
+	batches := make([]*MulBatch, len(ctx.engines))
+	shares := make([][]field.Element, len(ctx.engines))
+	for j, e := range ctx.engines {
+		var err error
+		if batches[j], shares[j], err = e.MulInit(xs[j], ys[j]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zs := make([][]AuthShare, len(ctx.engines))
+	for j, e := range ctx.engines {
+		var err error
+		if zs[j], err = e.MulFinalize(batches[j], shares); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return zs
+}
+
+// open runs an opening round across all engines
+func (ctx *onlineTestContext) open(t *testing.T, xs [][]AuthShare) []field.Element {
+	shares := make([][]field.Element, len(ctx.engines))
+	for j, e := range ctx.engines {
+		shares[j] = e.OpenInit(xs[j])
+	}
+	f := ctx.params.Field()
+	var values []field.Element
+	for j, e := range ctx.engines {
+		out, err := e.OpenFinalize(xs[j], shares)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j > 0 {
+			for k := range out {
+				if !f.Equal(out[k], values[k]) {
+					t.Fatalf("party %d opened %s at index %d, party 0 opened %s", j, f.Big(out[k]), k, f.Big(values[k]))
+				}
+			}
+		}
+		values = out
+	}
+	return values
+}
+
+func TestEngine(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := params.Field()
+	numParties := 3
+	numMuls := 8
+
+	ctx := genOnlineTestContext(t, params, numParties, numMuls)
+
+	xs := make([]field.Element, numMuls)
+	ys := make([]field.Element, numMuls)
+	xShares := make([][]AuthShare, numParties)
+	yShares := make([][]AuthShare, numParties)
+	for k := 0; k < numMuls; k++ {
+		xs[k] = randModT(t, params)
+		ys[k] = randModT(t, params)
+		xsh := dealAuthShares(t, params, ctx.alphas, xs[k])
+		ysh := dealAuthShares(t, params, ctx.alphas, ys[k])
+		for j := 0; j < numParties; j++ {
+			xShares[j] = append(xShares[j], xsh[j])
+			yShares[j] = append(yShares[j], ysh[j])
+		}
+	}
+
+	c := f.SetUint64(f.NewElement(), 12345)
+	three := f.SetUint64(f.NewElement(), 3)
+
+	// compute 3 * (x * y + c) - x
+	zShares := ctx.mul(t, xShares, yShares)
+	for j, e := range ctx.engines {
+		for k := range zShares[j] {
+			z := e.AddConst(zShares[j][k], c)
+			z = e.MulScalar(z, three)
+			zShares[j][k] = e.Sub(z, xShares[j][k])
+		}
+	}
+
+	zs := ctx.open(t, zShares)
+
+	alpha := f.NewElement()
+	for _, a := range ctx.alphas {
+		f.Add(alpha, alpha, a)
+	}
+
+	expected, mac, expectedMac := f.NewElement(), f.NewElement(), f.NewElement()
+	for k := 0; k < numMuls; k++ {
+		f.Mul(expected, xs[k], ys[k])
+		f.Add(expected, expected, c)
+		f.Mul(expected, expected, three)
+		f.Sub(expected, expected, xs[k])
+		if !f.Equal(zs[k], expected) {
+			t.Fatalf("Engine test failed at index %d: got %s, want %s", k, f.Big(zs[k]), f.Big(expected))
+		}
+
+		f.SetUint64(mac, 0)
+		for j := range ctx.engines {
+			f.Add(mac, mac, zShares[j][k].Mac)
+		}
+		f.Mul(expectedMac, alpha, expected)
+		if !f.Equal(mac, expectedMac) {
+			t.Fatalf("Engine MAC check failed at index %d: got %s, want %s", k, f.Big(mac), f.Big(expectedMac))
+		}
+	}
+
+	if _, _, err := ctx.engines[0].MulInit(xShares[0][:1], yShares[0][:1]); err != ErrNoTriples {
+		t.Fatalf("expected ErrNoTriples, got %v", err)
+	}
+
+	// the shares of a missing party
+	shares := [][]field.Element{ctx.engines[0].OpenInit(xShares[0]), ctx.engines[1].OpenInit(xShares[1])}
+	if _, err := ctx.engines[0].OpenFinalize(xShares[0], shares); err == nil {
+		t.Fatal("OpenFinalize accepted the shares of 2 parties out of 3")
+	}
+}
@@ -3,41 +3,13 @@ package protocol
 import (
 	"spdz-go/hpbfv"
 
-	"math/big"
+	"errors"
+	"fmt"
 )
 
 // SampleUniformModT samples a message with coefficients uniformly random in [0, t)
 func (p *SohoParty) SampleUniformModT() *hpbfv.Message {
-	params := p.params
-	// Rejection sampling
-	samples := make([]*big.Int, params.Slots())
-	t := params.T()
-	bitLen := t.BitLen()
-	byteLen := (bitLen + 7) / 8
-	mask := big.NewInt(1)
-	mask.Lsh(mask, uint(bitLen))
-	mask.Sub(mask, big.NewInt(1))
-
-	for i := 0; i < params.Slots(); i++ {
-		for {
-			buf := make([]byte, byteLen)
-			_, err := p.prng.Read(buf)
-			if err != nil {
-				panic("cannot SampleUniformModT: PRNG read error")
-			}
-			sample := new(big.Int).SetBytes(buf)
-			sample.And(sample, mask)
-			if sample.Cmp(t) < 0 {
-				samples[i] = sample
-				break
-			}
-		}
-	}
-	msg := hpbfv.NewMessage(params)
-	for i := 0; i < params.Slots(); i++ {
-		msg.Value[i] = samples[i]
-	}
-	return msg
+	return sampleUniformModT(p.params, p.prng)
 }
 
 func (p *SohoParty) Aggregate(cts []*hpbfv.Ciphertext) *hpbfv.Ciphertext {
@@ -54,36 +26,173 @@ func (p *SohoParty) AggregateAndAdd(ctIn *hpbfv.Ciphertext, cts []*hpbfv.Ciphert
 	return sumCt
 }
 
-func (p *SohoParty) ReshareInit(ctIn *hpbfv.Ciphertext, noiseBits int) (*hpbfv.Message, *hpbfv.DistDecShare) {
+// The masks of a resharing are encrypted under the joint public key and broadcast with a proof of plaintext
+// knowledge one round before the decryption shares, usually along with the inputs of the batch. Every party
+// then decrypts the ciphertext masked by the sum of the encrypted masks, and proves that its decryption
+// share was computed with the secret key of its partial public key, so that no party can shift the
+// decrypted value. The leader takes the masked message minus its mask as its share, and the other parties
+// minus their mask.
+//
+// The steps of a resharing take the set of parties taking part in it, in increasing order. With a nil set,
+// every party takes part and decrypts with its secret key. Otherwise only the parties of the set, at least
+// threshold+1 of them, mask, decrypt with their share of the threshold key, see SetupThreshold, and prove
+// their decryption shares against the threshold key; the messages of the other parties are ignored, the
+// first party of the set leads, and the message is reshared among the parties of the set.
 
+// ReshareShare is a decryption share of a resharing with the proof that its sender computed it with the
+// secret key of its partial public key, or with its share of the threshold key.
+type ReshareShare struct {
+	Share          *hpbfv.DistDecShare
+	Proof          *hpbfv.DecryptionProof
+	ThresholdProof *hpbfv.ThresholdDecryptionProof
+}
+
+// ReshareMask samples the party's mask for a resharing and returns it with its encryption under the joint
+// public key and a proof of plaintext knowledge, to be broadcast before ReshareInit.
+func (p *SohoParty) ReshareMask() (*hpbfv.Message, *hpbfv.Ciphertext, *hpbfv.PlaintextProof) {
 	s := p.SampleUniformModT()
-	dsh := p.ddec.PartialDecrypt(ctIn, noiseBits)
+	cts, proof := p.encryptAndProve("reshare-mask", s)
+	return s, cts[0], proof
+}
 
-	// add s to dsh
-	level := ctIn.Level()
-	ringQ := p.params.RingQ()
-	sPoly := p.ecd.EncodeNew(s).Value
-	ringQ.AddLvl(level, dsh.Poly, sPoly, dsh.Poly)
+// VerifyReshareMasks checks the proofs of plaintext knowledge of the encrypted masks css of ReshareMask and
+// returns an AbortError naming the first party whose proof does not verify.
+func (p *SohoParty) VerifyReshareMasks(css []*hpbfv.Ciphertext, proofs []*hpbfv.PlaintextProof) error {
+	return p.verifyProofs("reshare-mask", nil, proofs, css)
+}
 
-	return s, dsh
+// ReshareInit masks ctIn with the verified encryptions css of the masks of the parties taking part and returns
+// the masked ciphertext with the party's proven decryption share of it, flooded to hide its estimated noise
+// with statistical security statSec. It returns an error wrapping hpbfv.ErrDecryptionFailure if the noise of
+// the masked ciphertext is too large to flood, and an error if parties is not a valid set, see checkParties.
+func (p *SohoParty) ReshareInit(ctIn *hpbfv.Ciphertext, css []*hpbfv.Ciphertext, parties []int, statSec int) (*hpbfv.Ciphertext, *ReshareShare, error) {
+	if err := p.checkParties(parties); err != nil {
+		return nil, nil, fmt.Errorf("cannot ReshareInit: %w", err)
+	}
+	ctMasked := p.aggregateOf(css, parties)
+	p.eval.Add(ctIn, ctMasked, ctMasked)
+	share, err := p.partialDecrypt(ctMasked, parties, statSec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ctMasked, share, nil
 }
 
-func (p *SohoParty) ReshareFinalize(ctIn *hpbfv.Ciphertext, shares []*hpbfv.DistDecShare, msg *hpbfv.Message) *hpbfv.Message {
-	if p.id != 0 {
-		negMsg := hpbfv.NewMessage(p.params)
-		t := p.params.T()
-		for i := 0; i < p.params.Slots(); i++ {
-			negMsg.Value[i].Sub(t, msg.Value[i])
-		}
-		return negMsg
+// ReshareFinalize returns the party's share of the message of the ciphertext masked by ReshareInit from
+// the decryption shares of the parties taking part and its mask s. The leader verifies the shares and returns
+// an AbortError naming the first party whose share is missing or does not match its proof of decryption.
+func (p *SohoParty) ReshareFinalize(ctMasked *hpbfv.Ciphertext, shares []*ReshareShare, s *hpbfv.Message, parties []int, statSec int) (*hpbfv.Message, error) {
+	if err := p.checkParties(parties); err != nil {
+		return nil, fmt.Errorf("cannot ReshareFinalize: %w", err)
+	}
+	if p.id != p.leader(parties) {
+		return p.unmask(nil, s, parties), nil
 	}
 
-	msgDec := p.ddec.JointDecryptToMsgNew(ctIn, shares)
+	masked, err := p.jointDecryptToMsg(ctMasked, shares, parties, statSec)
+	if err != nil {
+		return nil, err
+	}
+	return p.unmask(masked, s, parties), nil
+}
 
+// unmask returns the party's share of a reshared message from the masked message and the party's mask s:
+// the leader takes masked - s and the other parties -s, so masked may be nil for them.
+func (p *SohoParty) unmask(masked, s *hpbfv.Message, parties []int) *hpbfv.Message {
+	f := p.params.Field()
+	leader := p.id == p.leader(parties)
+	share := hpbfv.NewMessage(p.params)
 	for i := 0; i < p.params.Slots(); i++ {
-		msgDec.Value[i].Sub(msgDec.Value[i], msg.Value[i])
-		msgDec.Value[i].Mod(msgDec.Value[i], p.params.T())
+		if leader {
+			f.Sub(share.Value[i], masked.Value[i], s.Value[i])
+		} else {
+			f.Neg(share.Value[i], s.Value[i])
+		}
+	}
+	return share
+}
+
+// ReshareFinalizeWithCiphertext behaves as ReshareFinalize and additionally returns a fresh encryption
+// of the reshared message m, computed as Enc(m + sum(s)) - sum(Enc(s)) from the public masked value and the
+// encryptions css of the masks passed to ReshareInit. Every party verifies the decryption shares.
+// The output ciphertext carries only fresh encryption noise and can be multiplied again.
+func (p *SohoParty) ReshareFinalizeWithCiphertext(ctMasked *hpbfv.Ciphertext, css []*hpbfv.Ciphertext, shares []*ReshareShare, s *hpbfv.Message, parties []int, statSec int) (*hpbfv.Message, *hpbfv.Ciphertext, error) {
+	if err := p.checkParties(parties); err != nil {
+		return nil, nil, fmt.Errorf("cannot ReshareFinalizeWithCiphertext: %w", err)
+	}
+	masked, err := p.jointDecryptToMsg(ctMasked, shares, parties, statSec)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return msgDec
+	ctOut := p.eval.NegNew(p.aggregateOf(css, parties))
+	p.eval.PlaintextAdd(ctOut, p.ecd.EncodeNew(masked), ctOut)
+
+	return p.unmask(masked, s, parties), ctOut, nil
+}
+
+// partialDecrypt returns the proven decryption share of ct of the party for parties, flooded so that the
+// joint decryption hides the estimated noise of ct with statistical security statSec.
+// It returns an error wrapping hpbfv.ErrDecryptionFailure if the flooded ciphertext would not decrypt correctly.
+func (p *SohoParty) partialDecrypt(ct *hpbfv.Ciphertext, parties []int, statSec int) (*ReshareShare, error) {
+	noiseBits, err := p.floodingNoiseBits(ct, parties, statSec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot partialDecrypt: %w", err)
+	}
+	if parties != nil {
+		dsh, proof, err := p.tdec.PartialDecryptWithProof(ct, parties, noiseBits)
+		if err != nil {
+			return nil, err
+		}
+		return &ReshareShare{Share: dsh, ThresholdProof: proof}, nil
+	}
+	dsh, proof, err := p.ddec.PartialDecryptWithProof(ct, p.ppk, noiseBits)
+	if err != nil {
+		return nil, err
+	}
+	return &ReshareShare{Share: dsh, Proof: proof}, nil
+}
+
+// floodingNoiseBits returns the bit-size of the flooding noise of the decryption shares of ct for parties,
+// which every party derives from the estimated noise of ct, see hpbfv.Parameters.FloodingNoiseBits and
+// hpbfv.ThresholdDecryptor.FloodingNoiseBits.
+func (p *SohoParty) floodingNoiseBits(ct *hpbfv.Ciphertext, parties []int, statSec int) (int, error) {
+	noise, numParties := ct.Noise()
+	if parties != nil {
+		return p.tdec.FloodingNoiseBits(noise, len(parties), statSec)
+	}
+	return p.params.FloodingNoiseBits(noise, numParties, statSec)
+}
+
+// jointDecryptToMsg decrypts ct from the decryption shares of the parties taking part, indexed by party, after
+// verifying the proof of decryption of every other party against its partial public key, or against the
+// threshold key. It returns an AbortError naming the first party whose share is missing, malformed or invalid.
+func (p *SohoParty) jointDecryptToMsg(ct *hpbfv.Ciphertext, shares []*ReshareShare, parties []int, statSec int) (*hpbfv.Message, error) {
+	noiseBits, err := p.floodingNoiseBits(ct, parties, statSec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot jointDecryptToMsg: %w", err)
+	}
+	members := p.members(parties)
+	active := make([]*hpbfv.DistDecShare, len(members))
+	for i, j := range members {
+		if j >= len(shares) || shares[j] == nil || shares[j].Share == nil || shares[j].Share.Poly == nil ||
+			shares[j].Share.N() != p.params.N() || shares[j].Share.Level() != ct.Level() {
+			return nil, &AbortError{Party: j, Round: "reshare", Err: errors.New("missing or malformed decryption share")}
+		}
+		if j != p.id {
+			if parties != nil {
+				err = p.tdec.VerifyShare(ct, j, parties, shares[j].Share, shares[j].ThresholdProof, noiseBits)
+			} else {
+				err = p.ddec.VerifyShare(ct, shares[j].Share, shares[j].Proof, p.ppks[j], noiseBits)
+			}
+			if err != nil {
+				return nil, &AbortError{Party: j, Round: "reshare", Err: err}
+			}
+		}
+		active[i] = shares[j].Share
+	}
+	if parties != nil {
+		return p.tdec.JointDecryptToMsgNew(ct, active), nil
+	}
+	return p.ddec.JointDecryptToMsgNew(ct, active), nil
 }
@@ -1,21 +1,24 @@
 package protocol
 
 import (
+	"errors"
 	"testing"
 
 	"spdz-go/hpbfv"
 	"spdz-go/rlwe"
 
 	"crypto/rand"
-	"math/big"
 )
 
 func TestReshare(t *testing.T) {
 	// Generate two ciphertexts and test distributed decryption shares
-	params := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HPN13D10T128)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	crs := make([]byte, 32)
-	_, err := rand.Read(crs)
+	_, err = rand.Read(crs)
 	if err != nil {
 		t.Fatalf("cannot generate crs: %v", err)
 	}
@@ -30,14 +33,18 @@ func TestReshare(t *testing.T) {
 	// Round 0 (Key Generation)
 	ppks := make([]*rlwe.PublicKey, len(parties))
 	prlks := make([]*hpbfv.RelinearizationKey, len(parties))
+	keyProofs := make([]*hpbfv.KeyProof, len(parties))
 
 	for i, party := range parties {
 		ppks[i] = party.ppk
 		prlks[i] = party.prlk
+		keyProofs[i] = party.keyProof
 	}
 
 	for _, party := range parties {
-		party.Setup(ppks, prlks)
+		if err := party.Setup(ppks, prlks, keyProofs); err != nil {
+			t.Fatal(err)
+		}
 	}
 
 	as := make([]*hpbfv.Message, len(parties))
@@ -55,18 +62,15 @@ func TestReshare(t *testing.T) {
 		cbs[i] = party.enc.EncryptMsgNew(bs[i])
 	}
 
-	aSum := make([]*big.Int, params.Slots())
-	bSum := make([]*big.Int, params.Slots())
+	f := params.Field()
+	aSum := f.NewVector(params.Slots())
+	bSum := f.NewVector(params.Slots())
 
 	for i := 0; i < params.Slots(); i++ {
-		aSum[i] = big.NewInt(0)
-		bSum[i] = big.NewInt(0)
 		for j := 0; j < len(parties); j++ {
-			aSum[i].Add(aSum[i], as[j].Value[i])
-			bSum[i].Add(bSum[i], bs[j].Value[i])
+			f.Add(aSum[i], aSum[i], as[j].Value[i])
+			f.Add(bSum[i], bSum[i], bs[j].Value[i])
 		}
-		aSum[i].Mod(aSum[i], params.T())
-		bSum[i].Mod(bSum[i], params.T())
 	}
 
 	ca := parties[0].Aggregate(cas)
@@ -87,55 +91,82 @@ func TestReshare(t *testing.T) {
 	decMsg := parties[0].ddec.JointDecryptToMsgNew(ca, dcas)
 
 	for i := 0; i < params.Slots(); i++ {
-		if decMsg.Value[i].Cmp(aSum[i]) != 0 {
-			t.Fatalf("aSum = %s, but decrypted aSum = %s", aSum[i].String(), decMsg.Value[i].String())
+		if !f.Equal(decMsg.Value[i], aSum[i]) {
+			t.Fatalf("aSum = %s, but decrypted aSum = %s", f.Big(aSum[i]), f.Big(decMsg.Value[i]))
 		}
 	}
 	decMsg = parties[0].ddec.JointDecryptToMsgNew(cb, dcbs)
 
 	for i := 0; i < params.Slots(); i++ {
-		if decMsg.Value[i].Cmp(bSum[i]) != 0 {
-			t.Fatalf("bSum = %s, but decrypted bSum = %s", bSum[i].String(), decMsg.Value[i].String())
+		if !f.Equal(decMsg.Value[i], bSum[i]) {
+			t.Fatalf("bSum = %s, but decrypted bSum = %s", f.Big(bSum[i]), f.Big(decMsg.Value[i]))
 		}
 	}
 	decMsg = parties[0].ddec.JointDecryptToMsgNew(cc, dccs)
 
 	for i := 0; i < params.Slots(); i++ {
-		expected := new(big.Int).Mul(aSum[i], bSum[i])
-		expected.Mod(expected, params.T())
-		if decMsg.Value[i].Cmp(expected) != 0 {
-			t.Fatalf("c = aSum * bSum = %s, but decrypted c = %s", expected.String(), decMsg.Value[i].String())
+		expected := f.Mul(f.NewElement(), aSum[i], bSum[i])
+		if !f.Equal(decMsg.Value[i], expected) {
+			t.Fatalf("c = aSum * bSum = %s, but decrypted c = %s", f.Big(expected), f.Big(decMsg.Value[i]))
 		}
 	}
+	// Each party samples and encrypts its mask
 	ss := make([]*hpbfv.Message, len(parties))
-	dshs := make([]*hpbfv.DistDecShare, len(parties))
+	css := make([]*hpbfv.Ciphertext, len(parties))
+	maskProofs := make([]*hpbfv.PlaintextProof, len(parties))
+	for i, party := range parties {
+		ss[i], css[i], maskProofs[i] = party.ReshareMask()
+	}
 
-	// Each party samples share of noise
+	var ccMasked *hpbfv.Ciphertext
+	shs := make([]*ReshareShare, len(parties))
 	for i, party := range parties {
-		ss[i], dshs[i] = party.ReshareInit(cc, 80)
+		if err = party.VerifyReshareMasks(css, maskProofs); err != nil {
+			t.Fatal(err)
+		}
+		if ccMasked, shs[i], err = party.ReshareInit(cc, css, nil, 40); err != nil {
+			t.Fatal(err)
+		}
 	}
-	
+
 	ress := make([]*hpbfv.Message, len(parties))
 	// Each party resharing
 	for i, party := range parties {
-		ress[i] = party.ReshareFinalize(cc, dshs, ss[i])
+		if ress[i], err = party.ReshareFinalize(ccMasked, shs, ss[i], nil, 40); err != nil {
+			t.Fatal(err)
+		}
 	}
 
 	finalMsg := hpbfv.NewMessage(params)
 	for i := 0; i < params.Slots(); i++ {
-		finalMsg.Value[i] = big.NewInt(0)
 		for j := 0; j < len(parties); j++ {
-			finalMsg.Value[i].Add(finalMsg.Value[i], ress[j].Value[i])
+			f.Add(finalMsg.Value[i], finalMsg.Value[i], ress[j].Value[i])
 		}
-		finalMsg.Value[i].Mod(finalMsg.Value[i], params.T())
 	}
 
 	for i := 0; i < params.Slots(); i++ {
-		expected := new(big.Int).Mul(aSum[i], bSum[i])
-		expected.Mod(expected, params.T())
+		expected := f.Mul(f.NewElement(), aSum[i], bSum[i])
 
-		if finalMsg.Value[i].Cmp(expected) != 0 {
-			t.Fatalf("Reshared message[%d] = %s, but expected = %s", i, finalMsg.Value[i].String(), expected.String())
+		if !f.Equal(finalMsg.Value[i], expected) {
+			t.Fatalf("Reshared message[%d] = %s, but expected = %s", i, f.Big(finalMsg.Value[i]), f.Big(expected))
 		}
 	}
+
+	// a flooding beyond the decryption margin
+	if _, _, err = parties[0].ReshareInit(cc, css, nil, int(params.DecryptionMargin())); !errors.Is(err, hpbfv.ErrDecryptionFailure) {
+		t.Fatalf("expected a decryption failure, got %v", err)
+	}
+
+	// the leader blames a party whose share does not match its proof of decryption
+	var abort *AbortError
+	forged := []*ReshareShare{shs[0], {Share: shs[2].Share, Proof: shs[1].Proof}, shs[2]}
+	if _, err = parties[0].ReshareFinalize(ccMasked, forged, ss[0], nil, 40); !errors.Is(err, hpbfv.ErrInvalidDecryptionShare) || !errors.As(err, &abort) || abort.Party != 1 {
+		t.Fatalf("expected an invalid decryption share of party 1, got %v", err)
+	}
+
+	// the leader blames a party whose share is missing
+	shs[2] = nil
+	if _, err = parties[0].ReshareFinalize(ccMasked, shs, ss[0], nil, 40); !errors.As(err, &abort) || abort.Party != 2 {
+		t.Fatalf("expected an abort blaming party 2, got %v", err)
+	}
 }
@@ -0,0 +1,162 @@
+package protocol
+
+import (
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+
+	"errors"
+	"fmt"
+)
+
+// ErrSacrificeFailed is returned when a sacrificed triple reveals that a checked triple is incorrect.
+var ErrSacrificeFailed = errors.New("triple sacrifice failed")
+
+// VerifiedTriples is a TripleSource that only hands out triples that passed a sacrifice and the
+// MAC check on its openings. Raw triples are drawn from an underlying source, two per verified triple.
+type VerifiedTriples struct {
+	params hpbfv.Parameters
+	src    TripleSource
+	engine *Engine // engine running the openings of the sacrifice
+	queue  *TripleQueue
+}
+
+// Sacrifice checks a batch of triples (a, b, c) by sacrificing one triple (f, g, h) for each of them:
+// for a joint random t, the parties open rho = t*a - f and sigma = b - g, then open
+// t*c - h - sigma*f - rho*g - sigma*rho and check that it is zero. The protocol takes five
+// broadcast rounds followed by the four rounds of the MAC check on all openings; messages of
+// all parties are indexed by sender ID.
+type Sacrifice struct {
+	vt *VerifiedTriples
+
+	checked    []*AuthTriple
+	sacrificed []*AuthTriple
+
+	coin   *coinToss
+	ts     []field.Element
+	masked []AuthShare // rho_k for all k, followed by sigma_k
+	zs     []AuthShare
+}
+
+// NewVerifiedTriples creates the verification stage of party id of numParties with MAC key share alpha on top of src.
+func NewVerifiedTriples(id int, params hpbfv.Parameters, numParties int, alpha field.Element, src TripleSource) *VerifiedTriples {
+	return &VerifiedTriples{
+		params: params,
+		src:    src,
+		engine: NewEngine(id, params, numParties, alpha, nil),
+		queue:  NewTripleQueue(nil),
+	}
+}
+
+// NextAuthTriple pops the next verified triple.
+func (vt *VerifiedTriples) NextAuthTriple() (*AuthTriple, error) {
+	return vt.queue.NextAuthTriple()
+}
+
+// Len returns the number of verified triples left.
+func (vt *VerifiedTriples) Len() int {
+	return vt.queue.Len()
+}
+
+// SacrificeInit draws 2n triples from the underlying source and returns the commitment to the
+// party's seed for the coin toss of the sacrifice coefficients.
+func (vt *VerifiedTriples) SacrificeInit(n int) (*Sacrifice, []byte, error) {
+	sac := &Sacrifice{
+		vt:         vt,
+		checked:    make([]*AuthTriple, n),
+		sacrificed: make([]*AuthTriple, n),
+	}
+	for k := 0; k < 2*n; k++ {
+		triple, err := vt.src.NextAuthTriple()
+		if err != nil {
+			return nil, nil, err
+		}
+		if k < n {
+			sac.checked[k] = triple
+		} else {
+			sac.sacrificed[k-n] = triple
+		}
+	}
+
+	coin, com, err := newCoinToss()
+	if err != nil {
+		return nil, nil, err
+	}
+	sac.coin = coin
+	return sac, com, nil
+}
+
+// RoundTwo records the seed commitments of all parties and reveals the party's seed and its opening.
+func (sac *Sacrifice) RoundTwo(seedComs [][]byte) (seed, opening []byte) {
+	return sac.coin.reveal(seedComs)
+}
+
+// RoundThree derives the joint coefficients t and returns the shares of rho and sigma to be broadcast.
+func (sac *Sacrifice) RoundThree(seeds, openings [][]byte) ([]field.Element, error) {
+	prng, err := sac.coin.finalize(seeds, openings)
+	if err != nil {
+		return nil, err
+	}
+
+	e := sac.vt.engine
+	n := len(sac.checked)
+	sac.ts = e.f.NewVector(n)
+	sac.masked = make([]AuthShare, 2*n)
+	for k := 0; k < n; k++ {
+		e.f.Sample(prng, sac.ts[k])
+		sac.masked[k] = e.Sub(e.MulScalar(sac.checked[k].A, sac.ts[k]), sac.sacrificed[k].A)
+		sac.masked[n+k] = e.Sub(sac.checked[k].B, sac.sacrificed[k].B)
+	}
+	return e.OpenInit(sac.masked), nil
+}
+
+// RoundFour opens rho and sigma and returns the shares of t*c - h - sigma*f - rho*g - sigma*rho to be broadcast.
+func (sac *Sacrifice) RoundFour(shares [][]field.Element) ([]field.Element, error) {
+	e := sac.vt.engine
+	opened, err := e.OpenFinalize(sac.masked, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(sac.checked)
+	sac.zs = make([]AuthShare, n)
+	sigmaRho := e.f.NewElement()
+	for k := 0; k < n; k++ {
+		rho, sigma := opened[k], opened[n+k]
+		sacrificed := sac.sacrificed[k]
+
+		z := e.Sub(e.MulScalar(sac.checked[k].C, sac.ts[k]), sacrificed.C)
+		z = e.Sub(z, e.MulScalar(sacrificed.A, sigma))
+		z = e.Sub(z, e.MulScalar(sacrificed.B, rho))
+		e.f.Neg(sigmaRho, e.f.Mul(sigmaRho, sigma, rho))
+		sac.zs[k] = e.AddConst(z, sigmaRho)
+	}
+	return e.OpenInit(sac.zs), nil
+}
+
+// RoundFive opens the check values and returns ErrSacrificeFailed if any of them is non-zero.
+// Otherwise it starts the MAC check on all openings of the sacrifice and returns it together
+// with the party's seed commitment; the caller runs its rounds two to four and then calls Finalize.
+func (sac *Sacrifice) RoundFive(shares [][]field.Element) (*MacCheck, []byte, error) {
+	e := sac.vt.engine
+	opened, err := e.OpenFinalize(sac.zs, shares)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, z := range opened {
+		if !e.f.IsZero(z) {
+			return nil, nil, fmt.Errorf("%w: check value of triple %d is non-zero", ErrSacrificeFailed, k)
+		}
+	}
+	return e.MacCheckInit()
+}
+
+// Finalize completes the MAC check with the revealed sigmas and, if it passes, makes the checked
+// triples available through the VerifiedTriples. The sacrificed triples are discarded in any case.
+func (sac *Sacrifice) Finalize(mc *MacCheck, sigmas, openings [][]byte) error {
+	if _, err := mc.Finalize(sigmas, openings); err != nil {
+		return err
+	}
+	sac.vt.queue.triples = append(sac.vt.queue.triples, sac.checked...)
+	return nil
+}
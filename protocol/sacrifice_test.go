@@ -0,0 +1,156 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+)
+
+// runSacrifice runs a sacrifice of n triples across all parties and returns the error of each party
+func runSacrifice(t *testing.T, vts []*VerifiedTriples, n int) []error {
+	numParties := len(vts)
+	errs := make([]error, numParties)
+
+	sacs := make([]*Sacrifice, numParties)
+	coms := make([][]byte, numParties)
+	for j, vt := range vts {
+		var err error
+		if sacs[j], coms[j], err = vt.SacrificeInit(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seeds := make([][]byte, numParties)
+	openings := make([][]byte, numParties)
+	for j, sac := range sacs {
+		seeds[j], openings[j] = sac.RoundTwo(coms)
+	}
+
+	shares := make([][]field.Element, numParties)
+	for j, sac := range sacs {
+		var err error
+		if shares[j], err = sac.RoundThree(seeds, openings); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zShares := make([][]field.Element, numParties)
+	for j, sac := range sacs {
+		var err error
+		if zShares[j], err = sac.RoundFour(shares); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mcs := make([]*MacCheck, numParties)
+	failed := false
+	for j, sac := range sacs {
+		if mcs[j], coms[j], errs[j] = sac.RoundFive(zShares); errs[j] != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return errs
+	}
+
+	for j, mc := range mcs {
+		seeds[j], openings[j] = mc.RoundTwo(coms)
+	}
+	sigmaComs := make([][]byte, numParties)
+	for j, mc := range mcs {
+		var err error
+		if sigmaComs[j], err = mc.RoundThree(seeds, openings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sigmas := make([][]byte, numParties)
+	for j, mc := range mcs {
+		sigmas[j], openings[j] = mc.RoundFour(sigmaComs)
+	}
+	for j, sac := range sacs {
+		errs[j] = sac.Finalize(mcs[j], sigmas, openings)
+	}
+	return errs
+}
+
+func TestSacrifice(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := params.Field()
+	numParties := 3
+	n := 4
+
+	setup := func() ([]field.Element, [][]*AuthTriple, []*VerifiedTriples) {
+		alphas := make([]field.Element, numParties)
+		for j := range alphas {
+			alphas[j] = randModT(t, params)
+		}
+		triples := dealAuthTriples(t, params, alphas, 2*n)
+		vts := make([]*VerifiedTriples, numParties)
+		for j := range vts {
+			vts[j] = NewVerifiedTriples(j, params, numParties, alphas[j], NewTripleQueue(triples[j]))
+		}
+		return alphas, triples, vts
+	}
+
+	t.Run("Honest", func(t *testing.T) {
+		_, triples, vts := setup()
+		for j, err := range runSacrifice(t, vts, n) {
+			if err != nil {
+				t.Fatalf("party %d: unexpected error: %v", j, err)
+			}
+		}
+		for j, vt := range vts {
+			if vt.Len() != n {
+				t.Fatalf("party %d: expected %d verified triples, got %d", j, n, vt.Len())
+			}
+			triple, err := vt.NextAuthTriple()
+			if err != nil || triple != triples[j][0] {
+				t.Fatalf("party %d: verified triples are not the checked ones", j)
+			}
+		}
+	})
+
+	t.Run("WrongProduct", func(t *testing.T) {
+		alphas, triples, vts := setup()
+
+		// c of the first checked triple is off by one, with a consistent MAC
+		alpha := f.NewElement()
+		for _, a := range alphas {
+			f.Add(alpha, alpha, a)
+		}
+		c := triples[0][0].C
+		f.Add(c.Value, c.Value, f.SetUint64(f.NewElement(), 1))
+		f.Add(c.Mac, c.Mac, alpha)
+
+		for j, err := range runSacrifice(t, vts, n) {
+			if !errors.Is(err, ErrSacrificeFailed) {
+				t.Fatalf("party %d: expected ErrSacrificeFailed, got %v", j, err)
+			}
+			if vts[j].Len() != 0 {
+				t.Fatalf("party %d: triples released despite failed sacrifice", j)
+			}
+		}
+	})
+
+	t.Run("WrongMac", func(t *testing.T) {
+		_, triples, vts := setup()
+
+		// party 2 shifts its MAC share of the first sacrificed triple
+		mac := triples[2][n].B.Mac
+		f.Add(mac, mac, f.SetUint64(f.NewElement(), 1))
+
+		for j, err := range runSacrifice(t, vts, n) {
+			if err != ErrMacCheckFailed {
+				t.Fatalf("party %d: expected ErrMacCheckFailed, got %v", j, err)
+			}
+			if vts[j].Len() != 0 {
+				t.Fatalf("party %d: triples released despite failed MAC check", j)
+			}
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package protocol
 
 import (
+	"errors"
+	"fmt"
+
+	"spdz-go/field"
 	"spdz-go/hpbfv"
 	"spdz-go/rlwe"
 	"spdz-go/utils"
@@ -15,10 +19,13 @@ type SohoParty struct {
 
 	sk   *rlwe.SecretKey
 	ppk  *rlwe.PublicKey
+	ppks []*rlwe.PublicKey // partial public keys of all parties, against which their decryption shares are verified
 	jpk  *rlwe.PublicKey
 	prlk *hpbfv.RelinearizationKey
 	jrlk *hpbfv.RelinearizationKey
 
+	keyProof *hpbfv.KeyProof // proof that ppk and prlk are well formed
+
 	prng utils.PRNG
 
 	ecd  *hpbfv.Encoder
@@ -26,12 +33,62 @@ type SohoParty struct {
 	eval *hpbfv.MEvaluator
 	ddec *hpbfv.DistributedDecryptor
 
-	triples []*Triple
+	numParties int
+
+	tdec      *hpbfv.ThresholdDecryptor // decrypts with the party's share of the threshold key, see SetupThreshold
+	threshold int
+
+	prover   *hpbfv.PlaintextProver   // proves knowledge of the plaintexts broadcast in round one
+	verifier *hpbfv.PlaintextVerifier // verifies the ciphertexts received in round one
+
+	alpha  field.Element     // share of the global MAC key
+	cAlpha *hpbfv.Ciphertext // encryption of the global MAC key under jpk
+
+	triples     []*Triple
+	authTriples []*AuthTriple
+	inputMasks  map[int][]*InputMask // indexed by input owner
+	squares     []*AuthSquare
+}
+
+// SohoAuthBatch holds the secret values a party keeps between the rounds of
+// authenticated triple generation for one batch of params.Slots() triples.
+type SohoAuthBatch struct {
+	a, b, c          *hpbfv.Message
+	macA, macB, macC *hpbfv.Message
+
+	sC, sMacA, sMacB, sMacC     *hpbfv.Message      // masks of the resharings
+	csC, csMacA, csMacB, csMacC []*hpbfv.Ciphertext // encrypted masks of all parties, indexed by party
+
+	cc, cMacA, cMacB, cMacC *hpbfv.Ciphertext // masked ciphertexts being reshared
+}
+
+// SohoSquareBatch holds the secret values a party keeps between the rounds of
+// authenticated square pair generation for one batch of params.Slots() pairs.
+type SohoSquareBatch struct {
+	a, a2       *hpbfv.Message
+	macA, macA2 *hpbfv.Message
+
+	sA2, sMacA, sMacA2    *hpbfv.Message      // masks of the resharings
+	csA2, csMacA, csMacA2 []*hpbfv.Ciphertext // encrypted masks of all parties, indexed by party
+
+	cA2, cMacA, cMacA2 *hpbfv.Ciphertext // masked ciphertexts being reshared
+}
+
+// SohoInputBatch holds the values a party keeps between the rounds of input mask
+// generation for one batch of params.Slots() masks of a given owner.
+type SohoInputBatch struct {
+	owner int
+
+	r, mac *hpbfv.Message
+	sMac   *hpbfv.Message
+
+	csMac    []*hpbfv.Ciphertext // encrypted masks of all parties, indexed by party
+	cr, cMac *hpbfv.Ciphertext
 }
 
 func NewSohoParty(id int, params hpbfv.Parameters, crs []byte) *SohoParty {
 	keygen := hpbfv.NewPartialKeyGenerator(params, crs)
-	sk, ppk, prlk := keygen.GenKeys()
+	sk, ppk, prlk, keyProof := keygen.GenKeysWithProof(id)
 
 	triples := make([]*Triple, 0)
 
@@ -41,48 +98,156 @@ func NewSohoParty(id int, params hpbfv.Parameters, crs []byte) *SohoParty {
 	}
 
 	return &SohoParty{
-		id:      id,
-		params:  params,
-		keygen:  keygen,
-		sk:      sk,
-		ppk:     ppk,
-		prlk:    prlk,
-		prng:    prng,
-		ecd:     hpbfv.NewEncoder(params),
-		eval:    hpbfv.NewMEvaluator(params),
-		ddec:    hpbfv.NewDistributedDecryptor(params, sk),
-		triples: triples,
+		id:       id,
+		params:   params,
+		keygen:   keygen,
+		sk:       sk,
+		ppk:      ppk,
+		prlk:     prlk,
+		keyProof: keyProof,
+		prng:     prng,
+		ecd:      hpbfv.NewEncoder(params),
+		eval:     hpbfv.NewMEvaluator(params),
+		ddec:     hpbfv.NewDistributedDecryptor(params, sk),
+		triples:  triples,
+
+		authTriples: make([]*AuthTriple, 0),
+		inputMasks:  make(map[int][]*InputMask),
+		squares:     make([]*AuthSquare, 0),
 	}
 }
 
-func (party *SohoParty) Setup(ppks []*rlwe.PublicKey, prlks []*hpbfv.RelinearizationKey) {
-	party.jpk, party.jrlk = party.keygen.AggregateKeys(ppks, prlks)
-	party.enc = hpbfv.NewEncryptor(party.params, party.jpk)
+// Setup verifies the partial keys of all parties against their proofs of key generation and aggregates
+// them into the joint keys. It returns an AbortError naming the first party whose keys are invalid.
+func (party *SohoParty) Setup(ppks []*rlwe.PublicKey, prlks []*hpbfv.RelinearizationKey, keyProofs []*hpbfv.KeyProof) error {
+	if len(ppks) == 0 || len(prlks) != len(ppks) || len(keyProofs) != len(ppks) {
+		return fmt.Errorf("cannot Setup: got %d public keys, %d relinearization keys and %d proofs", len(ppks), len(prlks), len(keyProofs))
+	}
+	for j := range ppks {
+		if err := party.keygen.VerifyPartialKeys(ppks[j], prlks[j], keyProofs[j], j); err != nil {
+			return &AbortError{Party: j, Round: "keys", Err: err}
+		}
+	}
+	jpk, jrlk, err := party.keygen.AggregateKeys(ppks, prlks)
+	if err != nil {
+		var aggErr *hpbfv.KeyAggregationError
+		if errors.As(err, &aggErr) {
+			return &AbortError{Party: aggErr.Party, Round: "keys", Err: aggErr.Err}
+		}
+		return err
+	}
+	party.jpk, party.jrlk = jpk, jrlk
+	party.ppks = ppks
+	party.numParties = len(ppks)
+	party.enc = hpbfv.NewJointEncryptor(party.params, party.jpk, len(ppks))
+	party.prover = hpbfv.NewPlaintextProver(party.params, party.jpk, len(ppks))
+	party.verifier = hpbfv.NewPlaintextVerifier(party.params, party.jpk)
+	return nil
+}
+
+// proofContext binds a proof of plaintext knowledge to the step of the protocol and to the
+// party that made it, so that a party cannot replay the ciphertexts and proof of another party.
+func proofContext(label string, id int) []byte {
+	return []byte(fmt.Sprintf("soho/%s/party-%d", label, id))
 }
 
-func (party *SohoParty) BufferTriplesRoundOne() (a, b *hpbfv.Message, ca, cb *hpbfv.Ciphertext) {
+// encryptAndProve encrypts msgs under the joint public key with a single proof of plaintext knowledge.
+func (party *SohoParty) encryptAndProve(label string, msgs ...*hpbfv.Message) ([]*hpbfv.Ciphertext, *hpbfv.PlaintextProof) {
+	return party.prover.EncryptAndProve(msgs, proofContext(label, party.id))
+}
+
+// verifyProofs checks the proof of plaintext knowledge proofs[j] of the ciphertexts ctss[0][j], ctss[1][j], ...
+// broadcast by every other party j taking part with parties, all parties if nil, and returns an AbortError
+// naming the first party whose proof does not verify.
+// The noise of the ciphertexts proven is estimated as the largest that the proofs accept, see hpbfv.Parameters.ProvenNoise,
+// so that every party floods their decryptions by the same amount, enough to hide the noise of a dishonest party.
+func (party *SohoParty) verifyProofs(label string, parties []int, proofs []*hpbfv.PlaintextProof, ctss ...[]*hpbfv.Ciphertext) error {
+	if len(proofs) != party.numParties {
+		return fmt.Errorf("got %d proofs for %d parties", len(proofs), party.numParties)
+	}
+	cts := make([]*hpbfv.Ciphertext, len(ctss))
+	for j := range proofs {
+		if !takesPart(parties, j) {
+			continue
+		}
+		for i := range ctss {
+			if len(ctss[i]) != len(proofs) {
+				return fmt.Errorf("got %d ciphertexts for %d proofs", len(ctss[i]), len(proofs))
+			}
+			cts[i] = ctss[i][j]
+		}
+		if j != party.id {
+			if err := party.verifier.Verify(cts, proofs[j], proofContext(label, j)); err != nil {
+				return &AbortError{Party: j, Round: label, Err: err}
+			}
+		}
+		for _, ct := range cts {
+			ct.SetNoise(party.params.ProvenNoise(len(cts), party.numParties), party.numParties)
+		}
+	}
+	return nil
+}
+
+// splitMasks returns the k encrypted masks broadcast by every party, indexed by mask and then by
+// party, and an AbortError naming the first party that did not broadcast k of them.
+func (party *SohoParty) splitMasks(label string, csss [][]*hpbfv.Ciphertext, k int) ([][]*hpbfv.Ciphertext, error) {
+	masks := make([][]*hpbfv.Ciphertext, k)
+	for i := range masks {
+		masks[i] = make([]*hpbfv.Ciphertext, len(csss))
+	}
+	for j, css := range csss {
+		if len(css) != k {
+			return nil, &AbortError{Party: j, Round: label, Err: fmt.Errorf("got %d encrypted masks, expected %d", len(css), k)}
+		}
+		for i, cs := range css {
+			masks[i][j] = cs
+		}
+	}
+	return masks, nil
+}
+
+// BufferTriplesRoundOne samples the shares of a and b and the mask s of the resharing of c, and encrypts
+// them under the joint public key with a proof of plaintext knowledge to be broadcast along with the ciphertexts.
+func (party *SohoParty) BufferTriplesRoundOne() (a, b, s *hpbfv.Message, ca, cb, cs *hpbfv.Ciphertext, proof *hpbfv.PlaintextProof) {
 	a = party.SampleUniformModT()
 	b = party.SampleUniformModT()
-	
-	ca = party.enc.EncryptMsgNew(a)
-	cb = party.enc.EncryptMsgNew(b)
-	return
+	s = party.SampleUniformModT()
+
+	var cts []*hpbfv.Ciphertext
+	cts, proof = party.encryptAndProve("triples", a, b, s)
+	return a, b, s, cts[0], cts[1], cts[2], proof
 }
 
-func (party *SohoParty) BufferTriplesRoundTwo(cas, cbs []*hpbfv.Ciphertext, noiseBits int) (*hpbfv.Message, *hpbfv.Ciphertext, *hpbfv.DistDecShare) {
-	sumCa := party.Aggregate(cas)
-	sumCb := party.Aggregate(cbs)
+// BufferTriplesRoundTwo verifies the proofs of plaintext knowledge of the parties taking part, all parties
+// if parties is nil, computes the encryption of c = a*b and starts its resharing among them with statistical
+// security statSec, masked by their encrypted masks css, see ReshareInit. The ciphertexts of the other
+// parties are ignored. It returns the masked encryption of c and the party's decryption share of it.
+// It returns an error wrapping hpbfv.ErrInvalidProof if a party broadcast a ciphertext it cannot prove.
+func (party *SohoParty) BufferTriplesRoundTwo(cas, cbs, css []*hpbfv.Ciphertext, proofs []*hpbfv.PlaintextProof, parties []int, statSec int) (*hpbfv.Ciphertext, *ReshareShare, error) {
+	if err := party.checkParties(parties); err != nil {
+		return nil, nil, fmt.Errorf("cannot BufferTriplesRoundTwo: %w", err)
+	}
+	if err := party.verifyProofs("triples", parties, proofs, cas, cbs, css); err != nil {
+		return nil, nil, err
+	}
+
+	sumCa := party.aggregateOf(cas, parties)
+	sumCb := party.aggregateOf(cbs, parties)
 
 	// Compute c = a*b
 	cc := party.eval.MulAndRelinNew(sumCa, sumCb, party.jrlk)
 
-	s, dsh := party.ReshareInit(cc, noiseBits)
-
-	return s, cc, dsh
+	return party.ReshareInit(cc, css, parties, statSec)
 }
 
-func (party *SohoParty) FinalizeTriple(a, b *hpbfv.Message, cc *hpbfv.Ciphertext, s *hpbfv.Message, dshs []*hpbfv.DistDecShare) {
-	c := party.ReshareFinalize(cc, dshs, s)
+// FinalizeTriple finishes the resharing of c among parties with the party's mask s and stores the triples of
+// the batch, which are shared among parties.
+// It returns an AbortError naming the first party whose decryption share is missing or invalid.
+func (party *SohoParty) FinalizeTriple(a, b *hpbfv.Message, cc *hpbfv.Ciphertext, s *hpbfv.Message, shares []*ReshareShare, parties []int, statSec int) error {
+	c, err := party.ReshareFinalize(cc, shares, s, parties, statSec)
+	if err != nil {
+		return err
+	}
 
 	for i := 0; i < party.params.Slots(); i++ {
 		ai := a.Value[i]
@@ -95,4 +260,269 @@ func (party *SohoParty) FinalizeTriple(a, b *hpbfv.Message, cc *hpbfv.Ciphertext
 			C: ci,
 		})
 	}
+	return nil
+}
+
+// GenMacKeyShare samples the party's share of the global MAC key alpha and returns its
+// encryption under the joint public key with a proof of plaintext knowledge, to be broadcast to all parties.
+// It must be called after Setup.
+func (party *SohoParty) GenMacKeyShare() (*hpbfv.Ciphertext, *hpbfv.PlaintextProof) {
+	f := party.params.Field()
+	party.alpha = party.SampleUniformModT().Value[0]
+
+	alphaMsg := hpbfv.NewMessage(party.params)
+	for i := 0; i < party.params.Slots(); i++ {
+		f.Set(alphaMsg.Value[i], party.alpha)
+	}
+	cts, proof := party.encryptAndProve("mac-key", alphaMsg)
+	return cts[0], proof
+}
+
+// SetupMacKey verifies the proofs of plaintext knowledge of the encrypted MAC key shares of all parties
+// and aggregates them into an encryption of alpha.
+func (party *SohoParty) SetupMacKey(cAlphas []*hpbfv.Ciphertext, proofs []*hpbfv.PlaintextProof) error {
+	if err := party.verifyProofs("mac-key", nil, proofs, cAlphas); err != nil {
+		return err
+	}
+	party.cAlpha = party.Aggregate(cAlphas)
+	return nil
+}
+
+// MacKeyShare returns the party's share of the global MAC key.
+func (party *SohoParty) MacKeyShare() field.Element {
+	f := party.params.Field()
+	return f.Set(f.NewElement(), party.alpha)
+}
+
+// AuthTriplesRoundOne samples the shares of a and b and the masks of the resharings of c, alpha*a, alpha*b
+// and alpha*c, and encrypts them under the joint public key with a single proof of plaintext knowledge.
+// The encrypted masks css are in that order.
+func (party *SohoParty) AuthTriplesRoundOne() (batch *SohoAuthBatch, ca, cb *hpbfv.Ciphertext, css []*hpbfv.Ciphertext, proof *hpbfv.PlaintextProof) {
+	batch = new(SohoAuthBatch)
+	batch.a = party.SampleUniformModT()
+	batch.b = party.SampleUniformModT()
+	batch.sC = party.SampleUniformModT()
+	batch.sMacA = party.SampleUniformModT()
+	batch.sMacB = party.SampleUniformModT()
+	batch.sMacC = party.SampleUniformModT()
+
+	cts, proof := party.encryptAndProve("auth-triples", batch.a, batch.b, batch.sC, batch.sMacA, batch.sMacB, batch.sMacC)
+	return batch, cts[0], cts[1], cts[2:], proof
+}
+
+// AuthTriplesRoundTwo verifies the proofs of plaintext knowledge of all parties, computes the encryptions
+// of c = a*b, alpha*a and alpha*b and starts their resharing, masked by the encrypted masks csss of all
+// parties, indexed by party.
+// It returns the party's decryption shares of the three masked ciphertexts.
+func (party *SohoParty) AuthTriplesRoundTwo(batch *SohoAuthBatch, cas, cbs []*hpbfv.Ciphertext, csss [][]*hpbfv.Ciphertext, proofs []*hpbfv.PlaintextProof, statSec int) (shC, shMacA, shMacB *ReshareShare, err error) {
+	var masks [][]*hpbfv.Ciphertext
+	if masks, err = party.splitMasks("auth-triples", csss, 4); err != nil {
+		return
+	}
+	if err = party.verifyProofs("auth-triples", nil, proofs, append([][]*hpbfv.Ciphertext{cas, cbs}, masks...)...); err != nil {
+		return
+	}
+	batch.csC, batch.csMacA, batch.csMacB, batch.csMacC = masks[0], masks[1], masks[2], masks[3]
+
+	sumCa := party.Aggregate(cas)
+	sumCb := party.Aggregate(cbs)
+
+	cc := party.eval.MulAndRelinNew(sumCa, sumCb, party.jrlk)
+	cMacA := party.eval.MulAndRelinNew(party.cAlpha, sumCa, party.jrlk)
+	cMacB := party.eval.MulAndRelinNew(party.cAlpha, sumCb, party.jrlk)
+
+	if batch.cc, shC, err = party.ReshareInit(cc, batch.csC, nil, statSec); err != nil {
+		return
+	}
+	if batch.cMacA, shMacA, err = party.ReshareInit(cMacA, batch.csMacA, nil, statSec); err != nil {
+		return
+	}
+	batch.cMacB, shMacB, err = party.ReshareInit(cMacB, batch.csMacB, nil, statSec)
+	return
+}
+
+// AuthTriplesRoundThree finishes the resharing of c, alpha*a and alpha*b, multiplies the fresh
+// encryption of c by the encrypted MAC key and returns the decryption share for alpha*c.
+// It returns an AbortError naming the first party whose decryption share is missing or invalid.
+func (party *SohoParty) AuthTriplesRoundThree(batch *SohoAuthBatch, shCs, shMacAs, shMacBs []*ReshareShare, statSec int) (shMacC *ReshareShare, err error) {
+	var ccFresh *hpbfv.Ciphertext
+	if batch.c, ccFresh, err = party.ReshareFinalizeWithCiphertext(batch.cc, batch.csC, shCs, batch.sC, nil, statSec); err != nil {
+		return
+	}
+	if batch.macA, err = party.ReshareFinalize(batch.cMacA, shMacAs, batch.sMacA, nil, statSec); err != nil {
+		return
+	}
+	if batch.macB, err = party.ReshareFinalize(batch.cMacB, shMacBs, batch.sMacB, nil, statSec); err != nil {
+		return
+	}
+
+	cMacC := party.eval.MulAndRelinNew(party.cAlpha, ccFresh, party.jrlk)
+
+	batch.cMacC, shMacC, err = party.ReshareInit(cMacC, batch.csMacC, nil, statSec)
+	return
+}
+
+// FinalizeAuthTriple finishes the resharing of alpha*c and stores the authenticated triples of the batch.
+// It returns an AbortError naming the first party whose decryption share is missing or invalid.
+func (party *SohoParty) FinalizeAuthTriple(batch *SohoAuthBatch, shMacCs []*ReshareShare, statSec int) error {
+	var err error
+	if batch.macC, err = party.ReshareFinalize(batch.cMacC, shMacCs, batch.sMacC, nil, statSec); err != nil {
+		return err
+	}
+
+	party.authTriples = append(party.authTriples,
+		newAuthTriples(party.params, batch.a, batch.b, batch.c, batch.macA, batch.macB, batch.macC)...)
+	return nil
+}
+
+// InputMasksRoundOne samples the party's share of a batch of input masks r for owner and the mask of the
+// resharing of alpha*r, and encrypts them under the joint public key with a proof of plaintext knowledge.
+func (party *SohoParty) InputMasksRoundOne(owner int) (batch *SohoInputBatch, cr, cs *hpbfv.Ciphertext, proof *hpbfv.PlaintextProof) {
+	batch = &SohoInputBatch{owner: owner}
+	batch.r = party.SampleUniformModT()
+	batch.sMac = party.SampleUniformModT()
+	cts, proof := party.encryptAndProve(fmt.Sprintf("input-masks/owner-%d", owner), batch.r, batch.sMac)
+	return batch, cts[0], cts[1], proof
+}
+
+// InputMasksRoundTwo verifies the proofs of plaintext knowledge of all parties, computes the encryption
+// of alpha*r and starts its resharing, masked by the encrypted masks css of all parties.
+// It returns the decryption share of alpha*r, to be broadcast, and the decryption share
+// of r, to be sent to the input owner only.
+func (party *SohoParty) InputMasksRoundTwo(batch *SohoInputBatch, crs, css []*hpbfv.Ciphertext, proofs []*hpbfv.PlaintextProof, statSec int) (shMac, shR *ReshareShare, err error) {
+	if err = party.verifyProofs(fmt.Sprintf("input-masks/owner-%d", batch.owner), nil, proofs, crs, css); err != nil {
+		return
+	}
+	batch.csMac = css
+
+	batch.cr = party.Aggregate(crs)
+	cMac := party.eval.MulAndRelinNew(party.cAlpha, batch.cr, party.jrlk)
+
+	if batch.cMac, shMac, err = party.ReshareInit(cMac, batch.csMac, nil, statSec); err != nil {
+		return
+	}
+	shR, err = party.partialDecrypt(batch.cr, nil, statSec)
+	return
+}
+
+// FinalizeInputMasks finishes the resharing of alpha*r and stores the input masks of the batch.
+// The input owner passes the decryption shares of r it received and learns r; the other parties pass nil.
+// It returns an AbortError naming the first party whose decryption share is missing or invalid.
+func (party *SohoParty) FinalizeInputMasks(batch *SohoInputBatch, shMacs, shRs []*ReshareShare, statSec int) error {
+	var err error
+	if batch.mac, err = party.ReshareFinalize(batch.cMac, shMacs, batch.sMac, nil, statSec); err != nil {
+		return err
+	}
+
+	var r *hpbfv.Message
+	if party.id == batch.owner {
+		if r, err = party.jointDecryptToMsg(batch.cr, shRs, nil, statSec); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < party.params.Slots(); i++ {
+		mask := &InputMask{Share: AuthShare{Value: batch.r.Value[i], Mac: batch.mac.Value[i]}}
+		if r != nil {
+			mask.Value = r.Value[i]
+		}
+		party.inputMasks[batch.owner] = append(party.inputMasks[batch.owner], mask)
+	}
+	return nil
+}
+
+// NextInputMask pops the next input mask of owner produced by the party.
+func (party *SohoParty) NextInputMask(owner int) (*InputMask, error) {
+	masks := party.inputMasks[owner]
+	if len(masks) == 0 {
+		return nil, ErrNoInputMasks
+	}
+	party.inputMasks[owner] = masks[1:]
+	return masks[0], nil
+}
+
+// SquaresRoundOne samples the party's share of a and the masks of the resharings of a^2, alpha*a and
+// alpha*a^2, and encrypts them under the joint public key with a single proof of plaintext knowledge.
+// The encrypted masks css are in that order.
+func (party *SohoParty) SquaresRoundOne() (batch *SohoSquareBatch, ca *hpbfv.Ciphertext, css []*hpbfv.Ciphertext, proof *hpbfv.PlaintextProof) {
+	batch = new(SohoSquareBatch)
+	batch.a = party.SampleUniformModT()
+	batch.sA2 = party.SampleUniformModT()
+	batch.sMacA = party.SampleUniformModT()
+	batch.sMacA2 = party.SampleUniformModT()
+
+	cts, proof := party.encryptAndProve("squares", batch.a, batch.sA2, batch.sMacA, batch.sMacA2)
+	return batch, cts[0], cts[1:], proof
+}
+
+// SquaresRoundTwo verifies the proofs of plaintext knowledge of all parties, computes the encryptions
+// of a^2 and alpha*a and starts their resharing, masked by the encrypted masks csss of all parties,
+// indexed by party.
+// Only one ciphertext is squared, against two ciphertexts multiplied in AuthTriplesRoundTwo.
+// It returns the party's decryption shares of both masked ciphertexts.
+func (party *SohoParty) SquaresRoundTwo(batch *SohoSquareBatch, cas []*hpbfv.Ciphertext, csss [][]*hpbfv.Ciphertext, proofs []*hpbfv.PlaintextProof, statSec int) (shA2, shMacA *ReshareShare, err error) {
+	var masks [][]*hpbfv.Ciphertext
+	if masks, err = party.splitMasks("squares", csss, 3); err != nil {
+		return
+	}
+	if err = party.verifyProofs("squares", nil, proofs, append([][]*hpbfv.Ciphertext{cas}, masks...)...); err != nil {
+		return
+	}
+	batch.csA2, batch.csMacA, batch.csMacA2 = masks[0], masks[1], masks[2]
+
+	sumCa := party.Aggregate(cas)
+
+	cA2 := party.eval.MulAndRelinNew(sumCa, sumCa, party.jrlk)
+	cMacA := party.eval.MulAndRelinNew(party.cAlpha, sumCa, party.jrlk)
+
+	if batch.cA2, shA2, err = party.ReshareInit(cA2, batch.csA2, nil, statSec); err != nil {
+		return
+	}
+	batch.cMacA, shMacA, err = party.ReshareInit(cMacA, batch.csMacA, nil, statSec)
+	return
+}
+
+// SquaresRoundThree finishes the resharing of a^2 and alpha*a, multiplies the fresh encryption
+// of a^2 by the encrypted MAC key and returns the decryption share for alpha*a^2.
+// It returns an AbortError naming the first party whose decryption share is missing or invalid.
+func (party *SohoParty) SquaresRoundThree(batch *SohoSquareBatch, shA2s, shMacAs []*ReshareShare, statSec int) (shMacA2 *ReshareShare, err error) {
+	var cA2Fresh *hpbfv.Ciphertext
+	if batch.a2, cA2Fresh, err = party.ReshareFinalizeWithCiphertext(batch.cA2, batch.csA2, shA2s, batch.sA2, nil, statSec); err != nil {
+		return
+	}
+	if batch.macA, err = party.ReshareFinalize(batch.cMacA, shMacAs, batch.sMacA, nil, statSec); err != nil {
+		return
+	}
+
+	cMacA2 := party.eval.MulAndRelinNew(party.cAlpha, cA2Fresh, party.jrlk)
+
+	batch.cMacA2, shMacA2, err = party.ReshareInit(cMacA2, batch.csMacA2, nil, statSec)
+	return
+}
+
+// FinalizeSquares finishes the resharing of alpha*a^2 and stores the authenticated square pairs of the batch.
+// It returns an AbortError naming the first party whose decryption share is missing or invalid.
+func (party *SohoParty) FinalizeSquares(batch *SohoSquareBatch, shMacA2s []*ReshareShare, statSec int) error {
+	var err error
+	if batch.macA2, err = party.ReshareFinalize(batch.cMacA2, shMacA2s, batch.sMacA2, nil, statSec); err != nil {
+		return err
+	}
+
+	for i := 0; i < party.params.Slots(); i++ {
+		party.squares = append(party.squares, &AuthSquare{
+			A:  AuthShare{Value: batch.a.Value[i], Mac: batch.macA.Value[i]},
+			A2: AuthShare{Value: batch.a2.Value[i], Mac: batch.macA2.Value[i]},
+		})
+	}
+	return nil
+}
+
+// NextAuthSquare pops the next authenticated square pair produced by the party.
+func (party *SohoParty) NextAuthSquare() (*AuthSquare, error) {
+	if len(party.squares) == 0 {
+		return nil, ErrNoSquares
+	}
+	square := party.squares[0]
+	party.squares = party.squares[1:]
+	return square, nil
 }
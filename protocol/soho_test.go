@@ -1,13 +1,14 @@
 package protocol
 
 import (
+	"errors"
 	"testing"
 
+	"spdz-go/field"
 	"spdz-go/hpbfv"
 	"spdz-go/rlwe"
 
 	"crypto/rand"
-	"math/big"
 
 	"sync"
 )
@@ -19,19 +20,22 @@ type sohoKeyMsg struct {
 	senderID int
 	ppk      *rlwe.PublicKey
 	prlk     *hpbfv.RelinearizationKey
+	keyProof *hpbfv.KeyProof
 }
 
-// Round 1: Ciphertexts (CA, CB)
+// Round 1: Ciphertexts (CA, CB), encrypted masks and their proof of plaintext knowledge
 type sohoCTMsg struct {
 	senderID int
 	cA       *hpbfv.Ciphertext
 	cB       *hpbfv.Ciphertext
+	masks    []*hpbfv.Ciphertext
+	proof    *hpbfv.PlaintextProof
 }
 
-// Round 2: Distributed Decryption Shares
+// Round 2: Distributed Decryption Shares and their proofs of decryption
 type sohoShareMsg struct {
 	senderID int
-	ddsh     *hpbfv.DistDecShare
+	share    *ReshareShare
 }
 
 // Channels for a single party
@@ -43,7 +47,10 @@ type sohoPartyChannels struct {
 
 func TestSohoPrep(t *testing.T) {
 	// Common Setup
-	params := hpbfv.NewParametersFromLiteral(hpbfv.SOHO)
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	crs := make([]byte, 32)
 	if _, err := rand.Read(crs); err != nil {
 		t.Fatalf("cannot generate crs: %v", err)
@@ -71,7 +78,9 @@ func TestSohoPrep(t *testing.T) {
 		wg.Add(1)
 		go func(pid int) {
 			defer wg.Done()
-			runSohoParty(pid, numParties, params, crs, partyChans, finishedParties)
+			if err := runSohoParty(pid, numParties, params, crs, partyChans, finishedParties); err != nil {
+				t.Errorf("party %d: %v", pid, err)
+			}
 		}(i)
 	}
 	wg.Wait()
@@ -82,59 +91,62 @@ func TestSohoPrep(t *testing.T) {
 		parties[p.id] = p
 	}
 
+	f := params.Field()
 	for i := range parties[0].triples {
-		aSum := big.NewInt(0)
-		bSum := big.NewInt(0)
-		cSum := big.NewInt(0)
+		aSum := f.NewElement()
+		bSum := f.NewElement()
+		cSum := f.NewElement()
 
 		for _, party := range parties {
 			triple := party.triples[i]
-			aSum.Add(aSum, triple.A)
-			bSum.Add(bSum, triple.B)
-			cSum.Add(cSum, triple.C)
+			f.Add(aSum, aSum, triple.A)
+			f.Add(bSum, bSum, triple.B)
+			f.Add(cSum, cSum, triple.C)
 		}
 
-		aSum.Mod(aSum, params.T())
-		bSum.Mod(bSum, params.T())
-		cSum.Mod(cSum, params.T())
-
-		ab := new(big.Int).Mul(aSum, bSum)
-		ab.Mod(ab, params.T())
+		ab := f.Mul(f.NewElement(), aSum, bSum)
 
-		if cSum.Cmp(ab) != 0 {
-			t.Fatalf("Triple check failed at index %d: A=%s, B=%s, C=%s, but A*B=%s", i, aSum.String(), bSum.String(), cSum.String(), ab.String())
+		if !f.Equal(cSum, ab) {
+			t.Fatalf("Triple check failed at index %d: A=%s, B=%s, C=%s, but A*B=%s", i, f.Big(aSum), f.Big(bSum), f.Big(cSum), f.Big(ab))
 		}
 	}
 }
 
-func runSohoParty(id, numParties int, params hpbfv.Parameters, crs []byte, allChans []sohoPartyChannels, resultChan chan<- *SohoParty) {
+func runSohoParty(id, numParties int, params hpbfv.Parameters, crs []byte, allChans []sohoPartyChannels, resultChan chan<- *SohoParty) error {
 	// --- Round 0: Key Generation & Exchange ---
 	party := NewSohoParty(id, params, crs)
 	myKeyMsg := sohoKeyMsg{
 		senderID: id,
 		ppk:      party.ppk,
 		prlk:     party.prlk,
+		keyProof: party.keyProof,
 	}
 	for peer := 0; peer < numParties; peer++ {
 		allChans[peer].keyIn <- myKeyMsg
 	}
 	ppks := make([]*rlwe.PublicKey, numParties)
 	prlks := make([]*hpbfv.RelinearizationKey, numParties)
+	keyProofs := make([]*hpbfv.KeyProof, numParties)
 	for i := 0; i < numParties; i++ {
 		msg := <-allChans[id].keyIn
 		ppks[msg.senderID] = msg.ppk
 		prlks[msg.senderID] = msg.prlk
+		keyProofs[msg.senderID] = msg.keyProof
+	}
+	if err := party.Setup(ppks, prlks, keyProofs); err != nil {
+		return err
 	}
-	party.Setup(ppks, prlks)
 
 	// --- Round 1: Sampling & Exchange ---
-	a, b, ca, cb := party.BufferTriplesRoundOne()
+	a, b, s, ca, cb, cs, proof := party.BufferTriplesRoundOne()
 
 	// Broadcast my ciphertexts
 	myCTMsg := sohoCTMsg{
 		senderID: id,
 		cA:       ca,
 		cB:       cb,
+		masks:    []*hpbfv.Ciphertext{cs},
+		proof:    proof,
 	}
 	for peer := 0; peer < numParties; peer++ {
 		allChans[peer].ctIn <- myCTMsg
@@ -143,33 +155,443 @@ func runSohoParty(id, numParties int, params hpbfv.Parameters, crs []byte, allCh
 	// Collect ciphertexts from everyone
 	cas := make([]*hpbfv.Ciphertext, numParties)
 	cbs := make([]*hpbfv.Ciphertext, numParties)
+	css := make([]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
 	for i := 0; i < numParties; i++ {
 		msg := <-allChans[id].ctIn
 		cas[msg.senderID] = msg.cA
 		cbs[msg.senderID] = msg.cB
+		css[msg.senderID] = msg.masks[0]
+		proofs[msg.senderID] = msg.proof
 	}
 
 	// --- Round 2: Multiplication & Resharing ---
-	s, cc, dsh := party.BufferTriplesRoundTwo(cas, cbs, 80)
+	cc, sh, err := party.BufferTriplesRoundTwo(cas, cbs, css, proofs, nil, 40)
+	if err != nil {
+		return err
+	}
 
 	// Broadcast my decryption share
 	myShareMsg := sohoShareMsg{
 		senderID: id,
-		ddsh:     dsh,
+		share:    sh,
 	}
 	for peer := 0; peer < numParties; peer++ {
 		allChans[peer].shareIn <- myShareMsg
 	}
 
 	// Collect decryption shares from everyone
-	dshs := make([]*hpbfv.DistDecShare, numParties)
+	shs := make([]*ReshareShare, numParties)
 	for i := 0; i < numParties; i++ {
 		msg := <-allChans[id].shareIn
-		dshs[msg.senderID] = msg.ddsh
+		shs[msg.senderID] = msg.share
 	}
 
 	// --- Finalize ---
-	party.FinalizeTriple(a, b, cc, s, dshs)
+	if err = party.FinalizeTriple(a, b, cc, s, shs, nil, 40); err != nil {
+		return err
+	}
 
 	resultChan <- party
+	return nil
+}
+
+// --- Message Structs for Authenticated Triples ---
+
+// MAC key setup: encrypted MAC key share and its proof of plaintext knowledge
+type sohoMacKeyMsg struct {
+	senderID int
+	cAlpha   *hpbfv.Ciphertext
+	proof    *hpbfv.PlaintextProof
+}
+
+// Round 2: decryption shares for c, alpha*a, alpha*b
+type sohoAuthShareMsg struct {
+	senderID int
+	shC      *ReshareShare
+	shMacA   *ReshareShare
+	shMacB   *ReshareShare
+}
+
+// Channels for a single party
+type sohoAuthPartyChannels struct {
+	keyIn      chan sohoKeyMsg
+	macKeyIn   chan sohoMacKeyMsg
+	ctIn       chan sohoCTMsg
+	authIn     chan sohoAuthShareMsg
+	macShareIn chan sohoShareMsg
+}
+
+func TestSohoAuthPrep(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crs := make([]byte, 32)
+	if _, err := rand.Read(crs); err != nil {
+		t.Fatalf("cannot generate crs: %v", err)
+	}
+
+	numParties := 3
+
+	partyChans := make([]sohoAuthPartyChannels, numParties)
+	for i := 0; i < numParties; i++ {
+		partyChans[i] = sohoAuthPartyChannels{
+			keyIn:      make(chan sohoKeyMsg, numParties),
+			macKeyIn:   make(chan sohoMacKeyMsg, numParties),
+			ctIn:       make(chan sohoCTMsg, numParties),
+			authIn:     make(chan sohoAuthShareMsg, numParties),
+			macShareIn: make(chan sohoShareMsg, numParties),
+		}
+	}
+
+	finishedParties := make(chan *SohoParty, numParties)
+	var wg sync.WaitGroup
+
+	for i := 0; i < numParties; i++ {
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			if err := runSohoAuthParty(pid, numParties, params, crs, partyChans, finishedParties); err != nil {
+				t.Errorf("party %d: %v", pid, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(finishedParties)
+
+	parties := make([]*SohoParty, numParties)
+	for p := range finishedParties {
+		parties[p.id] = p
+	}
+
+	f := params.Field()
+	alpha := f.NewElement()
+	for _, party := range parties {
+		f.Add(alpha, alpha, party.MacKeyShare())
+	}
+
+	if len(parties[0].authTriples) != params.Slots() {
+		t.Fatalf("expected %d authenticated triples, got %d", params.Slots(), len(parties[0].authTriples))
+	}
+
+	open := func(shares []AuthShare) (value, mac field.Element) {
+		value, mac = f.NewElement(), f.NewElement()
+		for _, sh := range shares {
+			f.Add(value, value, sh.Value)
+			f.Add(mac, mac, sh.Mac)
+		}
+		return value, mac
+	}
+
+	for i := range parties[0].authTriples {
+		as := make([]AuthShare, numParties)
+		bs := make([]AuthShare, numParties)
+		cs := make([]AuthShare, numParties)
+		for j, party := range parties {
+			as[j] = party.authTriples[i].A
+			bs[j] = party.authTriples[i].B
+			cs[j] = party.authTriples[i].C
+		}
+
+		for name, shares := range map[string][]AuthShare{"A": as, "B": bs, "C": cs} {
+			value, mac := open(shares)
+			expected := f.Mul(f.NewElement(), alpha, value)
+			if !f.Equal(mac, expected) {
+				t.Fatalf("MAC check failed for %s at index %d: mac=%s, alpha*value=%s", name, i, f.Big(mac), f.Big(expected))
+			}
+		}
+
+		a, _ := open(as)
+		b, _ := open(bs)
+		c, _ := open(cs)
+		ab := f.Mul(f.NewElement(), a, b)
+		if !f.Equal(c, ab) {
+			t.Fatalf("Triple check failed at index %d: A=%s, B=%s, C=%s, but A*B=%s", i, f.Big(a), f.Big(b), f.Big(c), f.Big(ab))
+		}
+	}
+}
+
+func runSohoAuthParty(id, numParties int, params hpbfv.Parameters, crs []byte, allChans []sohoAuthPartyChannels, resultChan chan<- *SohoParty) error {
+	// --- Round 0: Key Generation & Exchange ---
+	party := NewSohoParty(id, params, crs)
+	for peer := 0; peer < numParties; peer++ {
+		allChans[peer].keyIn <- sohoKeyMsg{senderID: id, ppk: party.ppk, prlk: party.prlk, keyProof: party.keyProof}
+	}
+	ppks := make([]*rlwe.PublicKey, numParties)
+	prlks := make([]*hpbfv.RelinearizationKey, numParties)
+	keyProofs := make([]*hpbfv.KeyProof, numParties)
+	for i := 0; i < numParties; i++ {
+		msg := <-allChans[id].keyIn
+		ppks[msg.senderID] = msg.ppk
+		prlks[msg.senderID] = msg.prlk
+		keyProofs[msg.senderID] = msg.keyProof
+	}
+	if err := party.Setup(ppks, prlks, keyProofs); err != nil {
+		return err
+	}
+
+	// --- MAC Key Setup ---
+	cAlpha, proof := party.GenMacKeyShare()
+	for peer := 0; peer < numParties; peer++ {
+		allChans[peer].macKeyIn <- sohoMacKeyMsg{senderID: id, cAlpha: cAlpha, proof: proof}
+	}
+	cAlphas := make([]*hpbfv.Ciphertext, numParties)
+	alphaProofs := make([]*hpbfv.PlaintextProof, numParties)
+	for i := 0; i < numParties; i++ {
+		msg := <-allChans[id].macKeyIn
+		cAlphas[msg.senderID] = msg.cAlpha
+		alphaProofs[msg.senderID] = msg.proof
+	}
+	if err := party.SetupMacKey(cAlphas, alphaProofs); err != nil {
+		return err
+	}
+
+	// --- Round 1: Sampling & Exchange ---
+	batch, ca, cb, css, proof := party.AuthTriplesRoundOne()
+	for peer := 0; peer < numParties; peer++ {
+		allChans[peer].ctIn <- sohoCTMsg{senderID: id, cA: ca, cB: cb, masks: css, proof: proof}
+	}
+	cas := make([]*hpbfv.Ciphertext, numParties)
+	cbs := make([]*hpbfv.Ciphertext, numParties)
+	csss := make([][]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for i := 0; i < numParties; i++ {
+		msg := <-allChans[id].ctIn
+		cas[msg.senderID] = msg.cA
+		cbs[msg.senderID] = msg.cB
+		csss[msg.senderID] = msg.masks
+		proofs[msg.senderID] = msg.proof
+	}
+
+	// --- Round 2: Multiplication & Resharing of c, alpha*a, alpha*b ---
+	shC, shMacA, shMacB, err := party.AuthTriplesRoundTwo(batch, cas, cbs, csss, proofs, 40)
+	if err != nil {
+		return err
+	}
+	for peer := 0; peer < numParties; peer++ {
+		allChans[peer].authIn <- sohoAuthShareMsg{senderID: id, shC: shC, shMacA: shMacA, shMacB: shMacB}
+	}
+	shCs := make([]*ReshareShare, numParties)
+	shMacAs := make([]*ReshareShare, numParties)
+	shMacBs := make([]*ReshareShare, numParties)
+	for i := 0; i < numParties; i++ {
+		msg := <-allChans[id].authIn
+		shCs[msg.senderID] = msg.shC
+		shMacAs[msg.senderID] = msg.shMacA
+		shMacBs[msg.senderID] = msg.shMacB
+	}
+
+	// --- Round 3: Resharing of alpha*c ---
+	shMacC, err := party.AuthTriplesRoundThree(batch, shCs, shMacAs, shMacBs, 40)
+	if err != nil {
+		return err
+	}
+	for peer := 0; peer < numParties; peer++ {
+		allChans[peer].macShareIn <- sohoShareMsg{senderID: id, share: shMacC}
+	}
+	shMacCs := make([]*ReshareShare, numParties)
+	for i := 0; i < numParties; i++ {
+		msg := <-allChans[id].macShareIn
+		shMacCs[msg.senderID] = msg.share
+	}
+
+	// --- Finalize ---
+	if err = party.FinalizeAuthTriple(batch, shMacCs, 40); err != nil {
+		return err
+	}
+
+	resultChan <- party
+	return nil
+}
+
+// setupSohoParties runs the key generation and MAC key setup of numParties Soho parties sequentially
+func setupSohoParties(t *testing.T, params hpbfv.Parameters, numParties int) []*SohoParty {
+	crs := make([]byte, 32)
+	if _, err := rand.Read(crs); err != nil {
+		t.Fatalf("cannot generate crs: %v", err)
+	}
+
+	parties := make([]*SohoParty, numParties)
+	for i := range parties {
+		parties[i] = NewSohoParty(i, params, crs)
+	}
+
+	// Round 0 (Key Generation)
+	ppks := make([]*rlwe.PublicKey, numParties)
+	prlks := make([]*hpbfv.RelinearizationKey, numParties)
+	keyProofs := make([]*hpbfv.KeyProof, numParties)
+	for i, party := range parties {
+		ppks[i] = party.ppk
+		prlks[i] = party.prlk
+		keyProofs[i] = party.keyProof
+	}
+	for _, party := range parties {
+		if err := party.Setup(ppks, prlks, keyProofs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// MAC key setup
+	cAlphas := make([]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for i, party := range parties {
+		cAlphas[i], proofs[i] = party.GenMacKeyShare()
+	}
+	for _, party := range parties {
+		if err := party.SetupMacKey(cAlphas, proofs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return parties
+}
+
+func TestSohoRejectsUnprovenCiphertexts(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numParties := 3
+
+	parties := setupSohoParties(t, params, numParties)
+
+	cas := make([]*hpbfv.Ciphertext, numParties)
+	cbs := make([]*hpbfv.Ciphertext, numParties)
+	css := make([]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for i, party := range parties {
+		_, _, _, cas[i], cbs[i], css[i], proofs[i] = party.BufferTriplesRoundOne()
+	}
+
+	t.Run("Replayed", func(t *testing.T) {
+		// party 1 replays the ciphertexts and proof of party 2
+		replayed := []*hpbfv.Ciphertext{cas[0], cas[2], cas[2]}
+		replayedB := []*hpbfv.Ciphertext{cbs[0], cbs[2], cbs[2]}
+		replayedS := []*hpbfv.Ciphertext{css[0], css[2], css[2]}
+		replayedProofs := []*hpbfv.PlaintextProof{proofs[0], proofs[2], proofs[2]}
+		_, _, err := parties[0].BufferTriplesRoundTwo(replayed, replayedB, replayedS, replayedProofs, nil, 40)
+		var abort *AbortError
+		if !errors.Is(err, hpbfv.ErrInvalidProof) || !errors.As(err, &abort) || abort.Party != 1 {
+			t.Fatalf("expected an invalid proof of party 1, got %v", err)
+		}
+	})
+
+	t.Run("Unproven", func(t *testing.T) {
+		// party 2 sends an encryption that is not covered by its proof
+		unproven := []*hpbfv.Ciphertext{cas[0], cas[1], parties[2].enc.EncryptMsgNew(parties[2].SampleUniformModT())}
+		_, _, err := parties[0].BufferTriplesRoundTwo(unproven, cbs, css, proofs, nil, 40)
+		var abort *AbortError
+		if !errors.Is(err, hpbfv.ErrInvalidProof) || !errors.As(err, &abort) || abort.Party != 2 {
+			t.Fatalf("expected an invalid proof of party 2, got %v", err)
+		}
+	})
+
+	if _, _, err := parties[0].BufferTriplesRoundTwo(cas, cbs, css, proofs, nil, 40); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSohoThreshold(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numParties, threshold, statSec := 3, 1, 40
+
+	parties := setupSohoParties(t, params, numParties)
+
+	// every party deals committed Shamir shares of its secret key to the others
+	coms := make([]*hpbfv.ShamirCommitment, numParties)
+	received := make([][]*hpbfv.ShamirShare, numParties)
+	for j := range received {
+		received[j] = make([]*hpbfv.ShamirShare, numParties)
+	}
+	for i, party := range parties {
+		var shares []*hpbfv.ShamirShare
+		if coms[i], shares, err = party.GenThresholdShares(threshold); err != nil {
+			t.Fatal(err)
+		}
+		for j, share := range shares {
+			received[j][i] = share
+		}
+	}
+
+	// party 1 deals party 0 the share of party 2
+	tampered := append([]*hpbfv.ShamirShare{}, received[0]...)
+	tampered[1] = received[2][1]
+	var abort *AbortError
+	if err := parties[0].SetupThreshold(threshold, coms, tampered); !errors.As(err, &abort) || abort.Party != 1 {
+		t.Fatalf("expected an invalid share of party 1, got %v", err)
+	}
+
+	for j, party := range parties {
+		if err := party.SetupThreshold(threshold, coms, received[j]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, set := range [][]int{{0}, {1, 2}, {1, 0}, {0, 0}, {0, 3}} {
+		if _, _, err := parties[0].ReshareInit(nil, nil, set, statSec); err == nil {
+			t.Fatalf("party 0 accepted the set of parties %v", set)
+		}
+	}
+	if _, _, err := parties[0].ReshareInit(nil, nil, []int{0}, statSec); !errors.Is(err, hpbfv.ErrTooFewParties) {
+		t.Fatalf("expected too few parties, got %v", err)
+	}
+
+	// party 2 is offline: parties 0 and 1 generate a batch of triples on their own
+	present := []int{0, 1}
+
+	as := make([]*hpbfv.Message, numParties)
+	bs := make([]*hpbfv.Message, numParties)
+	ss := make([]*hpbfv.Message, numParties)
+	cas := make([]*hpbfv.Ciphertext, numParties)
+	cbs := make([]*hpbfv.Ciphertext, numParties)
+	css := make([]*hpbfv.Ciphertext, numParties)
+	proofs := make([]*hpbfv.PlaintextProof, numParties)
+	for _, j := range present {
+		as[j], bs[j], ss[j], cas[j], cbs[j], css[j], proofs[j] = parties[j].BufferTriplesRoundOne()
+	}
+
+	ccs := make([]*hpbfv.Ciphertext, numParties)
+	shs := make([]*ReshareShare, numParties)
+	for _, j := range present {
+		if ccs[j], shs[j], err = parties[j].BufferTriplesRoundTwo(cas, cbs, css, proofs, present, statSec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("ForgedShare", func(t *testing.T) {
+		// party 1 sends the decryption share of party 0 with its own proof
+		forged := []*ReshareShare{shs[0], {Share: shs[0].Share, ThresholdProof: shs[1].ThresholdProof}, nil}
+		_, err := parties[0].ReshareFinalize(ccs[0], forged, ss[0], present, statSec)
+		var abort *AbortError
+		if !errors.Is(err, hpbfv.ErrInvalidDecryptionShare) || !errors.As(err, &abort) || abort.Party != 1 {
+			t.Fatalf("expected an invalid decryption share of party 1, got %v", err)
+		}
+	})
+
+	for _, j := range present {
+		if err := parties[j].FinalizeTriple(as[j], bs[j], ccs[j], ss[j], shs, present, statSec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := params.Field()
+	if len(parties[0].triples) == 0 || len(parties[0].triples) != len(parties[1].triples) {
+		t.Fatalf("expected a batch of triples, got %d and %d", len(parties[0].triples), len(parties[1].triples))
+	}
+	for i := range parties[0].triples {
+		aSum, bSum, cSum := f.NewElement(), f.NewElement(), f.NewElement()
+		for _, j := range present {
+			triple := parties[j].triples[i]
+			f.Add(aSum, aSum, triple.A)
+			f.Add(bSum, bSum, triple.B)
+			f.Add(cSum, cSum, triple.C)
+		}
+		if ab := f.Mul(f.NewElement(), aSum, bSum); !f.Equal(cSum, ab) {
+			t.Fatalf("Triple check failed at index %d: A=%s, B=%s, C=%s, but A*B=%s", i, f.Big(aSum), f.Big(bSum), f.Big(cSum), f.Big(ab))
+		}
+	}
 }
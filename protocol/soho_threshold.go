@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"spdz-go/hpbfv"
+)
+
+// The threshold key lets any threshold+1 parties decrypt and reshare under the joint key without the others.
+// After Setup, every party deals Shamir shares of its secret key with GenThresholdShares, broadcasts the
+// commitment and sends the share of index j privately to party j. SetupThreshold checks the shares received
+// against the commitments and the partial public keys of their dealers, and aggregates them into the party's
+// share of the threshold key. The steps that take a set of parties then run among the parties of a non-nil
+// set only, see ReshareInit, with decryption shares proven against the threshold key.
+//
+// Key generation and the dealing of the threshold shares still need every party. The shares of the MAC key
+// are additive, so the authenticated steps, which reshare values under the MAC key, and the online phase also
+// need every party: only the unauthenticated triples of BufferTriplesRoundTwo and FinalizeTriple and the
+// resharings called directly take a set of parties.
+
+// GenThresholdShares Shamir-shares the party's secret key so that any threshold+1 parties decrypt under the
+// joint key. It returns the commitment to the sharing, to be broadcast, and the shares, of which the share
+// of index j must be sent privately to party j. It must be called after Setup, and returns an error if the
+// parameters do not support a threshold key of the parties.
+func (party *SohoParty) GenThresholdShares(threshold int) (*hpbfv.ShamirCommitment, []*hpbfv.ShamirShare, error) {
+	thr, err := hpbfv.NewThresholdizer(party.params, party.numParties, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+	com, shares := thr.GenShares(party.sk, party.ppk)
+	return com, shares, nil
+}
+
+// SetupThreshold checks the Shamir shares received by the party against the broadcast commitments of their
+// dealers, both indexed by dealer, and aggregates them into the party's share of the threshold key.
+// It returns an AbortError naming the first dealer whose share or commitment is missing or invalid.
+func (party *SohoParty) SetupThreshold(threshold int, coms []*hpbfv.ShamirCommitment, shares []*hpbfv.ShamirShare) error {
+	thr, err := hpbfv.NewThresholdizer(party.params, party.numParties, threshold)
+	if err != nil {
+		return fmt.Errorf("cannot SetupThreshold: %w", err)
+	}
+	if len(coms) != party.numParties || len(shares) != party.numParties {
+		return fmt.Errorf("cannot SetupThreshold: got %d commitments and %d shares for %d parties", len(coms), len(shares), party.numParties)
+	}
+	for i := range shares {
+		if err := thr.VerifyShare(party.id, shares[i], coms[i], party.ppks[i]); err != nil {
+			return &AbortError{Party: i, Round: "threshold-shares", Err: err}
+		}
+	}
+	share, err := thr.AggregateShares(shares)
+	if err != nil {
+		return err
+	}
+	key, err := thr.AggregateCommitments(party.ppks, coms)
+	if err != nil {
+		return err
+	}
+	if party.tdec, err = hpbfv.NewThresholdDecryptor(party.params, key, share, party.id); err != nil {
+		return err
+	}
+	party.threshold = threshold
+	return nil
+}
+
+// checkParties returns nil if parties is nil, and otherwise an error if the party has no threshold key or if
+// parties is not a set of at least threshold+1 parties in increasing order containing the party, wrapping
+// hpbfv.ErrTooFewParties if there are too few.
+func (party *SohoParty) checkParties(parties []int) error {
+	if parties == nil {
+		return nil
+	}
+	if party.tdec == nil {
+		return errors.New("no threshold key for a set of parties")
+	}
+	if len(parties) < party.threshold+1 {
+		return fmt.Errorf("%w: %d parties for threshold %d", hpbfv.ErrTooFewParties, len(parties), party.threshold)
+	}
+	self := false
+	for i, j := range parties {
+		if j < 0 || j >= party.numParties || (i > 0 && parties[i-1] >= j) {
+			return fmt.Errorf("invalid set of parties %v", parties)
+		}
+		self = self || j == party.id
+	}
+	if !self {
+		return fmt.Errorf("party %d is not in %v", party.id, parties)
+	}
+	return nil
+}
+
+// members returns the parties taking part: parties, or every party if parties is nil.
+func (party *SohoParty) members(parties []int) []int {
+	if parties != nil {
+		return parties
+	}
+	all := make([]int, party.numParties)
+	for j := range all {
+		all[j] = j
+	}
+	return all
+}
+
+// takesPart returns true if party j takes part with parties.
+func takesPart(parties []int, j int) bool {
+	if parties == nil {
+		return true
+	}
+	for _, k := range parties {
+		if k == j {
+			return true
+		}
+	}
+	return false
+}
+
+// leader returns the first party taking part, which decrypts the masked values in ReshareFinalize.
+func (party *SohoParty) leader(parties []int) int {
+	if parties == nil {
+		return 0
+	}
+	return parties[0]
+}
+
+// aggregateOf sums the ciphertexts of the parties taking part, indexed by party.
+func (party *SohoParty) aggregateOf(cts []*hpbfv.Ciphertext, parties []int) *hpbfv.Ciphertext {
+	if parties == nil {
+		return party.Aggregate(cts)
+	}
+	sumCt := hpbfv.NewCiphertext(party.params, 1)
+	for _, j := range parties {
+		party.eval.Add(sumCt, cts[j], sumCt)
+	}
+	return sumCt
+}
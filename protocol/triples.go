@@ -1,11 +1,104 @@
 package protocol
 
 import (
-	"math/big"
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+	"spdz-go/utils"
 )
 
 type Triple struct {
-	A *big.Int
-	B *big.Int
-	C *big.Int
-}
\ No newline at end of file
+	A field.Element
+	B field.Element
+	C field.Element
+}
+
+// AuthShare is an additive share of a value x mod T together with an additive share
+// of its MAC alpha*x under the global MAC key alpha.
+type AuthShare struct {
+	Value field.Element
+	Mac   field.Element
+}
+
+// newAuthShare returns a zero AuthShare whose value and MAC share a single allocation.
+func newAuthShare(f *field.Field) AuthShare {
+	l := f.Limbs()
+	buf := make(field.Element, 2*l)
+	return AuthShare{Value: buf[:l:l], Mac: buf[l:]}
+}
+
+// AuthTriple is a multiplication triple (a, b, c = a*b) whose components are authenticated shares.
+type AuthTriple struct {
+	A AuthShare
+	B AuthShare
+	C AuthShare
+}
+
+// sampleUniformModT samples a message with slots uniformly random in Z_T from prng.
+func sampleUniformModT(params hpbfv.Parameters, prng utils.PRNG) *hpbfv.Message {
+	msg := hpbfv.NewMessage(params)
+	params.Field().Sample(prng, msg.Value...)
+	return msg
+}
+
+// newAuthTriples slices the slot-wise shares and MAC shares of a batch into authenticated triples.
+func newAuthTriples(params hpbfv.Parameters, a, b, c, macA, macB, macC *hpbfv.Message) []*AuthTriple {
+	triples := make([]*AuthTriple, params.Slots())
+	for i := 0; i < params.Slots(); i++ {
+		triples[i] = &AuthTriple{
+			A: AuthShare{Value: a.Value[i], Mac: macA.Value[i]},
+			B: AuthShare{Value: b.Value[i], Mac: macB.Value[i]},
+			C: AuthShare{Value: c.Value[i], Mac: macC.Value[i]},
+		}
+	}
+	return triples
+}
+
+// InputMask is a preprocessed mask for a private input: every party holds an authenticated
+// share of a random r, and the input owner additionally knows r.
+type InputMask struct {
+	Share AuthShare
+	Value field.Element // r, only set for the input owner
+}
+
+// AuthSquare is a square pair (a, a^2) whose components are authenticated shares.
+type AuthSquare struct {
+	A  AuthShare
+	A2 AuthShare
+}
+
+// WriteWire writes the triple to w.
+func (t *Triple) WriteWire(w *hpbfv.WireWriter) {
+	w.WriteScalar(t.A)
+	w.WriteScalar(t.B)
+	w.WriteScalar(t.C)
+}
+
+// ReadTriple reads a triple written by Triple.WriteWire. The result must not be used before r.Close returned nil.
+func ReadTriple(r *hpbfv.WireReader) *Triple {
+	return &Triple{A: r.ReadScalar(), B: r.ReadScalar(), C: r.ReadScalar()}
+}
+
+// WriteWire writes the authenticated share to w.
+func (s AuthShare) WriteWire(w *hpbfv.WireWriter) {
+	w.WriteScalar(s.Value)
+	w.WriteScalar(s.Mac)
+}
+
+// ReadAuthShare reads an authenticated share written by AuthShare.WriteWire.
+// The result must not be used before r.Close returned nil.
+func ReadAuthShare(r *hpbfv.WireReader) AuthShare {
+	return AuthShare{Value: r.ReadScalar(), Mac: r.ReadScalar()}
+}
+
+// WriteWire writes the authenticated triple to w.
+func (t *AuthTriple) WriteWire(w *hpbfv.WireWriter) {
+	t.A.WriteWire(w)
+	t.B.WriteWire(w)
+	t.C.WriteWire(w)
+}
+
+// ReadAuthTriple reads an authenticated triple written by AuthTriple.WriteWire.
+// The result must not be used before r.Close returned nil.
+func ReadAuthTriple(r *hpbfv.WireReader) *AuthTriple {
+	return &AuthTriple{A: ReadAuthShare(r), B: ReadAuthShare(r), C: ReadAuthShare(r)}
+}
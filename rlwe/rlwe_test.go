@@ -31,6 +31,42 @@ func testString(params Parameters, opname string) string {
 		params.PCount())
 }
 
+func TestSecurity(t *testing.T) {
+	// the estimates match the table points of the homomorphic encryption security standard
+	for _, c := range []struct {
+		logN  int
+		logQ  float64
+		level float64
+	}{{10, 27, 128}, {14, 438, 128}, {14, 305, 192}, {14, 237, 256}, {15, 881, 128}} {
+		assert.InDelta(t, c.level, HEStandardSecurity(c.logN, c.logQ, SecretTernary), 1e-9)
+		assert.InDelta(t, c.level, PrimalUSVPSecurity(c.logN, c.logQ, DefaultSigma, math.Sqrt(2.0/3)), 5)
+	}
+	assert.Greater(t, HEStandardSecurity(14, 438, SecretGaussian), 128.0)
+
+	// the security decreases with the modulus and increases with the degree
+	assert.Less(t, HEStandardSecurity(14, 500, SecretTernary), 128.0)
+	assert.Greater(t, HEStandardSecurity(14, 250, SecretTernary), 192.0)
+	assert.Greater(t, HEStandardSecurity(16, 1700, SecretTernary), 128.0)
+	assert.Less(t, HEStandardSecurity(16, 1800, SecretTernary), 128.0)
+
+	// the estimate is capped at the largest level of the tables outside of their range
+	assert.Equal(t, 256.0, HEStandardSecurity(14, 100, SecretTernary))
+	assert.LessOrEqual(t, HEStandardSecurity(17, 60, SecretTernary), 256.0)
+	assert.LessOrEqual(t, HEStandardSecurity(20, 200, SecretGaussian), 256.0)
+
+	params, err := NewParametersFromLiteral(TestPN14QP438)
+	require.NoError(t, err)
+	dense := params.SecurityLevel()
+	assert.InDelta(t, 128, dense, 1)
+
+	// sparse secrets are less secure
+	sparse := TestPN14QP438
+	sparse.H = 64
+	params, err = NewParametersFromLiteral(sparse)
+	require.NoError(t, err)
+	assert.Less(t, params.SecurityLevel(), dense)
+}
+
 func TestRLWE(t *testing.T) {
 
 	var err error
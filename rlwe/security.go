@@ -0,0 +1,168 @@
+package rlwe
+
+import (
+	"math"
+)
+
+// SecretDistribution is the distribution of the secret key, which selects the table of the
+// homomorphic encryption security standard used to estimate the security of a parameter set.
+type SecretDistribution int
+
+const (
+	// SecretTernary is the uniform distribution over {-1, 0, 1}.
+	SecretTernary SecretDistribution = iota
+	// SecretGaussian is the error distribution.
+	SecretGaussian
+)
+
+// heStandardLogN is the smallest log2 of the ring degree covered by heStandardMaxLogQ.
+const heStandardLogN = 10
+
+// heStandardLevels are the security levels, in bits, of the columns of heStandardMaxLogQ.
+var heStandardLevels = [3]float64{128, 192, 256}
+
+// heStandardMaxLogQ gives, for each secret distribution and each ring degree from 2^10 to 2^15,
+// the largest log2(QP) reaching 128, 192 and 256 bits of classical security
+// (Albrecht et al., Homomorphic Encryption Security Standard, 2018, Tables 1 and 2).
+var heStandardMaxLogQ = map[SecretDistribution][][3]float64{
+	SecretTernary: {
+		{27, 19, 14},
+		{54, 37, 29},
+		{109, 75, 58},
+		{218, 152, 118},
+		{438, 305, 237},
+		{881, 611, 476},
+	},
+	SecretGaussian: {
+		{29, 21, 16},
+		{56, 39, 31},
+		{111, 77, 60},
+		{220, 154, 120},
+		{440, 307, 239},
+		{883, 613, 478},
+	},
+}
+
+// heStandardMaxLevel is the largest security level of the tables, in bits.
+const heStandardMaxLevel = 256
+
+// HEStandardSecurity returns an estimate of the classical bit security of the RLWE problem of
+// ring degree 2^logN and modulus of logQ bits with the Gaussian error of the standard
+// (sigma = 3.2) and the given secret distribution.
+//
+// The estimate interpolates the tables of the homomorphic encryption security standard linearly
+// in 1/logQ. Ring degrees above 2^15 are extrapolated by scaling the largest modulus linearly with
+// the degree, which is conservative. Outside of the tabulated range, the extrapolated estimate is
+// not trusted alone and the minimum with the primal unique-SVP estimate is returned, see
+// PrimalUSVPSecurity. The estimate never exceeds the largest level of the tables, 256 bits.
+func HEStandardSecurity(logN int, logQ float64, secret SecretDistribution) float64 {
+	table, ok := heStandardMaxLogQ[secret]
+	if !ok || logN < heStandardLogN || logQ <= 0 {
+		return 0
+	}
+
+	var maxLogQ [3]float64
+	tabulated := true
+	if i := logN - heStandardLogN; i < len(table) {
+		maxLogQ = table[i]
+	} else {
+		tabulated = false
+		scale := math.Exp2(float64(i - len(table) + 1))
+		for j, q := range table[len(table)-1] {
+			maxLogQ[j] = q * scale
+		}
+	}
+	if logQ < maxLogQ[2] || logQ > maxLogQ[0] {
+		tabulated = false
+	}
+
+	// the security is close to linear in 1/logQ, pick the segment closest to logQ
+	j := 0
+	if logQ < maxLogQ[1] {
+		j = 1
+	}
+	x0, x1 := 1/maxLogQ[j], 1/maxLogQ[j+1]
+	y0, y1 := heStandardLevels[j], heStandardLevels[j+1]
+	level := y0 + (y1-y0)*(1/logQ-x0)/(x1-x0)
+
+	if !tabulated {
+		secretStd := DefaultSigma
+		if secret == SecretTernary {
+			secretStd = math.Sqrt(2.0 / 3)
+		}
+		level = math.Min(level, PrimalUSVPSecurity(logN, logQ, DefaultSigma, secretStd))
+	}
+	return math.Max(0, math.Min(heStandardMaxLevel, level))
+}
+
+// PrimalUSVPSecurity returns an estimate of the classical bit security of the RLWE problem of ring
+// degree 2^logN and modulus of logQ bits with error of standard deviation sigma and secret of
+// standard deviation secretStd, against the primal attack on the unique-SVP embedding.
+//
+// The attack succeeds with block size beta if sigma * sqrt(beta) <= delta^(2beta-d) * vol^(1/d) for
+// some number of samples m, where d = n + m + 1 and the secret is rescaled to the size of the error
+// (Alkim et al., Post-quantum key exchange - a new hope, 2016). Running BKZ-beta is assumed to cost
+// 2^(0.292beta + 16.4) * 8d operations.
+func PrimalUSVPSecurity(logN int, logQ, sigma, secretStd float64) float64 {
+	n := 1 << logN
+	if logQ <= 0 || sigma <= 0 || secretStd <= 0 {
+		return 0
+	}
+	logNu := math.Log2(sigma / secretStd)
+
+	// returns the dimension of the smallest successful embedding for beta, or 0 if there is none
+	solves := func(beta int) int {
+		b := float64(beta)
+		logDelta := math.Log2(math.Pow(math.Pi*b, 1/b)*b/(2*math.Pi*math.E)) / (2 * (b - 1))
+		lhs := math.Log2(sigma) + 0.5*math.Log2(b)
+		step := 1 + n/512
+		for m := step; m <= 2*n; m += step {
+			d := n + m + 1
+			if beta > d {
+				continue
+			}
+			rhs := float64(2*beta-d)*logDelta + (float64(m)*logQ+float64(n)*logNu)/float64(d)
+			if lhs <= rhs {
+				return d
+			}
+		}
+		return 0
+	}
+
+	lo, hi := 50, 2*n
+	if solves(hi) == 0 {
+		return math.Inf(1)
+	}
+	for lo < hi {
+		if mid := (lo + hi) / 2; solves(mid) != 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return 0.292*float64(lo) + 16.4 + math.Log2(8*float64(solves(lo)))
+}
+
+// LogQPFloat returns the size of the extended modulus QP in bits as a float.
+func (p Parameters) LogQPFloat() (logQP float64) {
+	for _, qi := range p.qi {
+		logQP += math.Log2(float64(qi))
+	}
+	for _, pi := range p.pi {
+		logQP += math.Log2(float64(pi))
+	}
+	return
+}
+
+// SecurityLevel returns an estimate of the classical bit security of the parameters, computed
+// from the tables of the homomorphic encryption security standard for ternary secrets, see
+// HEStandardSecurity. For sparse secrets, of Hamming weight below N/2, the estimate is the
+// minimum with the primal unique-SVP estimate, see PrimalUSVPSecurity.
+func (p Parameters) SecurityLevel() float64 {
+	logQP := p.LogQPFloat()
+	level := HEStandardSecurity(p.logN, logQP, SecretTernary)
+	if p.h < p.N()/2 {
+		level = math.Min(level, PrimalUSVPSecurity(p.logN, logQP, p.sigma, math.Sqrt(float64(p.h)/float64(p.N()))))
+	}
+	return level
+}
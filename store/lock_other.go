@@ -0,0 +1,15 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// lockFile opens file. Advisory locking is not supported on this platform, so the caller
+// must ensure that a single process opens the store.
+func lockFile(file string) (*os.File, error) {
+	return os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0o600)
+}
+
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"spdz-go/field"
+	"spdz-go/hpbfv"
+)
+
+// MacKeyFile is the name of the file holding the MAC key share in the store directory.
+const MacKeyFile = "mac_key"
+
+// SaveMacKeyShare durably writes the share of the global MAC key the triples of dir are authenticated under.
+func SaveMacKeyShare(dir string, params hpbfv.Parameters, alpha field.Element) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	w := hpbfv.NewWireWriter(params)
+	w.WriteScalar(alpha)
+	data, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dir, MacKeyFile, data)
+}
+
+// LoadMacKeyShare reads the MAC key share written by SaveMacKeyShare.
+func LoadMacKeyShare(dir string, params hpbfv.Parameters) (field.Element, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MacKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	r := hpbfv.NewWireReader(params, data)
+	alpha := r.ReadScalar()
+	if err = r.Close(); err != nil {
+		return nil, fmt.Errorf("%w: MAC key share: %v", ErrCorrupted, err)
+	}
+	return alpha, nil
+}
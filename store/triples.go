@@ -0,0 +1,550 @@
+// Package store implements the persistent storage of preprocessed material.
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"spdz-go/hpbfv"
+	"spdz-go/protocol"
+)
+
+const (
+	// TriplesFile is the name of the append-only file of triples in the store directory.
+	TriplesFile = "triples.log"
+	// CursorFile is the name of the file holding the number of consumed triples.
+	CursorFile = "triples.cursor"
+	// LockFile is the name of the file locked by the process owning the store.
+	LockFile = "triples.lock"
+
+	storeVersion = 1
+
+	// maxRecordSize bounds the size of a record read from disk.
+	maxRecordSize = 1 << 30
+	// maxSessionSize bounds the size of a session ID.
+	maxSessionSize = 1 << 10
+)
+
+var storeMagic = [8]byte{'S', 'P', 'D', 'Z', 'T', 'R', 'P', 'L'}
+
+// fileHeaderSize is the size of the header of the triples file: magic, version and parameter fingerprint.
+const fileHeaderSize = len(storeMagic) + 1 + hpbfv.FingerprintSize
+
+// ErrDuplicateBatch is returned when appending a batch whose key is already in the store.
+var ErrDuplicateBatch = errors.New("batch already stored")
+
+// ErrCorrupted is returned when the store files are inconsistent.
+var ErrCorrupted = errors.New("corrupted triple store")
+
+// record locates a batch of triples in the triples file.
+type record struct {
+	session string
+	batch   uint32
+	offset  int64 // offset of the wire payload
+	length  int   // length of the wire payload
+	count   int   // number of triples
+	first   int   // index of the first triple in the store
+}
+
+// TripleStore is an on-disk store of the authenticated triples of one party.
+//
+// Triples are appended by batch, keyed by a session ID and a batch index, to an append-only file,
+// and handed out in the order they were appended. The number of triples handed out is kept in
+// a cursor file that is durably advanced before any triple is returned, so that a triple is never
+// handed out twice, even across crashes: a crash may only waste reserved triples. The store
+// directory is locked by the process that opened it.
+//
+// Record layout: body length (4 bytes) | body | CRC-32 of body (4 bytes), where
+// body = session length (2 bytes) | session | batch index (4 bytes) | count (4 bytes) | wire payload.
+type TripleStore struct {
+	mu     sync.Mutex
+	params hpbfv.Parameters
+	dir    string
+	file   *os.File
+	lock   *os.File
+	size   int64
+
+	payloadHeader int // size of the header of the wire payload of a record
+	tripleSize    int // size of the wire encoding of a triple
+
+	records []record
+	keys    map[string]bool
+	total   int
+	cursor  int
+
+	// cache of the last decoded record
+	cached    int
+	cachedOut []*protocol.AuthTriple
+}
+
+// OpenTripleStore opens the store in dir, creating it if needed. A trailing record left incomplete
+// by a crash during Append is discarded once the store is checked; an incomplete record followed by
+// valid ones fails with ErrCorrupted and leaves the file unchanged. The cursor file is durably created with the store, and
+// opening a store whose cursor file is missing while it holds triples fails with ErrCorrupted.
+func OpenTripleStore(dir string, params hpbfv.Parameters) (s *TripleStore, err error) {
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockFile(filepath.Join(dir, LockFile))
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock triple store %s: %w", dir, err)
+	}
+
+	s = &TripleStore{params: params, dir: dir, lock: lock, keys: make(map[string]bool), cached: -1}
+	s.payloadHeader, s.tripleSize = wireSizes(params)
+	defer func() {
+		if err != nil {
+			s.Close()
+			s = nil
+		}
+	}()
+
+	if s.file, err = os.OpenFile(filepath.Join(dir, TriplesFile), os.O_RDWR|os.O_CREATE, 0o600); err != nil {
+		return
+	}
+	if err = s.load(); err != nil {
+		return
+	}
+	s.cursor, err = readCursor(filepath.Join(dir, CursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		// Without its cursor, a store cannot tell which triples were handed out: only a store
+		// without triples, new or left by a crash while creating it, gets a new cursor.
+		if s.total > 0 {
+			err = fmt.Errorf("%w: missing cursor file with %d stored triples", ErrCorrupted, s.total)
+			return
+		}
+		err = writeCursor(dir, 0)
+	}
+	if err != nil {
+		return
+	}
+	if s.cursor > s.total {
+		err = fmt.Errorf("%w: cursor %d beyond %d stored triples", ErrCorrupted, s.cursor, s.total)
+		return
+	}
+	err = s.discardTornRecord()
+	return
+}
+
+// wireSizes returns the size of the header of a wire payload and of the wire encoding of a triple.
+func wireSizes(params hpbfv.Parameters) (header, triple int) {
+	f := params.Field()
+	zero := protocol.AuthShare{Value: f.NewElement(), Mac: f.NewElement()}
+	w := hpbfv.NewWireWriter(params)
+	data, _ := w.Bytes()
+	header = len(data)
+	(&protocol.AuthTriple{A: zero, B: zero, C: zero}).WriteWire(w)
+	data, _ = w.Bytes()
+	return header, len(data) - header
+}
+
+// load checks the file header, or writes it for a new store, and indexes the records. A trailing record
+// left incomplete by a crash is skipped, and discarded by discardTornRecord once the store is checked.
+// An incomplete record followed by a valid one is not a torn write, and load returns ErrCorrupted.
+func (s *TripleStore) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	fp := s.params.Fingerprint()
+	header := append(append(append([]byte{}, storeMagic[:]...), storeVersion), fp[:]...)
+
+	if info.Size() < int64(fileHeaderSize) {
+		// new store, or crash while creating it
+		if err = s.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = s.file.WriteAt(header, 0); err != nil {
+			return err
+		}
+		s.size = int64(fileHeaderSize)
+		return s.sync()
+	}
+
+	buf := make([]byte, fileHeaderSize)
+	if _, err = s.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf[:len(storeMagic)], storeMagic[:]) || buf[len(storeMagic)] != storeVersion {
+		return fmt.Errorf("%w: invalid header", ErrCorrupted)
+	}
+	if !bytes.Equal(buf[len(storeMagic)+1:], fp[:]) {
+		return fmt.Errorf("%w: parameter fingerprint mismatch", ErrCorrupted)
+	}
+
+	offset := int64(fileHeaderSize)
+	for offset < info.Size() {
+		rec, next, err := s.readRecord(offset, info.Size())
+		if err != nil {
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			follows, err := s.recordFollows(offset, info.Size())
+			if err != nil {
+				return err
+			}
+			if follows {
+				return fmt.Errorf("%w: invalid record at offset %d followed by valid records", ErrCorrupted, offset)
+			}
+			// torn write of the last record
+			break
+		}
+		rec.first = s.total
+		s.records = append(s.records, rec)
+		s.keys[recordKey(rec.session, rec.batch)] = true
+		s.total += rec.count
+		offset = next
+	}
+	s.size = offset
+	return nil
+}
+
+// discardTornRecord truncates the triples file after the last complete record.
+func (s *TripleStore) discardTornRecord() error {
+	info, err := s.file.Stat()
+	if err != nil || info.Size() == s.size {
+		return err
+	}
+	if err = s.file.Truncate(s.size); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// recordFollows reports whether a complete record with a valid checksum starts after offset in a file of
+// size bytes. Such a record rules out a torn write at offset, since only the last record can be torn.
+// Only the positions whose length prefix matches the session and count that follow it are checksummed.
+func (s *TripleStore) recordFollows(offset, size int64) (bool, error) {
+	const chunkSize = 1 << 20
+	maxHeader := 4 + 2 + maxSessionSize + 8
+	buf := make([]byte, chunkSize+maxHeader)
+	for start := offset + 1; start < size; start += chunkSize {
+		chunk := buf
+		if int64(len(chunk)) > size-start {
+			chunk = chunk[:size-start]
+		}
+		if _, err := s.file.ReadAt(chunk, start); err != nil {
+			return false, err
+		}
+		for i := 0; i < chunkSize && i < len(chunk); i++ {
+			if !s.plausibleRecord(chunk[i:], start+int64(i), size) {
+				continue
+			}
+			if _, _, err := s.readRecord(start+int64(i), size); err == nil {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// plausibleRecord reports whether the record header hdr at offset declares a record that fits in a file of
+// size bytes and whose length matches its session and triple count.
+func (s *TripleStore) plausibleRecord(hdr []byte, offset, size int64) bool {
+	if len(hdr) < 6 {
+		return false
+	}
+	n := int64(binary.BigEndian.Uint32(hdr))
+	sessionLen := int(binary.BigEndian.Uint16(hdr[4:]))
+	if sessionLen > maxSessionSize || len(hdr) < 6+sessionLen+8 || offset+4+n+4 > size {
+		return false
+	}
+	count := int64(binary.BigEndian.Uint32(hdr[6+sessionLen+4:]))
+	return n == int64(2+sessionLen+8+s.payloadHeader)+count*int64(s.tripleSize)
+}
+
+// readRecord reads the record at offset in a file of size bytes. It returns io.ErrUnexpectedEOF
+// if the record is incomplete, which load only accepts for the last record, see recordFollows.
+func (s *TripleStore) readRecord(offset, size int64) (rec record, next int64, err error) {
+	var lenBuf [4]byte
+	if size-offset < 4 {
+		return rec, 0, io.ErrUnexpectedEOF
+	}
+	if _, err = s.file.ReadAt(lenBuf[:], offset); err != nil {
+		return
+	}
+	n := int64(binary.BigEndian.Uint32(lenBuf[:]))
+	if n > maxRecordSize {
+		return rec, 0, fmt.Errorf("%w: record of %d bytes at offset %d", ErrCorrupted, n, offset)
+	}
+	if size-offset-4 < n+4 {
+		return rec, 0, io.ErrUnexpectedEOF
+	}
+
+	buf := make([]byte, n+4)
+	if _, err = s.file.ReadAt(buf, offset+4); err != nil {
+		return
+	}
+	body := buf[:n]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(buf[n:]) {
+		if offset+4+n+4 == size {
+			// torn write of the last record
+			return rec, 0, io.ErrUnexpectedEOF
+		}
+		return rec, 0, fmt.Errorf("%w: checksum mismatch at offset %d", ErrCorrupted, offset)
+	}
+
+	if len(body) < 2 {
+		return rec, 0, fmt.Errorf("%w: invalid record at offset %d", ErrCorrupted, offset)
+	}
+	sessionLen := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+sessionLen+8 {
+		return rec, 0, fmt.Errorf("%w: invalid record at offset %d", ErrCorrupted, offset)
+	}
+	rec.session = string(body[2 : 2+sessionLen])
+	body = body[2+sessionLen:]
+	rec.batch = binary.BigEndian.Uint32(body)
+	rec.count = int(binary.BigEndian.Uint32(body[4:]))
+	rec.offset = offset + 4 + int64(2+sessionLen+8)
+	rec.length = len(body) - 8
+	return rec, offset + 4 + n + 4, nil
+}
+
+// Append durably appends a batch of triples under the key (session, batch).
+// It returns ErrDuplicateBatch if the key is already in the store.
+func (s *TripleStore) Append(session string, batch int, triples []*protocol.AuthTriple) error {
+	if len(session) > maxSessionSize || batch < 0 || uint64(batch) > uint64(^uint32(0)) {
+		return fmt.Errorf("cannot Append: invalid key (%q, %d)", session, batch)
+	}
+
+	w := hpbfv.NewWireWriter(s.params)
+	for _, t := range triples {
+		t.WriteWire(w)
+	}
+	payload, err := w.Bytes()
+	if err != nil {
+		return fmt.Errorf("cannot Append: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+
+	key := recordKey(session, uint32(batch))
+	if s.keys[key] {
+		return fmt.Errorf("cannot Append (%q, %d): %w", session, batch, ErrDuplicateBatch)
+	}
+
+	var body []byte
+	body = binary.BigEndian.AppendUint16(body, uint16(len(session)))
+	body = append(body, session...)
+	body = binary.BigEndian.AppendUint32(body, uint32(batch))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(triples)))
+	headerLen := len(body)
+	body = append(body, payload...)
+	if len(body) > maxRecordSize {
+		return fmt.Errorf("cannot Append: batch of %d bytes too large", len(body))
+	}
+
+	rec := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	rec = append(rec, body...)
+	rec = binary.BigEndian.AppendUint32(rec, crc32.ChecksumIEEE(body))
+
+	if _, err = s.file.WriteAt(rec, s.size); err != nil {
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.records = append(s.records, record{
+		session: session,
+		batch:   uint32(batch),
+		offset:  s.size + 4 + int64(headerLen),
+		length:  len(payload),
+		count:   len(triples),
+		first:   s.total,
+	})
+	s.keys[key] = true
+	s.total += len(triples)
+	s.size += int64(len(rec))
+	return nil
+}
+
+// NextAuthTriple hands out the next unused triple. It returns protocol.ErrNoTriples if all triples were used.
+func (s *TripleStore) NextAuthTriple() (*protocol.AuthTriple, error) {
+	triples, err := s.Take(1)
+	if err != nil {
+		return nil, err
+	}
+	return triples[0], nil
+}
+
+// Take hands out the next n unused triples. The triples are marked as used on disk before
+// they are returned. It returns protocol.ErrNoTriples if fewer than n triples are left.
+func (s *TripleStore) Take(n int) ([]*protocol.AuthTriple, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil, os.ErrClosed
+	}
+	if n < 0 || s.total-s.cursor < n {
+		return nil, protocol.ErrNoTriples
+	}
+
+	start := s.cursor
+	if err := writeCursor(s.dir, start+n); err != nil {
+		return nil, err
+	}
+	s.cursor = start + n
+
+	// The triples are reserved from here on: if reading them fails, they are lost but never reused.
+	triples := make([]*protocol.AuthTriple, 0, n)
+	for i := start; i < start+n; {
+		r := s.findRecord(i)
+		batch, err := s.readBatch(r)
+		if err != nil {
+			return nil, err
+		}
+		rec := s.records[r]
+		end := rec.first + rec.count
+		if end > start+n {
+			end = start + n
+		}
+		triples = append(triples, batch[i-rec.first:end-rec.first]...)
+		i = end
+	}
+	return triples, nil
+}
+
+// Len returns the number of unused triples.
+func (s *TripleStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total - s.cursor
+}
+
+// Close closes the store and releases its lock.
+func (s *TripleStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	if s.lock != nil {
+		if lockErr := unlockFile(s.lock); err == nil {
+			err = lockErr
+		}
+		s.lock = nil
+	}
+	return err
+}
+
+// findRecord returns the index of the record holding the i-th triple.
+func (s *TripleStore) findRecord(i int) int {
+	lo, hi := 0, len(s.records)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if s.records[mid].first <= i {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// readBatch reads and decodes the triples of the r-th record.
+func (s *TripleStore) readBatch(r int) ([]*protocol.AuthTriple, error) {
+	if r == s.cached {
+		return s.cachedOut, nil
+	}
+	rec := s.records[r]
+	data := make([]byte, rec.length)
+	if _, err := s.file.ReadAt(data, rec.offset); err != nil {
+		return nil, err
+	}
+
+	reader := hpbfv.NewWireReader(s.params, data)
+	triples := make([]*protocol.AuthTriple, rec.count)
+	for i := range triples {
+		triples[i] = protocol.ReadAuthTriple(reader)
+	}
+	if err := reader.Close(); err != nil {
+		return nil, fmt.Errorf("%w: batch (%q, %d): %v", ErrCorrupted, rec.session, rec.batch, err)
+	}
+
+	s.cached, s.cachedOut = r, triples
+	return triples, nil
+}
+
+func (s *TripleStore) sync() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+func recordKey(session string, batch uint32) string {
+	return fmt.Sprintf("%d:%s/%d", len(session), session, batch)
+}
+
+// readCursor reads the cursor file, which holds the number of consumed triples and its CRC-32.
+// It returns an error wrapping os.ErrNotExist if the file is missing. The caller checks the cursor against
+// the number of stored triples.
+func readCursor(file string) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 12 || crc32.ChecksumIEEE(data[:8]) != binary.BigEndian.Uint32(data[8:]) {
+		return 0, fmt.Errorf("%w: invalid cursor file", ErrCorrupted)
+	}
+	cursor := binary.BigEndian.Uint64(data)
+	if cursor > math.MaxInt {
+		return 0, fmt.Errorf("%w: invalid cursor %d", ErrCorrupted, cursor)
+	}
+	return int(cursor), nil
+}
+
+// writeCursor atomically and durably replaces the cursor file of dir.
+func writeCursor(dir string, cursor int) error {
+	data := binary.BigEndian.AppendUint64(nil, uint64(cursor))
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	return writeFileAtomic(dir, CursorFile, data)
+}
+
+// writeFileAtomic atomically and durably replaces the file name of dir with data.
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp := filepath.Join(dir, name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"spdz-go/hpbfv"
+	"spdz-go/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+func genTriples(params hpbfv.Parameters, first, n int) []*protocol.AuthTriple {
+	triples := make([]*protocol.AuthTriple, n)
+	for i := range triples {
+		x := int64(6 * (first + i))
+		share := func(j int64) protocol.AuthShare {
+			f := params.Field()
+			return protocol.AuthShare{Value: f.NewElementFromBig(big.NewInt(x + j)), Mac: f.NewElementFromBig(big.NewInt(-x - j - 1))}
+		}
+		triples[i] = &protocol.AuthTriple{A: share(0), B: share(2), C: share(4)}
+	}
+	return triples
+}
+
+func requireTriples(t *testing.T, want, got []*protocol.AuthTriple) {
+	require.Len(t, got, len(want))
+	for i := range want {
+		for _, pair := range [][2]protocol.AuthShare{{want[i].A, got[i].A}, {want[i].B, got[i].B}, {want[i].C, got[i].C}} {
+			require.Equal(t, pair[0].Value, pair[1].Value, "triple %d", i)
+			require.Equal(t, pair[0].Mac, pair[1].Mac, "triple %d", i)
+		}
+	}
+}
+
+func TestTripleStore(t *testing.T) {
+	params, err := hpbfv.NewParametersFromLiteral(hpbfv.SOHO_V2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hemi, err := hpbfv.NewParametersFromLiteral(hpbfv.HEMI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Order", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		defer s.Close()
+
+		require.NoError(t, s.Append("s", 0, genTriples(params, 0, 5)))
+		require.NoError(t, s.Append("s", 1, genTriples(params, 5, 3)))
+		require.NoError(t, s.Append("t", 0, genTriples(params, 8, 4)))
+		require.ErrorIs(t, s.Append("s", 1, genTriples(params, 0, 1)), ErrDuplicateBatch)
+		require.Equal(t, 12, s.Len())
+
+		triples, err := s.Take(2)
+		require.NoError(t, err)
+		requireTriples(t, genTriples(params, 0, 2), triples)
+
+		// spans the three batches
+		triples, err = s.Take(9)
+		require.NoError(t, err)
+		requireTriples(t, genTriples(params, 2, 9), triples)
+
+		triple, err := s.NextAuthTriple()
+		require.NoError(t, err)
+		requireTriples(t, genTriples(params, 11, 1), []*protocol.AuthTriple{triple})
+
+		_, err = s.NextAuthTriple()
+		require.ErrorIs(t, err, protocol.ErrNoTriples)
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		require.NoError(t, s.Append("s", 0, genTriples(params, 0, 4)))
+		_, err = s.Take(3)
+		require.NoError(t, err)
+
+		// a second process cannot open the store
+		_, err = OpenTripleStore(dir, params)
+		require.Error(t, err)
+		require.NoError(t, s.Close())
+
+		s, err = OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		require.Equal(t, 1, s.Len())
+		require.ErrorIs(t, s.Append("s", 0, genTriples(params, 0, 1)), ErrDuplicateBatch)
+		require.NoError(t, s.Append("s", 1, genTriples(params, 4, 2)))
+
+		triples, err := s.Take(3)
+		require.NoError(t, err)
+		requireTriples(t, genTriples(params, 3, 3), triples)
+		require.NoError(t, s.Close())
+
+		_, err = OpenTripleStore(dir, hemi)
+		require.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("MissingCursor", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(dir, CursorFile))
+		require.NoError(t, err, "the cursor is created with the store")
+		require.NoError(t, s.Append("s", 0, genTriples(params, 0, 4)))
+		_, err = s.Take(3)
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+
+		// reopening without the cursor would hand out the consumed triples again
+		require.NoError(t, os.Remove(filepath.Join(dir, CursorFile)))
+		_, err = OpenTripleStore(dir, params)
+		require.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("MacKey", func(t *testing.T) {
+		dir := t.TempDir()
+		alpha := params.Field().NewElementFromBig(big.NewInt(-2))
+		require.NoError(t, SaveMacKeyShare(dir, params, alpha))
+		alphaOut, err := LoadMacKeyShare(dir, params)
+		require.NoError(t, err)
+		require.Equal(t, alpha, alphaOut)
+
+		_, err = LoadMacKeyShare(dir, hemi)
+		require.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("TornWrite", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		require.NoError(t, s.Append("s", 0, genTriples(params, 0, 2)))
+		require.NoError(t, s.Append("s", 1, genTriples(params, 2, 2)))
+		require.NoError(t, s.Close())
+
+		file := filepath.Join(dir, TriplesFile)
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(file, info.Size()-5))
+
+		s, err = OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		require.Equal(t, 2, s.Len())
+
+		// the batch lost in the crash can be stored again
+		require.NoError(t, s.Append("s", 1, genTriples(params, 2, 2)))
+		triples, err := s.Take(4)
+		require.NoError(t, err)
+		requireTriples(t, genTriples(params, 0, 4), triples)
+		require.NoError(t, s.Close())
+	})
+
+	t.Run("CorruptedLength", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		require.NoError(t, s.Append("s", 0, genTriples(params, 0, 2)))
+		require.NoError(t, s.Append("s", 1, genTriples(params, 2, 2)))
+		require.NoError(t, s.Append("s", 2, genTriples(params, 4, 2)))
+		require.NoError(t, s.Close())
+
+		// the length of the middle record points past the end of the file
+		file := filepath.Join(dir, TriplesFile)
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		first := fileHeaderSize + 4 + int(binary.BigEndian.Uint32(data[fileHeaderSize:])) + 4
+		binary.BigEndian.PutUint32(data[first:], uint32(len(data)))
+		require.NoError(t, os.WriteFile(file, data, 0o600))
+
+		_, err = OpenTripleStore(dir, params)
+		require.ErrorIs(t, err, ErrCorrupted)
+		dataOut, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Equal(t, data, dataOut, "the corrupted store is left unchanged")
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenTripleStore(dir, params)
+		require.NoError(t, err)
+		require.NoError(t, s.Append("s", 0, genTriples(params, 0, 2)))
+		require.NoError(t, s.Append("s", 1, genTriples(params, 2, 2)))
+		require.NoError(t, s.Close())
+
+		file := filepath.Join(dir, TriplesFile)
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		data[fileHeaderSize+10] ^= 1
+		require.NoError(t, os.WriteFile(file, data, 0o600))
+		_, err = OpenTripleStore(dir, params)
+		require.ErrorIs(t, err, ErrCorrupted)
+
+		data[fileHeaderSize+10] ^= 1
+		require.NoError(t, os.WriteFile(file, data, 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, CursorFile), []byte("cursor"), 0o600))
+		_, err = OpenTripleStore(dir, params)
+		require.ErrorIs(t, err, ErrCorrupted)
+
+		// a well-formed cursor that does not fit in an int
+		cursor := binary.BigEndian.AppendUint64(nil, 1<<63)
+		cursor = binary.BigEndian.AppendUint32(cursor, crc32.ChecksumIEEE(cursor))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, CursorFile), cursor, 0o600))
+		_, err = OpenTripleStore(dir, params)
+		require.ErrorIs(t, err, ErrCorrupted)
+	})
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// CommitmentOpeningSize is the size in bytes of the random opening of a commitment.
+const CommitmentOpeningSize = 32
+
+// Commit returns a hiding and binding commitment to msg, computed as blake2b-256(opening || msg)
+// with a fresh random opening, together with the opening needed to reveal it.
+func Commit(msg []byte) (com, opening []byte, err error) {
+	opening = make([]byte, CommitmentOpeningSize)
+	if _, err = rand.Read(opening); err != nil {
+		return nil, nil, err
+	}
+	return commitWithOpening(msg, opening), opening, nil
+}
+
+// VerifyCommitment checks in constant time that com is a commitment to msg with the given opening.
+func VerifyCommitment(com, msg, opening []byte) bool {
+	if len(opening) != CommitmentOpeningSize {
+		return false
+	}
+	return subtle.ConstantTimeCompare(com, commitWithOpening(msg, opening)) == 1
+}
+
+func commitWithOpening(msg, opening []byte) []byte {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	h.Write(opening)
+	h.Write(msg)
+	return h.Sum(nil)
+}
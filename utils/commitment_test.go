@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitment(t *testing.T) {
+	msg := []byte("sigma")
+
+	com, opening, err := Commit(msg)
+	require.NoError(t, err)
+	require.True(t, VerifyCommitment(com, msg, opening))
+
+	require.False(t, VerifyCommitment(com, []byte("sigmb"), opening))
+
+	opening[0] ^= 1
+	require.False(t, VerifyCommitment(com, msg, opening))
+	require.False(t, VerifyCommitment(com, msg, opening[:8]))
+
+	com2, _, err := Commit(msg)
+	require.NoError(t, err)
+	require.NotEqual(t, com, com2)
+}
@@ -14,6 +14,14 @@ func TestAllDistinct(t *testing.T) {
 	require.False(t, AllDistinct([]uint64{1, 2, 3, 4, 5, 5}))
 }
 
+func TestEqualSlice(t *testing.T) {
+	require.True(t, EqualSliceUint64([]uint64{1, 2}, []uint64{1, 2}))
+	require.False(t, EqualSliceUint64([]uint64{1, 2}, []uint64{1, 2, 3}))
+	require.False(t, EqualSliceUint64([]uint64{1, 2, 3}, []uint64{1, 2}))
+	require.False(t, EqualSliceInt64([]int64{1, 2}, []int64{1}))
+	require.False(t, EqualSliceUint8([]uint8{1}, []uint8{1, 2}))
+}
+
 func TestRotateUint64(t *testing.T) {
 	s := []uint64{0, 1, 2, 3, 4, 5, 6, 7}
 	sout := make([]uint64, len(s))